@@ -0,0 +1,57 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/AzraelSec/cube/pkg/dap"
+	"github.com/AzraelSec/cube/pkg/debugger"
+	"github.com/AzraelSec/cube/pkg/evaluator"
+)
+
+func debugCmd(args []string) int {
+	fs := flag.NewFlagSet("cube debug", flag.ContinueOnError)
+	adapter := fs.Bool("dap", false, "speak the Debug Adapter Protocol over stdin and stdout, the program comes from the launch request")
+	fs.BoolVar(&strictMode, "strict", strictMode, "disable implicit conversions")
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: cube debug [flags] file.cb [args...]")
+		fmt.Fprintln(os.Stderr, "\tcube debug -dap\t(the launch request sets noStrict instead of -strict=false)")
+		fs.PrintDefaults()
+	}
+
+	if err := fs.Parse(args); err != nil {
+		return exitUsage
+	}
+
+	if *adapter {
+		if fs.NArg() != 0 || isFlagSet(fs, "strict") {
+			fs.Usage()
+			return exitUsage
+		}
+		return dap.Serve(os.Stdin, os.Stdout, os.Stderr)
+	}
+
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return exitUsage
+	}
+
+	path := fs.Arg(0)
+	content, err := os.ReadFile(path)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "impossible to read the file %s: %v\n", path, err)
+		return exitIOError
+	}
+
+	prog, env, ok := load(path, string(content), fs.Args()[1:])
+	if !ok {
+		return exitParseError
+	}
+
+	d := debugger.New(prog)
+	debugger.NewConsole(d, string(content), os.Stdin, os.Stdout)
+
+	env.Runtime().Hook = d
+	return report(path, evaluator.Eval(prog, env), false)
+}
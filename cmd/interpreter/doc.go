@@ -0,0 +1,72 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/AzraelSec/cube/pkg/doc"
+	"github.com/AzraelSec/cube/pkg/lexer"
+	"github.com/AzraelSec/cube/pkg/parser"
+)
+
+func docCmd(args []string) int {
+	fs := flag.NewFlagSet("cube doc", flag.ContinueOnError)
+	html := fs.Bool("html", false, "write an HTML page instead of Markdown")
+	output := fs.String("o", "", "write the reference to `file` instead of stdout")
+	builtins := fs.Bool("builtins", false, "document the builtin functions too")
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: cube doc [flags] [files...]")
+		fmt.Fprintln(os.Stderr, "\twith no files, the builtin functions are documented")
+		fs.PrintDefaults()
+	}
+
+	if err := fs.Parse(args); err != nil {
+		return exitUsage
+	}
+
+	modules := []doc.Module{}
+	code := exitOK
+	for _, path := range fs.Args() {
+		content, err := os.ReadFile(path)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "impossible to read the file %s: %v\n", path, err)
+			code = worst(code, exitIOError)
+			continue
+		}
+
+		p := parser.New(lexer.New(string(content)))
+		prog := p.ParseProgram()
+		if len(p.Errors()) != 0 {
+			fmt.Fprintf(os.Stderr, "%s: parse errors:\n", path)
+			printParserErrors(os.Stderr, p.Errors())
+			code = worst(code, exitParseError)
+			continue
+		}
+		modules = append(modules, doc.New(path, prog))
+	}
+	if code != exitOK {
+		return code
+	}
+	if *builtins || fs.NArg() == 0 {
+		modules = append(modules, doc.Builtins())
+	}
+
+	write := func(w io.Writer) error { return doc.WriteMarkdown(w, modules) }
+	if *html {
+		write = func(w io.Writer) error { return doc.WriteHTML(w, modules) }
+	}
+
+	var err error
+	if *output != "" {
+		err = writeFile(*output, write)
+	} else {
+		err = write(os.Stdout)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "impossible to write the reference: %v\n", err)
+		return exitIOError
+	}
+	return exitOK
+}
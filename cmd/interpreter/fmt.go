@@ -0,0 +1,83 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/AzraelSec/cube/pkg/format"
+)
+
+func fmtCmd(args []string) int {
+	fs := flag.NewFlagSet("cube fmt", flag.ContinueOnError)
+	write := fs.Bool("w", false, "write the result to the source files instead of stdout")
+	check := fs.Bool("check", false, "list the files that are not formatted and fail if there are any")
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: cube fmt [flags] [files...]")
+		fmt.Fprintln(os.Stderr, "\twith no files, the source is read from stdin and written to stdout")
+		fs.PrintDefaults()
+	}
+
+	if err := fs.Parse(args); err != nil {
+		return exitUsage
+	}
+
+	if fs.NArg() == 0 {
+		if *write {
+			fmt.Fprintln(os.Stderr, "cannot use -w when reading from stdin")
+			return exitUsage
+		}
+		content, err := io.ReadAll(os.Stdin)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "impossible to read from stdin: %v\n", err)
+			return exitIOError
+		}
+		return formatSource("<stdin>", string(content), false, *check)
+	}
+
+	code := exitOK
+	for _, path := range fs.Args() {
+		content, err := os.ReadFile(path)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "impossible to read the file %s: %v\n", path, err)
+			code = worst(code, exitIOError)
+			continue
+		}
+		code = worst(code, formatSource(path, string(content), *write, *check))
+	}
+	return code
+}
+
+// formatSource formats a single source, either printing it, writing it back
+// to its file or only checking it is already formatted.
+func formatSource(name, source string, write, check bool) int {
+	formatted, err := format.Source(source)
+
+	var pe *format.ParseError
+	if errors.As(err, &pe) {
+		fmt.Fprintf(os.Stderr, "%s: parse errors:\n", name)
+		printParserErrors(os.Stderr, pe.Errors)
+		return exitParseError
+	}
+
+	switch {
+	case check:
+		if formatted != source {
+			fmt.Println(name)
+			return exitRuntimeError
+		}
+	case write:
+		if formatted == source {
+			return exitOK
+		}
+		if err := os.WriteFile(name, []byte(formatted), 0o644); err != nil {
+			fmt.Fprintf(os.Stderr, "impossible to write the file %s: %v\n", name, err)
+			return exitIOError
+		}
+	default:
+		fmt.Print(formatted)
+	}
+	return exitOK
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/AzraelSec/cube/pkg/coverage"
+	"github.com/AzraelSec/cube/pkg/evaluator"
+	"github.com/AzraelSec/cube/pkg/profiler"
+)
+
+// profileTop is the number of functions in the summary printed at exit.
+const profileTop = 10
+
+// instruments are the tools following a run, the empty ones are disabled.
+type instruments struct {
+	profile  string // file to write the profile to
+	coverDir string // directory to write the coverage reports to
+}
+
+func (in instruments) enabled() bool {
+	return in.profile != "" || in.coverDir != ""
+}
+
+// runInstrumented runs the script like runFile, writing the reports of the
+// instruments once it ends.
+func runInstrumented(path string, args []string, in instruments) int {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "impossible to read the file %s: %v\n", path, err)
+		return exitIOError
+	}
+
+	prog, env, ok := load(path, string(content), args)
+	if !ok {
+		return exitParseError
+	}
+
+	var hooks evaluator.Hooks
+	var p *profiler.Profiler
+	if in.profile != "" {
+		p = profiler.New(prog, path)
+		hooks = append(hooks, p)
+	}
+	var c *coverage.Coverage
+	if in.coverDir != "" {
+		c = coverage.New()
+		c.Add(prog, path, string(content))
+		hooks = append(hooks, c)
+	}
+
+	env.Runtime().Hook = hooks
+	if p != nil {
+		p.Start()
+	}
+	evaluated := evaluator.Eval(prog, env)
+	if p != nil {
+		p.Stop()
+	}
+
+	code := report(path, evaluated, false)
+
+	if p != nil {
+		if err := writeFile(in.profile, p.WritePprof); err != nil {
+			fmt.Fprintf(os.Stderr, "impossible to write the profile %s: %v\n", in.profile, err)
+			return exitIOError
+		}
+		p.Summary(os.Stderr, profileTop)
+	}
+
+	if c != nil {
+		files := c.Files()
+		if err := writeCoverage(in.coverDir, files); err != nil {
+			fmt.Fprintf(os.Stderr, "impossible to write the coverage reports: %v\n", err)
+			return exitIOError
+		}
+		coverage.Summary(os.Stderr, files)
+	}
+	return code
+}
+
+// writeCoverage writes the LCOV and HTML reports to dir, creating it if
+// needed.
+func writeCoverage(dir string, files []coverage.File) error {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	err := writeFile(filepath.Join(dir, "lcov.info"), func(w io.Writer) error {
+		return coverage.WriteLCOV(w, files)
+	})
+	if err != nil {
+		return err
+	}
+	return writeFile(filepath.Join(dir, "index.html"), func(w io.Writer) error {
+		return coverage.WriteHTML(w, files)
+	})
+}
+
+func writeFile(path string, write func(io.Writer) error) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := write(f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
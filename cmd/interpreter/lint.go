@@ -0,0 +1,110 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/AzraelSec/cube/pkg/lexer"
+	"github.com/AzraelSec/cube/pkg/lint"
+	"github.com/AzraelSec/cube/pkg/parser"
+)
+
+func lintCmd(args []string) int {
+	fs := flag.NewFlagSet("cube lint", flag.ContinueOnError)
+	enable := fs.String("enable", "", "comma separated `rules` to run, all of them by default")
+	disable := fs.String("disable", "", "comma separated `rules` not to run")
+	rules := fs.Bool("rules", false, "list the available rules")
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: cube lint [flags] [files...]")
+		fmt.Fprintln(os.Stderr, "\twith no files, the source is read from stdin")
+		fs.PrintDefaults()
+	}
+
+	if err := fs.Parse(args); err != nil {
+		return exitUsage
+	}
+
+	if *rules {
+		for _, rule := range lint.Rules {
+			fmt.Printf("%-20s %s\n", rule.ID, rule.Summary)
+		}
+		return exitOK
+	}
+
+	cfg, err := lintConfig(*enable, *disable)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitUsage
+	}
+
+	if fs.NArg() == 0 {
+		content, err := io.ReadAll(os.Stdin)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "impossible to read from stdin: %v\n", err)
+			return exitIOError
+		}
+		return lintSource("<stdin>", string(content), cfg)
+	}
+
+	code := exitOK
+	for _, path := range fs.Args() {
+		content, err := os.ReadFile(path)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "impossible to read the file %s: %v\n", path, err)
+			code = worst(code, exitIOError)
+			continue
+		}
+		code = worst(code, lintSource(path, string(content), cfg))
+	}
+	return code
+}
+
+func lintConfig(enable, disable string) (lint.Config, error) {
+	cfg := lint.Config{Disabled: map[string]bool{}}
+
+	if enable != "" {
+		for _, rule := range lint.Rules {
+			cfg.Disabled[rule.ID] = true
+		}
+		for _, id := range strings.Split(enable, ",") {
+			if !lint.IsRule(id) {
+				return cfg, fmt.Errorf("unknown lint rule %s", id)
+			}
+			cfg.Disabled[id] = false
+		}
+	}
+
+	if disable != "" {
+		for _, id := range strings.Split(disable, ",") {
+			if !lint.IsRule(id) {
+				return cfg, fmt.Errorf("unknown lint rule %s", id)
+			}
+			cfg.Disabled[id] = true
+		}
+	}
+	return cfg, nil
+}
+
+// lintSource prints the findings for a single source, failing if there are any.
+func lintSource(name, source string, cfg lint.Config) int {
+	p := parser.New(lexer.New(source))
+
+	prog := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		fmt.Fprintf(os.Stderr, "%s: parse errors:\n", name)
+		printParserErrors(os.Stderr, p.Errors())
+		return exitParseError
+	}
+
+	findings := lint.Lint(prog, cfg)
+	for _, finding := range findings {
+		fmt.Printf("%s:%s\n", name, finding)
+	}
+	if len(findings) != 0 {
+		return exitRuntimeError
+	}
+	return exitOK
+}
@@ -0,0 +1,27 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/AzraelSec/cube/pkg/lsp"
+)
+
+func lspCmd(args []string) int {
+	fs := flag.NewFlagSet("cube lsp", flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: cube lsp")
+		fmt.Fprintln(os.Stderr, "\tspeaks the Language Server Protocol over stdin and stdout")
+	}
+
+	if err := fs.Parse(args); err != nil {
+		return exitUsage
+	}
+	if fs.NArg() != 0 {
+		fs.Usage()
+		return exitUsage
+	}
+
+	return lsp.Serve(os.Stdin, os.Stdout, os.Stderr)
+}
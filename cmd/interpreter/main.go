@@ -1,61 +1,245 @@
 package main
 
 import (
+	"flag"
 	"fmt"
 	"io"
 	"os"
-	"strings"
+	"sort"
 
+	"github.com/AzraelSec/cube/pkg/ast"
 	"github.com/AzraelSec/cube/pkg/evaluator"
 	"github.com/AzraelSec/cube/pkg/lexer"
 	"github.com/AzraelSec/cube/pkg/object"
 	"github.com/AzraelSec/cube/pkg/parser"
+	"github.com/AzraelSec/cube/pkg/types"
 )
 
+// exit codes, so that callers can tell apart the kind of failure
+const (
+	exitOK = iota
+	exitRuntimeError
+	exitUsage
+	exitParseError
+	exitIOError
+)
+
+// worst returns the more severe of two exit codes, for the commands that
+// go on after a failing file.
+func worst(code, other int) int {
+	if other > code {
+		return other
+	}
+	return code
+}
+
+// strictMode disables implicit conversions in the programs run, it's set
+// by the -strict flag of the interpreter and of its commands.
+var strictMode = true
+
+type command struct {
+	summary string
+	run     func(args []string) int
+}
+
+var commands map[string]command
+
+func init() {
+	commands = map[string]command{
+		"debug": {summary: "run a script file in the debugger", run: debugCmd},
+		"doc":   {summary: "write the API reference of source files", run: docCmd},
+		"fmt":   {summary: "format source files in the canonical style", run: fmtCmd},
+		"lint":  {summary: "report suspicious constructs in source files", run: lintCmd},
+		"lsp":   {summary: "run the language server for editors", run: lspCmd},
+		"run":   {summary: "run a script file", run: runCmd},
+		"test":  {summary: "run the tests of *_test.cb files", run: testCmd},
+	}
+}
+
 func main() {
-	if len(os.Args) < 2 {
-		help(os.Args[0])
-		return
+	os.Exit(cli(os.Args[1:]))
+}
+
+func cli(args []string) int {
+	fs := flag.NewFlagSet("cube", flag.ContinueOnError)
+	expr := fs.String("e", "", "evaluate `source` and print its result")
+	fs.BoolVar(&strictMode, "strict", strictMode, "disable implicit conversions")
+	fs.Usage = func() { usage(fs) }
+
+	if err := fs.Parse(args); err != nil {
+		return exitUsage
 	}
 
-	if !strings.HasSuffix(os.Args[1], ".cb") {
-		fmt.Printf("wrong file suffix in %s", os.Args[1])
-		return
+	rest := fs.Args()
+	if isFlagSet(fs, "e") {
+		return execute("-e", *expr, rest, true)
 	}
 
-	file, err := os.Open(os.Args[1])
-	if err != nil {
-		fmt.Printf("impossible to open the file %s: %v", os.Args[1], err)
-		return
+	if len(rest) == 0 {
+		usage(fs)
+		return exitUsage
 	}
 
-	env := object.NewEnvironment()
-	content, err := io.ReadAll(file)
+	if rest[0] == "-" {
+		content, err := io.ReadAll(os.Stdin)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "impossible to read from stdin: %v\n", err)
+			return exitIOError
+		}
+		return execute("<stdin>", string(content), rest[1:], false)
+	}
+
+	if cmd, ok := commands[rest[0]]; ok {
+		return cmd.run(rest[1:])
+	}
+
+	// note: `cube file.cb` is a shorthand for `cube run file.cb`
+	return runFile(rest[0], rest[1:])
+}
+
+func runCmd(args []string) int {
+	fs := flag.NewFlagSet("cube run", flag.ContinueOnError)
+	fs.BoolVar(&strictMode, "strict", strictMode, "disable implicit conversions")
+	profile := fs.String("profile", "", "write a pprof profile of the functions called to `file`, and print the top ones")
+	cover := fs.Bool("cover", false, "record the lines, branches and functions executed, and print the ratio of them")
+	coverDir := fs.String("coverdir", "coverage", "write the LCOV and HTML coverage reports to `dir`")
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: cube run [flags] file.cb [args...]")
+		fs.PrintDefaults()
+	}
+
+	if err := fs.Parse(args); err != nil {
+		return exitUsage
+	}
+
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return exitUsage
+	}
+
+	in := instruments{profile: *profile}
+	if *cover {
+		in.coverDir = *coverDir
+	}
+	if in.enabled() {
+		return runInstrumented(fs.Arg(0), fs.Args()[1:], in)
+	}
+	return runFile(fs.Arg(0), fs.Args()[1:])
+}
+
+func runFile(path string, args []string) int {
+	content, err := os.ReadFile(path)
 	if err != nil {
-		fmt.Println("impossible to read file content")
-		return
+		fmt.Fprintf(os.Stderr, "impossible to read the file %s: %v\n", path, err)
+		return exitIOError
 	}
+	return execute(path, string(content), args, false)
+}
 
-	scontent := string(content)
+// execute runs the source with the given script arguments bound to `args`,
+// returning the process exit code.
+func execute(name, source string, args []string, printResult bool) int {
+	prog, env, ok := load(name, source, args)
+	if !ok {
+		return exitParseError
+	}
+	return report(name, evaluator.Eval(prog, env), printResult)
+}
 
-	l := lexer.New(scontent)
+// load parses and checks the source, reporting the errors it finds, and
+// returns the environment to run it in, with the script arguments bound to
+// `args`.
+func load(name, source string, args []string) (*ast.Program, *object.Environment, bool) {
+	l := lexer.New(source)
 	p := parser.New(l)
 
 	prog := p.ParseProgram()
 	if len(p.Errors()) != 0 {
-		fmt.Println("Errors found:")
+		fmt.Fprintf(os.Stderr, "%s: parse errors:\n", name)
 		printParserErrors(os.Stderr, p.Errors())
-		return
+		return nil, nil, false
+	}
+	for _, warning := range p.Warnings() {
+		fmt.Fprintf(os.Stderr, "%s:%d:%d: warning: %s\n", name, warning.Line, warning.Column, warning.Msg)
+	}
+
+	env := object.NewEnvironment()
+	env.Runtime().Strict = strictMode
+	env.Set("args", scriptArgs(args))
+
+	errs := evaluator.Resolve(prog, env)
+	if len(errs) == 0 {
+		info := &types.Info{}
+		errs = types.Config{Strict: strictMode}.Check(prog, types.Globals(env), info)
+		for _, warning := range info.Warnings {
+			fmt.Fprintf(os.Stderr, "%s:%d:%d: warning: %s\n", name, warning.Line, warning.Column, warning.Msg)
+		}
 	}
+	if len(errs) != 0 {
+		fmt.Fprintf(os.Stderr, "%s: errors:\n", name)
+		printParserErrors(os.Stderr, parser.ErrorStrings(errs))
+		return nil, nil, false
+	}
+	return prog, env, true
+}
+
+// report prints the outcome of a program, returning the process exit code.
+func report(name string, evaluated object.Object, printResult bool) int {
+	switch evaluated := evaluated.(type) {
+	case *object.Error:
+		fmt.Fprintf(os.Stderr, "%s: %s\n", name, evaluated.Inspect())
+		return exitRuntimeError
+	case *object.Exit:
+		return int(evaluated.Code)
+	case nil, *object.Null:
+		// note: nothing worth printing
+	default:
+		if printResult {
+			fmt.Println(evaluated.Inspect())
+		}
+	}
+	return exitOK
+}
 
-	if evaluated := evaluator.Eval(prog, env); evaluated != nil && evaluated.Type() == object.ERROR_OBJ {
-		fmt.Println(evaluated.Inspect())
-		return
+func scriptArgs(args []string) *object.Array {
+	elems := make([]object.Object, len(args))
+	for idx, arg := range args {
+		elems[idx] = &object.String{Value: arg}
 	}
+	return &object.Array{Elements: elems}
 }
 
-func help(exec string) {
-	fmt.Printf("usage: %s [file.cb]", exec)
+func isFlagSet(fs *flag.FlagSet, name string) bool {
+	set := false
+	fs.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			set = true
+		}
+	})
+	return set
+}
+
+func usage(fs *flag.FlagSet) {
+	fmt.Fprintln(os.Stderr, "usage:")
+	fmt.Fprintln(os.Stderr, "\tcube [flags] file.cb [args...]")
+	fmt.Fprintln(os.Stderr, "\tcube [flags] -e 'source' [args...]")
+	fmt.Fprintln(os.Stderr, "\tcube [flags] - [args...]\t(read the script from stdin)")
+	fmt.Fprintln(os.Stderr, "\tcube <command> [arguments]")
+	fmt.Fprintln(os.Stderr, "\ncommands:")
+	for _, name := range sortedCommands() {
+		fmt.Fprintf(os.Stderr, "\t%-8s %s\n", name, commands[name].summary)
+	}
+	fmt.Fprintln(os.Stderr, "\nflags:")
+	fs.PrintDefaults()
+}
+
+func sortedCommands() []string {
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
 }
 
 func printParserErrors(out io.Writer, errors []string) {
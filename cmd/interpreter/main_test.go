@@ -0,0 +1,101 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// runCLI runs cli with the given stdin, returning what it wrote to stdout
+// and stderr and its exit code.
+func runCLI(t *testing.T, stdin string, args ...string) (string, string, int) {
+	t.Helper()
+	dir := t.TempDir()
+	files := make([]*os.File, 3)
+	for idx, name := range []string{"stdin", "stdout", "stderr"} {
+		f, err := os.Create(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+		files[idx] = f
+	}
+	if _, err := files[0].WriteString(stdin); err != nil {
+		t.Fatal(err)
+	}
+	files[0].Seek(0, 0)
+
+	oldStdin, oldStdout, oldStderr := os.Stdin, os.Stdout, os.Stderr
+	os.Stdin, os.Stdout, os.Stderr = files[0], files[1], files[2]
+	defer func() {
+		os.Stdin, os.Stdout, os.Stderr = oldStdin, oldStdout, oldStderr
+		strictMode = true
+	}()
+
+	code := cli(args)
+
+	stdout, _ := os.ReadFile(files[1].Name())
+	stderr, _ := os.ReadFile(files[2].Name())
+	return string(stdout), string(stderr), code
+}
+
+func TestCLI(t *testing.T) {
+	dir := t.TempDir()
+	script := filepath.Join(dir, "script.cb")
+	if err := os.WriteFile(script, []byte(`print(len(args)); print(args[0]); 1 + 2`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	broken := filepath.Join(dir, "broken.cb")
+	if err := os.WriteFile(broken, []byte(`let = 1`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	missing := filepath.Join(dir, "missing.cb")
+
+	tests := []struct {
+		args   []string
+		stdin  string
+		stdout string
+		stderr string // only has to be contained in the actual one
+		code   int
+	}{
+		// note: the result of a file isn't printed, unlike the one of -e
+		{[]string{script, "a", "b"}, "", "2\na\n", "", exitOK},
+		{[]string{"run", script, "a"}, "", "1\na\n", "", exitOK},
+		{[]string{broken}, "", "", broken + ": parse errors:\n\t0: expected next token to be IDENT, found =\n", exitParseError},
+		{[]string{missing}, "", "", "impossible to read the file " + missing, exitIOError},
+
+		{[]string{"-e", "1 + 2"}, "", "3\n", "", exitOK},
+		{[]string{"-e", "print(args); len(args)", "x", "y"}, "", "[x, y]\n2\n", "", exitOK},
+		{[]string{"-e", "if (false) { 1 }"}, "", "", "", exitOK},
+		{[]string{"-e", `assert(false, "boom")`}, "", "", "-e: Error: boom: assert failed: condition is false\n", exitRuntimeError},
+		{[]string{"-e", "let = 1"}, "", "", "-e: parse errors:\n", exitParseError},
+		{[]string{"-e", "y + 1"}, "", "", "-e: errors:\n\t0: 1:1: identifier not found: y\n", exitParseError},
+		{[]string{"-e", `"a" + 1`}, "", "", "-e:1:1: warning: type mismatch: string + int\n-e: Error: type mismatch: STRING + INTEGER\n", exitRuntimeError},
+		{[]string{"-e", `let x: int = "a"`}, "", "", "-e: errors:\n\t0: 1:14: cannot assign string to x of type int\n", exitParseError},
+		{[]string{"-strict=false", "-e", `"a" + 1`}, "", "a1\n", "", exitOK},
+		{[]string{"-e", "exit(7)"}, "", "", "", 7},
+		{[]string{"-e", `print("bye"); exit(0); print("unreachable")`}, "", "bye\n", "", exitOK},
+
+		{[]string{"-"}, `print("from stdin")`, "from stdin\n", "", exitOK},
+		{[]string{"-", "a"}, `print(args[0]); exit(5)`, "a\n", "", 5},
+		{[]string{"-"}, `let f = fn(x) { x(1) }; f(2)`, "", "<stdin>: Error: not a function: INTEGER\n", exitRuntimeError},
+		{[]string{"-"}, `let = 1`, "", "<stdin>: parse errors:\n", exitParseError},
+
+		{[]string{}, "", "", "usage:\n", exitUsage},
+		{[]string{"-unknown"}, "", "", "flag provided but not defined: -unknown", exitUsage},
+	}
+
+	for _, tt := range tests {
+		stdout, stderr, code := runCLI(t, tt.stdin, tt.args...)
+		if code != tt.code {
+			t.Errorf("cube %s: wrong exit code. got=%d, want=%d (stderr: %q)", strings.Join(tt.args, " "), code, tt.code, stderr)
+		}
+		if stdout != tt.stdout {
+			t.Errorf("cube %s: wrong stdout. got=%q, want=%q", strings.Join(tt.args, " "), stdout, tt.stdout)
+		}
+		if !strings.Contains(stderr, tt.stderr) || tt.stderr == "" && stderr != "" {
+			t.Errorf("cube %s: wrong stderr. got=%q, want=%q", strings.Join(tt.args, " "), stderr, tt.stderr)
+		}
+	}
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/AzraelSec/cube/pkg/coverage"
+	"github.com/AzraelSec/cube/pkg/testrunner"
+)
+
+func testCmd(args []string) int {
+	fs := flag.NewFlagSet("cube test", flag.ContinueOnError)
+	run := fs.String("run", "", "run only the tests whose name matches `regexp`")
+	parallel := fs.Int("parallel", runtime.NumCPU(), "number of test files run at the same time")
+	verbose := fs.Bool("v", false, "list every test, not only the failing ones")
+	junit := fs.String("junit", "", "write a JUnit XML report to `file`")
+	cover := fs.Bool("cover", false, "record the lines, branches and functions executed, and print the ratio of them")
+	coverDir := fs.String("coverdir", "coverage", "write the LCOV and HTML coverage reports to `dir`")
+	fs.BoolVar(&strictMode, "strict", strictMode, "disable implicit conversions")
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: cube test [flags] [files or directories...]")
+		fmt.Fprintf(os.Stderr, "\truns the tests of the *%s files, dir/... standing for dir and its subdirectories\n", testrunner.Suffix)
+		fs.PrintDefaults()
+	}
+
+	if err := fs.Parse(args); err != nil {
+		return exitUsage
+	}
+
+	opts := testrunner.Options{Parallel: *parallel, NoStrict: !strictMode}
+	if *run != "" {
+		re, err := regexp.Compile(*run)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "invalid -run: %v\n", err)
+			return exitUsage
+		}
+		opts.Run = re
+	}
+	if *cover {
+		opts.Coverage = coverage.New()
+	}
+
+	paths, err := testrunner.Find(fs.Args())
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitIOError
+	}
+	if len(paths) == 0 {
+		fmt.Println("no test files")
+		return exitOK
+	}
+
+	files := testrunner.Run(paths, opts)
+	code := exitOK
+	for _, f := range files {
+		printTestFile(os.Stdout, f, *verbose)
+		if f.Failed() {
+			code = exitRuntimeError
+		}
+	}
+
+	if *junit != "" {
+		err := writeFile(*junit, func(w io.Writer) error { return testrunner.WriteJUnit(w, files) })
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "impossible to write the JUnit report %s: %v\n", *junit, err)
+			return exitIOError
+		}
+	}
+	if opts.Coverage != nil {
+		covered := opts.Coverage.Files()
+		if err := writeCoverage(*coverDir, covered); err != nil {
+			fmt.Fprintf(os.Stderr, "impossible to write the coverage reports: %v\n", err)
+			return exitIOError
+		}
+		coverage.Summary(os.Stdout, covered)
+	}
+	return code
+}
+
+// printTestFile reports the outcome of a file in the style of go test.
+func printTestFile(w io.Writer, f testrunner.File, verbose bool) {
+	if f.Error != "" {
+		fmt.Fprintf(w, "--- ERROR: %s\n", f.Path)
+		fmt.Fprint(w, indent(f.Error))
+	}
+	for _, t := range f.Tests {
+		switch {
+		case t.Failure != "":
+			fmt.Fprintf(w, "--- FAIL: %s (%s)\n", t.Name, testSeconds(t.Duration))
+			fmt.Fprint(w, indent(t.Failure))
+		case verbose:
+			fmt.Fprintf(w, "--- PASS: %s (%s)\n", t.Name, testSeconds(t.Duration))
+		}
+	}
+
+	status, note := "ok  ", ""
+	if f.Failed() {
+		status = "FAIL"
+	} else if len(f.Tests) == 0 {
+		note = " [no tests to run]"
+	}
+	fmt.Fprintf(w, "%s\t%s\t%s%s\n", status, f.Path, testSeconds(f.Duration), note)
+}
+
+func indent(msg string) string {
+	var b strings.Builder
+	for _, line := range strings.Split(msg, "\n") {
+		fmt.Fprintf(&b, "    %s\n", line)
+	}
+	return b.String()
+}
+
+func testSeconds(d time.Duration) string {
+	return fmt.Sprintf("%.3fs", d.Seconds())
+}
@@ -1,54 +1,30 @@
 package main
 
 import (
-	"bufio"
-	"fmt"
 	"io"
 	"os"
+	"path/filepath"
 
-	"github.com/AzraelSec/cube/pkg/evaluator"
-	"github.com/AzraelSec/cube/pkg/lexer"
-	"github.com/AzraelSec/cube/pkg/object"
-	"github.com/AzraelSec/cube/pkg/parser"
+	"github.com/AzraelSec/cube/pkg/repl"
+	"golang.org/x/term"
 )
 
-const prompt = ">>"
+const historyFileName = ".cube_history"
 
 func main() {
-	start(os.Stdin, os.Stdout)
-}
-
-func start(in io.Reader, out io.Writer) {
-	scanner := bufio.NewScanner(os.Stdin)
-	env := object.NewEnvironment()
-
-	for {
-		fmt.Print(prompt)
-		sn := scanner.Scan()
-		if !sn {
-			return
-		}
-
-		line := scanner.Text()
-		l := lexer.New(line)
-		p := parser.New(l)
-
-		prog := p.ParseProgram()
-		if len(p.Errors()) != 0 {
-			printParserErrors(out, p.Errors())
-			continue
-		}
-
-		evaluated := evaluator.Eval(prog, env)
-		if evaluated != nil {
-			io.WriteString(out, evaluated.Inspect())
-			io.WriteString(out, "\n")
-		}
+	fd := int(os.Stdin.Fd())
+	if !term.IsTerminal(fd) {
+		os.Exit(repl.Start(os.Stdin, os.Stdout))
 	}
-}
 
-func printParserErrors(out io.Writer, errors []string) {
-	for _, msg := range errors {
-		io.WriteString(out, "\t"+msg+"\n")
+	historyFile := ""
+	if home, err := os.UserHomeDir(); err == nil {
+		historyFile = filepath.Join(home, historyFileName)
 	}
+
+	rw := struct {
+		io.Reader
+		io.Writer
+	}{os.Stdin, os.Stdout}
+	os.Exit(repl.StartTerminal(rw, fd, historyFile))
 }
@@ -1,3 +1,10 @@
 module github.com/AzraelSec/cube
 
 go 1.20
+
+require (
+	github.com/creack/pty v1.1.24
+	golang.org/x/term v0.29.0
+)
+
+require golang.org/x/sys v0.30.0 // indirect
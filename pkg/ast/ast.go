@@ -25,8 +25,9 @@ type Expression interface {
 
 // Expressions
 type Identifier struct {
-	Token token.Token // token.IDENT token
-	Value string
+	Token   token.Token // token.IDENT token
+	Value   string
+	Binding Binding `ast:"-"` // set by the resolver
 }
 
 func (*Identifier) expressionNode()        {}
@@ -63,6 +64,7 @@ func (s *StringLiteral) String() string       { return s.Token.Literal }
 type ArrayLiteral struct {
 	Token    token.Token // token.LBRACKET
 	Elements []Expression
+	End      token.Token // token.RBRACKET
 }
 
 func (*ArrayLiteral) expressionNode()         {}
@@ -103,9 +105,15 @@ func (ie *IndexExpression) String() string {
 	return buff.String()
 }
 
+type HashPair struct {
+	Key   Expression
+	Value Expression
+}
+
 type HashLiteral struct {
-	Token   token.Token // token.LBRACE
-	Content map[Expression]Expression
+	Token token.Token // token.LBRACE
+	Pairs []HashPair  // note: kept in source order
+	End   token.Token // token.RBRACE
 }
 
 func (*HashLiteral) expressionNode()         {}
@@ -113,9 +121,9 @@ func (hl *HashLiteral) TokenLiteral() string { return hl.Token.Literal }
 func (hl *HashLiteral) String() string {
 	var buff bytes.Buffer
 
-	elems := []string{}
-	for key, val := range hl.Content {
-		elems = append(elems, fmt.Sprintf("%s:%s", key, val))
+	elems := make([]string, len(hl.Pairs))
+	for idx, pair := range hl.Pairs {
+		elems[idx] = fmt.Sprintf("%s:%s", pair.Key, pair.Value)
 	}
 
 	buff.WriteString("{")
@@ -150,6 +158,38 @@ func (ie *IfExpression) String() string {
 	return buff.String()
 }
 
+// MatchExpression evaluates to the value of the first arm whose pattern
+// matches the subject and whose guard, if any, holds.
+type MatchExpression struct {
+	Token   token.Token // token.MATCH
+	Subject Expression
+	Arms    []MatchArm
+	End     token.Token // token.RBRACE
+}
+
+// MatchArm is an arm of a match expression, `pattern if guard => value`.
+// The variables it binds are only visible in its guard and value.
+type MatchArm struct {
+	Pattern Pattern
+	Guard   Expression // nil if there's none
+	Value   Expression
+	Slots   int // number of variables of its scope, set by the resolver
+}
+
+func (*MatchExpression) expressionNode()         {}
+func (me *MatchExpression) TokenLiteral() string { return me.Token.Literal }
+func (me *MatchExpression) String() string {
+	arms := make([]string, len(me.Arms))
+	for idx, arm := range me.Arms {
+		arms[idx] = arm.Pattern.String()
+		if arm.Guard != nil {
+			arms[idx] += " if " + arm.Guard.String()
+		}
+		arms[idx] += " => " + arm.Value.String()
+	}
+	return "match (" + me.Subject.String() + ") { " + strings.Join(arms, ", ") + " }"
+}
+
 type PrefixExpression struct {
 	Token    token.Token // token.BANG, token.MINUS
 	Operator string
@@ -193,7 +233,15 @@ func (ie *InfixExpression) String() string {
 type FunctionLiteral struct {
 	Token      token.Token // token.FUNC
 	Parameters []*Identifier
+	ParamTypes []TypeExpression // one per parameter, nil if not annotated
+	Defaults   []Expression     // one per parameter, nil if it's required
+	Patterns   []Pattern        // one per parameter, nil unless it's destructured, the parameter being named after it
+	Rest       bool             // whether the last parameter collects the remaining arguments
+	ReturnType TypeExpression   // nil if not annotated
 	Body       *BlockStatement
+	Doc        string `ast:"-"` // text of the /// comments before it, or before its let
+	Name       string `ast:"-"` // name it's declared with, or of its let, empty if anonymous
+	Slots      int    `ast:"-"` // number of local variables, set by the resolver
 }
 
 func (*FunctionLiteral) expressionNode()         {}
@@ -202,23 +250,93 @@ func (fl *FunctionLiteral) String() string {
 	var buff bytes.Buffer
 
 	params := []string{}
-	for _, p := range fl.Parameters {
-		params = append(params, p.String())
+	for idx := range fl.Parameters {
+		params = append(params, fl.Param(idx))
 	}
 
 	buff.WriteString(fl.TokenLiteral())
 	buff.WriteString("(")
 	buff.WriteString(strings.Join(params, ", "))
 	buff.WriteString(")")
+	if fl.ReturnType != nil {
+		buff.WriteString(": ")
+		buff.WriteString(fl.ReturnType.String())
+	}
 	buff.WriteString(fl.Body.String())
 
 	return buff.String()
 }
 
+// Param returns the source form of the parameter at idx, along with its
+// annotation and default value.
+func (fl *FunctionLiteral) Param(idx int) string {
+	param := fl.Parameters[idx].String()
+	if fl.IsRest(idx) {
+		param = "..." + param
+	}
+	if idx < len(fl.ParamTypes) && fl.ParamTypes[idx] != nil {
+		param += ": " + fl.ParamTypes[idx].String()
+	}
+	if def := fl.Default(idx); def != nil {
+		param += " = " + def.String()
+	}
+	return param
+}
+
+// Default returns the default value of the parameter at idx, nil if it's
+// required.
+func (fl *FunctionLiteral) Default(idx int) Expression {
+	if idx < len(fl.Defaults) {
+		return fl.Defaults[idx]
+	}
+	return nil
+}
+
+// Pattern returns the pattern destructuring the parameter at idx, nil if
+// there's none.
+func (fl *FunctionLiteral) Pattern(idx int) Pattern {
+	if idx < len(fl.Patterns) {
+		return fl.Patterns[idx]
+	}
+	return nil
+}
+
+// Names returns the identifiers the parameters bind, the ones of their
+// patterns for the destructured ones.
+func (fl *FunctionLiteral) Names() []*Identifier {
+	names := []*Identifier{}
+	for idx, param := range fl.Parameters {
+		if pattern := fl.Pattern(idx); pattern != nil {
+			names = append(names, Names(pattern)...)
+			continue
+		}
+		names = append(names, param)
+	}
+	return names
+}
+
+// IsRest reports whether the parameter at idx is the rest parameter.
+func (fl *FunctionLiteral) IsRest(idx int) bool {
+	return fl.Rest && idx == len(fl.Parameters)-1
+}
+
+// Required returns the number of arguments a call must pass: the parameters
+// with no default value, which come first, and not the rest one.
+func (fl *FunctionLiteral) Required() int {
+	required := 0
+	for idx := range fl.Parameters {
+		if fl.Default(idx) == nil && !fl.IsRest(idx) {
+			required++
+		}
+	}
+	return required
+}
+
 type CallExpression struct {
 	Token    token.Token // token.LPAREN
 	Function Expression  // Identifier || FunctionLiteral
 	Args     []Expression
+	End      token.Token // token.RPAREN
 }
 
 func (*CallExpression) expressionNode()         {}
@@ -239,11 +357,49 @@ func (ce *CallExpression) String() string {
 	return buff.String()
 }
 
+// SpreadExpression passes the elements of an array as separate arguments
+// of a call, e.g. f(...xs).
+type SpreadExpression struct {
+	Token token.Token // token.ELLIPSIS
+	Value Expression
+}
+
+func (*SpreadExpression) expressionNode()         {}
+func (se *SpreadExpression) TokenLiteral() string { return se.Token.Literal }
+func (se *SpreadExpression) String() string {
+	return se.TokenLiteral() + se.Value.String()
+}
+
+// KeywordArgument passes an argument of a call to the parameter it names,
+// e.g. f(b: 2).
+type KeywordArgument struct {
+	Token token.Token // token.IDENT, the name of the parameter
+	Name  string
+	Value Expression
+}
+
+func (*KeywordArgument) expressionNode()         {}
+func (ka *KeywordArgument) TokenLiteral() string { return ka.Token.Literal }
+func (ka *KeywordArgument) String() string {
+	return ka.Name + ": " + ka.Value.String()
+}
+
 // Statements
 type LetStatement struct {
-	Token token.Token // token.TOKEN token
-	Name  *Identifier
-	Value Expression
+	Token   token.Token // token.TOKEN token
+	Name    *Identifier
+	Pattern Pattern        // set instead of Name when destructuring
+	Type    TypeExpression // nil if not annotated
+	Value   Expression
+	Doc     string `ast:"-"` // text of the /// comments before it
+}
+
+// Names returns the identifiers the let binds.
+func (ls *LetStatement) Names() []*Identifier {
+	if ls.Pattern != nil {
+		return Names(ls.Pattern)
+	}
+	return []*Identifier{ls.Name}
 }
 
 func (*LetStatement) statementNode()          {}
@@ -253,7 +409,15 @@ func (ls *LetStatement) String() string {
 
 	buff.WriteString(ls.TokenLiteral())
 	buff.WriteString(" ")
-	buff.WriteString(ls.Name.String())
+	if ls.Pattern != nil {
+		buff.WriteString(ls.Pattern.String())
+	} else {
+		buff.WriteString(ls.Name.String())
+	}
+	if ls.Type != nil {
+		buff.WriteString(": ")
+		buff.WriteString(ls.Type.String())
+	}
 	buff.WriteString(" = ")
 
 	if ls.Value != nil {
@@ -264,6 +428,21 @@ func (ls *LetStatement) String() string {
 	return buff.String()
 }
 
+// FunctionStatement declares a named function, which is bound before any
+// statement of its block runs, so that functions can call each other
+// regardless of their order.
+type FunctionStatement struct {
+	Token    token.Token // token.FUNCTION
+	Name     *Identifier
+	Function *FunctionLiteral
+}
+
+func (*FunctionStatement) statementNode()          {}
+func (fs *FunctionStatement) TokenLiteral() string { return fs.Token.Literal }
+func (fs *FunctionStatement) String() string {
+	return fs.TokenLiteral() + " " + fs.Name.String() + strings.TrimPrefix(fs.Function.String(), fs.Function.TokenLiteral())
+}
+
 type ReturnStatement struct {
 	Token    token.Token // token.RETURN
 	RetValue Expression
@@ -304,6 +483,7 @@ func (es *ExpressionStatement) String() string {
 type BlockStatement struct {
 	Token      token.Token // token.LBRACE
 	Statements []Statement
+	End        token.Token // token.RBRACE
 }
 
 func (*BlockStatement) statementNode()          {}
@@ -0,0 +1,25 @@
+package ast
+
+type Scope int
+
+const (
+	// Global names are looked up by name in the outermost environment, which
+	// is also where builtins are found
+	Global Scope = iota
+	// Local names live in a slot of the frame of a function call
+	Local
+)
+
+// Binding tells where the variable an identifier refers to is stored.
+type Binding struct {
+	Scope Scope
+	Depth int // number of enclosing functions to go through to reach the frame
+	Slot  int
+	// note: the parameter or the first let declaring the variable, nil for
+	// the globals defined outside of the program
+	Decl *Identifier
+	// Outer is the binding of the variable of the same name outside of the
+	// function, for the locals that may be read before their let runs: the
+	// identifier refers to it until they're set. nil if there's none.
+	Outer *Binding
+}
@@ -0,0 +1,74 @@
+package ast
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// Dump renders the tree rooted at node, one node per line, with children
+// indented under their parent and labelled with the field holding them.
+func Dump(node Node) string {
+	var buff bytes.Buffer
+	buff.WriteString(describe(reflect.ValueOf(node)))
+	buff.WriteString("\n")
+	dumpChildren(&buff, reflect.ValueOf(node), 1)
+	return buff.String()
+}
+
+var nodeType = reflect.TypeOf((*Node)(nil)).Elem()
+
+// describe returns the type name of the node followed by its scalar fields.
+func describe(v reflect.Value) string {
+	v = reflect.Indirect(v)
+	parts := []string{v.Type().Name()}
+
+	for i := 0; i < v.NumField(); i++ {
+		field, value := v.Type().Field(i), v.Field(i)
+		if !isNodeField(field) {
+			continue
+		}
+		switch value.Kind() {
+		case reflect.String:
+			parts = append(parts, fmt.Sprintf("%s=%q", field.Name, value.String()))
+		case reflect.Int, reflect.Int64, reflect.Bool:
+			parts = append(parts, fmt.Sprintf("%s=%v", field.Name, value.Interface()))
+		}
+	}
+	return strings.Join(parts, " ")
+}
+
+func dumpChildren(buff *bytes.Buffer, v reflect.Value, depth int) {
+	v = reflect.Indirect(v)
+	for i := 0; i < v.NumField(); i++ {
+		field, value := v.Type().Field(i), v.Field(i)
+		if isNodeField(field) {
+			dumpField(buff, field.Name, value, depth)
+		}
+	}
+}
+
+func dumpField(buff *bytes.Buffer, label string, value reflect.Value, depth int) {
+	indent := strings.Repeat("  ", depth)
+
+	switch {
+	case value.Kind() == reflect.Slice:
+		for idx := 0; idx < value.Len(); idx++ {
+			dumpField(buff, fmt.Sprintf("%s[%d]", label, idx), value.Index(idx), depth)
+		}
+	case value.Type().Implements(nodeType):
+		if isNilNode(value) {
+			return
+		}
+		if value.Kind() == reflect.Interface {
+			value = value.Elem()
+		}
+		fmt.Fprintf(buff, "%s%s: %s\n", indent, label, describe(value))
+		dumpChildren(buff, value, depth+1)
+	case value.Kind() == reflect.Struct:
+		// note: helper structs (e.g. hash pairs) only group other nodes
+		fmt.Fprintf(buff, "%s%s:\n", indent, label)
+		dumpChildren(buff, value, depth+1)
+	}
+}
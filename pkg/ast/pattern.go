@@ -0,0 +1,203 @@
+package ast
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/AzraelSec/cube/pkg/token"
+)
+
+// Pattern is what a let or a parameter binds a value to: an identifier, or
+// an array or hash pattern destructuring the value into several variables.
+type Pattern interface {
+	Node
+	patternNode()
+}
+
+func (*Identifier) patternNode() {}
+
+// PatternElement is an element of an array or hash pattern.
+type PatternElement struct {
+	Key     string // key of the value in hash patterns, empty in array ones
+	Target  Pattern
+	Default Expression // bound when the value is missing, nil if there's none
+}
+
+func (pe PatternElement) render(hash bool) string {
+	var res string
+	switch ident, ok := pe.Target.(*Identifier); {
+	case !hash:
+		res = pe.Target.String()
+	case ok && ident.Value == pe.Key:
+		// note: the shorthand {name} stands for {name: name}
+		res = pe.Key
+	default:
+		res = HashKey(pe.Key) + ": " + pe.Target.String()
+	}
+	if pe.Default != nil {
+		res += " = " + pe.Default.String()
+	}
+	return res
+}
+
+// HashKey renders key as written in hash patterns, quoting the ones that
+// aren't identifiers.
+func HashKey(key string) string {
+	if key == "" || token.LookupIdent(key) != token.IDENT {
+		return strconv.Quote(key)
+	}
+	for _, ch := range key {
+		if !('a' <= ch && ch <= 'z' || 'A' <= ch && ch <= 'Z' || ch == '_') {
+			return strconv.Quote(key)
+		}
+	}
+	return key
+}
+
+// ArrayPattern binds the elements of an array in order, e.g. [a, b, ...rest].
+type ArrayPattern struct {
+	Token    token.Token // token.LBRACKET
+	Elements []PatternElement
+	Rest     *Identifier // bound to the remaining elements, nil if there's none
+}
+
+func (*ArrayPattern) patternNode()            {}
+func (ap *ArrayPattern) TokenLiteral() string { return ap.Token.Literal }
+func (ap *ArrayPattern) String() string {
+	elems := []string{}
+	for _, elem := range ap.Elements {
+		elems = append(elems, elem.render(false))
+	}
+	if ap.Rest != nil {
+		elems = append(elems, "..."+ap.Rest.String())
+	}
+	return "[" + strings.Join(elems, ", ") + "]"
+}
+
+// HashPattern binds the values of a hash by their string keys, e.g.
+// {name, age: years, ...others}.
+type HashPattern struct {
+	Token    token.Token // token.LBRACE
+	Elements []PatternElement
+	Rest     *Identifier // bound to a hash of the remaining pairs, nil if there's none
+}
+
+func (*HashPattern) patternNode()            {}
+func (hp *HashPattern) TokenLiteral() string { return hp.Token.Literal }
+func (hp *HashPattern) String() string {
+	elems := []string{}
+	for _, elem := range hp.Elements {
+		elems = append(elems, elem.render(true))
+	}
+	if hp.Rest != nil {
+		elems = append(elems, "..."+hp.Rest.String())
+	}
+	return "{" + strings.Join(elems, ", ") + "}"
+}
+
+// The following patterns can fail to match, so they're only found in the
+// arms of match expressions.
+
+// WildcardPattern matches any value without binding it, written `_`.
+type WildcardPattern struct {
+	Token token.Token // token.IDENT
+}
+
+func (*WildcardPattern) patternNode()            {}
+func (wp *WildcardPattern) TokenLiteral() string { return wp.Token.Literal }
+func (wp *WildcardPattern) String() string       { return "_" }
+
+// LiteralPattern matches the values equal to an integer, string or boolean
+// literal.
+type LiteralPattern struct {
+	Token token.Token // the first token of the literal
+	Value Expression
+}
+
+func (*LiteralPattern) patternNode()            {}
+func (lp *LiteralPattern) TokenLiteral() string { return lp.Token.Literal }
+func (lp *LiteralPattern) String() string {
+	switch value := lp.Value.(type) {
+	case *StringLiteral:
+		return strconv.Quote(value.Value)
+	case *PrefixExpression:
+		return value.Operator + value.Right.String()
+	default:
+		return value.String()
+	}
+}
+
+// TypePattern matches the values of a type, binding them to its target,
+// an identifier or a wildcard, e.g. `n: int`.
+type TypePattern struct {
+	Target Pattern
+	Type   TypeExpression
+}
+
+func (*TypePattern) patternNode()            {}
+func (tp *TypePattern) TokenLiteral() string { return tp.Target.TokenLiteral() }
+func (tp *TypePattern) String() string       { return tp.Target.String() + ": " + tp.Type.String() }
+
+// Irrefutable reports whether p matches any value.
+func Irrefutable(p Pattern) bool {
+	switch p := p.(type) {
+	case *Identifier, *WildcardPattern:
+		return true
+	case *TypePattern:
+		name, ok := p.Type.(*TypeName)
+		return ok && name.Name == "any"
+	default:
+		return false
+	}
+}
+
+// Names returns the identifiers a pattern binds, in source order.
+func Names(p Pattern) []*Identifier {
+	switch p := p.(type) {
+	case *Identifier:
+		return []*Identifier{p}
+	case *ArrayPattern:
+		names := []*Identifier{}
+		for _, elem := range p.Elements {
+			names = append(names, Names(elem.Target)...)
+		}
+		if p.Rest != nil {
+			names = append(names, p.Rest)
+		}
+		return names
+	case *HashPattern:
+		names := []*Identifier{}
+		for _, elem := range p.Elements {
+			names = append(names, Names(elem.Target)...)
+		}
+		if p.Rest != nil {
+			names = append(names, p.Rest)
+		}
+		return names
+	case *TypePattern:
+		return Names(p.Target)
+	default:
+		return nil
+	}
+}
+
+// Defaults returns the default values of the elements of a pattern,
+// nested ones included, in source order.
+func Defaults(p Pattern) []Expression {
+	var elems []PatternElement
+	switch p := p.(type) {
+	case *ArrayPattern:
+		elems = p.Elements
+	case *HashPattern:
+		elems = p.Elements
+	}
+
+	defaults := []Expression{}
+	for _, elem := range elems {
+		defaults = append(defaults, Defaults(elem.Target)...)
+		if elem.Default != nil {
+			defaults = append(defaults, elem.Default)
+		}
+	}
+	return defaults
+}
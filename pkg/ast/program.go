@@ -4,6 +4,7 @@ import "bytes"
 
 type Program struct {
 	Statements []Statement
+	Resolved   bool `ast:"-"` // set by the resolver when it found no errors
 }
 
 func (p *Program) String() string {
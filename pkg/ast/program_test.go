@@ -1,6 +1,8 @@
 package ast
 
 import (
+	"reflect"
+	"strings"
 	"testing"
 
 	"github.com/AzraelSec/cube/pkg/token"
@@ -27,3 +29,66 @@ func TestString(t *testing.T) {
 		t.Errorf("program.String() wrong. got=%q", p.String())
 	}
 }
+
+func TestDump(t *testing.T) {
+	p := &Program{
+		Statements: []Statement{
+			&LetStatement{
+				Token: token.Token{Type: token.LET, Literal: "let"},
+				Name:  &Identifier{Token: token.Token{Type: token.IDENT, Literal: "x"}, Value: "x"},
+				Value: &InfixExpression{
+					Token:    token.Token{Type: token.PLUS, Literal: "+"},
+					Left:     &IntegerLiteral{Token: token.Token{Type: token.INT, Literal: "1"}, Value: 1},
+					Operator: "+",
+					Right: &HashLiteral{
+						Token: token.Token{Type: token.LBRACE, Literal: "{"},
+						Pairs: []HashPair{{
+							Key:   &StringLiteral{Token: token.Token{Type: token.STRING, Literal: "a"}, Value: "a"},
+							Value: &Boolean{Token: token.Token{Type: token.TRUE, Literal: "true"}, Value: true},
+						}},
+					},
+				},
+			},
+		},
+	}
+
+	expected := `Program
+  Statements[0]: LetStatement
+    Name: Identifier Value="x"
+    Value: InfixExpression Operator="+"
+      Left: IntegerLiteral Value=1
+      Right: HashLiteral
+        Pairs[0]:
+          Key: StringLiteral Value="a"
+          Value: Boolean Value=true
+`
+	if Dump(p) != expected {
+		t.Errorf("Dump() wrong. got=\n%s\nwant=\n%s", Dump(p), expected)
+	}
+}
+
+func TestInspect(t *testing.T) {
+	x := &Identifier{Token: token.Token{Type: token.IDENT, Literal: "x"}, Value: "x"}
+	one := &IntegerLiteral{Token: token.Token{Type: token.INT, Literal: "1"}, Value: 1}
+	fn := &FunctionLiteral{
+		Token:      token.Token{Type: token.FUNCTION, Literal: "fn"},
+		Parameters: []*Identifier{x},
+		Body: &BlockStatement{
+			Statements: []Statement{&ExpressionStatement{Expression: one}},
+		},
+	}
+	p := &Program{Statements: []Statement{&ExpressionStatement{Expression: fn}}}
+
+	visited := []string{}
+	Inspect(p, func(n Node) bool {
+		visited = append(visited, reflect.TypeOf(n).Elem().Name())
+		// note: skip the function body
+		_, isBlock := n.(*BlockStatement)
+		return !isBlock
+	})
+
+	expected := "Program ExpressionStatement FunctionLiteral Identifier BlockStatement"
+	if strings.Join(visited, " ") != expected {
+		t.Errorf("Inspect() wrong visit order. got=%q, want=%q", strings.Join(visited, " "), expected)
+	}
+}
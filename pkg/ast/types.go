@@ -0,0 +1,76 @@
+package ast
+
+import (
+	"bytes"
+	"strings"
+
+	"github.com/AzraelSec/cube/pkg/token"
+)
+
+// TypeExpression is a type annotation.
+type TypeExpression interface {
+	Node
+	typeNode()
+}
+
+type TypeName struct {
+	Token token.Token // token.IDENT
+	Name  string
+}
+
+func (*TypeName) typeNode()               {}
+func (tn *TypeName) TokenLiteral() string { return tn.Token.Literal }
+func (tn *TypeName) String() string       { return tn.Name }
+
+type ArrayType struct {
+	Token token.Token // token.LBRACKET
+	Elem  TypeExpression
+}
+
+func (*ArrayType) typeNode()               {}
+func (at *ArrayType) TokenLiteral() string { return at.Token.Literal }
+func (at *ArrayType) String() string       { return "[" + at.Elem.String() + "]" }
+
+type HashType struct {
+	Token token.Token // token.LBRACE
+	Key   TypeExpression
+	Value TypeExpression
+}
+
+func (*HashType) typeNode()               {}
+func (ht *HashType) TokenLiteral() string { return ht.Token.Literal }
+func (ht *HashType) String() string {
+	return "{" + ht.Key.String() + ": " + ht.Value.String() + "}"
+}
+
+type FunctionType struct {
+	Token  token.Token // token.FUNCTION
+	Params []TypeExpression
+	Rest   TypeExpression // type of the further arguments, nil if there are none
+	Return TypeExpression // nil if not annotated
+}
+
+func (*FunctionType) typeNode()               {}
+func (ft *FunctionType) TokenLiteral() string { return ft.Token.Literal }
+func (ft *FunctionType) String() string {
+	var buff bytes.Buffer
+
+	params := []string{}
+	for _, p := range ft.Params {
+		params = append(params, p.String())
+	}
+	if ft.Rest != nil {
+		params = append(params, "..."+ft.Rest.String())
+	}
+
+	buff.WriteString(ft.TokenLiteral())
+	buff.WriteString("(")
+	buff.WriteString(strings.Join(params, ", "))
+	buff.WriteString(")")
+	if ft.Return != nil {
+		buff.WriteString(": ")
+		buff.WriteString(ft.Return.String())
+	}
+
+	return buff.String()
+}
@@ -0,0 +1,99 @@
+package ast
+
+import (
+	"reflect"
+
+	"github.com/AzraelSec/cube/pkg/token"
+)
+
+// Inspect traverses the tree rooted at node in depth-first, source order:
+// f is called for each node and its children are only visited if it
+// returns true.
+func Inspect(node Node, f func(Node) bool) {
+	if isNilNode(reflect.ValueOf(node)) || !f(node) {
+		return
+	}
+	for _, child := range Children(node) {
+		Inspect(child, f)
+	}
+}
+
+// Children returns the direct child nodes of node, in source order.
+func Children(node Node) []Node {
+	children := []Node{}
+	v := reflect.Indirect(reflect.ValueOf(node))
+	for i := 0; i < v.NumField(); i++ {
+		if isNodeField(v.Type().Field(i)) {
+			children = collectNodes(children, v.Field(i))
+		}
+	}
+	return children
+}
+
+func collectNodes(nodes []Node, value reflect.Value) []Node {
+	switch {
+	case value.Kind() == reflect.Slice:
+		for idx := 0; idx < value.Len(); idx++ {
+			nodes = collectNodes(nodes, value.Index(idx))
+		}
+	case value.Type().Implements(nodeType):
+		if !isNilNode(value) {
+			nodes = append(nodes, value.Interface().(Node))
+		}
+	case value.Kind() == reflect.Struct:
+		for i := 0; i < value.NumField(); i++ {
+			if isNodeField(value.Type().Field(i)) {
+				nodes = collectNodes(nodes, value.Field(i))
+			}
+		}
+	}
+	return nodes
+}
+
+// note: fields tagged `ast:"-"` are annotations, like doc comments or what
+// later passes add
+func isNodeField(field reflect.StructField) bool {
+	return field.IsExported() && field.Name != "Token" && field.Name != "End" && field.Tag.Get("ast") != "-"
+}
+
+func isNilNode(value reflect.Value) bool {
+	if !value.IsValid() {
+		return true
+	}
+	if value.Kind() == reflect.Interface {
+		value = value.Elem()
+	}
+	return !value.IsValid() || value.Kind() == reflect.Pointer && value.IsNil()
+}
+
+// Start returns the leftmost token of node in the source, which infix, call
+// and index expressions don't keep as their own token.
+func Start(node Node) token.Token {
+	switch node := node.(type) {
+	case *Program:
+		if len(node.Statements) == 0 {
+			return token.Token{}
+		}
+		return Start(node.Statements[0])
+	case *ExpressionStatement:
+		if node.Expression == nil {
+			return node.Token
+		}
+		return Start(node.Expression)
+	case *InfixExpression:
+		return Start(node.Left)
+	case *CallExpression:
+		return Start(node.Function)
+	case *IndexExpression:
+		return Start(node.Left)
+	case *TypePattern:
+		return Start(node.Target)
+	}
+
+	if field := reflect.Indirect(reflect.ValueOf(node)).FieldByName("Token"); field.IsValid() {
+		if tok, ok := field.Interface().(token.Token); ok {
+			return tok
+		}
+	}
+	return token.Token{}
+}
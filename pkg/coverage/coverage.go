@@ -0,0 +1,232 @@
+// Package coverage records which statements, branches and functions of a
+// program are executed, writing the reports in the LCOV and HTML formats.
+package coverage
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+
+	"github.com/AzraelSec/cube/pkg/ast"
+	"github.com/AzraelSec/cube/pkg/object"
+)
+
+// Coverage is an object.Hook counting how many times the statements,
+// branches and functions of the programs added to it are executed. Programs
+// can run at the same time.
+type Coverage struct {
+	mu         sync.Mutex
+	files      []*source
+	statements map[ast.Statement]int64
+	branches   map[*ast.IfExpression]*[2]int64
+	functions  map[*ast.BlockStatement]int64
+}
+
+type source struct {
+	name, text string
+	statements []ast.Statement
+	ifs        []*ast.IfExpression
+	functions  []*ast.FunctionLiteral
+}
+
+func New() *Coverage {
+	return &Coverage{
+		statements: map[ast.Statement]int64{},
+		branches:   map[*ast.IfExpression]*[2]int64{},
+		functions:  map[*ast.BlockStatement]int64{},
+	}
+}
+
+// Add tracks prog, read from the file name whose content is text.
+func (c *Coverage) Add(prog *ast.Program, name, text string) {
+	src := &source{name: name, text: text}
+	ast.Inspect(prog, func(n ast.Node) bool {
+		switch n := n.(type) {
+		case *ast.BlockStatement, *ast.Program:
+			// note: their statements are the ones being executed
+		case ast.Statement:
+			src.statements = append(src.statements, n)
+		case *ast.IfExpression:
+			src.ifs = append(src.ifs, n)
+		case *ast.FunctionLiteral:
+			src.functions = append(src.functions, n)
+		}
+		return true
+	})
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, ie := range src.ifs {
+		c.branches[ie] = &[2]int64{}
+	}
+	c.files = append(c.files, src)
+}
+
+func (c *Coverage) Statement(stm ast.Statement, env *object.Environment) object.Object {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.statements[stm]++
+	return nil
+}
+
+func (c *Coverage) Call(call *ast.CallExpression, fn *object.Function, env *object.Environment) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.functions[fn.Body]++
+}
+
+func (c *Coverage) Return(*ast.CallExpression, object.Object) {}
+
+func (c *Coverage) Branch(ie *ast.IfExpression, taken bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	counts, ok := c.branches[ie]
+	if !ok {
+		return
+	}
+	if taken {
+		counts[0]++
+	} else {
+		counts[1]++
+	}
+}
+
+// File is the coverage of a file.
+type File struct {
+	Name      string
+	Source    string
+	Lines     []Line // the lines where statements start, in order
+	Branches  []Branch
+	Functions []Function
+}
+
+// Line is a line where statements start, Hits being the number of times the
+// most executed of them was, and Missed telling whether any of them never
+// was.
+type Line struct {
+	Number int
+	Hits   int64
+	Missed bool
+}
+
+// Branch is one of the two ways an if can go: Index 0 is its consequence, 1
+// its alternative, even if it has none. Block tells apart the ifs of a file,
+// and Reached whether the condition of the if was ever evaluated.
+type Branch struct {
+	Line    int
+	Block   int
+	Index   int
+	Hits    int64
+	Reached bool
+}
+
+// Function is a function of a file, with the name it's declared with or
+// assigned to, or anonymous:<line>.
+type Function struct {
+	Name string
+	Line int
+	Hits int64
+}
+
+// Files returns the coverage of the files added so far.
+func (c *Coverage) Files() []File {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	files := make([]File, 0, len(c.files))
+	for _, src := range c.files {
+		f := File{Name: src.name, Source: src.text}
+
+		lines := map[int]*Line{}
+		for _, stm := range src.statements {
+			number := ast.Start(stm).Line
+			l, ok := lines[number]
+			if !ok {
+				l = &Line{Number: number}
+				lines[number] = l
+			}
+			n := c.statements[stm]
+			if n > l.Hits {
+				l.Hits = n
+			}
+			l.Missed = l.Missed || n == 0
+		}
+		for _, l := range lines {
+			f.Lines = append(f.Lines, *l)
+		}
+		sort.Slice(f.Lines, func(i, j int) bool { return f.Lines[i].Number < f.Lines[j].Number })
+
+		for block, ie := range src.ifs {
+			counts := c.branches[ie]
+			reached := counts[0]+counts[1] > 0
+			for idx, n := range counts {
+				f.Branches = append(f.Branches, Branch{Line: ie.Token.Line, Block: block, Index: idx, Hits: n, Reached: reached})
+			}
+		}
+
+		for _, fl := range src.functions {
+			name := fl.Name
+			if name == "" {
+				name = fmt.Sprintf("anonymous:%d", fl.Token.Line)
+			}
+			f.Functions = append(f.Functions, Function{Name: name, Line: fl.Token.Line, Hits: c.functions[fl.Body]})
+		}
+
+		files = append(files, f)
+	}
+	return files
+}
+
+// Ratio is the number of items executed out of Total.
+type Ratio struct {
+	Hit, Total int
+}
+
+func (r Ratio) String() string {
+	if r.Total == 0 {
+		return "-"
+	}
+	return fmt.Sprintf("%.1f%% (%d/%d)", 100*float64(r.Hit)/float64(r.Total), r.Hit, r.Total)
+}
+
+func (r Ratio) add(hit bool) Ratio {
+	r.Total++
+	if hit {
+		r.Hit++
+	}
+	return r
+}
+
+func (f File) LineRatio() Ratio {
+	var r Ratio
+	for _, l := range f.Lines {
+		r = r.add(l.Hits > 0)
+	}
+	return r
+}
+
+func (f File) BranchRatio() Ratio {
+	var r Ratio
+	for _, b := range f.Branches {
+		r = r.add(b.Hits > 0)
+	}
+	return r
+}
+
+func (f File) FunctionRatio() Ratio {
+	var r Ratio
+	for _, fn := range f.Functions {
+		r = r.add(fn.Hits > 0)
+	}
+	return r
+}
+
+// Summary writes the ratio of lines, branches and functions executed in each
+// of the files.
+func Summary(w io.Writer, files []File) {
+	for _, f := range files {
+		fmt.Fprintf(w, "coverage: %s: lines %s, branches %s, functions %s\n",
+			f.Name, f.LineRatio(), f.BranchRatio(), f.FunctionRatio())
+	}
+}
@@ -0,0 +1,135 @@
+package coverage
+
+import (
+	"bytes"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/AzraelSec/cube/pkg/evaluator"
+	"github.com/AzraelSec/cube/pkg/lexer"
+	"github.com/AzraelSec/cube/pkg/object"
+	"github.com/AzraelSec/cube/pkg/parser"
+)
+
+const program = `let classify = fn(n) {
+    if (n < 0) { return "negative"; }
+    if (n == 0) {
+        "zero"
+    } else {
+        "positive"
+    }
+};
+let never = fn(n) { if (n) { 1 } };
+classify(3);
+classify(5);
+fn() { 0 }()`
+
+func TestWriteLCOV(t *testing.T) {
+	var out bytes.Buffer
+	if err := WriteLCOV(&out, cover(t, program)); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := `TN:
+SF:test.cb
+FN:1,classify
+FN:9,never
+FN:12,anonymous:12
+FNDA:2,classify
+FNDA:0,never
+FNDA:1,anonymous:12
+FNF:3
+FNH:2
+BRDA:2,0,0,0
+BRDA:2,0,1,2
+BRDA:3,1,0,0
+BRDA:3,1,1,2
+BRDA:9,2,0,-
+BRDA:9,2,1,-
+BRF:6
+BRH:2
+DA:1,1
+DA:2,2
+DA:3,2
+DA:4,0
+DA:6,2
+DA:9,1
+DA:10,1
+DA:11,1
+DA:12,1
+LF:9
+LH:8
+end_of_record
+`
+	if out.String() != expected {
+		t.Errorf("wrong tracefile.\ngot:\n%s\nwant:\n%s", out.String(), expected)
+	}
+}
+
+func TestWriteHTML(t *testing.T) {
+	var out bytes.Buffer
+	if err := WriteHTML(&out, cover(t, program)); err != nil {
+		t.Fatal(err)
+	}
+
+	row := regexp.MustCompile(`<tr(?: class="(\w+)")?><td class="number">(\d+)</td><td class="hits">(\d*)</td><td class="code">(.*)</td></tr>`)
+	got := []string{}
+	for _, m := range row.FindAllStringSubmatch(out.String(), -1) {
+		got = append(got, strings.TrimSpace(strings.Join(m[1:], " ")))
+	}
+
+	expected := []string{
+		`hit 1 1 let classify = fn(n) {`,
+		`partial 2 2     if (n &lt; 0) { return &#34;negative&#34;; }`,
+		`partial 3 2     if (n == 0) {`,
+		`miss 4 0         &#34;zero&#34;`,
+		`5      } else {`,
+		`hit 6 2         &#34;positive&#34;`,
+		`7      }`,
+		`8  };`,
+		`partial 9 1 let never = fn(n) { if (n) { 1 } };`,
+		`hit 10 1 classify(3);`,
+		`hit 11 1 classify(5);`,
+		`hit 12 1 fn() { 0 }()`,
+	}
+	if strings.Join(got, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("wrong rows.\ngot:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(expected, "\n"))
+	}
+
+	summary := `<td><a href="#file0">test.cb</a></td><td>88.9% (8/9)</td><td>33.3% (2/6)</td><td>66.7% (2/3)</td>`
+	if !strings.Contains(out.String(), summary) {
+		t.Errorf("missing summary %q", summary)
+	}
+}
+
+func TestSummary(t *testing.T) {
+	var out bytes.Buffer
+	Summary(&out, cover(t, program))
+
+	expected := "coverage: test.cb: lines 88.9% (8/9), branches 33.3% (2/6), functions 66.7% (2/3)\n"
+	if out.String() != expected {
+		t.Errorf("wrong summary. got=%q, want=%q", out.String(), expected)
+	}
+}
+
+func cover(t *testing.T, input string) []File {
+	t.Helper()
+	p := parser.New(lexer.New(input))
+	prog := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		t.Fatalf("unexpected parse errors %v", p.Errors())
+	}
+	env := object.NewEnvironment()
+	if errs := evaluator.Resolve(prog, env); len(errs) != 0 {
+		t.Fatalf("unexpected resolve errors %v", errs)
+	}
+
+	c := New()
+	c.Add(prog, "test.cb", input)
+	env.Runtime().Hook = c
+	if res, ok := evaluator.Eval(prog, env).(*object.Error); ok {
+		t.Fatalf("unexpected error %s", res.Inspect())
+	}
+	return c.Files()
+}
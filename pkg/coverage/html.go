@@ -0,0 +1,100 @@
+package coverage
+
+import (
+	"html/template"
+	"io"
+	"strconv"
+	"strings"
+)
+
+var report = template.Must(template.New("report").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Coverage report</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+table.summary td, table.summary th { padding: 0.2em 1em; text-align: left; }
+table.source { border-collapse: collapse; font-family: monospace; width: 100%; }
+table.source td { padding: 0 0.5em; white-space: pre; vertical-align: top; }
+td.number, td.hits { color: #888; text-align: right; width: 1%; }
+tr.hit td.code { background: #dfd; }
+tr.miss td.code { background: #fdd; }
+tr.partial td.code { background: #ffc; }
+</style>
+</head>
+<body>
+<h1>Coverage report</h1>
+<table class="summary">
+<tr><th>File</th><th>Lines</th><th>Branches</th><th>Functions</th></tr>
+{{- range $idx, $f := .}}
+<tr><td><a href="#file{{$idx}}">{{$f.Name}}</a></td><td>{{$f.LineRatio}}</td><td>{{$f.BranchRatio}}</td><td>{{$f.FunctionRatio}}</td></tr>
+{{- end}}
+</table>
+{{- range $idx, $f := .}}
+<h2 id="file{{$idx}}">{{$f.Name}}</h2>
+<table class="source">
+{{- range $f.Rows}}
+<tr{{with .Class}} class="{{.}}"{{end}}><td class="number">{{.Number}}</td><td class="hits">{{.Hits}}</td><td class="code">{{.Text}}</td></tr>
+{{- end}}
+</table>
+{{- end}}
+</body>
+</html>
+`))
+
+type row struct {
+	Number int
+	Hits   string
+	Text   string
+	Class  string // hit, miss, partial, or none for lines with no statements
+}
+
+type htmlFile struct {
+	File
+	Rows []row
+}
+
+// WriteHTML writes a report with the source of each of the files,
+// highlighting the lines that were executed, the ones that were not and the
+// ones with branches that were not taken.
+func WriteHTML(w io.Writer, files []File) error {
+	data := make([]htmlFile, len(files))
+	for idx, f := range files {
+		data[idx] = htmlFile{File: f, Rows: rows(f)}
+	}
+	return report.Execute(w, data)
+}
+
+func rows(f File) []row {
+	lines := strings.Split(strings.TrimSuffix(f.Source, "\n"), "\n")
+	rows := make([]row, len(lines))
+	for idx, text := range lines {
+		rows[idx] = row{Number: idx + 1, Text: strings.TrimRight(text, "\r")}
+	}
+
+	for _, l := range f.Lines {
+		if l.Number < 1 || l.Number > len(rows) {
+			continue
+		}
+		r := &rows[l.Number-1]
+		r.Hits = strconv.FormatInt(l.Hits, 10)
+		switch {
+		case l.Hits == 0:
+			r.Class = "miss"
+		case l.Missed:
+			r.Class = "partial"
+		default:
+			r.Class = "hit"
+		}
+	}
+	for _, b := range f.Branches {
+		if b.Line < 1 || b.Line > len(rows) {
+			continue
+		}
+		if r := &rows[b.Line-1]; r.Class == "hit" && b.Hits == 0 {
+			r.Class = "partial"
+		}
+	}
+	return rows
+}
@@ -0,0 +1,48 @@
+package coverage
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// WriteLCOV writes a record for each of the files in the LCOV tracefile
+// format, read by genhtml and most coverage services.
+func WriteLCOV(w io.Writer, files []File) error {
+	var b strings.Builder
+	for _, f := range files {
+		b.WriteString("TN:\n")
+		fmt.Fprintf(&b, "SF:%s\n", f.Name)
+
+		for _, fn := range f.Functions {
+			fmt.Fprintf(&b, "FN:%d,%s\n", fn.Line, fn.Name)
+		}
+		for _, fn := range f.Functions {
+			fmt.Fprintf(&b, "FNDA:%d,%s\n", fn.Hits, fn.Name)
+		}
+		r := f.FunctionRatio()
+		fmt.Fprintf(&b, "FNF:%d\nFNH:%d\n", r.Total, r.Hit)
+
+		for _, br := range f.Branches {
+			// note: a dash tells that the condition was never evaluated
+			taken := "-"
+			if br.Reached {
+				taken = fmt.Sprint(br.Hits)
+			}
+			fmt.Fprintf(&b, "BRDA:%d,%d,%d,%s\n", br.Line, br.Block, br.Index, taken)
+		}
+		r = f.BranchRatio()
+		fmt.Fprintf(&b, "BRF:%d\nBRH:%d\n", r.Total, r.Hit)
+
+		for _, l := range f.Lines {
+			fmt.Fprintf(&b, "DA:%d,%d\n", l.Number, l.Hits)
+		}
+		r = f.LineRatio()
+		fmt.Fprintf(&b, "LF:%d\nLH:%d\n", r.Total, r.Hit)
+
+		b.WriteString("end_of_record\n")
+	}
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
@@ -0,0 +1,476 @@
+// Package dap implements a Debug Adapter Protocol server, letting editors
+// debug Cube programs through the debugger package.
+package dap
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/AzraelSec/cube/pkg/ast"
+	"github.com/AzraelSec/cube/pkg/debugger"
+	"github.com/AzraelSec/cube/pkg/evaluator"
+	"github.com/AzraelSec/cube/pkg/framing"
+	"github.com/AzraelSec/cube/pkg/lexer"
+	"github.com/AzraelSec/cube/pkg/object"
+	"github.com/AzraelSec/cube/pkg/parser"
+	"github.com/AzraelSec/cube/pkg/types"
+)
+
+// note: programs are single threaded
+const threadID = 1
+
+type server struct {
+	out    *framing.Writer
+	logger *log.Logger
+
+	wmu sync.Mutex // keeps the messages in the order of their seq
+	seq int
+
+	launch LaunchArguments
+	prog   *ast.Program
+	env    *object.Environment
+	d      *debugger.Debugger
+
+	mu      sync.Mutex
+	paused  bool
+	resume  chan debugger.Action
+	started bool
+	done    chan struct{} // closed once the program ends
+
+	// note: the variables handed out since the program stopped, a
+	// variablesReference is an index in it plus one
+	refs [][]debugger.Variable
+	// next runs after the response to the current request is sent
+	next func()
+	quit bool
+}
+
+type handler func(s *server, args json.RawMessage) (interface{}, error)
+
+var handlers map[string]handler
+
+func init() {
+	handlers = map[string]handler{
+		"initialize":        (*server).initialize,
+		"launch":            (*server).launchProgram,
+		"setBreakpoints":    (*server).setBreakpoints,
+		"configurationDone": (*server).configurationDone,
+		"threads":           (*server).threads,
+		"stackTrace":        (*server).stackTrace,
+		"scopes":            (*server).scopes,
+		"variables":         (*server).variables,
+		"evaluate":          (*server).evaluate,
+		"continue":          resumeWith(debugger.Continue),
+		"next":              resumeWith(debugger.StepOver),
+		"stepIn":            resumeWith(debugger.StepIn),
+		"stepOut":           resumeWith(debugger.StepOut),
+		"pause":             (*server).pause,
+		"terminate":         (*server).terminate,
+		"disconnect":        (*server).disconnect,
+	}
+}
+
+// Serve speaks the protocol over in and out until the client disconnects,
+// running the program it launches. Problems with the connection are logged
+// to logs.
+func Serve(in io.Reader, out io.Writer, logs io.Writer) int {
+	s := &server{
+		out:    framing.NewWriter(out),
+		logger: log.New(logs, "cube dap: ", 0),
+		resume: make(chan debugger.Action, 1),
+		done:   make(chan struct{}),
+	}
+
+	r := framing.NewReader(in)
+	for !s.quit {
+		payload, err := r.Read()
+		if err != nil {
+			if err != io.EOF {
+				s.logger.Println(err)
+			}
+			break
+		}
+		s.handle(payload)
+	}
+
+	s.stop()
+	return 0
+}
+
+func (s *server) handle(payload []byte) {
+	var req request
+	if err := json.Unmarshal(payload, &req); err != nil {
+		s.logger.Printf("invalid message: %v", err)
+		return
+	}
+	if req.Type != "request" {
+		return
+	}
+
+	resp := response{Type: "response", RequestSeq: req.Seq, Command: req.Command, Success: true}
+	if h, ok := handlers[req.Command]; ok {
+		body, err := h(s, req.Arguments)
+		if err != nil {
+			resp.Success, resp.Message = false, err.Error()
+		}
+		resp.Body = body
+	} else {
+		resp.Success, resp.Message = false, "unsupported command "+req.Command
+	}
+	s.send(&resp.Seq, resp)
+
+	if next := s.next; next != nil {
+		s.next = nil
+		next()
+	}
+}
+
+func (s *server) send(seq *int, msg interface{}) {
+	s.wmu.Lock()
+	defer s.wmu.Unlock()
+
+	s.seq++
+	*seq = s.seq
+	if err := s.out.Write(msg); err != nil {
+		s.logger.Println(err)
+	}
+}
+
+func (s *server) event(name string, body interface{}) {
+	ev := event{Type: "event", Event: name, Body: body}
+	s.send(&ev.Seq, ev)
+}
+
+func (s *server) initialize(json.RawMessage) (interface{}, error) {
+	return Capabilities{
+		SupportsConfigurationDoneRequest: true,
+		SupportsTerminateRequest:         true,
+		SupportsEvaluateForHovers:        true,
+	}, nil
+}
+
+// launchProgram loads the program, which runs once the configuration is
+// done. The initialized event is only sent then, so that breakpoints can be
+// checked against the program.
+func (s *server) launchProgram(raw json.RawMessage) (interface{}, error) {
+	if s.prog != nil {
+		return nil, fmt.Errorf("a program was already launched")
+	}
+	if err := json.Unmarshal(raw, &s.launch); err != nil {
+		return nil, err
+	}
+
+	content, err := os.ReadFile(s.launch.Program)
+	if err != nil {
+		return nil, fmt.Errorf("impossible to read the file %s: %v", s.launch.Program, err)
+	}
+
+	p := parser.New(lexer.New(string(content)))
+	prog := p.ParseProgram()
+	if errs := p.Errors(); len(errs) != 0 {
+		return nil, fmt.Errorf("%s: parse errors: %s", s.launch.Program, strings.Join(errs, "; "))
+	}
+
+	env := object.NewEnvironment()
+	env.Runtime().Strict = !s.launch.NoStrict
+	args := make([]object.Object, len(s.launch.Args))
+	for idx, arg := range s.launch.Args {
+		args[idx] = &object.String{Value: arg}
+	}
+	env.Set("args", &object.Array{Elements: args})
+
+	errs := evaluator.Resolve(prog, env)
+	if len(errs) == 0 {
+		errs = types.Config{Strict: !s.launch.NoStrict}.Check(prog, types.Globals(env), nil)
+	}
+	if len(errs) != 0 {
+		return nil, fmt.Errorf("%s: errors: %s", s.launch.Program, strings.Join(parser.ErrorStrings(errs), "; "))
+	}
+
+	s.prog, s.env = prog, env
+	s.d = debugger.New(prog)
+	s.d.Stopped = s.stopped
+	s.d.StopOnEntry = s.launch.StopOnEntry && !s.launch.NoDebug
+	s.next = func() { s.event("initialized", nil) }
+	return nil, nil
+}
+
+func (s *server) setBreakpoints(raw json.RawMessage) (interface{}, error) {
+	var args SetBreakpointsArguments
+	if err := json.Unmarshal(raw, &args); err != nil {
+		return nil, err
+	}
+	if s.d == nil {
+		return nil, fmt.Errorf("no program was launched")
+	}
+
+	breakpoints := make([]Breakpoint, len(args.Breakpoints))
+	if !samePath(args.Source.Path, s.launch.Program) {
+		for idx, bp := range args.Breakpoints {
+			breakpoints[idx] = Breakpoint{Line: bp.Line, Message: "not the program being debugged"}
+		}
+		return map[string]interface{}{"breakpoints": breakpoints}, nil
+	}
+
+	lines := make([]int, len(args.Breakpoints))
+	for idx, bp := range args.Breakpoints {
+		lines[idx] = bp.Line
+	}
+	for idx, verified := range s.d.SetBreakpoints(lines) {
+		breakpoints[idx] = Breakpoint{Verified: verified, Line: lines[idx]}
+		if !verified {
+			breakpoints[idx].Message = "no statement starts at this line"
+		}
+	}
+	return map[string]interface{}{"breakpoints": breakpoints}, nil
+}
+
+func samePath(a, b string) bool {
+	absA, errA := filepath.Abs(a)
+	absB, errB := filepath.Abs(b)
+	return errA == nil && errB == nil && absA == absB
+}
+
+func (s *server) configurationDone(json.RawMessage) (interface{}, error) {
+	if s.prog == nil {
+		return nil, fmt.Errorf("no program was launched")
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.started {
+		return nil, fmt.Errorf("the program is already running")
+	}
+	s.started = true
+	s.next = func() { go s.run() }
+	return nil, nil
+}
+
+// run evaluates the program, redirecting its output to the client.
+func (s *server) run() {
+	defer close(s.done)
+
+	rt := s.env.Runtime()
+	rt.Stdout = output{s: s, category: "stdout"}
+	// note: stdin carries the protocol
+	rt.Stdin = strings.NewReader("")
+	if !s.launch.NoDebug {
+		rt.Hook = s.d
+	}
+
+	code := 0
+	switch res := evaluator.Eval(s.prog, s.env).(type) {
+	case *object.Error:
+		if res != debugger.Terminated {
+			s.event("output", OutputEventBody{Category: "stderr", Output: fmt.Sprintf("%s: %s\n", s.launch.Program, res.Inspect())})
+		}
+		code = 1
+	case *object.Exit:
+		code = int(res.Code)
+	}
+
+	s.event("exited", map[string]int{"exitCode": code})
+	s.event("terminated", nil)
+}
+
+type output struct {
+	s        *server
+	category string
+}
+
+func (o output) Write(p []byte) (int, error) {
+	o.s.event("output", OutputEventBody{Category: o.category, Output: string(p)})
+	return len(p), nil
+}
+
+// stopped is called by the debugger, from the goroutine running the program.
+func (s *server) stopped(reason string) debugger.Action {
+	s.mu.Lock()
+	s.paused = true
+	s.mu.Unlock()
+
+	s.event("stopped", StoppedEventBody{Reason: reason, ThreadID: threadID, AllThreadsStopped: true})
+	return <-s.resume
+}
+
+func resumeWith(action debugger.Action) handler {
+	return func(s *server, _ json.RawMessage) (interface{}, error) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if !s.paused {
+			return nil, fmt.Errorf("the program is not paused")
+		}
+
+		s.paused, s.refs = false, nil
+		// note: the program resumes after the response is sent, so that
+		// its events follow it
+		s.next = func() { s.resume <- action }
+		if action == debugger.Continue {
+			return map[string]bool{"allThreadsContinued": true}, nil
+		}
+		return nil, nil
+	}
+}
+
+func (s *server) pause(json.RawMessage) (interface{}, error) {
+	if s.d == nil {
+		return nil, fmt.Errorf("no program was launched")
+	}
+	s.d.Pause()
+	return nil, nil
+}
+
+// stop terminates the program, if it's running, and waits for it to end.
+func (s *server) stop() {
+	s.mu.Lock()
+	started := s.started
+	if s.d != nil {
+		s.d.Terminate()
+	}
+	if s.paused {
+		s.paused = false
+		s.resume <- debugger.Stop
+	}
+	s.mu.Unlock()
+
+	if started {
+		<-s.done
+	}
+}
+
+func (s *server) terminate(json.RawMessage) (interface{}, error) {
+	s.next = s.stop
+	return nil, nil
+}
+
+func (s *server) disconnect(json.RawMessage) (interface{}, error) {
+	s.quit = true
+	return nil, nil
+}
+
+func (s *server) threads(json.RawMessage) (interface{}, error) {
+	return map[string][]Thread{"threads": {{ID: threadID, Name: "main"}}}, nil
+}
+
+// frame returns the frame with the given id, the index in the stack.
+func (s *server) frame(id int) (debugger.Frame, error) {
+	s.mu.Lock()
+	paused := s.paused
+	s.mu.Unlock()
+	if !paused {
+		return debugger.Frame{}, fmt.Errorf("the program is not paused")
+	}
+
+	stack := s.d.Stack()
+	if id < 0 || id >= len(stack) {
+		return debugger.Frame{}, fmt.Errorf("unknown frame %d", id)
+	}
+	return stack[id], nil
+}
+
+func (s *server) stackTrace(raw json.RawMessage) (interface{}, error) {
+	if _, err := s.frame(0); err != nil {
+		return nil, err
+	}
+
+	source := Source{Name: filepath.Base(s.launch.Program), Path: s.launch.Program}
+	frames := []StackFrame{}
+	for idx, frame := range s.d.Stack() {
+		frames = append(frames, StackFrame{ID: idx, Name: frame.Name, Source: source, Line: frame.Line, Column: frame.Column})
+	}
+	return map[string]interface{}{"stackFrames": frames, "totalFrames": len(frames)}, nil
+}
+
+func (s *server) scopes(raw json.RawMessage) (interface{}, error) {
+	var args struct {
+		FrameID int `json:"frameId"`
+	}
+	if err := json.Unmarshal(raw, &args); err != nil {
+		return nil, err
+	}
+	frame, err := s.frame(args.FrameID)
+	if err != nil {
+		return nil, err
+	}
+
+	scopes := []Scope{}
+	for _, scope := range s.d.Scopes(frame) {
+		scopes = append(scopes, Scope{Name: scope.Name, VariablesReference: s.reference(scope.Variables)})
+	}
+	return map[string][]Scope{"scopes": scopes}, nil
+}
+
+func (s *server) reference(vars []debugger.Variable) int {
+	s.refs = append(s.refs, vars)
+	return len(s.refs)
+}
+
+func (s *server) variables(raw json.RawMessage) (interface{}, error) {
+	var args struct {
+		VariablesReference int `json:"variablesReference"`
+	}
+	if err := json.Unmarshal(raw, &args); err != nil {
+		return nil, err
+	}
+	if args.VariablesReference < 1 || args.VariablesReference > len(s.refs) {
+		return nil, fmt.Errorf("unknown variables reference %d", args.VariablesReference)
+	}
+
+	vars := []Variable{}
+	for _, v := range s.refs[args.VariablesReference-1] {
+		vars = append(vars, s.variable(v.Name, v.Value))
+	}
+	return map[string][]Variable{"variables": vars}, nil
+}
+
+// variable describes a value, giving a reference to its elements if it has
+// any.
+func (s *server) variable(name string, val object.Object) Variable {
+	v := Variable{Name: name, Value: debugger.Describe(val), Type: string(val.Type())}
+
+	switch val := val.(type) {
+	case *object.Array:
+		elems := []debugger.Variable{}
+		for idx, elem := range val.Elements {
+			elems = append(elems, debugger.Variable{Name: fmt.Sprintf("[%d]", idx), Value: elem})
+		}
+		v.VariablesReference = s.reference(elems)
+	case *object.Hash:
+		pairs := []debugger.Variable{}
+		for _, pair := range val.Pairs() {
+			pairs = append(pairs, debugger.Variable{Name: pair.Key.Inspect(), Value: pair.Value})
+		}
+		v.VariablesReference = s.reference(pairs)
+	}
+	return v
+}
+
+func (s *server) evaluate(raw json.RawMessage) (interface{}, error) {
+	var args struct {
+		Expression string `json:"expression"`
+		FrameID    int    `json:"frameId"`
+	}
+	if err := json.Unmarshal(raw, &args); err != nil {
+		return nil, err
+	}
+	frame, err := s.frame(args.FrameID)
+	if err != nil {
+		return nil, err
+	}
+
+	// note: evaluating arbitrary expressions could change the state of the
+	// program, only variables are looked up
+	name := strings.TrimSpace(args.Expression)
+	val, ok := s.d.Lookup(frame, name)
+	if !ok {
+		return nil, fmt.Errorf("no variable %s", name)
+	}
+	v := s.variable(name, val)
+	return map[string]interface{}{"result": v.Value, "type": v.Type, "variablesReference": v.VariablesReference}, nil
+}
@@ -0,0 +1,290 @@
+package dap
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/AzraelSec/cube/pkg/framing"
+)
+
+const program = `let add = fn(a, b) {
+    let sum = a + b;
+    sum
+};
+let x = add(1, 2);
+print(x);
+let xs = [x, 4];
+exit(xs[1])`
+
+type message struct {
+	Type       string          `json:"type"`
+	Event      string          `json:"event"`
+	RequestSeq int             `json:"request_seq"`
+	Command    string          `json:"command"`
+	Success    bool            `json:"success"`
+	Message    string          `json:"message"`
+	Body       json.RawMessage `json:"body"`
+}
+
+type client struct {
+	t    *testing.T
+	in   *io.PipeWriter
+	out  *framing.Reader
+	w    *framing.Writer
+	seq  int
+	msgs chan message
+	done chan int
+	seen []message
+}
+
+func TestSession(t *testing.T) {
+	path := write(t, program)
+	c := start(t)
+
+	c.request("initialize", map[string]string{"adapterID": "cube"})
+	c.request("launch", map[string]interface{}{"program": path})
+	c.event("initialized")
+
+	bps := c.request("setBreakpoints", map[string]interface{}{
+		"source":      map[string]string{"path": path},
+		"breakpoints": []map[string]int{{"line": 3}, {"line": 4}},
+	})
+	expect(t, bps.Body, `{"breakpoints":[{"verified":true,"line":3},{"verified":false,"line":4,"message":"no statement starts at this line"}]}`)
+
+	c.request("configurationDone", nil)
+	stopped := c.event("stopped")
+	expect(t, stopped.Body, `{"reason":"breakpoint","threadId":1,"allThreadsStopped":true}`)
+
+	trace := c.request("stackTrace", map[string]int{"threadId": 1})
+	expect(t, trace.Body, fmt.Sprintf(`{"stackFrames":[{"id":0,"name":"add","source":{"name":"test.cb","path":%q},"line":3,"column":5},{"id":1,"name":"\u003cmain\u003e","source":{"name":"test.cb","path":%q},"line":5,"column":1}],"totalFrames":2}`, path, path))
+
+	scopes := c.request("scopes", map[string]int{"frameId": 0})
+	expect(t, scopes.Body, `{"scopes":[{"name":"Locals","variablesReference":1,"expensive":false},{"name":"Globals","variablesReference":2,"expensive":false}]}`)
+
+	locals := c.request("variables", map[string]int{"variablesReference": 1})
+	expect(t, locals.Body, `{"variables":[{"name":"a","value":"1","type":"INTEGER","variablesReference":0},{"name":"b","value":"2","type":"INTEGER","variablesReference":0},{"name":"sum","value":"3","type":"INTEGER","variablesReference":0}]}`)
+
+	eval := c.request("evaluate", map[string]interface{}{"expression": "sum", "frameId": 0})
+	expect(t, eval.Body, `{"result":"3","type":"INTEGER","variablesReference":0}`)
+
+	c.request("stepOut", map[string]int{"threadId": 1})
+	stopped = c.event("stopped")
+	expect(t, stopped.Body, `{"reason":"step","threadId":1,"allThreadsStopped":true}`)
+
+	c.request("next", map[string]int{"threadId": 1})
+	c.event("stopped")
+	c.request("next", map[string]int{"threadId": 1})
+	c.event("stopped")
+
+	xs := c.request("evaluate", map[string]interface{}{"expression": "xs", "frameId": 0})
+	expect(t, xs.Body, `{"result":"[3, 4]","type":"ARRAY","variablesReference":1}`)
+	elems := c.request("variables", map[string]int{"variablesReference": 1})
+	expect(t, elems.Body, `{"variables":[{"name":"[0]","value":"3","type":"INTEGER","variablesReference":0},{"name":"[1]","value":"4","type":"INTEGER","variablesReference":0}]}`)
+
+	c.request("continue", map[string]int{"threadId": 1})
+	exited := c.event("exited")
+	expect(t, exited.Body, `{"exitCode":4}`)
+	c.event("terminated")
+
+	if output := c.output("stdout"); output != "3\n" {
+		t.Errorf("wrong output. got=%q", output)
+	}
+
+	c.request("disconnect", nil)
+	c.close()
+}
+
+func TestErrors(t *testing.T) {
+	path := write(t, program)
+	c := start(t)
+
+	tests := []struct {
+		command   string
+		arguments interface{}
+		expected  string
+	}{
+		{"configurationDone", nil, "no program was launched"},
+		{"launch", map[string]string{"program": path + ".missing"}, "impossible to read the file"},
+		{"launch", map[string]string{"program": write(t, "let a = ;")}, "parse errors"},
+		{"launch", map[string]string{"program": write(t, "a")}, "errors: 1:1: identifier not found: a"},
+		{"attach", nil, "unsupported command attach"},
+		{"launch", map[string]string{"program": path}, ""},
+		{"launch", map[string]string{"program": path}, "a program was already launched"},
+		{"continue", nil, "the program is not paused"},
+		{"stackTrace", nil, "the program is not paused"},
+		{"variables", map[string]int{"variablesReference": 1}, "unknown variables reference 1"},
+	}
+
+	for _, tt := range tests {
+		resp := c.send(tt.command, tt.arguments)
+		if tt.expected == "" {
+			if !resp.Success {
+				t.Errorf("%s: unexpected failure %q", tt.command, resp.Message)
+			}
+			continue
+		}
+		if resp.Success || !strings.Contains(resp.Message, tt.expected) {
+			t.Errorf("%s: wrong response. got=%v %q, want failure %q", tt.command, resp.Success, resp.Message, tt.expected)
+		}
+	}
+
+	c.request("disconnect", nil)
+	c.close()
+}
+
+func TestTerminate(t *testing.T) {
+	path := write(t, "let a = 1;\nlet b = 2;\na + b")
+	c := start(t)
+
+	c.request("initialize", nil)
+	c.request("launch", map[string]interface{}{"program": path, "stopOnEntry": true})
+	c.request("configurationDone", nil)
+	stopped := c.event("stopped")
+	expect(t, stopped.Body, `{"reason":"entry","threadId":1,"allThreadsStopped":true}`)
+
+	c.request("terminate", nil)
+	exited := c.event("exited")
+	expect(t, exited.Body, `{"exitCode":1}`)
+	c.event("terminated")
+
+	if output := c.output("stderr"); output != "" {
+		t.Errorf("unexpected errors %q", output)
+	}
+
+	// note: closing the connection is as good as disconnecting
+	c.close()
+}
+
+func write(t *testing.T, content string) string {
+	t.Helper()
+	dir := t.TempDir()
+	path := filepath.Join(dir, "test.cb")
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func start(t *testing.T) *client {
+	inR, inW := io.Pipe()
+	outR, outW := io.Pipe()
+
+	c := &client{t: t, in: inW, out: framing.NewReader(outR), w: framing.NewWriter(inW), msgs: make(chan message), done: make(chan int, 1)}
+	go func() {
+		c.done <- Serve(inR, outW, io.Discard)
+		outW.Close()
+	}()
+	go func() {
+		defer close(c.msgs)
+		for {
+			payload, err := c.out.Read()
+			if err != nil {
+				return
+			}
+			var msg message
+			if err := json.Unmarshal(payload, &msg); err != nil {
+				t.Errorf("invalid message %s", payload)
+				return
+			}
+			c.msgs <- msg
+		}
+	}()
+	return c
+}
+
+// send makes a request, returning the response to it.
+func (c *client) send(command string, arguments interface{}) message {
+	c.t.Helper()
+	c.seq++
+	req := map[string]interface{}{"seq": c.seq, "type": "request", "command": command}
+	if arguments != nil {
+		req["arguments"] = arguments
+	}
+	if err := c.w.Write(req); err != nil {
+		c.t.Fatal(err)
+	}
+
+	seq := c.seq
+	return c.wait(fmt.Sprintf("response to %s", command), func(msg message) bool {
+		return msg.Type == "response" && msg.RequestSeq == seq
+	})
+}
+
+// request is send, failing the test if the request is not successful.
+func (c *client) request(command string, arguments interface{}) message {
+	c.t.Helper()
+	resp := c.send(command, arguments)
+	if !resp.Success {
+		c.t.Fatalf("%s failed: %s", command, resp.Message)
+	}
+	return resp
+}
+
+func (c *client) event(name string) message {
+	c.t.Helper()
+	return c.wait("event "+name, func(msg message) bool {
+		return msg.Type == "event" && msg.Event == name
+	})
+}
+
+func (c *client) wait(what string, match func(message) bool) message {
+	c.t.Helper()
+	for {
+		select {
+		case msg, ok := <-c.msgs:
+			if !ok {
+				c.t.Fatalf("connection closed waiting for the %s", what)
+			}
+			c.seen = append(c.seen, msg)
+			if match(msg) {
+				return msg
+			}
+		case <-time.After(5 * time.Second):
+			c.t.Fatalf("timeout waiting for the %s", what)
+		}
+	}
+}
+
+// output joins the output events of the given category seen so far.
+func (c *client) output(category string) string {
+	var b strings.Builder
+	for _, msg := range c.seen {
+		if msg.Type != "event" || msg.Event != "output" {
+			continue
+		}
+		var body OutputEventBody
+		json.Unmarshal(msg.Body, &body)
+		if body.Category == category {
+			b.WriteString(body.Output)
+		}
+	}
+	return b.String()
+}
+
+func (c *client) close() {
+	c.t.Helper()
+	c.in.Close()
+	select {
+	case code := <-c.done:
+		if code != 0 {
+			c.t.Errorf("wrong exit code. got=%d", code)
+		}
+	case <-time.After(5 * time.Second):
+		c.t.Fatalf("the server did not stop")
+	}
+	for range c.msgs {
+	}
+}
+
+func expect(t *testing.T, got json.RawMessage, expected string) {
+	t.Helper()
+	if string(got) != expected {
+		t.Errorf("wrong body.\ngot= %s\nwant=%s", got, expected)
+	}
+}
@@ -0,0 +1,237 @@
+package debugger
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+const consolePrompt = "(debug) "
+
+// Console is a line oriented frontend, reading commands every time the
+// program stops.
+type Console struct {
+	d     *Debugger
+	lines []string // of the source
+	in    *bufio.Scanner
+	out   io.Writer
+	frame int // selected frame, 0 being the innermost one
+}
+
+type consoleCommand struct {
+	names []string
+	args  string
+	help  string
+	// note: run returns true when the program has to be resumed
+	run func(c *Console, arg string) (Action, bool)
+}
+
+var consoleCommands []consoleCommand
+
+func init() {
+	consoleCommands = []consoleCommand{
+		{[]string{"continue", "c"}, "", "resume the program", resume(Continue)},
+		{[]string{"step", "s"}, "", "stop at the next line, entering calls", resume(StepIn)},
+		{[]string{"next", "n"}, "", "stop at the next line, stepping over calls", resume(StepOver)},
+		{[]string{"out", "o"}, "", "stop once the current function returns", resume(StepOut)},
+		{[]string{"break", "b"}, "line", "add a breakpoint", (*Console).addBreakpoint},
+		{[]string{"clear"}, "line", "remove a breakpoint", (*Console).clearBreakpoint},
+		{[]string{"breakpoints"}, "", "list the breakpoints", (*Console).listBreakpoints},
+		{[]string{"backtrace", "bt"}, "", "show the call stack", (*Console).backtrace},
+		{[]string{"frame", "f"}, "n", "select the frame to inspect, 0 being the innermost", (*Console).selectFrame},
+		{[]string{"vars", "v"}, "", "show the variables visible from the selected frame", (*Console).vars},
+		{[]string{"print", "p"}, "name", "show the value of a variable", (*Console).print},
+		{[]string{"list", "l"}, "", "show the source around the current line", (*Console).list},
+		{[]string{"quit", "q"}, "", "terminate the program", resume(Stop)},
+		{[]string{"help", "h"}, "", "show this help", (*Console).help},
+	}
+}
+
+func resume(action Action) func(*Console, string) (Action, bool) {
+	return func(*Console, string) (Action, bool) { return action, true }
+}
+
+// NewConsole attaches a console to d, the program being debugged is src. The
+// program stops before its first statement, so that breakpoints can be set.
+func NewConsole(d *Debugger, src string, in io.Reader, out io.Writer) *Console {
+	c := &Console{d: d, lines: strings.Split(src, "\n"), in: bufio.NewScanner(in), out: out}
+	d.Stopped = c.stopped
+	d.StopOnEntry = true
+	return c
+}
+
+func (c *Console) stopped(reason string) Action {
+	c.frame = 0
+	top := c.d.Stack()[0]
+	fmt.Fprintf(c.out, "stopped at line %d (%s)\n", top.Line, reason)
+	c.printLine(top.Line, true)
+
+	for {
+		fmt.Fprint(c.out, consolePrompt)
+		if !c.in.Scan() {
+			// note: nobody is left to drive the program
+			fmt.Fprintln(c.out)
+			return Stop
+		}
+
+		line := strings.TrimSpace(c.in.Text())
+		if line == "" {
+			continue
+		}
+		if action, ok := c.run(line); ok {
+			return action
+		}
+	}
+}
+
+func (c *Console) run(line string) (Action, bool) {
+	name, arg, _ := strings.Cut(line, " ")
+	arg = strings.TrimSpace(arg)
+
+	for _, cmd := range consoleCommands {
+		for _, n := range cmd.names {
+			if n != name {
+				continue
+			}
+			if cmd.args != "" && arg == "" {
+				fmt.Fprintf(c.out, "usage: %s %s\n", cmd.names[0], cmd.args)
+				return Continue, false
+			}
+			return cmd.run(c, arg)
+		}
+	}
+
+	fmt.Fprintf(c.out, "unknown command %s, type help for the list of commands\n", name)
+	return Continue, false
+}
+
+func (c *Console) help(string) (Action, bool) {
+	for _, cmd := range consoleCommands {
+		usage := strings.Join(cmd.names, ", ")
+		if cmd.args != "" {
+			usage += " " + cmd.args
+		}
+		fmt.Fprintf(c.out, "%-20s %s\n", usage, cmd.help)
+	}
+	return Continue, false
+}
+
+func (c *Console) addBreakpoint(arg string) (Action, bool) {
+	line, err := strconv.Atoi(arg)
+	if err != nil {
+		fmt.Fprintf(c.out, "invalid line %s\n", arg)
+		return Continue, false
+	}
+
+	lines := append(c.d.Breakpoints(), line)
+	if verified := c.d.SetBreakpoints(lines); !verified[len(verified)-1] {
+		fmt.Fprintf(c.out, "no statement starts at line %d\n", line)
+		return Continue, false
+	}
+	fmt.Fprintf(c.out, "breakpoint at line %d\n", line)
+	return Continue, false
+}
+
+func (c *Console) clearBreakpoint(arg string) (Action, bool) {
+	line, err := strconv.Atoi(arg)
+	if err != nil {
+		fmt.Fprintf(c.out, "invalid line %s\n", arg)
+		return Continue, false
+	}
+
+	lines, found := []int{}, false
+	for _, l := range c.d.Breakpoints() {
+		if l == line {
+			found = true
+			continue
+		}
+		lines = append(lines, l)
+	}
+	if !found {
+		fmt.Fprintf(c.out, "no breakpoint at line %d\n", line)
+		return Continue, false
+	}
+	c.d.SetBreakpoints(lines)
+	return Continue, false
+}
+
+func (c *Console) listBreakpoints(string) (Action, bool) {
+	lines := c.d.Breakpoints()
+	if len(lines) == 0 {
+		fmt.Fprintln(c.out, "no breakpoints")
+	}
+	for _, line := range lines {
+		c.printLine(line, false)
+	}
+	return Continue, false
+}
+
+func (c *Console) backtrace(string) (Action, bool) {
+	for idx, frame := range c.d.Stack() {
+		marker := " "
+		if idx == c.frame {
+			marker = "*"
+		}
+		fmt.Fprintf(c.out, "%s %d: %s at line %d\n", marker, idx, frame.Name, frame.Line)
+	}
+	return Continue, false
+}
+
+func (c *Console) selectFrame(arg string) (Action, bool) {
+	idx, err := strconv.Atoi(arg)
+	if stack := c.d.Stack(); err != nil || idx < 0 || idx >= len(stack) {
+		fmt.Fprintf(c.out, "invalid frame %s\n", arg)
+		return Continue, false
+	}
+	c.frame = idx
+	return c.backtrace("")
+}
+
+func (c *Console) vars(string) (Action, bool) {
+	for _, scope := range c.d.Scopes(c.d.Stack()[c.frame]) {
+		fmt.Fprintf(c.out, "%s:\n", scope.Name)
+		for _, v := range scope.Variables {
+			fmt.Fprintf(c.out, "  %s = %s\n", v.Name, Describe(v.Value))
+		}
+	}
+	return Continue, false
+}
+
+func (c *Console) print(arg string) (Action, bool) {
+	val, ok := c.d.Lookup(c.d.Stack()[c.frame], arg)
+	if !ok {
+		fmt.Fprintf(c.out, "no variable %s\n", arg)
+		return Continue, false
+	}
+	fmt.Fprintf(c.out, "%s = %s\n", arg, Describe(val))
+	return Continue, false
+}
+
+func (c *Console) list(string) (Action, bool) {
+	current := c.d.Stack()[c.frame].Line
+	first, last := current-3, current+3
+	if first < 1 {
+		first = 1
+	}
+	if last > len(c.lines) {
+		last = len(c.lines)
+	}
+	for line := first; line <= last; line++ {
+		c.printLine(line, line == current)
+	}
+	return Continue, false
+}
+
+func (c *Console) printLine(line int, current bool) {
+	marker := " "
+	if current {
+		marker = ">"
+	}
+	text := ""
+	if line >= 1 && line <= len(c.lines) {
+		text = strings.TrimRight(c.lines[line-1], " \t\r")
+	}
+	fmt.Fprintf(c.out, "%s %4d | %s\n", marker, line, text)
+}
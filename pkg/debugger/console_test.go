@@ -0,0 +1,76 @@
+package debugger
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestConsole(t *testing.T) {
+	script := []string{
+		"b 3", "b 4", "b x", "breakpoints", "clear 6", "continue",
+		"bt", "vars", "frame 1", "p x", "p sum", "frame 5", "wat", "print",
+		"next", "list", "out", "c",
+	}
+
+	var out bytes.Buffer
+	prog := parse(t, program)
+	d := New(prog)
+	NewConsole(d, program, strings.NewReader(strings.Join(script, "\n")+"\n"), &out)
+	res := run(t, prog, d)
+
+	expected := `stopped at line 1 (entry)
+>    1 | let add = fn(a, b) {
+(debug) breakpoint at line 3
+(debug) no statement starts at line 4
+(debug) invalid line x
+(debug)      3 |     sum
+(debug) no breakpoint at line 6
+(debug) stopped at line 3 (breakpoint)
+>    3 |     sum
+(debug) * 0: add at line 3
+  1: <main> at line 5
+(debug) Locals:
+  a = 1
+  b = 2
+  sum = 3
+Globals:
+  add = fn(a, b) {...}
+  args = []
+(debug)   0: add at line 3
+* 1: <main> at line 5
+(debug) no variable x
+(debug) no variable sum
+(debug) invalid frame 5
+(debug) unknown command wat, type help for the list of commands
+(debug) usage: print name
+(debug) stopped at line 6 (step)
+>    6 | let y = add(x, 3);
+(debug)      3 |     sum
+     4 | };
+     5 | let x = add(1, 2);
+>    6 | let y = add(x, 3);
+     7 | y
+(debug) stopped at line 3 (breakpoint)
+>    3 |     sum
+(debug) `
+	if out.String() != expected {
+		t.Errorf("wrong transcript.\ngot:\n%s\nwant:\n%s", out.String(), expected)
+	}
+	if res.Inspect() != "6" {
+		t.Errorf("wrong result. got=%s", res.Inspect())
+	}
+}
+
+func TestConsoleQuit(t *testing.T) {
+	for _, input := range []string{"quit\n", ""} {
+		var out bytes.Buffer
+		prog := parse(t, program)
+		d := New(prog)
+		NewConsole(d, program, strings.NewReader(input), &out)
+
+		if res := run(t, prog, d); res != Terminated {
+			t.Errorf("%q: wrong result. got=%s", input, res.Inspect())
+		}
+	}
+}
@@ -0,0 +1,377 @@
+// Package debugger pauses the evaluation of Cube programs at breakpoints and
+// between steps, letting its frontends inspect the call stack and the
+// variables of each frame.
+package debugger
+
+import (
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/AzraelSec/cube/pkg/ast"
+	"github.com/AzraelSec/cube/pkg/object"
+)
+
+// Action tells the debugger how to resume the program.
+type Action int
+
+const (
+	Continue Action = iota
+	StepIn          // stop at the next line, entering calls
+	StepOver        // stop at the next line of the current function or of its callers
+	StepOut         // stop once the current function returns
+	Stop            // terminate the program
+)
+
+// reasons the program stops for
+const (
+	ReasonEntry      = "entry"
+	ReasonBreakpoint = "breakpoint"
+	ReasonStep       = "step"
+	ReasonPause      = "pause"
+)
+
+// Terminated is what the evaluation of a program stopped by the debugger
+// results in.
+var Terminated = &object.Error{Msg: "terminated by the debugger"}
+
+// Frame is a function call in progress, or the top-level of the program.
+type Frame struct {
+	Name         string
+	Line, Column int // of the statement being evaluated
+	// Env is the environment the statement is evaluated in: the frame of the
+	// call, or the scope of a match arm in it
+	Env *object.Environment
+}
+
+// Debugger is an object.Hook pausing the program it follows.
+type Debugger struct {
+	// Stopped is called, from the goroutine evaluating the program, every
+	// time it pauses. The program stays paused until it returns how to
+	// resume it.
+	Stopped func(reason string) Action
+	// StopOnEntry pauses the program before its first statement.
+	StopOnEntry bool
+
+	lines map[int]bool          // the lines where a statement starts
+	names map[ast.Node][]string // of the slots of function bodies and match arms
+
+	mu          sync.Mutex
+	breakpoints map[int]bool
+	pause       bool
+	terminate   bool
+	stack       []*Frame // innermost last
+	action      Action
+	depth       int // of the stack when action was chosen
+}
+
+// New returns a debugger for prog, which must have gone through the resolver.
+func New(prog *ast.Program) *Debugger {
+	d := &Debugger{
+		lines:       map[int]bool{},
+		names:       map[ast.Node][]string{},
+		breakpoints: map[int]bool{},
+	}
+	ast.Inspect(prog, func(n ast.Node) bool {
+		if stm, ok := n.(ast.Statement); ok {
+			if _, ok := stm.(*ast.BlockStatement); !ok {
+				d.lines[ast.Start(stm).Line] = true
+			}
+		}
+		return true
+	})
+	return d
+}
+
+// SetBreakpoints replaces the breakpoints with the given lines, reporting
+// for each of them whether a statement starts there: the others can't be
+// hit and are dropped.
+func (d *Debugger) SetBreakpoints(lines []int) []bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.breakpoints = map[int]bool{}
+	verified := make([]bool, len(lines))
+	for idx, line := range lines {
+		if d.lines[line] {
+			d.breakpoints[line], verified[idx] = true, true
+		}
+	}
+	return verified
+}
+
+// Breakpoints returns the lines with a breakpoint, sorted.
+func (d *Debugger) Breakpoints() []int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	lines := make([]int, 0, len(d.breakpoints))
+	for line := range d.breakpoints {
+		lines = append(lines, line)
+	}
+	sort.Ints(lines)
+	return lines
+}
+
+// Pause asks the running program to stop at the next statement.
+func (d *Debugger) Pause() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.pause = true
+}
+
+// Terminate asks the running program to stop for good at the next statement.
+func (d *Debugger) Terminate() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.terminate = true
+}
+
+// Stack returns a copy of the frames, innermost first. The positions are
+// only meaningful while the program is paused.
+func (d *Debugger) Stack() []Frame {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	frames := make([]Frame, len(d.stack))
+	for idx, frame := range d.stack {
+		frames[len(frames)-1-idx] = *frame
+	}
+	return frames
+}
+
+func (d *Debugger) Statement(stm ast.Statement, env *object.Environment) object.Object {
+	d.mu.Lock()
+	if len(d.stack) == 0 {
+		d.stack = append(d.stack, &Frame{Name: "<main>", Env: env})
+		if d.StopOnEntry {
+			d.action, d.depth = StepIn, 0
+		}
+	}
+
+	top := d.stack[len(d.stack)-1]
+	top.Env = env
+	start := ast.Start(stm)
+	// note: a line holding more statements, like a whole if/else, is
+	// stopped at once
+	newLine := start.Line != top.Line
+	top.Line, top.Column = start.Line, start.Column
+
+	reason := d.reason(newLine, start.Line)
+	terminate := d.terminate
+	d.mu.Unlock()
+
+	if terminate {
+		return Terminated
+	}
+	if reason == "" || d.Stopped == nil {
+		return nil
+	}
+
+	action := d.Stopped(reason)
+
+	d.mu.Lock()
+	d.action, d.depth = action, len(d.stack)
+	terminate = d.terminate
+	d.mu.Unlock()
+
+	if action == Stop || terminate {
+		return Terminated
+	}
+	return nil
+}
+
+// reason returns why the program has to stop at a statement of line, if it
+// has to.
+func (d *Debugger) reason(newLine bool, line int) string {
+	switch {
+	case d.pause:
+		d.pause = false
+		return ReasonPause
+	case d.action == StepIn && d.depth == 0:
+		return ReasonEntry
+	case d.action == StepIn && newLine:
+		return ReasonStep
+	case d.action == StepOver && newLine && len(d.stack) <= d.depth:
+		return ReasonStep
+	case d.action == StepOut && len(d.stack) < d.depth:
+		return ReasonStep
+	case newLine && d.breakpoints[line]:
+		return ReasonBreakpoint
+	}
+	return ""
+}
+
+func (d *Debugger) Call(call *ast.CallExpression, fn *object.Function, env *object.Environment) {
+	name := "<anonymous>"
+	switch {
+	case fn.Name != "":
+		name = fn.Name
+	case call != nil:
+		if _, ok := call.Function.(*ast.FunctionLiteral); !ok {
+			name = call.Function.String()
+		}
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.stack = append(d.stack, &Frame{Name: name, Env: env})
+}
+
+func (d *Debugger) Return(call *ast.CallExpression, result object.Object) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.stack = d.stack[:len(d.stack)-1]
+}
+
+func (d *Debugger) Branch(*ast.IfExpression, bool) {}
+
+// Variable is a variable visible from a frame.
+type Variable struct {
+	Name  string
+	Value object.Object
+}
+
+// Scope is a group of variables visible from a frame.
+type Scope struct {
+	Name      string
+	Variables []Variable
+}
+
+// Scopes returns the variables visible from frame, following its chain of
+// environments: the ones of the match arms being evaluated, the locals of
+// the call, the ones of the functions it closes over and the globals. It
+// must only be called while the program is paused.
+func (d *Debugger) Scopes(frame Frame) []Scope {
+	scopes := []Scope{}
+	name := "Locals"
+	for env := frame.Env; env != nil; env = env.Outer() {
+		if arm := env.Arm(); arm != nil {
+			scopes = append(scopes, Scope{Name: "Match", Variables: locals(env, d.armNames(arm))})
+			continue
+		}
+		fn := env.Function()
+		if fn == nil {
+			scopes = append(scopes, Scope{Name: "Globals", Variables: globals(env)})
+			continue
+		}
+
+		scopes = append(scopes, Scope{Name: name, Variables: locals(env, d.slotNames(fn))})
+		name = "Closure"
+	}
+	return scopes
+}
+
+// Lookup returns the value of the variable called name as seen from frame.
+func (d *Debugger) Lookup(frame Frame, name string) (object.Object, bool) {
+	for _, scope := range d.Scopes(frame) {
+		for _, v := range scope.Variables {
+			if v.Name == name {
+				return v.Value, true
+			}
+		}
+	}
+	return nil, false
+}
+
+// Describe returns a description of val fitting in one line, unlike the
+// Inspect form of functions.
+func Describe(val object.Object) string {
+	fn, ok := val.(*object.Function)
+	if !ok {
+		return val.Inspect()
+	}
+
+	params := make([]string, len(fn.Parameters))
+	for idx, param := range fn.Parameters {
+		params[idx] = param.Value
+	}
+	return "fn(" + strings.Join(params, ", ") + ") {...}"
+}
+
+func globals(env *object.Environment) []Variable {
+	vars := []Variable{}
+	for _, name := range env.Names() {
+		val, _ := env.Get(name)
+		vars = append(vars, Variable{Name: name, Value: val})
+	}
+	return vars
+}
+
+// locals returns the variables of the frame or scope env, named by slot,
+// skipping the ones whose let wasn't evaluated yet.
+func locals(env *object.Environment, names []string) []Variable {
+	vars := []Variable{}
+	for slot, val := range env.Locals() {
+		if val != nil && slot < len(names) {
+			vars = append(vars, Variable{Name: names[slot], Value: val})
+		}
+	}
+	return vars
+}
+
+// slotNames returns the names of the variables of fn by slot, as laid out by
+// the resolver.
+func (d *Debugger) slotNames(fn *object.Function) []string {
+	bound := append([]*ast.Identifier{}, fn.Parameters...)
+	for _, pattern := range fn.Patterns {
+		if pattern != nil {
+			bound = append(bound, ast.Names(pattern)...)
+		}
+	}
+	return d.scopeNames(fn.Body, fn.Slots, bound, fn.Body)
+}
+
+// armNames is like slotNames, for the variables of a match arm.
+func (d *Debugger) armNames(arm *ast.MatchArm) []string {
+	nodes := []ast.Node{arm.Value}
+	if arm.Guard != nil {
+		nodes = append(nodes, arm.Guard)
+	}
+	return d.scopeNames(arm.Value, arm.Slots, ast.Names(arm.Pattern), nodes...)
+}
+
+// scopeNames names the size slots of a scope, cached under key, after the
+// identifiers bound to them: the ones in bound and the ones declared in
+// nodes, outside of the functions and match arms nested in them.
+func (d *Debugger) scopeNames(key ast.Node, size int, bound []*ast.Identifier, nodes ...ast.Node) []string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if names, ok := d.names[key]; ok {
+		return names
+	}
+
+	names := make([]string, size)
+	name := func(ident *ast.Identifier) {
+		if ident.Binding.Scope == ast.Local && ident.Binding.Slot < len(names) {
+			names[ident.Binding.Slot] = ident.Value
+		}
+	}
+	for _, ident := range bound {
+		name(ident)
+	}
+	var visit func(n ast.Node) bool
+	visit = func(n ast.Node) bool {
+		switch n := n.(type) {
+		case *ast.LetStatement:
+			for _, ident := range n.Names() {
+				name(ident)
+			}
+		case *ast.FunctionStatement:
+			name(n.Name)
+		case *ast.MatchExpression:
+			ast.Inspect(n.Subject, visit)
+			return false
+		case *ast.FunctionLiteral:
+			return false
+		}
+		return true
+	}
+	for _, node := range nodes {
+		ast.Inspect(node, visit)
+	}
+
+	d.names[key] = names
+	return names
+}
@@ -0,0 +1,254 @@
+package debugger
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/AzraelSec/cube/pkg/ast"
+	"github.com/AzraelSec/cube/pkg/evaluator"
+	"github.com/AzraelSec/cube/pkg/lexer"
+	"github.com/AzraelSec/cube/pkg/object"
+	"github.com/AzraelSec/cube/pkg/parser"
+)
+
+const program = `let add = fn(a, b) {
+    let sum = a + b;
+    sum
+};
+let x = add(1, 2);
+let y = add(x, 3);
+y`
+
+func TestStepping(t *testing.T) {
+	tests := []struct {
+		entry       bool
+		breakpoints []int
+		actions     []Action
+		expected    []string
+	}{
+		{true, nil, []Action{StepIn, StepIn, StepIn, StepIn, StepIn, StepIn, StepIn}, []string{
+			"entry 1 <main>",
+			"step 5 <main>",
+			"step 2 add <main>",
+			"step 3 add <main>",
+			"step 6 <main>",
+			"step 2 add <main>",
+			"step 3 add <main>",
+			"step 7 <main>",
+		}},
+		{true, nil, []Action{StepOver, StepOver, StepOver, StepOver}, []string{
+			"entry 1 <main>",
+			"step 5 <main>",
+			"step 6 <main>",
+			"step 7 <main>",
+		}},
+		{false, []int{3}, nil, []string{
+			"breakpoint 3 add <main>",
+			"breakpoint 3 add <main>",
+		}},
+		{false, []int{2}, []Action{StepOut}, []string{
+			"breakpoint 2 add <main>",
+			"step 6 <main>",
+			"breakpoint 2 add <main>",
+		}},
+		{false, []int{2, 6}, []Action{StepOver, StepOver}, []string{
+			"breakpoint 2 add <main>",
+			"step 3 add <main>",
+			"step 6 <main>",
+			"breakpoint 2 add <main>",
+		}},
+	}
+
+	for idx, tt := range tests {
+		got, res := debug(t, program, tt.entry, tt.breakpoints, tt.actions)
+		if strings.Join(got, ", ") != strings.Join(tt.expected, ", ") {
+			t.Errorf("test %d: wrong stops.\ngot= %q\nwant=%q", idx, got, tt.expected)
+		}
+		if res.Inspect() != "6" {
+			t.Errorf("test %d: wrong result. got=%s", idx, res.Inspect())
+		}
+	}
+}
+
+func TestStop(t *testing.T) {
+	got, res := debug(t, program, false, []int{3}, []Action{Stop})
+	if len(got) != 1 {
+		t.Errorf("wrong stops. got=%q", got)
+	}
+	if res != Terminated {
+		t.Errorf("wrong result. got=%s", res.Inspect())
+	}
+}
+
+func TestSetBreakpoints(t *testing.T) {
+	d := New(parse(t, program))
+
+	verified := d.SetBreakpoints([]int{6, 2, 4, 100})
+	if fmt.Sprint(verified) != "[true true false false]" {
+		t.Errorf("wrong verification. got=%v", verified)
+	}
+	if lines := d.Breakpoints(); fmt.Sprint(lines) != "[2 6]" {
+		t.Errorf("wrong breakpoints. got=%v", lines)
+	}
+}
+
+func TestScopes(t *testing.T) {
+	input := `let base = 10;
+let make = fn(n) {
+    let k = n * 2;
+    fn(x) {
+        let r = x + k;
+        r
+    }
+};
+let f = make(1);
+f(5)`
+
+	prog := parse(t, input)
+	d := New(prog)
+	d.SetBreakpoints([]int{6})
+
+	var scopes []string
+	d.Stopped = func(string) Action {
+		for _, scope := range d.Scopes(d.Stack()[0]) {
+			vars := []string{}
+			for _, v := range scope.Variables {
+				vars = append(vars, v.Name+"="+Describe(v.Value))
+			}
+			scopes = append(scopes, scope.Name+": "+strings.Join(vars, " "))
+		}
+
+		if val, ok := d.Lookup(d.Stack()[0], "k"); !ok || val.Inspect() != "2" {
+			t.Errorf("wrong value for k. got=%v", val)
+		}
+		if _, ok := d.Lookup(d.Stack()[0], "nope"); ok {
+			t.Errorf("unexpected variable nope")
+		}
+		return Continue
+	}
+
+	run(t, prog, d)
+
+	expected := []string{
+		"Locals: x=5 r=7",
+		"Closure: n=1 k=2",
+		"Globals: args=[] base=10 f=fn(x) {...} make=fn(n) {...}",
+	}
+	if strings.Join(scopes, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("wrong scopes.\ngot= %q\nwant=%q", scopes, expected)
+	}
+}
+
+func TestMatchScopes(t *testing.T) {
+	input := `let f = fn(n) {
+    match ([n]) {
+        [m] if m > 0 => if (true) {
+            let k = m + n;
+            k
+        },
+        _ => 0
+    }
+};
+f(3)`
+
+	prog := parse(t, input)
+	d := New(prog)
+	d.SetBreakpoints([]int{5})
+
+	var scopes []string
+	d.Stopped = func(string) Action {
+		for _, scope := range d.Scopes(d.Stack()[0]) {
+			vars := []string{}
+			for _, v := range scope.Variables {
+				vars = append(vars, v.Name+"="+Describe(v.Value))
+			}
+			scopes = append(scopes, scope.Name+": "+strings.Join(vars, " "))
+		}
+		return Continue
+	}
+
+	run(t, prog, d)
+
+	expected := []string{
+		"Match: m=3 k=6",
+		"Locals: n=3",
+		"Globals: args=[] f=fn(n) {...}",
+	}
+	if strings.Join(scopes, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("wrong scopes.\ngot= %q\nwant=%q", scopes, expected)
+	}
+}
+
+func TestPause(t *testing.T) {
+	prog := parse(t, program)
+	d := New(prog)
+	d.Pause()
+
+	got := []string{}
+	d.Stopped = func(reason string) Action {
+		got = append(got, reason)
+		return Continue
+	}
+	run(t, prog, d)
+
+	if strings.Join(got, ", ") != "pause" {
+		t.Errorf("wrong stops. got=%q", got)
+	}
+}
+
+// debug runs input, stopping as told and resuming with actions, then with
+// Continue. It returns the stops, each with its reason, line and stack.
+func debug(t *testing.T, input string, entry bool, breakpoints []int, actions []Action) ([]string, object.Object) {
+	t.Helper()
+
+	prog := parse(t, input)
+	d := New(prog)
+	d.StopOnEntry = entry
+	d.SetBreakpoints(breakpoints)
+
+	got := []string{}
+	d.Stopped = func(reason string) Action {
+		stack := d.Stack()
+		names := []string{}
+		for _, frame := range stack {
+			names = append(names, frame.Name)
+		}
+		got = append(got, fmt.Sprintf("%s %d %s", reason, stack[0].Line, strings.Join(names, " ")))
+
+		if len(actions) == 0 {
+			return Continue
+		}
+		action := actions[0]
+		actions = actions[1:]
+		return action
+	}
+
+	return got, run(t, prog, d)
+}
+
+func run(t *testing.T, prog *ast.Program, d *Debugger) object.Object {
+	t.Helper()
+	env := env()
+	env.Runtime().Hook = d
+	return evaluator.Eval(prog, env)
+}
+
+func env() *object.Environment {
+	env := object.NewEnvironment()
+	env.Set("args", &object.Array{})
+	return env
+}
+
+func parse(t *testing.T, input string) *ast.Program {
+	t.Helper()
+	p := parser.New(lexer.New(input))
+	prog := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		t.Fatalf("unexpected parse errors %v", p.Errors())
+	}
+	if errs := evaluator.Resolve(prog, env()); len(errs) != 0 {
+		t.Fatalf("unexpected resolve errors %v", errs)
+	}
+	return prog
+}
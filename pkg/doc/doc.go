@@ -0,0 +1,127 @@
+// Package doc extracts the API reference of Cube modules from the ///
+// comments of their top-level let bindings, and renders it as Markdown or
+// HTML.
+package doc
+
+import (
+	"path/filepath"
+	"strings"
+
+	"github.com/AzraelSec/cube/pkg/ast"
+	"github.com/AzraelSec/cube/pkg/evaluator"
+)
+
+// Module is the reference of a source file, or of the builtins.
+type Module struct {
+	Name    string
+	Entries []Entry
+}
+
+// Entry documents a top-level binding.
+type Entry struct {
+	Name string
+	// Signature is the binding as it would be declared, like
+	// `fn add(a: int, b): int` for functions or `let max: int` for the rest.
+	Signature string
+	Doc       string
+	Line      int // zero for builtins
+}
+
+// New returns the reference of the module defined by prog, named after the
+// file it was read from. Bindings whose name starts with _ are private and
+// left out.
+func New(path string, prog *ast.Program) Module {
+	m := Module{Name: strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))}
+	for _, stm := range prog.Statements {
+		var entries []Entry
+		switch stm := stm.(type) {
+		case *ast.LetStatement:
+			if stm.Pattern == nil {
+				entries = []Entry{{Name: stm.Name.Value, Signature: letSignature(stm), Doc: stm.Doc, Line: stm.Token.Line}}
+				break
+			}
+			// note: a destructuring let documents each of its variables
+			for _, name := range stm.Names() {
+				entries = append(entries, Entry{Name: name.Value, Signature: "let " + name.Value, Doc: stm.Doc, Line: stm.Token.Line})
+			}
+		case *ast.FunctionStatement:
+			entries = []Entry{{Name: stm.Name.Value, Signature: signature(stm.Name.Value, stm.Function), Doc: stm.Function.Doc, Line: stm.Token.Line}}
+		}
+		for _, e := range entries {
+			if !strings.HasPrefix(e.Name, "_") {
+				m.Entries = append(m.Entries, e)
+			}
+		}
+	}
+	return m
+}
+
+// Builtins returns the reference of the builtin functions.
+func Builtins() Module {
+	m := Module{Name: "builtins"}
+	for _, name := range evaluator.BuiltinNames() {
+		b, _ := evaluator.LookupBuiltin(name)
+		m.Entries = append(m.Entries, Entry{Name: name, Signature: "fn " + b.Signature(), Doc: b.Doc})
+	}
+	return m
+}
+
+func letSignature(let *ast.LetStatement) string {
+	if let.Type != nil {
+		return "let " + let.Name.Value + ": " + let.Type.String()
+	}
+	if fn, ok := let.Value.(*ast.FunctionLiteral); ok {
+		return signature(let.Name.Value, fn)
+	}
+	return "let " + let.Name.Value
+}
+
+func signature(name string, fn *ast.FunctionLiteral) string {
+	params := make([]string, len(fn.Parameters))
+	for idx := range fn.Parameters {
+		params[idx] = fn.Param(idx)
+	}
+	sig := "fn " + name + "(" + strings.Join(params, ", ") + ")"
+	if fn.ReturnType != nil {
+		sig += ": " + fn.ReturnType.String()
+	}
+	return sig
+}
+
+type paragraph struct {
+	Text string
+	Pre  bool // indented in the comment, like code examples
+}
+
+// paragraphs splits a doc comment at its blank lines, removing the
+// indentation of preformatted paragraphs.
+func paragraphs(doc string) []paragraph {
+	res := []paragraph{}
+	for _, block := range strings.Split(doc, "\n\n") {
+		lines := strings.Split(strings.Trim(block, "\n"), "\n")
+		indent := -1
+		for _, line := range lines {
+			if strings.TrimSpace(line) == "" {
+				continue
+			}
+			n := len(line) - len(strings.TrimLeft(line, " \t"))
+			if indent < 0 || n < indent {
+				indent = n
+			}
+		}
+		if indent < 0 {
+			continue
+		}
+		if indent > 0 {
+			for idx, line := range lines {
+				if len(line) < indent {
+					lines[idx] = ""
+				} else {
+					lines[idx] = line[indent:]
+				}
+			}
+		}
+		res = append(res, paragraph{Text: strings.Join(lines, "\n"), Pre: indent > 0})
+	}
+	return res
+}
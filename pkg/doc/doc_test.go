@@ -0,0 +1,134 @@
+package doc
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/AzraelSec/cube/pkg/lexer"
+	"github.com/AzraelSec/cube/pkg/parser"
+)
+
+const source = `/// Adds two numbers.
+///
+///     add(1, 2)
+let add = fn(a: int, b): int { a + b };
+
+/// The largest <value>.
+let limit: int = 100;
+let _private = 1;
+let undocumented = fn(xs, f) { f(xs) };
+
+/// Calls f with x.
+fn apply(f: fn(int): int, x = 1) { f(x) }
+fn _helper() { 1 }
+add(1, 2);`
+
+func TestNew(t *testing.T) {
+	m := module(t, "lib/math.cb", source)
+
+	expected := []Entry{
+		{Name: "add", Signature: "fn add(a: int, b): int", Doc: "Adds two numbers.\n\n    add(1, 2)", Line: 4},
+		{Name: "limit", Signature: "let limit: int", Doc: "The largest <value>.", Line: 7},
+		{Name: "undocumented", Signature: "fn undocumented(xs, f)", Line: 9},
+		{Name: "apply", Signature: "fn apply(f: fn(int): int, x = 1)", Doc: "Calls f with x.", Line: 12},
+	}
+	if m.Name != "math" {
+		t.Errorf("wrong module name. got=%q", m.Name)
+	}
+	if len(m.Entries) != len(expected) {
+		t.Fatalf("wrong number of entries. got=%+v", m.Entries)
+	}
+	for idx, e := range m.Entries {
+		if e != expected[idx] {
+			t.Errorf("wrong entry %d.\ngot= %+v\nwant=%+v", idx, e, expected[idx])
+		}
+	}
+}
+
+func TestNewDestructuring(t *testing.T) {
+	m := module(t, "bounds.cb", "/// The bounds.\nlet [low, _mid, {high}] = [0, 1, {\"high\": 2}];")
+
+	expected := []Entry{
+		{Name: "low", Signature: "let low", Doc: "The bounds.", Line: 2},
+		{Name: "high", Signature: "let high", Doc: "The bounds.", Line: 2},
+	}
+	if len(m.Entries) != len(expected) {
+		t.Fatalf("wrong number of entries. got=%+v", m.Entries)
+	}
+	for idx, e := range m.Entries {
+		if e != expected[idx] {
+			t.Errorf("wrong entry %d.\ngot= %+v\nwant=%+v", idx, e, expected[idx])
+		}
+	}
+}
+
+func TestBuiltins(t *testing.T) {
+	entries := map[string]Entry{}
+	for _, e := range Builtins().Entries {
+		entries[e.Name] = e
+	}
+
+	tests := map[string]string{
+		"len":      "fn len(value: any): int",
+		"print":    "fn print(...values: any): null",
+		"assertEq": "fn assertEq(got: any, want: any, [message: any]): null",
+	}
+	for name, expected := range tests {
+		if entries[name].Signature != expected || entries[name].Doc == "" {
+			t.Errorf("%s: wrong entry %+v, want signature %q", name, entries[name], expected)
+		}
+	}
+}
+
+func TestWriteMarkdown(t *testing.T) {
+	var out bytes.Buffer
+	modules := []Module{module(t, "math.cb", source), {Name: "empty"}}
+	if err := WriteMarkdown(&out, modules); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := "# math\n" +
+		"\n## add\n\n```\nfn add(a: int, b): int\n```\n" +
+		"\nAdds two numbers.\n" +
+		"\n```\nadd(1, 2)\n```\n" +
+		"\n## limit\n\n```\nlet limit: int\n```\n" +
+		"\nThe largest <value>.\n" +
+		"\n## undocumented\n\n```\nfn undocumented(xs, f)\n```\n" +
+		"\n## apply\n\n```\nfn apply(f: fn(int): int, x = 1)\n```\n" +
+		"\nCalls f with x.\n" +
+		"\n# empty\n"
+	if out.String() != expected {
+		t.Errorf("wrong document.\ngot:\n%s\nwant:\n%s", out.String(), expected)
+	}
+}
+
+func TestWriteHTML(t *testing.T) {
+	var out bytes.Buffer
+	if err := WriteHTML(&out, []Module{module(t, "math.cb", source)}); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, expected := range []string{
+		`<li><a href="#math">math</a></li>`,
+		`<h3 id="math.add">add</h3>`,
+		`<pre class="signature">fn add(a: int, b): int</pre>`,
+		`<p>Adds two numbers.</p>`,
+		`<pre>add(1, 2)</pre>`,
+		`<p>The largest &lt;value&gt;.</p>`,
+	} {
+		if !strings.Contains(out.String(), expected) {
+			t.Errorf("missing %q in:\n%s", expected, out.String())
+		}
+	}
+}
+
+func module(t *testing.T, path, input string) Module {
+	t.Helper()
+	p := parser.New(lexer.New(input))
+	prog := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		t.Fatalf("unexpected parse errors %v", p.Errors())
+	}
+	return New(path, prog)
+}
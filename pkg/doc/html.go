@@ -0,0 +1,61 @@
+package doc
+
+import (
+	"html/template"
+	"io"
+)
+
+var page = template.Must(template.New("page").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>API reference</title>
+<style>
+body { font-family: sans-serif; margin: 2em; max-width: 60em; }
+pre { background: #f4f4f4; padding: 0.5em; }
+pre.signature { font-weight: bold; }
+</style>
+</head>
+<body>
+<h1>API reference</h1>
+<ul>
+{{- range .}}
+<li><a href="#{{.Name}}">{{.Name}}</a></li>
+{{- end}}
+</ul>
+{{- range $m := .}}
+<h2 id="{{$m.Name}}">{{$m.Name}}</h2>
+{{- range $m.Entries}}
+<h3 id="{{$m.Name}}.{{.Name}}">{{.Name}}</h3>
+<pre class="signature">{{.Signature}}</pre>
+{{- range .Paragraphs}}
+{{if .Pre}}<pre>{{.Text}}</pre>{{else}}<p>{{.Text}}</p>{{end}}
+{{- end}}
+{{- end}}
+{{- end}}
+</body>
+</html>
+`))
+
+type htmlEntry struct {
+	Entry
+	Paragraphs []paragraph
+}
+
+type htmlModule struct {
+	Name    string
+	Entries []htmlEntry
+}
+
+// WriteHTML writes the reference of the modules as a single HTML page, with
+// a section for each of them.
+func WriteHTML(w io.Writer, modules []Module) error {
+	data := make([]htmlModule, len(modules))
+	for idx, m := range modules {
+		data[idx].Name = m.Name
+		for _, e := range m.Entries {
+			data[idx].Entries = append(data[idx].Entries, htmlEntry{Entry: e, Paragraphs: paragraphs(e.Doc)})
+		}
+	}
+	return page.Execute(w, data)
+}
@@ -0,0 +1,31 @@
+package doc
+
+import (
+	"io"
+	"strings"
+)
+
+// WriteMarkdown writes the reference of the modules as a Markdown document,
+// with a section for each of them.
+func WriteMarkdown(w io.Writer, modules []Module) error {
+	var b strings.Builder
+	for idx, m := range modules {
+		if idx > 0 {
+			b.WriteString("\n")
+		}
+		b.WriteString("# " + m.Name + "\n")
+		for _, e := range m.Entries {
+			b.WriteString("\n## " + e.Name + "\n\n")
+			b.WriteString("```\n" + e.Signature + "\n```\n")
+			for _, p := range paragraphs(e.Doc) {
+				if p.Pre {
+					b.WriteString("\n```\n" + p.Text + "\n```\n")
+					continue
+				}
+				b.WriteString("\n" + p.Text + "\n")
+			}
+		}
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
@@ -0,0 +1,138 @@
+package evaluator
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/AzraelSec/cube/pkg/object"
+)
+
+// note: the assertion builtins are registered at init time, since
+// assertError calls functions, whose evaluation refers back to builtins
+func init() {
+	builtins["assert"] = &object.Builtin{
+		Name:    "assert",
+		MinArgs: 1,
+		MaxArgs: 2,
+		Params:  []object.Param{{Name: "condition", Type: "any"}, {Name: "message", Type: "any"}},
+		Result:  "null",
+		Doc:     "Fails with an error, prefixed by the message if any, when the condition is false.",
+		Fn: func(_ *object.Environment, o ...object.Object) object.Object {
+			if isTruthy(o[0]) {
+				return NULL
+			}
+			return newError("%s", failure("assert", "condition is %s", o[1:], show(o[0])))
+		},
+	}
+	builtins["assertEq"] = &object.Builtin{
+		Name:    "assertEq",
+		MinArgs: 2,
+		MaxArgs: 3,
+		Params:  []object.Param{{Name: "got", Type: "any"}, {Name: "want", Type: "any"}, {Name: "message", Type: "any"}},
+		Result:  "null",
+		Doc:     "Fails with an error, prefixed by the message if any, when the values differ, telling where the first difference is in arrays and hashes.",
+		Fn: func(_ *object.Environment, o ...object.Object) object.Object {
+			got, want := o[0], o[1]
+			if object.Equal(got, want) {
+				return NULL
+			}
+
+			msg := fmt.Sprintf("got %s, want %s", show(got), show(want))
+			if path, g, w := difference(got, want, ""); path != "" {
+				msg += fmt.Sprintf("; at %s: got %s, want %s", path, g, w)
+			}
+			return newError("%s", failure("assertEq", "%s", o[2:], msg))
+		},
+	}
+	builtins["assertError"] = &object.Builtin{
+		Name:    "assertError",
+		MinArgs: 1,
+		MaxArgs: 2,
+		Params:  []object.Param{{Name: "fn", Type: "fn()"}, {Name: "substring", Type: "string"}},
+		Result:  "null",
+		Doc:     "Calls a function taking no arguments, failing with an error when the call doesn't fail, or fails with an error not containing the substring.",
+		Fn: func(env *object.Environment, o ...object.Object) object.Object {
+			if fn, ok := o[0].(*object.Function); ok && fn.Required() != 0 {
+				return newError("argument to `assertError` must take no arguments, got %d parameters", fn.Required())
+			}
+
+			var substr string
+			if len(o) == 2 {
+				substr = o[1].(*object.String).Value
+			}
+
+			switch res := applyFunction(env, nil, o[0], nil).(type) {
+			case *object.Exit:
+				return res
+			case *object.Error:
+				if !strings.Contains(res.Msg, substr) {
+					return newError("assertError failed: error %q does not contain %q", res.Msg, substr)
+				}
+				return NULL
+			default:
+				return newError("assertError failed: no error, got %s", show(res))
+			}
+		},
+	}
+}
+
+// failure builds the message of a failed assertion, prefixed with the
+// optional message the assertion was given.
+func failure(name, format string, extra []object.Object, a ...interface{}) string {
+	msg := name + " failed: " + fmt.Sprintf(format, a...)
+	if len(extra) > 0 {
+		msg = extra[0].Inspect() + ": " + msg
+	}
+	return msg
+}
+
+// show renders a value in failure messages, quoting strings so that they
+// can be told apart from other values.
+func show(obj object.Object) string {
+	if str, ok := obj.(*object.String); ok {
+		return strconv.Quote(str.Value)
+	}
+	return obj.Inspect()
+}
+
+// difference returns where the first difference between got and want is,
+// if it's nested in arrays or hashes, along with the values found there.
+func difference(got, want object.Object, path string) (string, string, string) {
+	switch got := got.(type) {
+	case *object.Array:
+		want, ok := want.(*object.Array)
+		if !ok {
+			break
+		}
+		for idx := 0; idx < len(got.Elements) && idx < len(want.Elements); idx++ {
+			if !object.Equal(got.Elements[idx], want.Elements[idx]) {
+				return difference(got.Elements[idx], want.Elements[idx], fmt.Sprintf("%s[%d]", path, idx))
+			}
+		}
+		if len(got.Elements) != len(want.Elements) {
+			return path, fmt.Sprintf("%d elements", len(got.Elements)), fmt.Sprintf("%d elements", len(want.Elements))
+		}
+	case *object.Hash:
+		want, ok := want.(*object.Hash)
+		if !ok {
+			break
+		}
+		for _, pair := range want.Pairs() {
+			val, ok := got.Get(pair.Key)
+			key := fmt.Sprintf("%s[%s]", path, show(pair.Key))
+			if !ok {
+				return key, "nothing", show(pair.Value)
+			}
+			if !object.Equal(val, pair.Value) {
+				return difference(val, pair.Value, key)
+			}
+		}
+		for _, pair := range got.Pairs() {
+			if _, ok := want.Get(pair.Key); !ok {
+				return fmt.Sprintf("%s[%s]", path, show(pair.Key)), show(pair.Value), "nothing"
+			}
+		}
+	}
+	return path, show(got), show(want)
+}
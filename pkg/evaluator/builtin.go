@@ -3,34 +3,44 @@ package evaluator
 import (
 	"bufio"
 	"fmt"
-	"os"
+	"sort"
 	"strconv"
+	"strings"
 
 	"github.com/AzraelSec/cube/pkg/object"
 )
 
+// note: the arguments are validated against MinArgs, MaxArgs and the types
+// of Params before calling Fn, which is left with what annotations can't say
 var builtins = map[string]*object.Builtin{
 	"len": {
-		Fn: func(o ...object.Object) object.Object {
-			if err := checkBuiltinsLenParams(1, o...); err != nil {
-				return err
-			}
+		Name:    "len",
+		MinArgs: 1,
+		MaxArgs: 1,
+		Params:  []object.Param{{Name: "value", Type: "any"}},
+		Result:  "int",
+		Doc:     "Returns the number of bytes of a string, of elements of an array or of pairs of a hash.",
+		Fn: func(_ *object.Environment, o ...object.Object) object.Object {
 			switch arg := o[0].(type) {
 			case *object.String:
 				return &object.Integer{Value: int64(len(arg.Value))}
 			case *object.Array:
 				return &object.Integer{Value: int64(len(arg.Elements))}
+			case *object.Hash:
+				return &object.Integer{Value: int64(arg.Len())}
 			default:
 				return newError("argument to `len` not supported, got %s", arg.Type())
 			}
 		},
 	},
 	"first": {
-		Fn: func(o ...object.Object) object.Object {
-			if err := checkBuiltinsLenParams(1, o...); err != nil {
-				return err
-			}
-
+		Name:    "first",
+		MinArgs: 1,
+		MaxArgs: 1,
+		Params:  []object.Param{{Name: "value", Type: "any"}},
+		Result:  "any",
+		Doc:     "Returns the first element of an array, null if it's empty, or the first character of a string.",
+		Fn: func(_ *object.Environment, o ...object.Object) object.Object {
 			switch arg := o[0].(type) {
 			case *object.Array:
 				if len(arg.Elements) == 0 {
@@ -48,10 +58,13 @@ var builtins = map[string]*object.Builtin{
 		},
 	},
 	"last": {
-		Fn: func(o ...object.Object) object.Object {
-			if err := checkBuiltinsLenParams(1, o...); err != nil {
-				return err
-			}
+		Name:    "last",
+		MinArgs: 1,
+		MaxArgs: 1,
+		Params:  []object.Param{{Name: "value", Type: "any"}},
+		Result:  "any",
+		Doc:     "Returns the last element of an array, null if it's empty, or the last character of a string.",
+		Fn: func(_ *object.Environment, o ...object.Object) object.Object {
 			switch arg := o[0].(type) {
 			case *object.Array:
 				if len(arg.Elements) == 0 {
@@ -69,54 +82,164 @@ var builtins = map[string]*object.Builtin{
 		},
 	},
 	"rest": {
-		Fn: func(o ...object.Object) object.Object {
-			if err := checkBuiltinsLenParams(1, o...); err != nil {
-				return err
-			}
-			switch arg := o[0].(type) {
-			case *object.Array:
-				if len(arg.Elements) == 0 {
-					return &object.Array{Elements: []object.Object{}}
-				}
-				return &object.Array{Elements: arg.Elements[1:]}
-			default:
-				return newError("argument to `rest` not supported, got %s", arg.Type())
+		Name:    "rest",
+		MinArgs: 1,
+		MaxArgs: 1,
+		Params:  []object.Param{{Name: "array", Type: "[any]"}},
+		Result:  "[any]",
+		Doc:     "Returns the elements of an array but the first one.",
+		Fn: func(_ *object.Environment, o ...object.Object) object.Object {
+			arg := o[0].(*object.Array)
+			if len(arg.Elements) == 0 {
+				return &object.Array{Elements: []object.Object{}}
 			}
+			return &object.Array{Elements: arg.Elements[1:]}
 		},
 	},
 	"push": {
-		Fn: func(o ...object.Object) object.Object {
-			if err := checkBuiltinsLenParams(2, o...); err != nil {
-				return err
+		Name:    "push",
+		MinArgs: 2,
+		MaxArgs: 2,
+		Params:  []object.Param{{Name: "array", Type: "[any]"}, {Name: "value", Type: "any"}},
+		Result:  "[any]",
+		Doc:     "Returns a copy of an array with a value appended.",
+		Fn: func(_ *object.Environment, o ...object.Object) object.Object {
+			arg := o[0].(*object.Array)
+			arr := make([]object.Object, len(arg.Elements)+1, len(arg.Elements)+1)
+			copy(arr, arg.Elements)
+			arr[len(arr)-1] = o[1]
+			return &object.Array{Elements: arr}
+		},
+	},
+	"keys": {
+		Name:    "keys",
+		MinArgs: 1,
+		MaxArgs: 1,
+		Params:  []object.Param{{Name: "hash", Type: "{any: any}"}},
+		Result:  "[any]",
+		Doc:     "Returns the keys of a hash, in insertion order.",
+		Fn: func(_ *object.Environment, o ...object.Object) object.Object {
+			arg := o[0].(*object.Hash)
+			keys := make([]object.Object, arg.Len())
+			for idx, pair := range arg.Pairs() {
+				keys[idx] = pair.Key
+			}
+			return &object.Array{Elements: keys}
+		},
+	},
+	"values": {
+		Name:    "values",
+		MinArgs: 1,
+		MaxArgs: 1,
+		Params:  []object.Param{{Name: "hash", Type: "{any: any}"}},
+		Result:  "[any]",
+		Doc:     "Returns the values of a hash, in insertion order.",
+		Fn: func(_ *object.Environment, o ...object.Object) object.Object {
+			arg := o[0].(*object.Hash)
+			values := make([]object.Object, arg.Len())
+			for idx, pair := range arg.Pairs() {
+				values[idx] = pair.Value
+			}
+			return &object.Array{Elements: values}
+		},
+	},
+	"entries": {
+		Name:    "entries",
+		MinArgs: 1,
+		MaxArgs: 1,
+		Params:  []object.Param{{Name: "hash", Type: "{any: any}"}},
+		Result:  "[[any]]",
+		Doc:     "Returns the [key, value] pairs of a hash, in insertion order.",
+		Fn: func(_ *object.Environment, o ...object.Object) object.Object {
+			arg := o[0].(*object.Hash)
+			entries := make([]object.Object, arg.Len())
+			for idx, pair := range arg.Pairs() {
+				entries[idx] = &object.Array{Elements: []object.Object{pair.Key, pair.Value}}
+			}
+			return &object.Array{Elements: entries}
+		},
+	},
+	"has": {
+		Name:    "has",
+		MinArgs: 2,
+		MaxArgs: 2,
+		Params:  []object.Param{{Name: "hash", Type: "{any: any}"}, {Name: "key", Type: "any"}},
+		Result:  "bool",
+		Doc:     "Reports whether a hash has a key.",
+		Fn: func(_ *object.Environment, o ...object.Object) object.Object {
+			hash := o[0].(*object.Hash)
+			if _, ok := object.HashKeyOf(o[1]); !ok {
+				return newError("not hashable key: %s", o[1].Type())
 			}
 
-			switch arg := o[0].(type) {
-			case *object.Array:
-				arr := make([]object.Object, len(arg.Elements)+1, len(arg.Elements)+1)
-				copy(arr, arg.Elements)
-				arr[len(arr)-1] = o[1]
-				return &object.Array{Elements: arr}
-			default:
-				return newError("argument to `push` not supported, got %s", arg.Type())
+			_, ok := hash.Get(o[1])
+			return nativeBooleanMap(ok)
+		},
+	},
+	"delete": {
+		Name:    "delete",
+		MinArgs: 2,
+		MaxArgs: 2,
+		Params:  []object.Param{{Name: "hash", Type: "{any: any}"}, {Name: "key", Type: "any"}},
+		Result:  "{any: any}",
+		Doc:     "Returns a copy of a hash without a key.",
+		Fn: func(_ *object.Environment, o ...object.Object) object.Object {
+			hash := o[0].(*object.Hash)
+			if _, ok := object.HashKeyOf(o[1]); !ok {
+				return newError("not hashable key: %s", o[1].Type())
+			}
+
+			// note: hashes are immutable, so we return a copy without the key
+			res := &object.Hash{}
+			for _, pair := range hash.Pairs() {
+				res.Set(pair.Key, pair.Value)
 			}
+			res.Delete(o[1])
+			return res
+		},
+	},
+	"merge": {
+		Name:    "merge",
+		MinArgs: 0,
+		MaxArgs: -1,
+		Params:  []object.Param{{Name: "hashes", Type: "{any: any}"}},
+		Result:  "{any: any}",
+		Doc:     "Returns a hash with the pairs of all the hashes, the later ones winning on the keys they share.",
+		Fn: func(_ *object.Environment, o ...object.Object) object.Object {
+			res := &object.Hash{}
+			for _, arg := range o {
+				// note: later hashes win on conflicting keys, which keep their first position
+				for _, pair := range arg.(*object.Hash).Pairs() {
+					res.Set(pair.Key, pair.Value)
+				}
+			}
+			return res
 		},
 	},
 	"print": {
-		Fn: func(o ...object.Object) object.Object {
+		Name:    "print",
+		MinArgs: 0,
+		MaxArgs: -1,
+		Params:  []object.Param{{Name: "values", Type: "any"}},
+		Result:  "null",
+		Doc:     "Writes the values to the standard output, with no separator, followed by a newline.",
+		Fn: func(env *object.Environment, o ...object.Object) object.Object {
+			out := env.Runtime().Stdout
 			for _, arg := range o {
-				fmt.Print(arg.Inspect())
+				fmt.Fprint(out, arg.Inspect())
 			}
-			fmt.Println()
+			fmt.Fprintln(out)
 			return NULL
 		},
 	},
 	"read": {
-		Fn: func(o ...object.Object) object.Object {
-			if err := checkBuiltinsLenParams(0); err != nil {
-				return err
-			}
-
-			reader := bufio.NewReader(os.Stdin)
+		Name:    "read",
+		MinArgs: 0,
+		MaxArgs: 0,
+		Result:  "string",
+		Doc:     "Reads a line from the standard input, without the newline.",
+		Fn: func(env *object.Environment, o ...object.Object) object.Object {
+			reader := bufio.NewReader(env.Runtime().Stdin)
 			str, err := reader.ReadString('\n')
 			if err != nil {
 				return newError("impossible to read from stdin")
@@ -125,11 +248,13 @@ var builtins = map[string]*object.Builtin{
 		},
 	},
 	"int": {
-		Fn: func(o ...object.Object) object.Object {
-			if err := checkBuiltinsLenParams(1, o...); err != nil {
-				return err
-			}
-
+		Name:    "int",
+		MinArgs: 1,
+		MaxArgs: 1,
+		Params:  []object.Param{{Name: "value", Type: "any"}},
+		Result:  "int",
+		Doc:     "Converts a string, an integer or a boolean to an integer.",
+		Fn: func(_ *object.Environment, o ...object.Object) object.Object {
 			switch arg := o[0].(type) {
 			case *object.String:
 				res, err := strconv.ParseInt(arg.Value, 10, 64)
@@ -149,11 +274,188 @@ var builtins = map[string]*object.Builtin{
 			}
 		},
 	},
+	"exit": {
+		Name:    "exit",
+		MinArgs: 0,
+		MaxArgs: 1,
+		Params:  []object.Param{{Name: "code", Type: "int"}},
+		Result:  "never",
+		Doc:     "Stops the program with an exit code, zero by default.",
+		Fn: func(_ *object.Environment, o ...object.Object) object.Object {
+			if len(o) == 0 {
+				return &object.Exit{Code: 0}
+			}
+			return &object.Exit{Code: o[0].(*object.Integer).Value}
+		},
+	},
+	"str": {
+		Name:    "str",
+		MinArgs: 1,
+		MaxArgs: 1,
+		Params:  []object.Param{{Name: "value", Type: "any"}},
+		Result:  "string",
+		Doc:     "Converts a value to a string, as print shows it.",
+		Fn: func(_ *object.Environment, o ...object.Object) object.Object {
+			if str, ok := o[0].(*object.String); ok {
+				return str
+			}
+			return &object.String{Value: o[0].Inspect()}
+		},
+	},
+	"type": {
+		Name:    "type",
+		MinArgs: 1,
+		MaxArgs: 1,
+		Params:  []object.Param{{Name: "value", Type: "any"}},
+		Result:  "string",
+		Doc:     "Returns the name of the type of a value, like INTEGER or ARRAY.",
+		Fn: func(_ *object.Environment, o ...object.Object) object.Object {
+			return &object.String{Value: string(o[0].Type())}
+		},
+	},
+	"bool": {
+		Name:    "bool",
+		MinArgs: 1,
+		MaxArgs: 1,
+		Params:  []object.Param{{Name: "value", Type: "any"}},
+		Result:  "bool",
+		Doc:     "Converts a value to a boolean: only false and null are false, 0 and empty strings, arrays and hashes are true.",
+		Fn: func(_ *object.Environment, o ...object.Object) object.Object {
+			return nativeBooleanMap(isTruthy(o[0]))
+		},
+	},
+	"name": {
+		Name:    "name",
+		MinArgs: 1,
+		MaxArgs: 1,
+		Params:  []object.Param{{Name: "fn", Type: "any"}},
+		Result:  "string",
+		Doc:     "Returns the name of a function, empty if it's anonymous.",
+		Fn: func(_ *object.Environment, o ...object.Object) object.Object {
+			switch fn := o[0].(type) {
+			case *object.Function:
+				return &object.String{Value: fn.Name}
+			case *object.Builtin:
+				return &object.String{Value: fn.Name}
+			default:
+				return newError("argument to `name` not supported, got %s", fn.Type())
+			}
+		},
+	},
+	"arity": {
+		Name:    "arity",
+		MinArgs: 1,
+		MaxArgs: 1,
+		Params:  []object.Param{{Name: "fn", Type: "any"}},
+		Result:  "int",
+		Doc:     "Returns the number of arguments a function requires.",
+		Fn: func(_ *object.Environment, o ...object.Object) object.Object {
+			switch fn := o[0].(type) {
+			case *object.Function:
+				return &object.Integer{Value: int64(fn.Required())}
+			case *object.Builtin:
+				return &object.Integer{Value: int64(fn.MinArgs)}
+			default:
+				return newError("argument to `arity` not supported, got %s", fn.Type())
+			}
+		},
+	},
+	"params": {
+		Name:    "params",
+		MinArgs: 1,
+		MaxArgs: 1,
+		Params:  []object.Param{{Name: "fn", Type: "any"}},
+		Result:  "[string]",
+		Doc:     "Returns the names of the parameters of a function.",
+		Fn: func(_ *object.Environment, o ...object.Object) object.Object {
+			names := []object.Object{}
+			switch fn := o[0].(type) {
+			case *object.Function:
+				for _, p := range fn.Parameters {
+					names = append(names, &object.String{Value: p.Value})
+				}
+			case *object.Builtin:
+				for _, p := range fn.Params {
+					names = append(names, &object.String{Value: p.Name})
+				}
+			default:
+				return newError("argument to `params` not supported, got %s", fn.Type())
+			}
+			return &object.Array{Elements: names}
+		},
+	},
+}
+
+// LookupBuiltin returns the builtin function called name, if any.
+func LookupBuiltin(name string) (*object.Builtin, bool) {
+	builtin, ok := builtins[name]
+	return builtin, ok
 }
 
-func checkBuiltinsLenParams(expected int, o ...object.Object) *object.Error {
-	if len(o) != expected {
-		return newError("wrong number of arguments. got=%d, want=1", len(o))
+// BuiltinNames returns the names of every builtin function, sorted.
+func BuiltinNames() []string {
+	names := make([]string, 0, len(builtins))
+	for name := range builtins {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+var ordinals = []string{"first", "second", "third"}
+
+// checkBuiltinArgs validates the number and the types of the arguments of
+// a call to a builtin.
+func checkBuiltinArgs(b *object.Builtin, args []object.Object) *object.Error {
+	if len(args) < b.MinArgs || b.MaxArgs >= 0 && len(args) > b.MaxArgs {
+		return newError("wrong number of arguments. got=%d, want=%s", len(args), b.Arity())
+	}
+
+	for idx, arg := range args {
+		// note: the last parameter takes the variadic arguments
+		param := b.Params[len(b.Params)-1]
+		if idx < len(b.Params) {
+			param = b.Params[idx]
+		}
+		want, ok := accepts(param.Type, arg)
+		switch {
+		case ok:
+		case idx == 0 || b.MaxArgs < 0:
+			return newError("argument to `%s` not supported, got %s", b.Name, arg.Type())
+		case idx < len(ordinals):
+			return newError("%s argument to `%s` must be %s, got %s", ordinals[idx], b.Name, want, arg.Type())
+		default:
+			return newError("argument %d to `%s` must be %s, got %s", idx+1, b.Name, want, arg.Type())
+		}
 	}
 	return nil
 }
+
+// accepts reports whether obj can be passed for a parameter annotated with
+// typ, returning the type of objects it wants otherwise. Only the outer type
+// is checked: elements are left to the type checker.
+func accepts(typ string, obj object.Object) (object.ObjectType, bool) {
+	var want object.ObjectType
+	switch {
+	case typ == "any":
+		return "", true
+	case typ == "int":
+		want = object.INTEGER_OBJ
+	case typ == "string":
+		want = object.STRING_OBJ
+	case typ == "bool":
+		want = object.BOOLEAN_OBJ
+	case typ == "null":
+		want = object.NULL_OBJ
+	case strings.HasPrefix(typ, "["):
+		want = object.ARRAY_OBJ
+	case strings.HasPrefix(typ, "{"):
+		want = object.HASH_OBJ
+	case strings.HasPrefix(typ, "fn"):
+		want = object.FUNCTION_OBJ
+		if obj.Type() == object.BUILTIN_OBJ {
+			return want, true
+		}
+	}
+	return want, obj.Type() == want
+}
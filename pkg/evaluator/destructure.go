@@ -0,0 +1,180 @@
+package evaluator
+
+import (
+	"fmt"
+
+	"github.com/AzraelSec/cube/pkg/ast"
+	"github.com/AzraelSec/cube/pkg/object"
+)
+
+// destructure binds the variables of p to the parts of val, evaluating the
+// default values of the missing ones in env, after the elements before them
+// are bound. A non nil result is the error or exit that interrupted it.
+func destructure(p ast.Pattern, val object.Object, env *object.Environment) object.Object {
+	mismatch, halt := bindPattern(p, val, env)
+	if halt != nil {
+		return halt
+	}
+	if mismatch != "" {
+		return newError("%s", mismatch)
+	}
+	return nil
+}
+
+// bindPattern matches val against p, binding its variables in env. It
+// returns why val doesn't match, empty if it does, or the error or exit
+// that interrupted it. The variables bound before a mismatch stay bound,
+// match arms dropping them with their scope.
+func bindPattern(p ast.Pattern, val object.Object, env *object.Environment) (string, object.Object) {
+	switch p := p.(type) {
+	case *ast.Identifier:
+		bind(p, val, env)
+		return "", nil
+	case *ast.WildcardPattern:
+		return "", nil
+	case *ast.LiteralPattern:
+		lit := Eval(p.Value, env)
+		if isHalting(lit) {
+			return "", lit
+		}
+		if !object.Equal(lit, val) {
+			return fmt.Sprintf("%s doesn't match %s", val.Inspect(), p), nil
+		}
+		return "", nil
+	case *ast.TypePattern:
+		if !matchesType(p.Type, val) {
+			return fmt.Sprintf("%s isn't of type %s", val.Type(), p.Type), nil
+		}
+		return bindPattern(p.Target, val, env)
+	case *ast.ArrayPattern:
+		return bindArray(p, val, env)
+	case *ast.HashPattern:
+		return bindHash(p, val, env)
+	default:
+		return fmt.Sprintf("unknown pattern %s", p), nil
+	}
+}
+
+func bindArray(p *ast.ArrayPattern, val object.Object, env *object.Environment) (string, object.Object) {
+	arr, ok := val.(*object.Array)
+	if !ok {
+		return fmt.Sprintf("cannot destructure %s with array pattern %s", val.Type(), p), nil
+	}
+
+	required := 0
+	for idx, elem := range p.Elements {
+		if elem.Default == nil {
+			required = idx + 1
+		}
+	}
+	atMost := len(p.Elements)
+	if p.Rest != nil {
+		atMost = -1
+	}
+	if len(arr.Elements) < required || atMost >= 0 && len(arr.Elements) > atMost {
+		return fmt.Sprintf("wrong number of elements for %s. got=%d, want=%s", p, len(arr.Elements), object.DescribeArity(required, atMost)), nil
+	}
+
+	for idx, elem := range p.Elements {
+		var val object.Object
+		if idx < len(arr.Elements) {
+			val = arr.Elements[idx]
+		}
+		if mismatch, halt := bindElement(elem, val, env); mismatch != "" || halt != nil {
+			return mismatch, halt
+		}
+	}
+	if p.Rest != nil {
+		rest := []object.Object{}
+		if len(arr.Elements) > len(p.Elements) {
+			rest = append(rest, arr.Elements[len(p.Elements):]...)
+		}
+		bind(p.Rest, &object.Array{Elements: rest}, env)
+	}
+	return "", nil
+}
+
+func bindHash(p *ast.HashPattern, val object.Object, env *object.Environment) (string, object.Object) {
+	hash, ok := val.(*object.Hash)
+	if !ok {
+		return fmt.Sprintf("cannot destructure %s with hash pattern %s", val.Type(), p), nil
+	}
+
+	for _, elem := range p.Elements {
+		val, ok := hash.Get(&object.String{Value: elem.Key})
+		if !ok && elem.Default == nil {
+			return fmt.Sprintf("missing key %q for hash pattern %s", elem.Key, p), nil
+		}
+		if mismatch, halt := bindElement(elem, val, env); mismatch != "" || halt != nil {
+			return mismatch, halt
+		}
+	}
+	if p.Rest != nil {
+		rest := &object.Hash{}
+		for _, pair := range hash.Pairs() {
+			rest.Set(pair.Key, pair.Value)
+		}
+		for _, elem := range p.Elements {
+			rest.Delete(&object.String{Value: elem.Key})
+		}
+		bind(p.Rest, rest, env)
+	}
+	return "", nil
+}
+
+// bindElement binds the target of elem to val, or to its default value when
+// val is nil.
+func bindElement(elem ast.PatternElement, val object.Object, env *object.Environment) (string, object.Object) {
+	if val == nil {
+		val = Eval(elem.Default, env)
+		if isHalting(val) {
+			return "", val
+		}
+	}
+	return bindPattern(elem.Target, val, env)
+}
+
+// matchesType reports whether val is of the type described by te. Functions
+// match any function type, their signatures being left to the checker.
+func matchesType(te ast.TypeExpression, val object.Object) bool {
+	switch te := te.(type) {
+	case *ast.TypeName:
+		switch te.Name {
+		case "any":
+			return true
+		case "int":
+			return val.Type() == object.INTEGER_OBJ
+		case "string":
+			return val.Type() == object.STRING_OBJ
+		case "bool":
+			return val.Type() == object.BOOLEAN_OBJ
+		case "null":
+			return val.Type() == object.NULL_OBJ
+		}
+	case *ast.ArrayType:
+		arr, ok := val.(*object.Array)
+		if !ok {
+			return false
+		}
+		for _, elem := range arr.Elements {
+			if !matchesType(te.Elem, elem) {
+				return false
+			}
+		}
+		return true
+	case *ast.HashType:
+		hash, ok := val.(*object.Hash)
+		if !ok {
+			return false
+		}
+		for _, pair := range hash.Pairs() {
+			if !matchesType(te.Key, pair.Key) || !matchesType(te.Value, pair.Value) {
+				return false
+			}
+		}
+		return true
+	case *ast.FunctionType:
+		return val.Type() == object.FUNCTION_OBJ || val.Type() == object.BUILTIN_OBJ
+	}
+	return false
+}
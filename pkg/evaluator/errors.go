@@ -10,9 +10,11 @@ func newError(format string, a ...interface{}) *object.Error {
 	return &object.Error{Msg: fmt.Sprintf(format, a...)}
 }
 
-func isError(obj object.Object) bool {
-	if obj != nil && obj.Type() == object.ERROR_OBJ {
-		return true
+// isHalting reports whether obj must interrupt the evaluation: both errors
+// and exit requests unwind every enclosing expression and call.
+func isHalting(obj object.Object) bool {
+	if obj == nil {
+		return false
 	}
-	return false
+	return obj.Type() == object.ERROR_OBJ || obj.Type() == object.EXIT_OBJ
 }
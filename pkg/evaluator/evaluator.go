@@ -1,8 +1,12 @@
 package evaluator
 
 import (
+	"strings"
+
 	"github.com/AzraelSec/cube/pkg/ast"
 	"github.com/AzraelSec/cube/pkg/object"
+	"github.com/AzraelSec/cube/pkg/parser"
+	"github.com/AzraelSec/cube/pkg/resolver"
 )
 
 var (
@@ -11,9 +15,53 @@ var (
 	FALSE = &object.Boolean{Value: false}
 )
 
+// Hooks is an object.Hook notifying each of its hooks in turn. The
+// evaluation is interrupted by the first one asking for it.
+type Hooks []object.Hook
+
+func (hs Hooks) Statement(stm ast.Statement, env *object.Environment) object.Object {
+	for _, h := range hs {
+		if halt := h.Statement(stm, env); halt != nil {
+			return halt
+		}
+	}
+	return nil
+}
+
+func (hs Hooks) Call(call *ast.CallExpression, fn *object.Function, env *object.Environment) {
+	for _, h := range hs {
+		h.Call(call, fn, env)
+	}
+}
+
+func (hs Hooks) Return(call *ast.CallExpression, result object.Object) {
+	for _, h := range hs {
+		h.Return(call, result)
+	}
+}
+
+func (hs Hooks) Branch(ie *ast.IfExpression, taken bool) {
+	for _, h := range hs {
+		h.Branch(ie, taken)
+	}
+}
+
+// Resolve runs the resolver on prog, which is going to be evaluated in env.
+func Resolve(prog *ast.Program, env *object.Environment) []parser.Error {
+	return resolver.Resolve(prog, append(BuiltinNames(), env.Names()...))
+}
+
+// Eval evaluates node in env. The tree must have gone through the resolver
+// first, so that its identifiers are bound to their variables, which Eval
+// does itself for the programs that didn't, failing on the errors found.
 func Eval(node ast.Node, env *object.Environment) object.Object {
 	switch node := node.(type) {
 	case *ast.Program:
+		if !node.Resolved {
+			if errs := Resolve(node, env); len(errs) != 0 {
+				return newError("%s", strings.Join(parser.ErrorStrings(errs), "; "))
+			}
+		}
 		return evalProgram(node.Statements, env)
 	case *ast.ExpressionStatement:
 		return Eval(node.Expression, env)
@@ -26,23 +74,30 @@ func Eval(node ast.Node, env *object.Environment) object.Object {
 	case *ast.PrefixExpression:
 		return evalPrefixExpression(node.Operator, Eval(node.Right, env))
 	case *ast.InfixExpression:
-		return evalInfixExpression(node.Operator, Eval(node.Left, env), Eval(node.Right, env))
+		return evalInfixExpression(node.Operator, Eval(node.Left, env), Eval(node.Right, env), env.Runtime().Strict)
 	case *ast.BlockStatement:
 		return evalBlockStatement(node, env)
 	case *ast.IfExpression:
 		return evalIfExpression(node, env)
+	case *ast.MatchExpression:
+		return evalMatchExpression(node, env)
 	case *ast.ReturnStatement:
 		val := Eval(node.RetValue, env)
-		if isError(val) {
+		if isHalting(val) {
 			return val
 		}
 		return &object.ReturnValue{Value: val}
 	case *ast.LetStatement:
 		val := Eval(node.Value, env)
-		if isError(val) {
+		if isHalting(val) {
 			return val
 		}
-		env.Set(node.Name.Value, val)
+		if node.Pattern != nil {
+			return destructure(node.Pattern, val, env)
+		}
+		bind(node.Name, val, env)
+	case *ast.FunctionStatement:
+		// note: the function was bound when its block started
 	case *ast.Identifier:
 		return evalIdentifier(node, env)
 	case *ast.FunctionLiteral:
@@ -60,56 +115,249 @@ func Eval(node ast.Node, env *object.Environment) object.Object {
 }
 
 func evalIdentifier(node *ast.Identifier, env *object.Environment) object.Object {
-	if val, ok := env.Get(node.Value); ok {
+	if val, ok := lookup(node.Value, node.Binding, env); ok {
 		return val
 	}
-	if builtin, ok := builtins[node.Value]; ok {
-		return builtin
-	}
 	return newError("identifier not found: %s", node.Value)
 }
 
+// lookup returns the value of the variable called name, bound as b.
+func lookup(name string, b ast.Binding, env *object.Environment) (object.Object, bool) {
+	if b.Scope == ast.Local {
+		// note: a nil slot is a variable whose let wasn't evaluated yet
+		if val := env.GetSlot(b.Depth, b.Slot); val != nil {
+			return val, true
+		}
+		if b.Outer == nil {
+			return nil, false
+		}
+		return lookup(name, *b.Outer, env)
+	}
+
+	if val, ok := env.Get(name); ok {
+		return val, true
+	}
+	if builtin, ok := builtins[name]; ok {
+		return builtin, true
+	}
+	return nil, false
+}
+
 func evalFuncLiteral(node *ast.FunctionLiteral, env *object.Environment) object.Object {
-	return &object.Function{Parameters: node.Parameters, Body: node.Body, Env: env}
+	return &object.Function{
+		Name:       node.Name,
+		Parameters: node.Parameters,
+		Defaults:   node.Defaults,
+		Patterns:   node.Patterns,
+		Rest:       node.Rest,
+		Body:       node.Body,
+		Slots:      node.Slots,
+		Env:        env,
+		Doc:        node.Doc,
+	}
+}
+
+// bind stores val in the variable name is bound to.
+func bind(name *ast.Identifier, val object.Object, env *object.Environment) {
+	if name.Binding.Scope == ast.Local {
+		env.SetSlot(name.Binding.Depth, name.Binding.Slot, val)
+		return
+	}
+	env.Set(name.Value, val)
 }
 
 func evalCallExpression(node *ast.CallExpression, env *object.Environment) object.Object {
 	function := Eval(node.Function, env)
-	if isError(function) {
+	if isHalting(function) {
 		return function
 	}
 
-	evalParams, ok := evalExpressionList(node.Args, env)
-	if !ok {
-		// note: we assert it to be something based on our implementation
-		return evalParams[0]
+	args, keywords, halt := evalArguments(node.Args, env)
+	if halt != nil {
+		return halt
+	}
+	if len(keywords) != 0 {
+		if args, halt = placeKeywords(function, args, keywords); halt != nil {
+			return halt
+		}
+	}
+
+	return applyFunction(env, node, function, args)
+}
+
+// keyword is an argument passed by the name of its parameter.
+type keyword struct {
+	name  string
+	value object.Object
+}
+
+// evalArguments evaluates the arguments of a call, expanding the spread
+// arrays and setting the keyword arguments apart. A non nil result is the
+// error or exit that interrupted the evaluation.
+func evalArguments(exps []ast.Expression, env *object.Environment) ([]object.Object, []keyword, object.Object) {
+	args := []object.Object{}
+	keywords := []keyword{}
+
+	for _, exp := range exps {
+		switch exp := exp.(type) {
+		case *ast.SpreadExpression:
+			val := Eval(exp.Value, env)
+			if isHalting(val) {
+				return nil, nil, val
+			}
+			arr, ok := val.(*object.Array)
+			if !ok {
+				return nil, nil, newError("spread argument must be ARRAY, got %s", val.Type())
+			}
+			args = append(args, arr.Elements...)
+		case *ast.KeywordArgument:
+			val := Eval(exp.Value, env)
+			if isHalting(val) {
+				return nil, nil, val
+			}
+			keywords = append(keywords, keyword{name: exp.Name, value: val})
+		default:
+			val := Eval(exp, env)
+			if isHalting(val) {
+				return nil, nil, val
+			}
+			args = append(args, val)
+		}
 	}
+	return args, keywords, nil
+}
+
+// placeKeywords puts the keyword arguments after the positional ones, at
+// the index of their parameters. The parameters left out in between are nil,
+// for the defaults to fill them.
+func placeKeywords(fn object.Object, args []object.Object, keywords []keyword) ([]object.Object, object.Object) {
+	var names []string
+	var label string
+	rest := false
+	switch fn := fn.(type) {
+	case *object.Function:
+		for _, p := range fn.Parameters {
+			names = append(names, p.Value)
+		}
+		label, rest = fn.Label(), fn.Rest
+	case *object.Builtin:
+		for _, p := range fn.Params {
+			names = append(names, p.Name)
+		}
+		label, rest = fn.Name, fn.MaxArgs < 0
+	default:
+		return nil, newError("not a function: %s", fn.Type())
+	}
+
+	placed := append([]object.Object{}, args...)
+	for _, kw := range keywords {
+		idx := indexOf(names, kw.name)
+		switch {
+		case idx < 0:
+			return nil, newError("%s has no parameter named %s", label, kw.name)
+		case rest && idx == len(names)-1:
+			return nil, newError("rest parameter %s of %s can't be passed by name", kw.name, label)
+		case idx < len(placed) && placed[idx] != nil:
+			return nil, newError("multiple values for parameter %s of %s", kw.name, label)
+		}
+		for len(placed) <= idx {
+			placed = append(placed, nil)
+		}
+		placed[idx] = kw.value
+	}
+
+	if _, ok := fn.(*object.Builtin); ok {
+		// note: builtins have no defaults, their optional parameters can only be left out at the end
+		for idx, arg := range placed {
+			if arg == nil {
+				return nil, newError("missing argument for parameter %s of %s", names[idx], label)
+			}
+		}
+	}
+	return placed, nil
+}
 
-	return applyFunction(function, evalParams)
+func indexOf(names []string, name string) int {
+	for idx, n := range names {
+		if n == name {
+			return idx
+		}
+	}
+	return -1
 }
-func applyFunction(fn object.Object, args []object.Object) object.Object {
+
+// Apply calls fn with args from env, as a builtin would, letting tools call
+// the functions of a program.
+func Apply(env *object.Environment, fn object.Object, args ...object.Object) object.Object {
+	return applyFunction(env, nil, fn, args)
+}
+
+func applyFunction(env *object.Environment, call *ast.CallExpression, fn object.Object, args []object.Object) object.Object {
 	switch function := fn.(type) {
 	case *object.Function:
-		if len(args) != len(function.Parameters) {
-			return newError("wrong number of arguments for function %s: %d instead of %d", "IDK", len(args), len(function.Parameters))
+		if len(args) < function.Required() || !function.Rest && len(args) > len(function.Parameters) {
+			return newError("wrong number of arguments for %s. got=%d, want=%s", function.Label(), len(args), function.Arity())
+		}
+
+		extEnv, halt := extendedFunctionEnv(function, args)
+		if halt != nil {
+			return halt
+		}
+		hook := extEnv.Runtime().Hook
+		if hook == nil {
+			return unwrapReturnValue(Eval(function.Body, extEnv))
 		}
 
-		extEnv := extendedFunctionEnv(function, args)
-		evaluated := Eval(function.Body, extEnv)
-		return unwrapReturnValue(evaluated)
+		hook.Call(call, function, extEnv)
+		evaluated := unwrapReturnValue(Eval(function.Body, extEnv))
+		hook.Return(call, evaluated)
+		return evaluated
 	case *object.Builtin:
-		// todo: add validation on numbers of parameters
-		return function.Fn(args...)
+		if err := checkBuiltinArgs(function, args); err != nil {
+			return err
+		}
+		return function.Fn(env, args...)
 	default:
 		return newError("not a function: %s", fn.Type())
 	}
 }
-func extendedFunctionEnv(fn *object.Function, args []object.Object) *object.Environment {
-	env := object.NewEnclosedEnvironment(fn.Env)
+
+// extendedFunctionEnv returns the frame of a call of fn, binding the
+// parameters to args. The ones with no argument, or a nil one, take their
+// default value, evaluated in the environment fn was defined in, and the
+// rest parameter takes an array of the remaining arguments. The parameters
+// with a pattern are then destructured in the frame.
+func extendedFunctionEnv(fn *object.Function, args []object.Object) (*object.Environment, object.Object) {
+	env := object.NewFrame(fn)
 	for idx, param := range fn.Parameters {
-		env.Set(param.Value, args[idx])
+		switch {
+		case fn.Rest && idx == len(fn.Parameters)-1:
+			rest := []object.Object{}
+			if idx < len(args) {
+				rest = append(rest, args[idx:]...)
+			}
+			bind(param, &object.Array{Elements: rest}, env)
+		case idx < len(args) && args[idx] != nil:
+			bind(param, args[idx], env)
+		case idx < len(fn.Defaults) && fn.Defaults[idx] != nil:
+			val := Eval(fn.Defaults[idx], fn.Env)
+			if isHalting(val) {
+				return nil, val
+			}
+			bind(param, val, env)
+		default:
+			return nil, newError("missing argument for parameter %s of %s", param.Value, fn.Label())
+		}
 	}
-	return env
+	for idx, pattern := range fn.Patterns {
+		if pattern == nil {
+			continue
+		}
+		if halt := destructure(pattern, env.GetSlot(0, fn.Parameters[idx].Binding.Slot), env); halt != nil {
+			return nil, halt
+		}
+	}
+	return env, nil
 }
 func unwrapReturnValue(evaluated object.Object) object.Object {
 	if returnValue, ok := evaluated.(*object.ReturnValue); ok {
@@ -118,12 +366,50 @@ func unwrapReturnValue(evaluated object.Object) object.Object {
 	return evaluated
 }
 
+// evalMatchExpression evaluates the value of the first arm matching the
+// subject. Each arm binds its variables in a scope of its own, so that the
+// ones bound by an arm that doesn't match, or whose guard doesn't hold, are
+// dropped along with it.
+func evalMatchExpression(me *ast.MatchExpression, env *object.Environment) object.Object {
+	subject := Eval(me.Subject, env)
+	if isHalting(subject) {
+		return subject
+	}
+
+	for idx := range me.Arms {
+		arm := &me.Arms[idx]
+		scope := object.NewScope(env, arm)
+		mismatch, halt := bindPattern(arm.Pattern, subject, scope)
+		if halt != nil {
+			return halt
+		}
+		if mismatch != "" {
+			continue
+		}
+		if arm.Guard != nil {
+			guard := Eval(arm.Guard, scope)
+			if isHalting(guard) {
+				return guard
+			}
+			if !isTruthy(guard) {
+				continue
+			}
+		}
+		return Eval(arm.Value, scope)
+	}
+	return newError("no match for %s", subject.Inspect())
+}
+
 func evalIfExpression(ie *ast.IfExpression, env *object.Environment) object.Object {
 	condition := Eval(ie.Condition, env)
-	if isError(condition) {
+	if isHalting(condition) {
 		return condition
 	}
-	if isTruthy(condition) {
+	taken := isTruthy(condition)
+	if hook := env.Runtime().Hook; hook != nil {
+		hook.Branch(ie, taken)
+	}
+	if taken {
 		return Eval(ie.Consequence, env)
 	}
 	if ie.Alternative != nil {
@@ -141,14 +427,20 @@ func evalArrayLiteral(node *ast.ArrayLiteral, env *object.Environment) object.Ob
 }
 
 func evalProgram(stms []ast.Statement, env *object.Environment) object.Object {
+	declareFunctions(stms, env)
 	var res object.Object
 	for _, stm := range stms {
+		if hook := env.Runtime().Hook; hook != nil {
+			if halt := hook.Statement(stm, env); halt != nil {
+				return halt
+			}
+		}
 		res = Eval(stm, env)
 		switch res := res.(type) {
 		// note: early exit if we meet a return statement in top-level loop
 		case *object.ReturnValue:
 			return res.Value
-		case *object.Error:
+		case *object.Error, *object.Exit:
 			return res
 		}
 	}
@@ -156,19 +448,35 @@ func evalProgram(stms []ast.Statement, env *object.Environment) object.Object {
 }
 
 func evalBlockStatement(block *ast.BlockStatement, env *object.Environment) object.Object {
+	declareFunctions(block.Statements, env)
 	var res object.Object
 	for _, stm := range block.Statements {
+		if hook := env.Runtime().Hook; hook != nil {
+			if halt := hook.Statement(stm, env); halt != nil {
+				return halt
+			}
+		}
 		res = Eval(stm, env)
 
-		if res != nil && res.Type() == object.RETURN_VALUE_OBJ || res.Type() == object.ERROR_OBJ {
+		if res != nil && res.Type() == object.RETURN_VALUE_OBJ || isHalting(res) {
 			return res
 		}
 	}
 	return res
 }
 
+// declareFunctions binds the functions declared by stms before they run,
+// so that they can call each other.
+func declareFunctions(stms []ast.Statement, env *object.Environment) {
+	for _, stm := range stms {
+		if fs, ok := stm.(*ast.FunctionStatement); ok {
+			bind(fs.Name, evalFuncLiteral(fs.Function, env), env)
+		}
+	}
+}
+
 func evalPrefixExpression(op string, right object.Object) object.Object {
-	if isError(right) {
+	if isHalting(right) {
 		return right
 	}
 
@@ -209,21 +517,27 @@ func evalMinusOperatorExpression(right object.Object) object.Object {
 	return &object.Integer{Value: -1 * value}
 }
 
-func evalInfixExpression(op string, left, right object.Object) object.Object {
-	if isError(left) {
+func evalInfixExpression(op string, left, right object.Object, strict bool) object.Object {
+	if isHalting(left) {
 		return left
 	}
-	if isError(right) {
+	if isHalting(right) {
 		return right
 	}
 	switch {
+	// note: equality is defined between any two values
+	case op == "==":
+		return nativeBooleanMap(object.Equal(left, right))
+	case op == "!=":
+		return nativeBooleanMap(!object.Equal(left, right))
 	case left.Type() == object.INTEGER_OBJ && right.Type() == object.INTEGER_OBJ:
 		return evalInfixIntegerExpression(op, left, right)
 	case left.Type() == object.BOOLEAN_OBJ && right.Type() == object.BOOLEAN_OBJ:
 		return evalInfixBooleanExpression(op, left, right)
 	case left.Type() == object.STRING_OBJ && right.Type() == object.STRING_OBJ:
 		return evalInfixStringExpression(op, left, right)
-	// todo: remove this case to impement concatenation
+	case !strict && op == "+" && (left.Type() == object.STRING_OBJ || right.Type() == object.STRING_OBJ):
+		return &object.String{Value: left.Inspect() + right.Inspect()}
 	case left.Type() != right.Type():
 		return newError("type mismatch: %s %s %s", left.Type(), op, right.Type())
 	default:
@@ -283,7 +597,7 @@ func evalExpressionList(exps []ast.Expression, env *object.Environment) (result
 
 	for i, exp := range exps {
 		elem := Eval(exp, env)
-		if isError(elem) {
+		if isHalting(elem) {
 			return []object.Object{elem}, false
 		}
 		result[i] = elem
@@ -293,12 +607,12 @@ func evalExpressionList(exps []ast.Expression, env *object.Environment) (result
 }
 func evalIndexExpression(node *ast.IndexExpression, env *object.Environment) object.Object {
 	leftVal := Eval(node.Left, env)
-	if isError(leftVal) {
+	if isHalting(leftVal) {
 		return leftVal
 	}
 
 	indexVal := Eval(node.Index, env)
-	if isError(indexVal) {
+	if isHalting(indexVal) {
 		return indexVal
 	}
 
@@ -325,41 +639,38 @@ func evalArrayIndexExpression(array, index object.Object) object.Object {
 func evalHashIndexExpression(hash, key object.Object) object.Object {
 	hashVal := hash.(*object.Hash)
 
-	keyVal, ok := key.(object.Hashable)
-	if !ok {
+	if _, ok := object.HashKeyOf(key); !ok {
 		return newError("not hashable key: %s", key.Type())
 	}
 
-	pair, ok := hashVal.Pairs[keyVal.HashKey()]
+	val, ok := hashVal.Get(key)
 	if !ok {
 		return NULL
 	}
 
-	return pair.Value
+	return val
 }
 
 func evalHashLiteral(node *ast.HashLiteral, env *object.Environment) object.Object {
-	pairs := make(map[object.HashKey]object.HashPair)
+	hash := &object.Hash{}
 
-	for keyNode, valNode := range node.Content {
-		key := Eval(keyNode, env)
-		if isError(key) {
+	for _, pair := range node.Pairs {
+		key := Eval(pair.Key, env)
+		if isHalting(key) {
 			return key
 		}
 
-		hk, ok := key.(object.Hashable)
-		if !ok {
+		if _, ok := object.HashKeyOf(key); !ok {
 			return newError("not hashable key: %s", key.Type())
 		}
 
-		val := Eval(valNode, env)
-		if isError(val) {
+		val := Eval(pair.Value, env)
+		if isHalting(val) {
 			return val
 		}
 
-		hashed := hk.HashKey()
-		pairs[hashed] = object.HashPair{Key: key, Value: val}
+		hash.Set(key, val)
 	}
 
-	return &object.Hash{Pairs: pairs}
+	return hash
 }
@@ -1,8 +1,13 @@
 package evaluator
 
 import (
+	"bytes"
+	"fmt"
+	"strings"
+	"sync"
 	"testing"
 
+	"github.com/AzraelSec/cube/pkg/ast"
 	"github.com/AzraelSec/cube/pkg/lexer"
 	"github.com/AzraelSec/cube/pkg/object"
 	"github.com/AzraelSec/cube/pkg/parser"
@@ -48,6 +53,7 @@ func TestIfElseExpression(t *testing.T) {
 		{"if (1 > 2) { 10 }", nil},
 		{"if (1 > 2) { 10 } else { 20 }", 20},
 		{"if (1 < 2) { 10 } else { 20 }", 10},
+		{"if (true) { let x = 10; } 5", 5},
 	}
 	for _, tt := range tests {
 		evaluated := testEval(tt.input)
@@ -93,6 +99,23 @@ func TestEvalBooleanExpression(t *testing.T) {
 		{"(1 < 2) == false", false},
 		{"(1 > 2) == true", false},
 		{"(1 > 2) == false", true},
+
+		{`"a" == "a"`, true},
+		{`"a" != "b"`, true},
+		{"[1, 2] == [1, 2]", true},
+		{"[1, 2] != [1, 2]", false},
+		{"[1, [2, 3]] == [1, [2, 3]]", true},
+		{"[1, 2] == [2, 1]", false},
+		{`{"a": 1, "b": [2]} == {"b": [2], "a": 1}`, true},
+		{`{"a": 1} == {"a": 2}`, false},
+		{"let n = if (false) { 1 }; n == n", true},
+		{"let n = if (false) { 1 }; n == false", false},
+		{"let n = if (false) { 1 }; n != 0", true},
+		{"1 == true", false},
+		{`1 != "1"`, true},
+		{"let f = fn() { 1 }; f == f", true},
+		{"fn() { 1 } == fn() { 1 }", false},
+		{"len == len", true},
 	}
 	for _, tt := range tests {
 		evaluated := testEval(tt.input)
@@ -201,17 +224,29 @@ func TestErrorHandling(t *testing.T) {
 			"unknown operator: BOOLEAN + BOOLEAN",
 		},
 		{
-			"foobar",
+			"let f = fn() { foobar }; f(); let foobar = 1;",
 			"identifier not found: foobar",
 		},
+		{
+			"let f = fn() { let x = y; let y = 1; x }; f();",
+			"1:24: identifier not found: y",
+		},
 		{
 			`"Hello" - "World"`,
 			"unknown operator: STRING - STRING",
 		},
+		{
+			`exit("now")`,
+			"argument to `exit` not supported, got STRING",
+		},
 		{
 			`{"name": "Monkey"}[fn(x) { x }];`,
 			"not hashable key: FUNCTION",
 		},
+		{
+			`{[1, fn(x) { x }]: 1}`,
+			"not hashable key: ARRAY",
+		},
 	}
 	for _, tt := range tests {
 		evaluated := testEval(tt.input)
@@ -280,11 +315,153 @@ func TestFunctionApplication(t *testing.T) {
 	}
 }
 
+func TestFunctionStatements(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"fn add(a, b) { a + b } add(1, 2)", "3"},
+		{"let r = twice(4); fn twice(x) { x * 2 } r", "8"},
+		{"fn isEven(n) { if (n == 0) { true } else { isOdd(n - 1) } } fn isOdd(n) { if (n == 0) { false } else { isEven(n - 1) } } isEven(10)", "true"},
+		{"fn outer() { let r = inner(); fn inner() { 5 } r } outer()", "5"},
+		{"fn add(a, b) { a + b } add", "fn add(a, b)"},
+		{"let f = fn(x) { x }; f", "fn f(x)"},
+		{"fn(x) { x }", "fn(x)"},
+		{`fn add(a, b) { a + b } [name(add), name(fn() {}), name(len)]`, "[add, , len]"},
+		{"fn add(a, b) { a + b } [arity(add), arity(fn() {}), arity(push), arity(print)]", "[2, 0, 2, 0]"},
+		{"fn add(a, b) { a + b } [params(add), params(first)]", "[[a, b], [value]]"},
+		{"fn add(a, b) { a + b } add(1)", "Error: wrong number of arguments for add. got=1, want=2"},
+		{"fn(a) { a }()", "Error: wrong number of arguments for anonymous function. got=0, want=1"},
+		{"name(1)", "Error: argument to `name` not supported, got INTEGER"},
+	}
+	for _, tt := range tests {
+		if got := testEval(tt.input).Inspect(); got != tt.expected {
+			t.Errorf("%q: wrong result. got=%q, want=%q", tt.input, got, tt.expected)
+		}
+	}
+}
+
+func TestFunctionArguments(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"fn f(a, b = 10) { a + b } [f(1), f(1, 2)]", "[11, 3]"},
+		{"let n = 1; fn f(a = n) { a } let n = 2; f()", "2"},
+		{"fn f(a = []) { push(a, 1) } [f(), f(), f([0])]", "[[1], [1], [0, 1]]"},
+		{"fn f(a, b = a) { b } f(1)", "Error: 1:13: identifier not found: a"},
+		{"fn f(a = exit(3)) { a } f()", "exit(3)"},
+		{"fn f(first, ...others) { [first, others] } [f(1), f(1, 2, 3)]", "[[1, []], [1, [2, 3]]]"},
+		{"fn f(a, b, c) { [a, b, c] } let xs = [2, 3]; [f(...[1, 2, 3]), f(1, ...xs)]", "[[1, 2, 3], [1, 2, 3]]"},
+		{"fn f(...xs) { xs } f(...[], 1, ...[2, 3])", "[1, 2, 3]"},
+		{"push(...[[1], 2])", "[1, 2]"},
+		{"fn f(a, b) { a - b } f(b: 1, a: 3)", "2"},
+		{"fn f(a, b = 2, c = 3) { [a, b, c] } [f(1, c: 4), f(c: 5, a: 0)]", "[[1, 2, 4], [0, 2, 5]]"},
+		{"fn f(a, ...xs) { [a, xs] } f(a: 1)", "[1, []]"},
+		{"assertEq(got: 1, want: 1)", "null"},
+		{"fn f(a, b = 10) { a } f()", "Error: wrong number of arguments for f. got=0, want=1 or 2"},
+		{"fn f(a, b = 10) { a } f(1, 2, 3)", "Error: wrong number of arguments for f. got=3, want=1 or 2"},
+		{"fn f(a, ...xs) { a } f()", "Error: wrong number of arguments for f. got=0, want=at least 1"},
+		{"fn f(a, b) { a } f(b: 1)", "Error: missing argument for parameter a of f"},
+		{"fn f(a) { a } f(1, a: 2)", "Error: multiple values for parameter a of f"},
+		{"fn f(a) { a } f(c: 2)", "Error: f has no parameter named c"},
+		{"fn f(...xs) { xs } f(xs: [1])", "Error: rest parameter xs of f can't be passed by name"},
+		{"assertEq(1, message: \"m\")", "Error: missing argument for parameter want of assertEq"},
+		{"fn f(a) { a } f(...1)", "Error: spread argument must be ARRAY, got INTEGER"},
+		{"fn(a, b = 1, ...c) { a }", "fn(a, b = 1, ...c)"},
+		{"fn f(a, b = 1, ...c) { a } [arity(f), params(f)]", "[1, [a, b, c]]"},
+	}
+	for _, tt := range tests {
+		if got := testEval(tt.input).Inspect(); got != tt.expected {
+			t.Errorf("%q: wrong result. got=%q, want=%q", tt.input, got, tt.expected)
+		}
+	}
+}
+
+func TestDestructuring(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"let [a, b] = [1, 2]; [b, a]", "[2, 1]"},
+		{"let [a, ...others] = [1, 2, 3]; [a, others]", "[1, [2, 3]]"},
+		{"let [a, b = 2] = [1, 2, 3]", "Error: wrong number of elements for [a, b = 2]. got=3, want=1 or 2"},
+		{"let [a, ...others] = []", "Error: wrong number of elements for [a, ...others]. got=0, want=at least 1"},
+		{"let [a, b = a + 1, ...others] = [1]; [a, b, others]", "[1, 2, []]"},
+		{"let {x, \"y z\": y, w = 0} = {\"x\": 1, \"y z\": 2, \"v\": 3}; [x, y, w]", "[1, 2, 0]"},
+		{"let {tags: [first], meta: {id}} = {\"tags\": [\"t\"], \"meta\": {\"id\": 7}}; [first, id]", "[t, 7]"},
+		{"let [a = exit(2)] = []; a", "exit(2)"},
+		{"fn f([a, b], {c} = {\"c\": 3}) { a + b + c } [f([1, 2]), f([1, 2], {\"c\": 0})]", "[6, 3]"},
+		{"fn f([a, b = a]) { b } f([4])", "4"},
+		{"fn f({a}) { a } f(1)", "Error: cannot destructure INTEGER with hash pattern {a}"},
+		{"let [a] = {}", "Error: cannot destructure HASH with array pattern [a]"},
+		{"let [a, b = 1, c] = [1]", "Error: wrong number of elements for [a, b = 1, c]. got=1, want=3"},
+		{"let [a] = [1, 2]", "Error: wrong number of elements for [a]. got=2, want=1"},
+		{"let {a} = {\"b\": 1}", "Error: missing key \"a\" for hash pattern {a}"},
+		{"fn([a, b], c) { a }", "fn([a, b], c)"},
+		{"fn f([a, b]) { a } f([1])", "Error: wrong number of elements for [a, b]. got=1, want=2"},
+		{"fn f([a, b]) { a } f()", "Error: wrong number of arguments for f. got=0, want=1"},
+	}
+	for _, tt := range tests {
+		if got := testEval(tt.input).Inspect(); got != tt.expected {
+			t.Errorf("%q: wrong result. got=%q, want=%q", tt.input, got, tt.expected)
+		}
+	}
+}
+
+func TestMatchExpression(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"match (2) { 1 => \"one\", 2 => \"two\", _ => \"many\" }", "two"},
+		{"match (-1) { -1 => true, _ => false }", "true"},
+		{"match (\"hi\") { \"hi\" => 1, _ => 2 }", "1"},
+		{"match (false) { true => 1, false => 2 }", "2"},
+		{"match (5) { n if n > 10 => \"big\", n => n * 2 }", "10"},
+		{"match (5) { n: string => n, n: int => n + 1 }", "6"},
+		{"match ([1, \"a\"]) { _: [int] => 1, _: [any] => 2 }", "2"},
+		{"match ({\"a\": 1}) { _: {string: int} => 1, _ => 2 }", "1"},
+		{"match (len) { _: fn(any): int => 1, _ => 2 }", "1"},
+		{"match ([1, 2, 3]) { [] => 0, [x] => x, [x, ...others] => others }", "[2, 3]"},
+		{"match ([1, [2, 3]]) { [1, [a, b]] => a + b, _ => 0 }", "5"},
+		{"match ({\"kind\": \"circle\", \"r\": 2, \"color\": \"red\"}) { {kind: \"square\", side} => side, {kind: \"circle\", r, ...others} => [r, others] }", "[2, {color: red}]"},
+		{"match ({\"a\": 1}) { {b} => b, {a, b = 0} => a + b }", "1"},
+		{"match ([1]) { [a] if a > 1 => \"big\", [a] => a }", "1"},
+		// note: the bindings of an arm are neither seen after the match nor
+		// kept when the arm doesn't match
+		{"match (1) { x => x }; x", "Error: 1:23: identifier not found: x"},
+		{"let x = 10; match ([1, 2]) { [x, 3] => 0, _ => x }", "10"},
+		{"let f = fn(x) { match ([1, 2]) { [x, 3] => 0, _ => x } }; f(10)", "10"},
+		{"let x = 10; match (1) { x if x > 5 => x, _ => x }", "10"},
+		{"let f = fn() { let x = 10; let r = match (1) { x => x }; [r, x] }; f()", "[1, 10]"},
+		{"match (1) { [y] => y, _ => y }", "Error: 1:28: identifier not found: y"},
+		{"let fs = match (1) { x => fn() { x } }; match (2) { y => y }; fs()", "1"},
+		{"let f = fn(n) { match (n) { x => if (x > 0) { let y = x * 2; return y } } }; f(2)", "4"},
+		{"match (3) { 1 => 1, 2 => 2 }", "Error: no match for 3"},
+		{"match (1) { _ if exit(4) => 1 }", "exit(4)"},
+		{"match (1) { a => a, }", "1"},
+		{"let {a, ...others} = {\"a\": 1, \"b\": 2, \"c\": 3}; [a, others]", "[1, {b: 2, c: 3}]"},
+		{"let [a, ...xs] = [1]; let {b, ...hs} = {\"b\": 2}; [xs, hs]", "[[], {}]"},
+	}
+	for _, tt := range tests {
+		if got := testEval(tt.input).Inspect(); got != tt.expected {
+			t.Errorf("%q: wrong result. got=%q, want=%q", tt.input, got, tt.expected)
+		}
+	}
+}
+
 func testEval(input string) object.Object {
+	return testEvalIn(input, object.NewEnvironment())
+}
+
+func testEvalIn(input string, env *object.Environment) object.Object {
 	l := lexer.New(input)
 	p := parser.New(l)
 	program := p.ParseProgram()
-	env := object.NewEnvironment()
+	if errs := Resolve(program, env); len(errs) != 0 {
+		return &object.Error{Msg: strings.Join(parser.ErrorStrings(errs), "; ")}
+	}
 	return Eval(program, env)
 }
 
@@ -503,23 +680,30 @@ func TestHashLiterals(t *testing.T) {
 	if !ok {
 		t.Fatalf("Eval didn't return Hash. got=%T (%+v)", evaluated, evaluated)
 	}
-	expected := map[object.HashKey]int64{
-		(&object.String{Value: "one"}).HashKey():   1,
-		(&object.String{Value: "two"}).HashKey():   2,
-		(&object.String{Value: "three"}).HashKey(): 3,
-		(&object.Integer{Value: 4}).HashKey():      4,
-		TRUE.HashKey():                             5,
-		FALSE.HashKey():                            6,
+	expected := []struct {
+		key   object.Object
+		value int64
+	}{
+		{&object.String{Value: "one"}, 1},
+		{&object.String{Value: "two"}, 2},
+		{&object.String{Value: "three"}, 3},
+		{&object.Integer{Value: 4}, 4},
+		{TRUE, 5},
+		{FALSE, 6},
 	}
-	if len(result.Pairs) != len(expected) {
-		t.Fatalf("Hash has wrong num of pairs. got=%d", len(result.Pairs))
+	if result.Len() != len(expected) {
+		t.Fatalf("Hash has wrong num of pairs. got=%d", result.Len())
 	}
-	for expectedKey, expectedValue := range expected {
-		pair, ok := result.Pairs[expectedKey]
+	for idx, pair := range result.Pairs() {
+		if pair.Key.Inspect() != expected[idx].key.Inspect() {
+			t.Errorf("wrong key at position %d. got=%s, want=%s", idx, pair.Key.Inspect(), expected[idx].key.Inspect())
+		}
+		value, ok := result.Get(expected[idx].key)
 		if !ok {
 			t.Errorf("no pair for given key in Pairs")
+			continue
 		}
-		testIntegerObject(t, pair.Value, expectedValue)
+		testIntegerObject(t, value, expected[idx].value)
 	}
 }
 func TestHashIndexExpressions(t *testing.T) {
@@ -555,6 +739,18 @@ func TestHashIndexExpressions(t *testing.T) {
 			`{false: 5}[false]`,
 			5,
 		},
+		{
+			`{[1, "a"]: 5}[[1, "a"]]`,
+			5,
+		},
+		{
+			`{[1, [2, 3]]: 5}[[1, [2, 3]]]`,
+			5,
+		},
+		{
+			`{[1, 2]: 5}[[2, 1]]`,
+			nil,
+		},
 	}
 	for _, tt := range tests {
 		evaluated := testEval(tt.input)
@@ -566,3 +762,543 @@ func TestHashIndexExpressions(t *testing.T) {
 		}
 	}
 }
+
+func TestHashBuiltins(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{`len({})`, 0},
+		{`len({"a": 1, "b": 2})`, 2},
+		{`keys({"a": 1})`, []string{"a"}},
+		{`values({"a": 1})`, []int{1}},
+		{`len(entries({"a": 1, "b": 2}))`, 2},
+		{`entries({"a": 1})[0][1]`, 1},
+		{`let n = if (false) { 1 }; has({"a": n}, "a")`, true},
+		{`has({"a": 1}, "b")`, false},
+		{`let h = {"a": 1, "b": 2}; let d = delete(h, "a"); len(d)`, 1},
+		{`let h = {"a": 1, "b": 2}; let d = delete(h, "a"); has(d, "a")`, false},
+		{`let h = {"a": 1, "b": 2}; delete(h, "a"); has(h, "a")`, true},
+		{`len(delete({"a": 1}, "b"))`, 1},
+		{`merge({"a": 1}, {"b": 2})["b"]`, 2},
+		{`merge({"a": 1}, {"a": 2})["a"]`, 2},
+		{`len(merge({"a": 1}, {"a": 2}, {"c": 3}))`, 2},
+		{`keys({"b": 1, "a": 2, "c": 3})`, []string{"b", "a", "c"}},
+		{`values({"b": 1, "a": 2, "c": 3})`, []int{1, 2, 3}},
+		{`keys(delete({"b": 1, "a": 2, "c": 3}, "a"))`, []string{"b", "c"}},
+		{`keys(merge({"b": 1, "a": 2}, {"c": 3, "b": 4}))`, []string{"b", "a", "c"}},
+		{`values(merge({"b": 1, "a": 2}, {"c": 3, "b": 4}))`, []int{4, 2, 3}},
+		{`keys([])`, "argument to `keys` not supported, got ARRAY"},
+		{`has({}, fn() {})`, "not hashable key: FUNCTION"},
+		{`merge({}, 1)`, "argument to `merge` not supported, got INTEGER"},
+	}
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		switch expected := tt.expected.(type) {
+		case int:
+			testIntegerObject(t, evaluated, int64(expected))
+		case bool:
+			testBooleanObject(t, evaluated, expected)
+		case []int:
+			arr, ok := evaluated.(*object.Array)
+			if !ok {
+				t.Errorf("object is not Array. got=%T (%+v)", evaluated, evaluated)
+				continue
+			}
+			testArrayIntObject(t, *arr, expected)
+		case []string:
+			arr, ok := evaluated.(*object.Array)
+			if !ok {
+				t.Errorf("object is not Array. got=%T (%+v)", evaluated, evaluated)
+				continue
+			}
+			if len(arr.Elements) != len(expected) {
+				t.Errorf("array has wrong number of elements. got=%d, want=%d", len(arr.Elements), len(expected))
+				continue
+			}
+			for idx, elem := range arr.Elements {
+				str, ok := elem.(*object.String)
+				if !ok {
+					t.Errorf("object is not String. got=%T (%+v)", elem, elem)
+					continue
+				}
+				testStringObject(t, *str, expected[idx])
+			}
+		case string:
+			errObj, ok := evaluated.(*object.Error)
+			if !ok {
+				t.Errorf("no error object returned. got=%T(%+v)", evaluated, evaluated)
+				continue
+			}
+			if errObj.Msg != expected {
+				t.Errorf("wrong error message. expected=%q, got=%q", expected, errObj.Msg)
+			}
+		}
+	}
+}
+
+func TestHashInspectOrder(t *testing.T) {
+	input := `{"z": 1, "a": [1, 2], 3: "three", true: {"x": 0}}`
+	expected := `{z: 1, a: [1, 2], 3: three, true: {x: 0}}`
+
+	for i := 0; i < 20; i++ {
+		evaluated := testEval(input)
+		if evaluated.Inspect() != expected {
+			t.Fatalf("wrong inspect output. got=%q, want=%q", evaluated.Inspect(), expected)
+		}
+	}
+}
+
+func TestConversionBuiltins(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{`str(1)`, "1"},
+		{`str("a")`, "a"},
+		{`str(true)`, "true"},
+		{`str([1, "a"])`, "[1, a]"},
+		{`str({"a": 1})`, "{a: 1}"},
+		{`str(if (false) { 1 })`, "null"},
+		{`type(1)`, "INTEGER"},
+		{`type("a")`, "STRING"},
+		{`type(false)`, "BOOLEAN"},
+		{`type([])`, "ARRAY"},
+		{`type({})`, "HASH"},
+		{`type(fn() {})`, "FUNCTION"},
+		{`type(len)`, "BUILTIN"},
+		{`type(if (false) { 1 })`, "NULL"},
+		{`bool(1)`, true},
+		{`bool(0)`, true},
+		{`bool("")`, true},
+		{`bool([])`, true},
+		{`bool({})`, true},
+		{`bool(false)`, false},
+		{`bool(if (false) { 1 })`, false},
+	}
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		switch expected := tt.expected.(type) {
+		case string:
+			str, ok := evaluated.(*object.String)
+			if !ok {
+				t.Errorf("object is not String. got=%T (%+v)", evaluated, evaluated)
+				continue
+			}
+			testStringObject(t, *str, expected)
+		case bool:
+			testBooleanObject(t, evaluated, expected)
+		}
+	}
+}
+
+func TestStrictMode(t *testing.T) {
+	evaluated := testEval(`"count: " + 3`)
+	errObj, ok := evaluated.(*object.Error)
+	if !ok {
+		t.Fatalf("no error object returned in strict mode. got=%T(%+v)", evaluated, evaluated)
+	}
+	if errObj.Msg != "type mismatch: STRING + INTEGER" {
+		t.Errorf("wrong error message. got=%q", errObj.Msg)
+	}
+
+	lax := func(input string) object.Object {
+		env := object.NewEnvironment()
+		env.Runtime().Strict = false
+		return testEvalIn(input, env)
+	}
+
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`"count: " + 3`, "count: 3"},
+		{`3 + " apples"`, "3 apples"},
+		{`"ok: " + true`, "ok: true"},
+		{`"list: " + [1, 2]`, "list: [1, 2]"},
+		{`let f = fn(n) { n + "!" }; f(1)`, "1!"},
+	}
+	for _, tt := range tests {
+		evaluated := lax(tt.input)
+		str, ok := evaluated.(*object.String)
+		if !ok {
+			t.Errorf("object is not String. got=%T (%+v)", evaluated, evaluated)
+			continue
+		}
+		testStringObject(t, *str, tt.expected)
+	}
+
+	if _, ok := lax(`"a" - 1`).(*object.Error); !ok {
+		t.Errorf("non-strict mode should only relax `+`")
+	}
+}
+
+func TestExit(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected int64
+	}{
+		{"exit()", 0},
+		{"exit(3)", 3},
+		{"exit(2); 5", 2},
+		{"let x = exit(4); x", 4},
+		{"if (true) { exit(1); } 5", 1},
+		{"let f = fn(x) { exit(x); 10 }; f(7); 5", 7},
+		{"let f = fn(x) { exit(x); 10 }; let g = fn() { return f(8) + 1; }; g(); 5", 8},
+		{"[1, exit(9), 3]", 9},
+		{`{"a": exit(6)}`, 6},
+		{"let f = fn() { exit(5) }; len(str(f()))", 5},
+	}
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		exit, ok := evaluated.(*object.Exit)
+		if !ok {
+			t.Errorf("%q: object is not Exit. got=%T (%+v)", tt.input, evaluated, evaluated)
+			continue
+		}
+		if exit.Code != tt.expected {
+			t.Errorf("%q: wrong exit code. got=%d, want=%d", tt.input, exit.Code, tt.expected)
+		}
+	}
+}
+
+func TestBuiltinMetadata(t *testing.T) {
+	for _, name := range BuiltinNames() {
+		builtin, ok := LookupBuiltin(name)
+		if !ok {
+			t.Fatalf("builtin %s not found", name)
+		}
+		if builtin.Name != name || builtin.Doc == "" {
+			t.Errorf("builtin %s: missing name or doc", name)
+		}
+
+		params := builtin.MaxArgs
+		if params < 0 {
+			params = builtin.MinArgs + 1
+		}
+		if len(builtin.Params) != params {
+			t.Errorf("builtin %s: wrong number of parameters. got=%d, want=%d", name, len(builtin.Params), params)
+		}
+		annotations := []string{builtin.Result}
+		for _, p := range builtin.Params {
+			annotations = append(annotations, p.Type)
+		}
+		for _, annotation := range annotations {
+			if _, errs := parser.ParseType(annotation); len(errs) != 0 && annotation != "never" {
+				t.Errorf("builtin %s: wrong annotation %q: %v", name, annotation, errs)
+			}
+		}
+
+		counts := []int{}
+		if builtin.MinArgs > 0 {
+			counts = append(counts, builtin.MinArgs-1)
+		}
+		if builtin.MaxArgs >= 0 {
+			counts = append(counts, builtin.MaxArgs+1)
+		}
+		for _, count := range counts {
+			args := make([]object.Object, count)
+			for idx := range args {
+				args[idx] = NULL
+			}
+			expected := fmt.Sprintf("wrong number of arguments. got=%d, want=%s", count, builtin.Arity())
+			if err, ok := Apply(object.NewEnvironment(), builtin, args...).(*object.Error); !ok || err.Msg != expected {
+				t.Errorf("builtin %s: wrong result for %d arguments. got=%v, want=%q", name, count, err, expected)
+			}
+		}
+	}
+
+	if _, ok := LookupBuiltin("nope"); ok {
+		t.Errorf("unexpected builtin nope")
+	}
+}
+
+func TestBuiltinValidation(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`rest(1)`, "argument to `rest` not supported, got INTEGER"},
+		{`push([], 1, 2)`, "wrong number of arguments. got=3, want=2"},
+		{`exit("a")`, "argument to `exit` not supported, got STRING"},
+		{`exit(1, 2)`, "wrong number of arguments. got=2, want=0 or 1"},
+		{`merge({}, [])`, "argument to `merge` not supported, got ARRAY"},
+		{`assertError(fn() { first(1) }, 1)`, "second argument to `assertError` must be STRING, got INTEGER"},
+		{`assertError(fn(x) { x })`, "argument to `assertError` must take no arguments, got 1 parameters"},
+		{`assertEq(1, 1, 1, 1)`, "wrong number of arguments. got=4, want=2 or 3"},
+		{`read(1)`, "wrong number of arguments. got=1, want=0"},
+		{`help(1)`, "argument to `help` not supported, got INTEGER"},
+	}
+
+	for _, tt := range tests {
+		err, ok := testEval(tt.input).(*object.Error)
+		if !ok || err.Msg != tt.expected {
+			t.Errorf("%q: wrong result. got=%v, want=%q", tt.input, err, tt.expected)
+		}
+	}
+
+	tests = []struct {
+		input    string
+		expected string
+	}{
+		{`str(len)`, "builtin len(value: any): int"},
+		{`str(exit)`, "builtin exit([code: int]): never"},
+		{`str(print)`, "builtin print(...values: any): null"},
+		{`str(read)`, "builtin read(): string"},
+	}
+	for _, tt := range tests {
+		str, ok := testEval(tt.input).(*object.String)
+		if !ok || str.Value != tt.expected {
+			t.Errorf("%q: wrong result. got=%v, want=%q", tt.input, str, tt.expected)
+		}
+	}
+}
+
+func TestHelp(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`help(len)`, "len(value: any): int\n    Returns the number of bytes of a string, of elements of an array or of pairs of a hash.\n"},
+		{"/// Adds a and b.\n///\n///   add(1, 2)\nlet add = fn(a, b) { a + b }; help(add)", "fn add(a, b)\n    Adds a and b.\n\n      add(1, 2)\n"},
+		{`help(fn(x) { x })`, "fn(x)\n"},
+	}
+
+	helpOutput := func(input string) (object.Object, string) {
+		var out bytes.Buffer
+		env := object.NewEnvironment()
+		env.Runtime().Stdout = &out
+		return testEvalIn(input, env), out.String()
+	}
+
+	for _, tt := range tests {
+		res, out := helpOutput(tt.input)
+		if res != NULL {
+			t.Errorf("%q: unexpected result %s", tt.input, res.Inspect())
+		}
+		if out != tt.expected {
+			t.Errorf("%q: wrong output. got=%q, want=%q", tt.input, out, tt.expected)
+		}
+	}
+
+	_, out := helpOutput(`help()`)
+	lines := strings.Split(strings.TrimSpace(out), "\n")
+	if len(lines) != len(BuiltinNames()) || indexOf(lines, "assert(condition: any, [message: any]): null") < 0 {
+		t.Errorf("wrong builtins list:\n%s", out)
+	}
+}
+
+func TestAssertions(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string // the error message, empty if the assertion holds
+	}{
+		{`assert(1 < 2)`, ""},
+		{`assert(1 > 2)`, "assert failed: condition is false"},
+		{`assert(first([]), "must be set")`, "must be set: assert failed: condition is null"},
+		{`assertEq(1 + 1, 2)`, ""},
+		{`assertEq([1, {"a": [2]}], [1, {"a": [2]}])`, ""},
+		{`assertEq(1, "1")`, `assertEq failed: got 1, want "1"`},
+		{`assertEq(1, 2, "sum")`, "sum: assertEq failed: got 1, want 2"},
+		{`assertEq([1, 2, 3], [1, 5, 3])`, "assertEq failed: got [1, 2, 3], want [1, 5, 3]; at [1]: got 2, want 5"},
+		{`assertEq([1, [2]], [1, [2, 3]])`, "assertEq failed: got [1, [2]], want [1, [2, 3]]; at [1]: got 1 elements, want 2 elements"},
+		{`assertEq({"a": {"b": 1}}, {"a": {"b": "1"}})`, `assertEq failed: got {a: {b: 1}}, want {a: {b: 1}}; at ["a"]["b"]: got 1, want "1"`},
+		{`assertEq({"a": 1}, {"b": 1})`, `assertEq failed: got {a: 1}, want {b: 1}; at ["b"]: got nothing, want 1`},
+		{`assertEq({"a": 1, "b": 2}, {"a": 1})`, `assertEq failed: got {a: 1, b: 2}, want {a: 1}; at ["b"]: got 2, want nothing`},
+		{`assertError(fn() { 1 + true })`, ""},
+		{`assertError(fn() { 1 + true }, "type mismatch")`, ""},
+		{`assertError(fn() { 1 + true }, "division")`, `assertError failed: error "type mismatch: INTEGER + BOOLEAN" does not contain "division"`},
+		{`assertError(fn() { 1 })`, "assertError failed: no error, got 1"},
+		{`assertError(fn(x) { x })`, "argument to `assertError` must take no arguments, got 1 parameters"},
+		{`assertError(1)`, "argument to `assertError` not supported, got INTEGER"},
+		{`assertError(len)`, ""},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		if tt.expected == "" {
+			if evaluated != NULL {
+				t.Errorf("%s: unexpected result %s", tt.input, evaluated.Inspect())
+			}
+			continue
+		}
+
+		err, ok := evaluated.(*object.Error)
+		if !ok {
+			t.Errorf("%s: no error returned. got=%T (%+v)", tt.input, evaluated, evaluated)
+			continue
+		}
+		if err.Msg != tt.expected {
+			t.Errorf("%s: wrong error message.\ngot= %q\nwant=%q", tt.input, err.Msg, tt.expected)
+		}
+	}
+
+	if res := testEval(`assertError(fn() { exit(2) })`); res.Inspect() != "exit(2)" {
+		t.Errorf("exit was not propagated. got=%s", res.Inspect())
+	}
+}
+
+func TestScoping(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected int64
+	}{
+		{"let add = fn(a) { fn(b) { fn(c) { a + b + c } } }; add(1)(2)(3)", 6},
+		{"let x = 10; let f = fn(y) { let z = x + y; z * 2 }; f(1)", 22},
+		{"let f = fn(x) { if (x > 0) { let y = x; } y }; f(5)", 5},
+		{"let f = fn(x) { let x = x + 1; x }; f(1)", 2},
+		{"let fact = fn(n) { if (n < 2) { 1 } else { n * fact(n - 1) } }; fact(5)", 120},
+		{
+			`let f = fn(n) {
+				let even = fn(n) { if (n == 0) { true } else { odd(n - 1) } };
+				let odd = fn(n) { if (n == 0) { false } else { even(n - 1) } };
+				if (even(n)) { 1 } else { 0 }
+			};
+			f(10)`,
+			1,
+		},
+		{"let f = fn() { g() }; let g = fn() { 7 }; f()", 7},
+		{"let a = 1; let f = fn() { let a = 2; fn() { a } }; f()() + a", 3},
+		{"let x = 1; let x = x + 1; x", 2},
+		// note: a variable read before its let runs is the outer one
+		{"let x = 1; let f = fn() { if (false) { let x = 2 }; x }; f()", 1},
+		{"let x = 1; let f = fn() { if (true) { let x = 2 }; x }; f()", 2},
+		{"let x = 1; let f = fn() { let y = x; let x = 2; y }; f()", 1},
+		{"let x = 1; let f = fn() { let g = fn() { x }; let y = g(); let x = 2; y + g() }; f()", 3},
+		{"let f = fn(x) { let g = fn() { x }; if (x > 0) { let x = 0 }; g() }; f(5)", 0},
+	}
+
+	for _, tt := range tests {
+		testIntegerObject(t, testEval(tt.input), tt.expected)
+	}
+}
+
+func TestEvalUnresolved(t *testing.T) {
+	// note: programs that didn't go through the resolver are resolved by Eval
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"let x = 5; let f = fn(x) { x }; f(1); x", "5"},
+		{"let f = fn(a) { fn(b) { a + b } }; f(1)(2)", "3"},
+		{"outer + 1", "2"},
+		{"let f = fn() { y }; f()", "Error: 1:16: identifier not found: y"},
+	}
+
+	for _, tt := range tests {
+		program := parser.New(lexer.New(tt.input)).ParseProgram()
+		env := object.NewEnclosedEnvironment(object.NewEnvironment())
+		env.Outer().Set("outer", &object.Integer{Value: 1})
+		if got := Eval(program, env).Inspect(); got != tt.expected {
+			t.Errorf("%q: wrong result. got=%s, want=%s", tt.input, got, tt.expected)
+		}
+	}
+}
+
+// recorder is an object.Hook keeping track of what it's notified of.
+type recorder struct {
+	events []string
+	halt   int // line to interrupt the evaluation at, 0 for none
+}
+
+func (r *recorder) Statement(stm ast.Statement, env *object.Environment) object.Object {
+	line := ast.Start(stm).Line
+	r.events = append(r.events, fmt.Sprintf("stm %d", line))
+	if line == r.halt {
+		return &object.Exit{Code: 3}
+	}
+	return nil
+}
+
+func (r *recorder) Call(call *ast.CallExpression, fn *object.Function, env *object.Environment) {
+	r.events = append(r.events, fmt.Sprintf("call %s %d", call.Function, len(env.Locals())))
+}
+
+func (r *recorder) Return(call *ast.CallExpression, result object.Object) {
+	r.events = append(r.events, fmt.Sprintf("return %s %s", call.Function, result.Inspect()))
+}
+
+func (r *recorder) Branch(ie *ast.IfExpression, taken bool) {
+	r.events = append(r.events, fmt.Sprintf("branch %d %t", ie.Token.Line, taken))
+}
+
+func TestHook(t *testing.T) {
+	input := "let f = fn(x) {\nlet y = x + 1;\ny\n};\nlen([1]);\nif (true) { f(1) }\n3"
+	tests := []struct {
+		halt     int
+		expected []string
+		result   string
+	}{
+		{0, []string{"stm 1", "stm 5", "stm 6", "branch 6 true", "stm 6", "call f 2", "stm 2", "stm 3", "return f 2", "stm 7"}, "3"},
+		{3, []string{"stm 1", "stm 5", "stm 6", "branch 6 true", "stm 6", "call f 2", "stm 2", "stm 3", "return f exit(3)"}, "exit(3)"},
+	}
+
+	for _, tt := range tests {
+		r := &recorder{halt: tt.halt}
+		env := object.NewEnvironment()
+		env.Runtime().Hook = r
+		result := testEvalIn(input, env)
+
+		if result.Inspect() != tt.result {
+			t.Errorf("halt at %d: wrong result. got=%s, want=%s", tt.halt, result.Inspect(), tt.result)
+		}
+		if strings.Join(r.events, ", ") != strings.Join(tt.expected, ", ") {
+			t.Errorf("halt at %d: wrong events.\ngot= %q\nwant=%q", tt.halt, r.events, tt.expected)
+		}
+	}
+}
+
+func TestHooks(t *testing.T) {
+	first, second := &recorder{halt: 2}, &recorder{}
+	env := object.NewEnvironment()
+	env.Runtime().Hook = Hooks{first, second}
+	result := testEvalIn("if (false) { 1 }\n2\n3", env)
+
+	if result.Inspect() != "exit(3)" {
+		t.Errorf("wrong result. got=%s", result.Inspect())
+	}
+	expected := []string{"stm 1", "branch 1 false", "stm 2"}
+	if strings.Join(first.events, ", ") != strings.Join(expected, ", ") {
+		t.Errorf("wrong events for the first hook.\ngot= %q\nwant=%q", first.events, expected)
+	}
+	// note: the second hook is not told about the interrupted statement
+	expected = []string{"stm 1", "branch 1 false"}
+	if strings.Join(second.events, ", ") != strings.Join(expected, ", ") {
+		t.Errorf("wrong events for the second hook.\ngot= %q\nwant=%q", second.events, expected)
+	}
+}
+
+func TestRuntimeIsolation(t *testing.T) {
+	// note: programs evaluated at the same time keep their settings apart
+	var wg sync.WaitGroup
+	outs := make([]bytes.Buffer, 8)
+	recorders := make([]*recorder, len(outs))
+	for idx := range outs {
+		recorders[idx] = &recorder{}
+		env := object.NewEnvironment()
+		env.Runtime().Stdout = &outs[idx]
+		env.Runtime().Hook = recorders[idx]
+		env.Runtime().Strict = idx%2 == 0
+		env.Set("n", &object.Integer{Value: int64(idx)})
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			testEvalIn(`let f = fn(x) { print("n" + x) }; f(n)`, env)
+		}()
+	}
+	wg.Wait()
+
+	for idx := range outs {
+		expected := fmt.Sprintf("n%d\n", idx)
+		if idx%2 == 0 {
+			expected = ""
+		}
+		if outs[idx].String() != expected {
+			t.Errorf("program %d: wrong output. got=%q, want=%q", idx, outs[idx].String(), expected)
+		}
+		if len(recorders[idx].events) == 0 {
+			t.Errorf("program %d: hook not notified", idx)
+		}
+	}
+}
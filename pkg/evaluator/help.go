@@ -0,0 +1,47 @@
+package evaluator
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/AzraelSec/cube/pkg/object"
+)
+
+// note: help is registered at init time, since it lists the other builtins
+func init() {
+	builtins["help"] = &object.Builtin{
+		Name:    "help",
+		MinArgs: 0,
+		MaxArgs: 1,
+		Params:  []object.Param{{Name: "fn", Type: "any"}},
+		Result:  "null",
+		Doc:     "Prints the signature and the documentation of a function, or the signatures of the builtins when called with no arguments.",
+		Fn: func(env *object.Environment, o ...object.Object) object.Object {
+			out := env.Runtime().Stdout
+			if len(o) == 0 {
+				for _, name := range BuiltinNames() {
+					fmt.Fprintln(out, builtins[name].Signature())
+				}
+				return NULL
+			}
+
+			var signature, doc string
+			switch fn := o[0].(type) {
+			case *object.Builtin:
+				signature, doc = fn.Signature(), fn.Doc
+			case *object.Function:
+				signature, doc = fn.Inspect(), fn.Doc
+			default:
+				return newError("argument to `help` not supported, got %s", o[0].Type())
+			}
+
+			fmt.Fprintln(out, signature)
+			if doc != "" {
+				for _, line := range strings.Split(doc, "\n") {
+					fmt.Fprintln(out, strings.TrimRight("    "+line, " "))
+				}
+			}
+			return NULL
+		},
+	}
+}
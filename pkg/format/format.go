@@ -0,0 +1,488 @@
+// Package format pretty-prints Cube source code in its canonical style.
+package format
+
+import (
+	"bytes"
+	"strings"
+
+	"github.com/AzraelSec/cube/pkg/ast"
+	"github.com/AzraelSec/cube/pkg/lexer"
+	"github.com/AzraelSec/cube/pkg/parser"
+	"github.com/AzraelSec/cube/pkg/token"
+)
+
+const (
+	Indent = "    "
+	Width  = 80 // lists are broken one element per line past this column
+)
+
+type ParseError struct {
+	Errors []string
+}
+
+func (pe *ParseError) Error() string {
+	return "parse errors: " + strings.Join(pe.Errors, "; ")
+}
+
+// Source formats a whole source file, keeping its comments and a leading
+// `#!` line. Sources that don't parse are rejected with a *ParseError.
+func Source(src string) (string, error) {
+	l := lexer.New(src)
+	p := parser.New(l)
+
+	prog := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		return "", &ParseError{Errors: p.Errors()}
+	}
+
+	var buff bytes.Buffer
+	if strings.HasPrefix(src, "#!") {
+		shebang, _, _ := strings.Cut(src, "\n")
+		buff.WriteString(strings.TrimRight(shebang, " \t\r") + "\n")
+	}
+
+	pr := newPrinter(src, prog, l.Comments())
+	buff.WriteString(pr.statements(prog.Statements, pr.comments[nil], 0))
+
+	return buff.String(), nil
+}
+
+// Node formats a single node, without comments.
+func Node(node ast.Node) string {
+	pr := &printer{comments: map[ast.Node][]token.Token{}}
+
+	switch node := node.(type) {
+	case *ast.Program:
+		return pr.statements(node.Statements, nil, 0)
+	case *ast.BlockStatement:
+		return pr.block(node, 0, 0)
+	case ast.Statement:
+		return pr.statement(node, 0, 0)
+	case ast.Expression:
+		return pr.expression(node, 0, 0)
+	default:
+		return node.String()
+	}
+}
+
+type printer struct {
+	lines []string // the source lines, to preserve the original layout
+	// note: comments grouped by the innermost block, array, hash, call or
+	// match containing them, nil standing for the top level
+	comments map[ast.Node][]token.Token
+}
+
+// container is a node whose comments are printed between its statements,
+// elements, arguments or arms.
+type container struct {
+	node       ast.Node
+	start, end token.Token
+}
+
+func newPrinter(src string, prog *ast.Program, comments []token.Token) *printer {
+	containers := []container{}
+	ast.Inspect(prog, func(n ast.Node) bool {
+		switch n := n.(type) {
+		case *ast.BlockStatement:
+			containers = append(containers, container{n, n.Token, n.End})
+		case *ast.ArrayLiteral:
+			containers = append(containers, container{n, n.Token, n.End})
+		case *ast.HashLiteral:
+			containers = append(containers, container{n, n.Token, n.End})
+		case *ast.CallExpression:
+			containers = append(containers, container{n, n.Token, n.End})
+		case *ast.MatchExpression:
+			containers = append(containers, container{n, n.Token, n.End})
+		}
+		return true
+	})
+
+	pr := &printer{
+		lines:    strings.Split(src, "\n"),
+		comments: map[ast.Node][]token.Token{},
+	}
+	for _, c := range comments {
+		var owner ast.Node
+		for _, ctr := range containers {
+			// note: nodes are visited outside-in, so the last match is the innermost one
+			if ctr.start.Before(c) && c.Before(ctr.end) {
+				owner = ctr.node
+			}
+		}
+		pr.comments[owner] = append(pr.comments[owner], c)
+	}
+	return pr
+}
+
+func indent(depth int) string {
+	return strings.Repeat(Indent, depth)
+}
+
+// statements renders a list of statements, one per line and each line
+// indented at depth, interleaving the comments in source order. Single
+// blank lines between statements are kept.
+func (pr *printer) statements(stms []ast.Statement, comments []token.Token, depth int) string {
+	var buff bytes.Buffer
+
+	first := true
+	separate := func(line int) {
+		if !first && pr.blankBefore(line) {
+			buff.WriteString("\n")
+		}
+		first = false
+	}
+	emitComments := func(before *token.Token) {
+		for len(comments) > 0 && (before == nil || comments[0].Before(*before)) {
+			c := comments[0]
+			comments = comments[1:]
+
+			if !first && pr.trailing(c) {
+				// note: trailing comment, it stays on the same line
+				buff.Truncate(buff.Len() - 1)
+				buff.WriteString(" " + c.Literal + "\n")
+				continue
+			}
+			separate(c.Line)
+			buff.WriteString(indent(depth) + c.Literal + "\n")
+		}
+	}
+
+	for _, stm := range stms {
+		start := ast.Start(stm)
+		emitComments(&start)
+
+		separate(start.Line)
+		buff.WriteString(indent(depth) + pr.statement(stm, depth, len(indent(depth))) + "\n")
+	}
+	emitComments(nil)
+
+	return buff.String()
+}
+
+// blankBefore reports whether the source line preceding line is blank.
+func (pr *printer) blankBefore(line int) bool {
+	if line < 2 || line-2 >= len(pr.lines) {
+		return false
+	}
+	return strings.TrimSpace(pr.lines[line-2]) == ""
+}
+
+// trailing reports whether the comment follows some code on its line.
+func (pr *printer) trailing(c token.Token) bool {
+	if c.Line < 1 || c.Line > len(pr.lines) {
+		return false
+	}
+	return strings.TrimSpace(pr.lines[c.Line-1][:c.Column-1]) != ""
+}
+
+func (pr *printer) statement(stm ast.Statement, depth, col int) string {
+	switch stm := stm.(type) {
+	case *ast.LetStatement:
+		prefix := "let "
+		if stm.Pattern != nil {
+			prefix += pr.pattern(stm.Pattern, depth, col+len(prefix))
+		} else {
+			prefix += stm.Name.Value
+		}
+		if stm.Type != nil {
+			prefix += ": " + stm.Type.String()
+		}
+		prefix += " = "
+		return prefix + pr.expression(stm.Value, depth, col+len(prefix)) + ";"
+	case *ast.FunctionStatement:
+		return pr.function("fn "+stm.Name.Value, stm.Function, depth, col)
+	case *ast.ReturnStatement:
+		return "return " + pr.expression(stm.RetValue, depth, col+len("return ")) + ";"
+	case *ast.ExpressionStatement:
+		exp := pr.expression(stm.Expression, depth, col)
+		switch stm.Expression.(type) {
+		case *ast.IfExpression, *ast.MatchExpression:
+			return exp
+		}
+		return exp + ";"
+	case *ast.BlockStatement:
+		return pr.block(stm, depth, col)
+	default:
+		return stm.String()
+	}
+}
+
+// block renders a block whose opening brace is on a line indented at depth.
+// Blocks written on a single line with one statement are kept that way.
+func (pr *printer) block(block *ast.BlockStatement, depth, col int) string {
+	comments := pr.comments[block]
+
+	if len(block.Statements) == 0 && len(comments) == 0 {
+		return "{}"
+	}
+
+	if len(block.Statements) == 1 && len(comments) == 0 && block.Token.Line == block.End.Line {
+		inner := pr.statement(block.Statements[0], depth, col+2)
+		if _, ok := block.Statements[0].(*ast.ExpressionStatement); ok {
+			inner = strings.TrimSuffix(inner, ";")
+		}
+		if !strings.Contains(inner, "\n") && col+len(inner)+4 <= Width {
+			return "{ " + inner + " }"
+		}
+	}
+
+	return "{\n" + pr.statements(block.Statements, comments, depth+1) + indent(depth) + "}"
+}
+
+func (pr *printer) expression(exp ast.Expression, depth, col int) string {
+	switch exp := exp.(type) {
+	case *ast.Identifier:
+		return exp.Value
+	case *ast.IntegerLiteral:
+		return exp.Token.Literal
+	case *ast.Boolean:
+		return exp.Token.Literal
+	case *ast.StringLiteral:
+		return `"` + exp.Value + `"`
+	case *ast.PrefixExpression:
+		operand := pr.expression(exp.Right, depth, col+len(exp.Operator))
+		if _, ok := exp.Right.(*ast.InfixExpression); ok {
+			operand = "(" + operand + ")"
+		}
+		return exp.Operator + operand
+	case *ast.InfixExpression:
+		prec := parser.Precedence(exp.Token.Type)
+		left := pr.operand(exp.Left, depth, col, func(p int) bool { return p < prec })
+		col = advance(col, left) + len(exp.Operator) + 2
+		right := pr.operand(exp.Right, depth, col, func(p int) bool { return p <= prec })
+		return left + " " + exp.Operator + " " + right
+	case *ast.ArrayLiteral:
+		return pr.list("[", "]", pr.items(exp.Elements), pr.comments[exp], depth, col)
+	case *ast.HashLiteral:
+		pairs := make([]item, len(exp.Pairs))
+		for idx, pair := range exp.Pairs {
+			pair := pair
+			pairs[idx] = item{start: ast.Start(pair.Key), render: func(depth, col int) string {
+				key := pr.expression(pair.Key, depth, col)
+				return key + ": " + pr.expression(pair.Value, depth, advance(col, key)+2)
+			}}
+		}
+		return pr.list("{", "}", pairs, pr.comments[exp], depth, col)
+	case *ast.IndexExpression:
+		left := pr.postfixOperand(exp.Left, depth, col)
+		return left + "[" + pr.expression(exp.Index, depth, advance(col, left)+1) + "]"
+	case *ast.CallExpression:
+		function := pr.postfixOperand(exp.Function, depth, col)
+		return function + pr.list("(", ")", pr.items(exp.Args), pr.comments[exp], depth, advance(col, function))
+	case *ast.IfExpression:
+		condition := "if (" + pr.expression(exp.Condition, depth, col+4) + ") "
+		res := condition + pr.block(exp.Consequence, depth, advance(col, condition))
+		if exp.Alternative != nil {
+			res += " else " + pr.block(exp.Alternative, depth, advance(col, res)+6)
+		}
+		return res
+	case *ast.MatchExpression:
+		return pr.match(exp, depth, col)
+	case *ast.FunctionLiteral:
+		return pr.function("fn", exp, depth, col)
+	case *ast.SpreadExpression:
+		return "..." + pr.expression(exp.Value, depth, col+3)
+	case *ast.KeywordArgument:
+		return exp.Name + ": " + pr.expression(exp.Value, depth, col+len(exp.Name)+2)
+	default:
+		return exp.String()
+	}
+}
+
+// function renders a function, keyword being `fn` followed by its name if
+// it's declared by a statement.
+func (pr *printer) function(keyword string, fn *ast.FunctionLiteral, depth, col int) string {
+	params := make([]string, len(fn.Parameters))
+	for idx, param := range fn.Parameters {
+		params[idx] = param.Value
+		if pattern := fn.Pattern(idx); pattern != nil {
+			params[idx] = pr.pattern(pattern, depth, col)
+		}
+		if fn.IsRest(idx) {
+			params[idx] = "..." + params[idx]
+		}
+		if idx < len(fn.ParamTypes) && fn.ParamTypes[idx] != nil {
+			params[idx] += ": " + fn.ParamTypes[idx].String()
+		}
+		if def := fn.Default(idx); def != nil {
+			params[idx] += " = " + pr.expression(def, depth, col)
+		}
+	}
+	signature := keyword + "(" + strings.Join(params, ", ") + ")"
+	if fn.ReturnType != nil {
+		signature += ": " + fn.ReturnType.String()
+	}
+	signature += " "
+	return signature + pr.block(fn.Body, depth, col+len(signature))
+}
+
+// match renders a match expression one arm per line, with the comments in
+// between, unless it's written on a single line and fits in it.
+func (pr *printer) match(exp *ast.MatchExpression, depth, col int) string {
+	header := "match (" + pr.expression(exp.Subject, depth, col+7) + ") "
+	comments := pr.comments[exp]
+	if len(exp.Arms) == 0 && len(comments) == 0 {
+		return header + "{}"
+	}
+
+	arm := func(arm ast.MatchArm, depth, col int) string {
+		res := pr.pattern(arm.Pattern, depth, col)
+		if arm.Guard != nil {
+			res += " if " + pr.expression(arm.Guard, depth, advance(col, res)+4)
+		}
+		res += " => "
+		return res + pr.expression(arm.Value, depth, advance(col, res))
+	}
+
+	if exp.Token.Line == exp.End.Line && len(comments) == 0 {
+		arms := make([]string, len(exp.Arms))
+		for idx, a := range exp.Arms {
+			arms[idx] = arm(a, depth, col)
+		}
+		inline := header + "{ " + strings.Join(arms, ", ") + " }"
+		if !strings.Contains(inline, "\n") && col+len(inline) <= Width {
+			return inline
+		}
+	}
+
+	lines := []string{header + "{"}
+	for _, a := range exp.Arms {
+		start := ast.Start(a.Pattern)
+		lines, comments = pr.interleave(lines, comments, &start, depth+1)
+		lines = append(lines, indent(depth+1)+arm(a, depth+1, len(indent(depth+1)))+",")
+	}
+	lines, _ = pr.interleave(lines, comments, nil, depth+1)
+	return strings.Join(append(lines, indent(depth)+"}"), "\n")
+}
+
+// pattern renders the pattern of a let, a parameter or a match arm.
+func (pr *printer) pattern(p ast.Pattern, depth, col int) string {
+	var elems []string
+	switch p := p.(type) {
+	case *ast.ArrayPattern:
+		for _, elem := range p.Elements {
+			elems = append(elems, pr.patternElement(elem, false, depth, col))
+		}
+		if p.Rest != nil {
+			elems = append(elems, "..."+p.Rest.Value)
+		}
+		return "[" + strings.Join(elems, ", ") + "]"
+	case *ast.HashPattern:
+		for _, elem := range p.Elements {
+			elems = append(elems, pr.patternElement(elem, true, depth, col))
+		}
+		if p.Rest != nil {
+			elems = append(elems, "..."+p.Rest.Value)
+		}
+		return "{" + strings.Join(elems, ", ") + "}"
+	case *ast.LiteralPattern:
+		return pr.expression(p.Value, depth, col)
+	case *ast.TypePattern:
+		return pr.pattern(p.Target, depth, col) + ": " + p.Type.String()
+	default:
+		return p.String()
+	}
+}
+
+func (pr *printer) patternElement(elem ast.PatternElement, hash bool, depth, col int) string {
+	res := pr.pattern(elem.Target, depth, col)
+	if ident, ok := elem.Target.(*ast.Identifier); hash && !(ok && ident.Value == elem.Key) {
+		res = ast.HashKey(elem.Key) + ": " + res
+	}
+	if elem.Default != nil {
+		res += " = " + pr.expression(elem.Default, depth, col)
+	}
+	return res
+}
+
+// operand renders a sub-expression of an infix expression, parenthesized
+// when needsParens says its precedence is too low to stand on its own.
+func (pr *printer) operand(exp ast.Expression, depth, col int, needsParens func(int) bool) string {
+	if infix, ok := exp.(*ast.InfixExpression); ok && needsParens(parser.Precedence(infix.Token.Type)) {
+		return "(" + pr.expression(exp, depth, col+1) + ")"
+	}
+	return pr.expression(exp, depth, col)
+}
+
+// postfixOperand renders the target of a call or index expression.
+func (pr *printer) postfixOperand(exp ast.Expression, depth, col int) string {
+	switch exp.(type) {
+	case *ast.InfixExpression, *ast.PrefixExpression:
+		return "(" + pr.expression(exp, depth, col+1) + ")"
+	default:
+		return pr.expression(exp, depth, col)
+	}
+}
+
+// item is a list element, rendered starting at col on a line indented at
+// depth. Its start in the source places the comments around it.
+type item struct {
+	start  token.Token
+	render func(depth, col int) string
+}
+
+func (pr *printer) items(exps []ast.Expression) []item {
+	items := make([]item, len(exps))
+	for idx, exp := range exps {
+		exp := exp
+		items[idx] = item{start: ast.Start(exp), render: func(depth, col int) string { return pr.expression(exp, depth, col) }}
+	}
+	return items
+}
+
+// list renders a delimited, comma separated list on a single line if it
+// fits and has no comments, or else one element per line, with the
+// comments in between.
+func (pr *printer) list(open, close string, items []item, comments []token.Token, depth, col int) string {
+	if len(comments) == 0 {
+		inline := make([]string, len(items))
+		itemCol := col + len(open)
+		for idx, it := range items {
+			inline[idx] = it.render(depth, itemCol)
+			itemCol = advance(itemCol, inline[idx]) + 2
+		}
+
+		res := open + strings.Join(inline, ", ") + close
+		firstLine, _, _ := strings.Cut(res, "\n")
+		if col+len(firstLine) <= Width {
+			return res
+		}
+	}
+
+	lines := []string{open}
+	for idx, it := range items {
+		lines, comments = pr.interleave(lines, comments, &it.start, depth+1)
+		line := indent(depth+1) + it.render(depth+1, len(indent(depth+1)))
+		if idx < len(items)-1 {
+			line += ","
+		}
+		lines = append(lines, line)
+	}
+	lines, _ = pr.interleave(lines, comments, nil, depth+1)
+	return strings.Join(append(lines, indent(depth)+close), "\n")
+}
+
+// interleave adds to lines the comments before the token, all of them if
+// it's nil, returning the ones left. Trailing comments go at the end of the
+// last line, which holds the code they follow, and the others on lines of
+// their own indented at depth.
+func (pr *printer) interleave(lines []string, comments []token.Token, before *token.Token, depth int) ([]string, []token.Token) {
+	for len(comments) > 0 && (before == nil || comments[0].Before(*before)) {
+		c := comments[0]
+		comments = comments[1:]
+		if pr.trailing(c) {
+			lines[len(lines)-1] += " " + c.Literal
+		} else {
+			lines = append(lines, indent(depth)+c.Literal)
+		}
+	}
+	return lines, comments
+}
+
+// advance returns the column reached after writing s from col.
+func advance(col int, s string) int {
+	if idx := strings.LastIndex(s, "\n"); idx >= 0 {
+		return len(s) - idx - 1
+	}
+	return col + len(s)
+}
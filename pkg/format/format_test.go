@@ -0,0 +1,227 @@
+package format
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/AzraelSec/cube/pkg/lexer"
+	"github.com/AzraelSec/cube/pkg/parser"
+)
+
+func TestSource(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"let x=1+2*3", "let x = 1 + 2 * 3;\n"},
+		{"return   5", "return 5;\n"},
+		{"-a; !true; !(a == b)", "-a;\n!true;\n!(a == b);\n"},
+		{"(1 + 2) * 3; 1 + (2 * 3); (1 + 2) + 3; 1 - (2 - 3)", "(1 + 2) * 3;\n1 + 2 * 3;\n1 + 2 + 3;\n1 - (2 - 3);\n"},
+		{"(a + b)(c); (-a)[0]; a[1 + 2](3)", "(a + b)(c);\n(-a)[0];\na[1 + 2](3);\n"},
+		{`let s = "hello  world"`, "let s = \"hello  world\";\n"},
+		{"[1,2 , 3]; {}; []; {1:2,\"a\":[true]}", "[1, 2, 3];\n{};\n[];\n{1: 2, \"a\": [true]};\n"},
+		{"let f = fn(a,b){a+b}", "let f = fn(a, b) { a + b };\n"},
+		{"let f = fn(){}", "let f = fn() {};\n"},
+		{"fn add(a,b){a+b};add(1,2)", "fn add(a, b) { a + b }\nadd(1, 2);\n"},
+		{"fn f(a:int):int{\nreturn a}", "fn f(a: int): int {\n    return a;\n}\n"},
+		{"fn f(a,b=1+2,...rest:int){a}", "fn f(a, b = 1 + 2, ...rest: int) { a }\n"},
+		{"f(1,...xs,b:2,c:[3])", "f(1, ...xs, b: 2, c: [3]);\n"},
+		{"let g:fn(int,...string)=f", "let g: fn(int, ...string) = f;\n"},
+		{"let [a,b=(1+2),...rest]=xs", "let [a, b = 1 + 2, ...rest] = xs;\n"},
+		{"let {name:name,\"full age\":age=0,tags:[first]}:{string:any}=h", "let {name, \"full age\": age = 0, tags: [first]}: {string: any} = h;\n"},
+		{"fn f([a,b],{c}={}){a}", "fn f([a, b], {c} = {}) { a }\n"},
+		{"let {a,...others}=h", "let {a, ...others} = h;\n"},
+		{"match(x){1=>\"one\",n:int if n>1=>n,_=>0}", "match (x) { 1 => \"one\", n: int if n > 1 => n, _ => 0 }\n"},
+		{"match (x) {\n-1 => a, [y,...ys] => ys,\n{k:\"v\",...o} => o}", "match (x) {\n    -1 => a,\n    [y, ...ys] => ys,\n    {k: \"v\", ...o} => o,\n}\n"},
+		{"let r = match (x) {}", "let r = match (x) {};\n"},
+		{"let x:int=1", "let x: int = 1;\n"},
+		{"let f=fn(a:[int],b,c:{string:fn(int):bool}):fn(){a}", "let f = fn(a: [int], b, c: {string: fn(int): bool}): fn() { a };\n"},
+		{"let f = fn(a) {\nreturn a}", "let f = fn(a) {\n    return a;\n};\n"},
+		{"if(x){1}else{2}", "if (x) { 1 } else { 2 }\n"},
+		{"if (x) { let y = 1; }", "if (x) { let y = 1; }\n"},
+		{
+			"if (x) {\nif (y) {\n1\n}\n}",
+			"if (x) {\n    if (y) {\n        1;\n    }\n}\n",
+		},
+	}
+
+	for _, tt := range tests {
+		res, err := Source(tt.input)
+		if err != nil {
+			t.Errorf("%q: unexpected error %v", tt.input, err)
+			continue
+		}
+		if res != tt.expected {
+			t.Errorf("%q: wrong output. got=%q, want=%q", tt.input, res, tt.expected)
+		}
+	}
+}
+
+func TestSourceLayout(t *testing.T) {
+	input := `#!/usr/bin/env cube
+// leading
+let a = 1;   // trailing
+
+
+let b = fn(x) {
+  // first
+  let y = x;
+
+  y   // last expression
+  // end of block
+};
+// the end
+`
+	expected := `#!/usr/bin/env cube
+// leading
+let a = 1; // trailing
+
+let b = fn(x) {
+    // first
+    let y = x;
+
+    y; // last expression
+    // end of block
+};
+// the end
+`
+
+	res, err := Source(input)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if res != expected {
+		t.Errorf("wrong output.\ngot:\n%s\nwant:\n%s", res, expected)
+	}
+}
+
+func TestSourceLongLists(t *testing.T) {
+	input := `let h = {"first": [1, 2, 3], "second": fn(x) { x }, "third": "a rather long string"};
+print("some long argument", "another long argument", "a last argument that overflows");`
+	expected := `let h = {
+    "first": [1, 2, 3],
+    "second": fn(x) { x },
+    "third": "a rather long string"
+};
+print(
+    "some long argument",
+    "another long argument",
+    "a last argument that overflows"
+);
+`
+
+	res, err := Source(input)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if res != expected {
+		t.Errorf("wrong output.\ngot:\n%s\nwant:\n%s", res, expected)
+	}
+}
+
+func TestSourceExpressionComments(t *testing.T) {
+	input := `let h = {
+  // first
+  "a": 1, // trailing a
+  "b": [1, // one
+    // before two
+    2]
+  // last
+};
+f(1, // the one
+  2);
+let xs = [ // open
+  1];
+let m = match (x) { // cases
+  0 => 1, // zero
+  // the others
+  _ => 2
+};`
+	expected := `let h = {
+    // first
+    "a": 1, // trailing a
+    "b": [
+        1, // one
+        // before two
+        2
+    ]
+    // last
+};
+f(
+    1, // the one
+    2
+);
+let xs = [ // open
+    1
+];
+let m = match (x) { // cases
+    0 => 1, // zero
+    // the others
+    _ => 2,
+};
+`
+
+	res, err := Source(input)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if res != expected {
+		t.Errorf("wrong output.\ngot:\n%s\nwant:\n%s", res, expected)
+	}
+}
+
+func TestSourceIdempotent(t *testing.T) {
+	inputs := []string{
+		"let x = if (a) { 1 } else { let b = [1, 2]; b[0] };",
+		"let f = fn(a) {\n// c\nif (a > 1) { return a * (a - 1); } else { (a + 1) * 2 }\n\n\n// d\n};",
+		`let h = {"a": 1, "bbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbb": [1, 2, 3], "cccccccccccccccccccccccccccc": fn(x) { x }};
+let z = h;`,
+		"let f = fn(x) { match (x) { [a, b] if a > b => a, {name: \"aaaaaaaaaaaaaaaaaaaa\", ...rest} => rest, _ => fn(y) { y } } };",
+		"print(fn() {\n// in the body\n1\n}, // after the function\n[1, // one\n2]);",
+	}
+
+	for _, input := range inputs {
+		once, err := Source(input)
+		if err != nil {
+			t.Errorf("%q: unexpected error %v", input, err)
+			continue
+		}
+		twice, err := Source(once)
+		if err != nil {
+			t.Errorf("%q: unexpected error %v on the formatted output", input, err)
+			continue
+		}
+		if once != twice {
+			t.Errorf("%q: formatting is not idempotent.\nonce:\n%s\ntwice:\n%s", input, once, twice)
+		}
+	}
+}
+
+func TestSourceParseError(t *testing.T) {
+	_, err := Source("let = 5;")
+
+	var pe *ParseError
+	if !errors.As(err, &pe) {
+		t.Fatalf("expected a *ParseError, got %v", err)
+	}
+	if len(pe.Errors) == 0 {
+		t.Errorf("expected some parse errors")
+	}
+}
+
+func TestNode(t *testing.T) {
+	p := parser.New(lexer.New("let f = fn(a) { // dropped\n a * (2 + a) };"))
+	prog := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		t.Fatalf("unexpected parse errors %v", p.Errors())
+	}
+
+	res := Node(prog.Statements[0])
+	if res != "let f = fn(a) {\n    a * (2 + a);\n};" {
+		t.Errorf("wrong output. got=%q", res)
+	}
+	if strings.Contains(res, "dropped") {
+		t.Errorf("comments should not be rendered by Node")
+	}
+}
@@ -0,0 +1,66 @@
+package framing
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestReader(t *testing.T) {
+	input := "Content-Length: 2\r\n\r\n{}" +
+		"Content-Type: application/vscode-jsonrpc; charset=utf-8\r\ncontent-length: 7\r\n\r\n[1,2,3]" +
+		"Content-Length: 3\n\n\"a\""
+
+	r := NewReader(strings.NewReader(input))
+	for _, expected := range []string{"{}", "[1,2,3]", `"a"`} {
+		payload, err := r.Read()
+		if err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+		if string(payload) != expected {
+			t.Errorf("wrong payload. got=%q, want=%q", payload, expected)
+		}
+	}
+
+	if _, err := r.Read(); err != io.EOF {
+		t.Errorf("wrong error at the end of the stream. got=%v, want=%v", err, io.EOF)
+	}
+}
+
+func TestReaderErrors(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"Content-Length: 10\r\n\r\n{}", "unexpected EOF"},
+		{"Content-Length: 2\r\n", "unexpected EOF"},
+		{"Content-Length: a\r\n\r\n", `invalid Content-Length " a"`},
+		{"Content-Type: text\r\n\r\n{}", "missing Content-Length header"},
+		{"{}\r\n\r\n", `malformed header "{}"`},
+	}
+
+	for _, tt := range tests {
+		_, err := NewReader(strings.NewReader(tt.input)).Read()
+		if err == nil || err.Error() != tt.expected {
+			t.Errorf("%q: wrong error. got=%v, want=%q", tt.input, err, tt.expected)
+		}
+	}
+}
+
+func TestWriter(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+
+	if err := w.Write(map[string]int{"id": 1}); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if err := w.Write([]string{"é"}); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	expected := "Content-Length: 8\r\n\r\n{\"id\":1}Content-Length: 6\r\n\r\n[\"é\"]"
+	if buf.String() != expected {
+		t.Errorf("wrong output. got=%q, want=%q", buf.String(), expected)
+	}
+}
@@ -1,6 +1,10 @@
 package lexer
 
-import "github.com/AzraelSec/cube/pkg/token"
+import (
+	"strings"
+
+	"github.com/AzraelSec/cube/pkg/token"
+)
 
 const nul = 0
 
@@ -9,15 +13,32 @@ type Lexer struct {
 	position     int  // last index of input already tokenized
 	readPosition int  // index of input to read
 	ch           byte // input[readPosition]
+
+	line      int // line of input[position]
+	lineStart int // index of the first char of line
+
+	comments []token.Token
 }
 
 func New(s string) *Lexer {
-	l := &Lexer{input: s}
+	l := &Lexer{input: s, line: 1}
 	l.readChar()
+	l.skipShebang()
 	return l
 }
 
+// Comments returns the comments met so far. They aren't handed to the
+// parser, but tools like the formatter need them back.
+func (l *Lexer) Comments() []token.Token {
+	return l.comments
+}
+
 func (l *Lexer) readChar() {
+	if l.ch == '\n' {
+		l.line++
+		l.lineStart = l.readPosition
+	}
+
 	if l.readPosition >= len(l.input) {
 		l.ch = nul
 	} else {
@@ -29,7 +50,7 @@ func (l *Lexer) readChar() {
 }
 
 // todo: add special chars handling + other stuff
-func (l *Lexer) readString() string {
+func (l *Lexer) readString() (string, bool) {
 	pos := l.position + 1
 	for {
 		l.readChar()
@@ -38,7 +59,7 @@ func (l *Lexer) readString() string {
 		}
 	}
 
-	return l.input[pos:l.position]
+	return l.input[pos:l.position], l.ch == '"'
 }
 
 func (l *Lexer) readIdentifier() string {
@@ -58,9 +79,31 @@ func (l *Lexer) readNumber() string {
 }
 
 func (l *Lexer) NextToken() token.Token {
-	var tkn token.Token
-
 	l.skipWhiteSpaces()
+	for l.ch == '/' && l.peekChar() == '/' {
+		l.comments = append(l.comments, l.readComment())
+		l.skipWhiteSpaces()
+	}
+
+	line, column := l.line, l.position-l.lineStart+1
+	tkn := l.nextToken()
+	tkn.Line, tkn.Column = line, column
+	return tkn
+}
+
+func (l *Lexer) readComment() token.Token {
+	tkn := token.Token{Type: token.COMMENT, Line: l.line, Column: l.position - l.lineStart + 1}
+
+	pos := l.position
+	for l.ch != '\n' && l.ch != nul {
+		l.readChar()
+	}
+	tkn.Literal = strings.TrimRight(l.input[pos:l.position], " \t\r")
+	return tkn
+}
+
+func (l *Lexer) nextToken() token.Token {
+	var tkn token.Token
 
 	switch l.ch {
 	case '=':
@@ -68,6 +111,10 @@ func (l *Lexer) NextToken() token.Token {
 			ch := l.ch
 			l.readChar()
 			tkn = token.Token{Type: token.EQ, Literal: string(ch) + string(l.ch)}
+		} else if l.peekChar() == '>' {
+			ch := l.ch
+			l.readChar()
+			tkn = token.Token{Type: token.ARROW, Literal: string(ch) + string(l.ch)}
 		} else {
 			tkn = token.New(token.ASSIGN, string(l.ch))
 		}
@@ -105,8 +152,21 @@ func (l *Lexer) NextToken() token.Token {
 		tkn = token.New(token.SEMICOLON, string(l.ch))
 	case ':':
 		tkn = token.New(token.COLON, string(l.ch))
+	case '.':
+		if !strings.HasPrefix(l.input[l.position:], token.ELLIPSIS) {
+			tkn = token.New(token.ILLEGAL, string(l.ch))
+			break
+		}
+		l.readChar()
+		l.readChar()
+		tkn = token.New(token.ELLIPSIS, token.ELLIPSIS)
 	case '"':
-		tkn = token.New(token.STRING, l.readString())
+		str, terminated := l.readString()
+		if !terminated {
+			// note: the literal keeps the opening quote, so that the parser can tell this apart
+			return token.New(token.ILLEGAL, `"`+str)
+		}
+		tkn = token.New(token.STRING, str)
 	case '[':
 		tkn = token.New(token.LBRACKET, string(l.ch))
 	case ']':
@@ -138,6 +198,16 @@ func (l *Lexer) skipWhiteSpaces() {
 	}
 }
 
+// note: a leading `#!` line lets scripts be executed directly on unix systems
+func (l *Lexer) skipShebang() {
+	if l.ch != '#' || l.peekChar() != '!' {
+		return
+	}
+	for l.ch != '\n' && l.ch != nul {
+		l.readChar()
+	}
+}
+
 func (l *Lexer) peekChar() byte {
 	if l.readPosition >= len(l.input) {
 		return 0
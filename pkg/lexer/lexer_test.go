@@ -31,6 +31,8 @@ func TestNextToken(t *testing.T) {
 	[1,"hello"]
 
 	{"foo": "bar"}
+	...xs..
+	match (x) { _ => 1 }
 	`
 
 	tests := []struct {
@@ -125,6 +127,21 @@ func TestNextToken(t *testing.T) {
 		{token.STRING, "bar"},
 		{token.RBRACE, "}"},
 
+		{token.ELLIPSIS, "..."},
+		{token.IDENT, "xs"},
+		{token.ILLEGAL, "."},
+		{token.ILLEGAL, "."},
+
+		{token.MATCH, "match"},
+		{token.LPAREN, "("},
+		{token.IDENT, "x"},
+		{token.RPAREN, ")"},
+		{token.LBRACE, "{"},
+		{token.IDENT, "_"},
+		{token.ARROW, "=>"},
+		{token.INT, "1"},
+		{token.RBRACE, "}"},
+
 		{token.EOF, ""},
 	}
 
@@ -141,3 +158,110 @@ func TestNextToken(t *testing.T) {
 		}
 	}
 }
+
+func TestShebang(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected []token.Token
+	}{
+		{
+			"#!/usr/bin/env cube\nlet x = 1;",
+			[]token.Token{
+				token.New(token.LET, "let"),
+				token.New(token.IDENT, "x"),
+				token.New(token.ASSIGN, "="),
+				token.New(token.INT, "1"),
+				token.New(token.SEMICOLON, ";"),
+				token.New(token.EOF, ""),
+			},
+		},
+		{
+			"#!/usr/bin/env cube",
+			[]token.Token{token.New(token.EOF, "")},
+		},
+		{
+			"x #!",
+			[]token.Token{
+				token.New(token.IDENT, "x"),
+				token.New(token.ILLEGAL, "#"),
+				token.New(token.BANG, "!"),
+				token.New(token.EOF, ""),
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		l := New(tt.input)
+		for i, expected := range tt.expected {
+			tok := l.NextToken()
+			if tok.Type != expected.Type || tok.Literal != expected.Literal {
+				t.Fatalf("%q: test[%d] - wrong token. expected=%+v, got=%+v", tt.input, i, expected, tok)
+			}
+		}
+	}
+}
+
+func TestPositions(t *testing.T) {
+	input := "let x = 5;\n  x + \"a\nb\";\n\tfoo"
+
+	tests := []struct {
+		expectedLiteral string
+		line, column    int
+	}{
+		{"let", 1, 1},
+		{"x", 1, 5},
+		{"=", 1, 7},
+		{"5", 1, 9},
+		{";", 1, 10},
+		{"x", 2, 3},
+		{"+", 2, 5},
+		{"a\nb", 2, 7},
+		{";", 3, 3},
+		{"foo", 4, 2},
+		{"", 4, 5},
+	}
+
+	l := New(input)
+	for i, tt := range tests {
+		tok := l.NextToken()
+		if tok.Literal != tt.expectedLiteral {
+			t.Fatalf("test[%d] - token literal wrong expected=%q, got=%q", i, tt.expectedLiteral, tok.Literal)
+		}
+		if tok.Line != tt.line || tok.Column != tt.column {
+			t.Errorf("test[%d] - %q wrong position. expected=%d:%d, got=%d:%d", i, tok.Literal, tt.line, tt.column, tok.Line, tok.Column)
+		}
+	}
+}
+
+func TestComments(t *testing.T) {
+	input := `// leading
+let x = 5; // trailing   
+x / 2 //last`
+
+	expected := []token.TokenType{
+		token.LET, token.IDENT, token.ASSIGN, token.INT, token.SEMICOLON,
+		token.IDENT, token.SLASH, token.INT, token.EOF,
+	}
+
+	l := New(input)
+	for i, tt := range expected {
+		tok := l.NextToken()
+		if tok.Type != tt {
+			t.Fatalf("test[%d] - token type wrong expected=%q, got=%q", i, tt, tok.Type)
+		}
+	}
+
+	comments := []token.Token{
+		{Type: token.COMMENT, Literal: "// leading", Line: 1, Column: 1},
+		{Type: token.COMMENT, Literal: "// trailing", Line: 2, Column: 12},
+		{Type: token.COMMENT, Literal: "//last", Line: 3, Column: 7},
+	}
+	if len(l.Comments()) != len(comments) {
+		t.Fatalf("wrong number of comments. got=%d, want=%d", len(l.Comments()), len(comments))
+	}
+	for i, c := range l.Comments() {
+		if c != comments[i] {
+			t.Errorf("comment[%d] wrong. got=%+v, want=%+v", i, c, comments[i])
+		}
+	}
+}
@@ -0,0 +1,475 @@
+// Package lint reports suspicious, although valid, constructs in Cube
+// programs.
+package lint
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/AzraelSec/cube/pkg/ast"
+	"github.com/AzraelSec/cube/pkg/evaluator"
+)
+
+// rule IDs
+const (
+	Unused            = "unused"
+	Shadow            = "shadow"
+	Unreachable       = "unreachable"
+	BuiltinArity      = "builtin-arity"
+	DuplicateKey      = "duplicate-key"
+	ConstantCondition = "constant-condition"
+)
+
+type Rule struct {
+	ID      string
+	Summary string
+}
+
+var Rules = []Rule{
+	{ID: Unused, Summary: "let and match bindings, functions and parameters that are never used"},
+	{ID: Shadow, Summary: "names hiding a binding of an enclosing function or a builtin"},
+	{ID: Unreachable, Summary: "statements following a return"},
+	{ID: BuiltinArity, Summary: "builtin calls with the wrong number of arguments"},
+	{ID: DuplicateKey, Summary: "hash literals repeating a key"},
+	{ID: ConstantCondition, Summary: "if conditions that are always true or always false"},
+}
+
+// IsRule reports whether id identifies one of the Rules.
+func IsRule(id string) bool {
+	for _, rule := range Rules {
+		if rule.ID == id {
+			return true
+		}
+	}
+	return false
+}
+
+type Finding struct {
+	Rule         string
+	Line, Column int
+	Message      string
+}
+
+func (f Finding) String() string {
+	return fmt.Sprintf("%d:%d: %s (%s)", f.Line, f.Column, f.Message, f.Rule)
+}
+
+type Config struct {
+	Disabled map[string]bool // rule IDs that aren't reported
+}
+
+// Lint checks the program, returning the findings sorted by position.
+// Top-level bindings are never reported as unused since other sources (e.g.
+// the REPL :load command) may rely on them, nor are names starting with `_`.
+func Lint(prog *ast.Program, cfg Config) []Finding {
+	l := &linter{cfg: cfg}
+
+	l.enter(true)
+	for _, stm := range prog.Statements {
+		l.declareLets(stm)
+	}
+	l.statements(prog.Statements)
+	l.leave()
+
+	sort.SliceStable(l.findings, func(i, j int) bool {
+		a, b := l.findings[i], l.findings[j]
+		if a.Line != b.Line {
+			return a.Line < b.Line
+		}
+		return a.Column < b.Column
+	})
+	return l.findings
+}
+
+type binding struct {
+	name *ast.Identifier
+	kind string // "let binding", "match binding", "function" or "parameter"
+	used bool
+}
+
+type scope struct {
+	outer    *scope
+	global   bool
+	bindings map[string]*binding
+	order    []*binding
+}
+
+type linter struct {
+	cfg      Config
+	scope    *scope
+	findings []Finding
+}
+
+func (l *linter) report(rule string, node ast.Node, format string, args ...interface{}) {
+	if l.cfg.Disabled[rule] {
+		return
+	}
+	start := ast.Start(node)
+	l.findings = append(l.findings, Finding{
+		Rule:    rule,
+		Line:    start.Line,
+		Column:  start.Column,
+		Message: fmt.Sprintf(format, args...),
+	})
+}
+
+// note: lets are visible in the whole function or match arm they are in,
+// blocks don't open a new scope
+func (l *linter) enter(global bool) {
+	l.scope = &scope{outer: l.scope, global: global, bindings: map[string]*binding{}}
+}
+
+func (l *linter) leave() {
+	if !l.scope.global {
+		for _, b := range l.scope.order {
+			if !b.used && !strings.HasPrefix(b.name.Value, "_") {
+				l.report(Unused, b.name, "%s %s is never used", b.kind, b.name.Value)
+			}
+		}
+	}
+	l.scope = l.scope.outer
+}
+
+func (l *linter) declare(name *ast.Identifier, kind string) {
+	if _, ok := l.scope.bindings[name.Value]; ok {
+		// note: a new let for the same name in the same function only rebinds it
+		return
+	}
+
+	if outer := l.lookup(name.Value); outer != nil {
+		l.report(Shadow, name, "%s %s shadows the %s declared at %d:%d", kind, name.Value, outer.kind, outer.name.Token.Line, outer.name.Token.Column)
+	} else if _, ok := evaluator.LookupBuiltin(name.Value); ok {
+		l.report(Shadow, name, "%s %s shadows the builtin %s", kind, name.Value, name.Value)
+	}
+
+	b := &binding{name: name, kind: kind}
+	l.scope.bindings[name.Value] = b
+	l.scope.order = append(l.scope.order, b)
+}
+
+// declareLets declares upfront the lets and the named functions of node in
+// the current function or match arm, so that functions can refer to bindings
+// that come later in the source.
+func (l *linter) declareLets(node ast.Node) {
+	ast.Inspect(node, func(n ast.Node) bool {
+		switch n := n.(type) {
+		case *ast.LetStatement:
+			for _, name := range n.Names() {
+				l.declare(name, "let binding")
+			}
+		case *ast.FunctionStatement:
+			l.declare(n.Name, "function")
+		case *ast.MatchExpression:
+			l.declareLets(n.Subject)
+			return false
+		case *ast.FunctionLiteral:
+			return false
+		}
+		return true
+	})
+}
+
+func (l *linter) lookup(name string) *binding {
+	for s := l.scope; s != nil; s = s.outer {
+		if b, ok := s.bindings[name]; ok {
+			return b
+		}
+	}
+	return nil
+}
+
+func (l *linter) statements(stms []ast.Statement) {
+	for idx, stm := range stms {
+		l.node(stm)
+		if idx < len(stms)-1 && terminates(stm) {
+			l.report(Unreachable, stms[idx+1], "unreachable code")
+			for _, rest := range stms[idx+1:] {
+				l.node(rest)
+			}
+			return
+		}
+	}
+}
+
+func (l *linter) node(node ast.Node) {
+	switch node := node.(type) {
+	case *ast.Identifier:
+		if b := l.lookup(node.Value); b != nil {
+			b.used = true
+		}
+		return
+	case *ast.LetStatement:
+		l.node(node.Value)
+		if node.Pattern != nil {
+			for _, def := range ast.Defaults(node.Pattern) {
+				l.node(def)
+			}
+		}
+		return
+	case *ast.FunctionStatement:
+		l.node(node.Function)
+		return
+	case *ast.MatchExpression:
+		l.node(node.Subject)
+		// note: each arm has a scope of its own
+		for _, arm := range node.Arms {
+			l.enter(false)
+			for _, name := range ast.Names(arm.Pattern) {
+				l.declare(name, "match binding")
+			}
+			if arm.Guard != nil {
+				l.declareLets(arm.Guard)
+			}
+			l.declareLets(arm.Value)
+			for _, def := range ast.Defaults(arm.Pattern) {
+				l.node(def)
+			}
+			if arm.Guard != nil {
+				l.node(arm.Guard)
+			}
+			l.node(arm.Value)
+			l.leave()
+		}
+		return
+	case *ast.BlockStatement:
+		l.statements(node.Statements)
+		return
+	case *ast.FunctionLiteral:
+		// note: defaults are evaluated where the function is defined
+		for _, def := range node.Defaults {
+			if def != nil {
+				l.node(def)
+			}
+		}
+		l.enter(false)
+		for _, param := range node.Names() {
+			l.declare(param, "parameter")
+		}
+		for _, pattern := range node.Patterns {
+			if pattern != nil {
+				for _, def := range ast.Defaults(pattern) {
+					l.node(def)
+				}
+			}
+		}
+		l.declareLets(node.Body)
+		l.statements(node.Body.Statements)
+		l.leave()
+		return
+	case *ast.IfExpression:
+		l.checkCondition(node.Condition)
+	case *ast.CallExpression:
+		l.checkArity(node)
+	case *ast.HashLiteral:
+		l.checkKeys(node)
+	}
+
+	for _, child := range ast.Children(node) {
+		l.node(child)
+	}
+}
+
+// terminates reports whether stm always returns.
+func terminates(stm ast.Statement) bool {
+	switch stm := stm.(type) {
+	case *ast.ReturnStatement:
+		return true
+	case *ast.BlockStatement:
+		return len(stm.Statements) > 0 && terminates(stm.Statements[len(stm.Statements)-1])
+	case *ast.ExpressionStatement:
+		ifExp, ok := stm.Expression.(*ast.IfExpression)
+		return ok && ifExp.Alternative != nil && terminates(ifExp.Consequence) && terminates(ifExp.Alternative)
+	default:
+		return false
+	}
+}
+
+func (l *linter) checkCondition(condition ast.Expression) {
+	val, ok := fold(condition)
+	if !ok {
+		return
+	}
+	// note: null can't be written as a literal, false is the only falsy constant
+	if val == false {
+		l.report(ConstantCondition, condition, "if condition is always false")
+	} else {
+		l.report(ConstantCondition, condition, "if condition is always true")
+	}
+}
+
+// fold computes the value of a constant expression without evaluating it,
+// so that linting never runs the code. The value is an int64, a string or a
+// bool, or the expression itself for the arrays, hashes and functions, which
+// are only ever truthy. It fails on the expressions whose value depends on
+// the program, or that would raise an error, like a division by zero.
+func fold(exp ast.Expression) (any, bool) {
+	switch exp := exp.(type) {
+	case *ast.IntegerLiteral:
+		return exp.Value, true
+	case *ast.StringLiteral:
+		return exp.Value, true
+	case *ast.Boolean:
+		return exp.Value, true
+	case *ast.FunctionLiteral:
+		return exp, true
+	case *ast.ArrayLiteral:
+		for _, elem := range exp.Elements {
+			if _, ok := fold(elem); !ok {
+				return nil, false
+			}
+		}
+		return exp, true
+	case *ast.HashLiteral:
+		for _, pair := range exp.Pairs {
+			key, ok := fold(pair.Key)
+			if !ok || !isScalar(key) {
+				return nil, false
+			}
+			if _, ok := fold(pair.Value); !ok {
+				return nil, false
+			}
+		}
+		return exp, true
+	case *ast.PrefixExpression:
+		right, ok := fold(exp.Right)
+		if !ok {
+			return nil, false
+		}
+		return foldPrefix(exp.Operator, right)
+	case *ast.InfixExpression:
+		left, ok := fold(exp.Left)
+		if !ok {
+			return nil, false
+		}
+		right, ok := fold(exp.Right)
+		if !ok {
+			return nil, false
+		}
+		return foldInfix(exp.Operator, left, right)
+	default:
+		return nil, false
+	}
+}
+
+func isScalar(val any) bool {
+	switch val.(type) {
+	case int64, string, bool:
+		return true
+	default:
+		return false
+	}
+}
+
+// note: mirrors the prefix operators of the evaluator
+func foldPrefix(op string, right any) (any, bool) {
+	switch op {
+	case "!":
+		switch right := right.(type) {
+		case bool:
+			return !right, true
+		case int64:
+			return right == 0, true
+		default:
+			return false, true
+		}
+	case "-":
+		n, ok := right.(int64)
+		return -n, ok
+	default:
+		return nil, false
+	}
+}
+
+// note: mirrors the infix operators of the evaluator in strict mode, the
+// comparisons of arrays, hashes and functions are left to it
+func foldInfix(op string, left, right any) (any, bool) {
+	if op == "==" || op == "!=" {
+		if !isScalar(left) || !isScalar(right) {
+			return nil, false
+		}
+		return (left == right) == (op == "=="), true
+	}
+
+	switch left := left.(type) {
+	case int64:
+		right, ok := right.(int64)
+		if !ok {
+			return nil, false
+		}
+		switch op {
+		case "+":
+			return left + right, true
+		case "-":
+			return left - right, true
+		case "*":
+			return left * right, true
+		case "/":
+			if right == 0 {
+				return nil, false
+			}
+			return left / right, true
+		case "<":
+			return left < right, true
+		case ">":
+			return left > right, true
+		}
+	case string:
+		right, ok := right.(string)
+		if ok && op == "+" {
+			return left + right, true
+		}
+	}
+	return nil, false
+}
+
+func (l *linter) checkArity(call *ast.CallExpression) {
+	ident, ok := call.Function.(*ast.Identifier)
+	if !ok || l.lookup(ident.Value) != nil {
+		return
+	}
+	builtin, ok := evaluator.LookupBuiltin(ident.Value)
+	if !ok {
+		return
+	}
+
+	// note: the number of arguments a spread stands for is only known at runtime
+	for _, arg := range call.Args {
+		if _, ok := arg.(*ast.SpreadExpression); ok {
+			return
+		}
+	}
+
+	got := len(call.Args)
+	if got >= builtin.MinArgs && (builtin.MaxArgs < 0 || got <= builtin.MaxArgs) {
+		return
+	}
+
+	plural := "s"
+	if builtin.MaxArgs == 1 {
+		plural = ""
+	}
+	l.report(BuiltinArity, call, "builtin %s expects %s argument%s, got %d", ident.Value, builtin.Arity(), plural, got)
+}
+
+func (l *linter) checkKeys(hash *ast.HashLiteral) {
+	seen := map[string]bool{}
+	for _, pair := range hash.Pairs {
+		var key string
+		switch k := pair.Key.(type) {
+		case *ast.StringLiteral:
+			key = fmt.Sprintf("%q", k.Value)
+		case *ast.IntegerLiteral:
+			key = fmt.Sprintf("%d", k.Value)
+		case *ast.Boolean:
+			key = fmt.Sprintf("%t", k.Value)
+		default:
+			continue
+		}
+
+		if seen[key] {
+			l.report(DuplicateKey, pair.Key, "duplicate key %s in hash literal", key)
+		}
+		seen[key] = true
+	}
+}
@@ -0,0 +1,157 @@
+package lint
+
+import (
+	"testing"
+
+	"github.com/AzraelSec/cube/pkg/lexer"
+	"github.com/AzraelSec/cube/pkg/parser"
+)
+
+func TestLint(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected []string
+	}{
+		{"let a = 1; let f = fn(x) { x + a }; f(a);", []string{}},
+		{
+			"let f = fn(x, y) { let z = 1; x };",
+			[]string{
+				"1:15: parameter y is never used (unused)",
+				"1:24: let binding z is never used (unused)",
+			},
+		},
+		{"let f = fn(_x) { let _y = 1; 2 }; let unused = 1;", []string{}},
+		{"let f = fn() { g() }; let g = fn() { 1 }; f();", []string{}},
+		{"fn f() { g() } fn g() { 1 } fn h() { fn k() { 1 } fn used() { 2 } used() }", []string{"1:41: function k is never used (unused)"}},
+		{"let n = 1; fn f(a, b = n, ...others) { a + b } f(1);", []string{"1:30: parameter others is never used (unused)"}},
+		{"let xs = [1, 2]; len(...xs); len(value: xs); len(xs, value: 2);", []string{"1:46: builtin len expects 1 argument, got 2 (builtin-arity)"}},
+		{
+			"let d = 2; fn f([x, y], {k = d}) { let [a, _b, ...c] = x; a + k } f([[1], 2], {});",
+			[]string{
+				"1:21: parameter y is never used (unused)",
+				"1:51: let binding c is never used (unused)",
+			},
+		},
+		{"let x = 1; fn f({x}) { x } f({});", []string{"1:18: parameter x shadows the let binding declared at 1:5 (shadow)"}},
+		{
+			"fn f(x, d) { match (x) { [a, b] => a, {k = d, ...o} if k => 1, _len => 0 } } f(1, 2);",
+			[]string{"1:30: match binding b is never used (unused)", "1:50: match binding o is never used (unused)"},
+		},
+		{"fn f(x) { match (x) { len => len } } f(1);", []string{"1:23: match binding len shadows the builtin len (shadow)"}},
+		{
+			"let x = 1; x + match (2) { x => 0, [y] => y };",
+			[]string{
+				"1:28: match binding x shadows the let binding declared at 1:5 (shadow)",
+				"1:28: match binding x is never used (unused)",
+			},
+		},
+		{"fn f(x) { match (x) { [a] => a, a => a } } f(1);", []string{}},
+		{"let f = fn(n) { if (n > 0) { f(n - 1) } else { 0 } }; f(3);", []string{}},
+		{"let f = fn(x) { let x = x + 1; x }; f(1);", []string{}},
+		{
+			"let x = 1; let f = fn(x) { x }; f(x);",
+			[]string{"1:23: parameter x shadows the let binding declared at 1:5 (shadow)"},
+		},
+		{
+			"let f = fn(a) { let g = fn() { let a = 2; a }; g() }; f(1);",
+			[]string{
+				"1:12: parameter a is never used (unused)",
+				"1:36: let binding a shadows the parameter declared at 1:12 (shadow)",
+			},
+		},
+		{"let len = fn(_x) { 0 };", []string{"1:5: let binding len shadows the builtin len (shadow)"}},
+		{
+			"let f = fn() { return 1; 2; 3 }; f();",
+			[]string{"1:26: unreachable code (unreachable)"},
+		},
+		{
+			"let f = fn(x) { if (x) { return 1; } else { return 2; } x }; f(true);",
+			[]string{"1:57: unreachable code (unreachable)"},
+		},
+		{"let f = fn(x) { if (x) { return 1; } x }; f(true);", []string{}},
+		{
+			"len(); len(1, 2); push([]); exit(1, 2); print(); merge(); len([1])",
+			[]string{
+				"1:1: builtin len expects 1 argument, got 0 (builtin-arity)",
+				"1:8: builtin len expects 1 argument, got 2 (builtin-arity)",
+				"1:19: builtin push expects 2 arguments, got 1 (builtin-arity)",
+				"1:29: builtin exit expects 0 or 1 argument, got 2 (builtin-arity)",
+			},
+		},
+		{"let f = fn(len) { len(1, 2) }; f(1);", []string{"1:12: parameter len shadows the builtin len (shadow)"}},
+		{
+			`{"a": 1, "b": 2, "a": 3, 1: 1, 1: 2, true: 0, x: 1, x: 2}`,
+			[]string{
+				"1:18: duplicate key \"a\" in hash literal (duplicate-key)",
+				"1:32: duplicate key 1 in hash literal (duplicate-key)",
+			},
+		},
+		{
+			`if (true) { 1 }; if (1 > 2) { 1 }; if ("a") { 1 }; if (!fn() { 1 }) { 1 }; if (1 + true) { 1 }`,
+			[]string{
+				"1:5: if condition is always true (constant-condition)",
+				"1:22: if condition is always false (constant-condition)",
+				"1:40: if condition is always true (constant-condition)",
+				"1:56: if condition is always false (constant-condition)",
+			},
+		},
+		{"let x = true; if (x) { 1 }; if (len([1]) > 0) { 1 }", []string{}},
+		{"if (1 / 0) { 1 }; if ([1 / 0]) { 1 }; if ({1: 2 / 0}) { 1 }; if (\"a\" + 1) { 1 }; if ([1] == [1]) { 1 }", []string{}},
+		{
+			"if (6 / 3 == 2) { 1 }; if (!0) { 1 }; if (-1 > 0) { 1 }; if ({\"a\": [1]}) { 1 }",
+			[]string{
+				"1:5: if condition is always true (constant-condition)",
+				"1:28: if condition is always true (constant-condition)",
+				"1:43: if condition is always false (constant-condition)",
+				"1:62: if condition is always true (constant-condition)",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		res := lint(t, tt.input, Config{})
+		if len(res) != len(tt.expected) {
+			t.Errorf("%q: wrong number of findings. got=%q, want=%q", tt.input, res, tt.expected)
+			continue
+		}
+		for idx, finding := range res {
+			if finding != tt.expected[idx] {
+				t.Errorf("%q: wrong finding. got=%q, want=%q", tt.input, finding, tt.expected[idx])
+			}
+		}
+	}
+}
+
+func TestLintDisabledRules(t *testing.T) {
+	input := "let f = fn(len) { return 1; 2 }; if (true) { f(1) }"
+
+	res := lint(t, input, Config{Disabled: map[string]bool{Shadow: true, Unused: true, ConstantCondition: true}})
+	if len(res) != 1 || res[0] != "1:29: unreachable code (unreachable)" {
+		t.Errorf("wrong findings. got=%q", res)
+	}
+}
+
+func TestIsRule(t *testing.T) {
+	for _, rule := range Rules {
+		if !IsRule(rule.ID) {
+			t.Errorf("%s should be a rule", rule.ID)
+		}
+	}
+	if IsRule("nope") {
+		t.Errorf("nope should not be a rule")
+	}
+}
+
+func lint(t *testing.T, input string, cfg Config) []string {
+	p := parser.New(lexer.New(input))
+	prog := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		t.Fatalf("%q: unexpected parse errors %v", input, p.Errors())
+	}
+
+	res := []string{}
+	for _, finding := range Lint(prog, cfg) {
+		res = append(res, finding.String())
+	}
+	return res
+}
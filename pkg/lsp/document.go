@@ -0,0 +1,370 @@
+package lsp
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/AzraelSec/cube/pkg/ast"
+	"github.com/AzraelSec/cube/pkg/evaluator"
+	"github.com/AzraelSec/cube/pkg/lexer"
+	"github.com/AzraelSec/cube/pkg/object"
+	"github.com/AzraelSec/cube/pkg/parser"
+	"github.com/AzraelSec/cube/pkg/testrunner"
+	"github.com/AzraelSec/cube/pkg/token"
+	"github.com/AzraelSec/cube/pkg/types"
+)
+
+type document struct {
+	text        string
+	diagnostics []Diagnostic
+	// note: the last version of the text that parsed. While the user is in
+	// the middle of an edit the features other than the diagnostics keep
+	// working on it
+	parsed *source
+}
+
+// source is a version of a document along with what's known about it.
+type source struct {
+	lines  []string
+	tokens []token.Token // the last one is token.EOF
+	prog   *ast.Program  // nil if the text doesn't parse
+	info   *types.Info
+}
+
+// open analyzes the text of the document at uri, prev is its previous
+// version if any. Test files can use the test builtin.
+func open(uri, text string, prev *document) *document {
+	src := &source{lines: strings.Split(text, "\n")}
+	l := lexer.New(text)
+	for {
+		tok := l.NextToken()
+		src.tokens = append(src.tokens, tok)
+		if tok.Type == token.EOF {
+			break
+		}
+	}
+
+	doc := &document{text: text, diagnostics: []Diagnostic{}}
+	if prev != nil {
+		doc.parsed = prev.parsed
+	}
+
+	p := parser.New(lexer.New(text))
+	prog := p.ParseProgram()
+	for _, warning := range p.Warnings() {
+		diag := src.diagnostic(warning.Line, warning.Column, warning.Msg)
+		diag.Severity = severityWarning
+		doc.diagnostics = append(doc.diagnostics, diag)
+	}
+	if errs := p.ErrorList(); len(errs) != 0 {
+		for _, err := range errs {
+			doc.diagnostics = append(doc.diagnostics, src.diagnostic(err.Line, err.Column, err.Msg))
+		}
+		return doc
+	}
+
+	// note: the same environment scripts, or tests, are run in
+	env := object.NewEnvironment()
+	env.Set("args", &object.Array{})
+	if strings.HasSuffix(uri, testrunner.Suffix) {
+		env = testrunner.Globals(nil)
+	}
+	globals := types.Globals(env)
+	globals["args"] = &types.Array{Elem: types.String}
+
+	errs := evaluator.Resolve(prog, env)
+	src.prog, src.info = prog, &types.Info{}
+	checkErrs := types.CheckInfo(prog, globals, src.info)
+	if len(errs) == 0 {
+		errs = checkErrs
+		for _, warning := range src.info.Warnings {
+			diag := src.diagnostic(warning.Line, warning.Column, warning.Msg)
+			diag.Severity = severityWarning
+			doc.diagnostics = append(doc.diagnostics, diag)
+		}
+	}
+	for _, err := range errs {
+		doc.diagnostics = append(doc.diagnostics, src.diagnostic(err.Line, err.Column, err.Msg))
+	}
+
+	doc.parsed = src
+	return doc
+}
+
+func (src *source) diagnostic(line, col int, msg string) Diagnostic {
+	at := token.Token{Line: line, Column: col}
+	rng := Range{Start: src.position(line, col), End: src.position(line, col+1)}
+	if idx := src.tokenIndex(at); idx < len(src.tokens) && src.tokens[idx].Line == line && src.tokens[idx].Column == col {
+		rng = src.tokenRange(src.tokens[idx])
+	}
+	return Diagnostic{Range: rng, Severity: severityError, Source: "cube", Message: msg}
+}
+
+// position converts a 1-based line and byte column into a protocol position.
+func (src *source) position(line, col int) Position {
+	if line < 1 {
+		return Position{}
+	}
+	if line > len(src.lines) {
+		last := src.lines[len(src.lines)-1]
+		return Position{Line: len(src.lines) - 1, Character: utf16Len(last)}
+	}
+
+	text := src.lines[line-1]
+	if col < 1 {
+		col = 1
+	}
+	if col > len(text)+1 {
+		col = len(text) + 1
+	}
+	return Position{Line: line - 1, Character: utf16Len(text[:col-1])}
+}
+
+// location converts a protocol position into a 1-based line and byte column.
+func (src *source) location(pos Position) (int, int) {
+	if pos.Line < 0 || pos.Line >= len(src.lines) {
+		return pos.Line + 1, 1
+	}
+
+	text, units := src.lines[pos.Line], 0
+	for idx, r := range text {
+		if units >= pos.Character {
+			return pos.Line + 1, idx + 1
+		}
+		units++
+		if r >= 0x10000 {
+			units++
+		}
+	}
+	return pos.Line + 1, len(text) + 1
+}
+
+func utf16Len(s string) int {
+	units := 0
+	for _, r := range s {
+		units++
+		if r >= 0x10000 {
+			units++
+		}
+	}
+	return units
+}
+
+// tokenRange returns the range of tok in the source, strings can span more
+// than one line.
+func (src *source) tokenRange(tok token.Token) Range {
+	text := tok.Literal
+	if tok.Type == token.STRING {
+		text = `"` + text + `"`
+	}
+
+	line, col := tok.Line, tok.Column+len(text)
+	if idx := strings.LastIndexByte(text, '\n'); idx >= 0 {
+		line += strings.Count(text, "\n")
+		col = len(text) - idx
+	}
+	return Range{Start: src.position(tok.Line, tok.Column), End: src.position(line, col)}
+}
+
+// tokenIndex returns the index of the first token that doesn't start
+// before at.
+func (src *source) tokenIndex(at token.Token) int {
+	return sort.Search(len(src.tokens), func(idx int) bool {
+		return !src.tokens[idx].Before(at)
+	})
+}
+
+// span returns the range from the start of node to the end of the last
+// token before bound.
+func (src *source) span(node ast.Node, bound token.Token) Range {
+	start := ast.Start(node)
+	end := start
+	if idx := src.tokenIndex(bound); idx > 0 && start.Before(src.tokens[idx-1]) {
+		end = src.tokens[idx-1]
+	}
+	return Range{Start: src.position(start.Line, start.Column), End: src.tokenRange(end).End}
+}
+
+// identifierAt returns the identifier at pos, including the position right
+// after its last character.
+func (src *source) identifierAt(pos Position) *ast.Identifier {
+	line, col := src.location(pos)
+
+	// note: the last match wins, so that the variables of a destructured
+	// parameter take precedence over the parameter named after the pattern
+	var found *ast.Identifier
+	ast.Inspect(src.prog, func(n ast.Node) bool {
+		ident, ok := n.(*ast.Identifier)
+		if ok && ident.Token.Line == line && ident.Token.Column <= col && col <= ident.Token.Column+len(ident.Value) {
+			found = ident
+		}
+		return true
+	})
+	return found
+}
+
+// typeOf returns the type of ident as a string, empty if it's unknown.
+func (src *source) typeOf(ident *ast.Identifier) string {
+	if typ, ok := src.info.Types[ident]; ok && typ != nil {
+		return typ.String()
+	}
+	if fn, ok := types.Builtin(ident.Value); ok && ident.Binding.Decl == nil {
+		return fn.String()
+	}
+	return ""
+}
+
+// symbols returns the symbols declared by stms, bound is the token after
+// the last statement.
+func (src *source) symbols(stms []ast.Statement, bound token.Token) []DocumentSymbol {
+	syms := []DocumentSymbol{}
+	for idx, stm := range stms {
+		end := bound
+		if idx+1 < len(stms) {
+			end = ast.Start(stms[idx+1])
+		}
+
+		if let, ok := stm.(*ast.LetStatement); ok && let.Pattern != nil {
+			// note: a destructuring let declares a symbol per variable
+			for _, name := range let.Names() {
+				syms = append(syms, DocumentSymbol{
+					Name:           name.Value,
+					Detail:         src.typeOf(name),
+					Kind:           symbolVariable,
+					Range:          src.span(let, end),
+					SelectionRange: src.tokenRange(name.Token),
+				})
+			}
+		} else if ok {
+			sym := DocumentSymbol{
+				Name:           let.Name.Value,
+				Detail:         src.typeOf(let.Name),
+				Kind:           symbolVariable,
+				Range:          src.span(let, end),
+				SelectionRange: src.tokenRange(let.Name.Token),
+			}
+			if fn, ok := let.Value.(*ast.FunctionLiteral); ok {
+				sym.Kind = symbolFunction
+				sym.Children = src.functionSymbols(fn)
+			}
+			syms = append(syms, sym)
+		}
+		if fs, ok := stm.(*ast.FunctionStatement); ok {
+			syms = append(syms, DocumentSymbol{
+				Name:           fs.Name.Value,
+				Detail:         src.typeOf(fs.Name),
+				Kind:           symbolFunction,
+				Range:          src.span(fs, end),
+				SelectionRange: src.tokenRange(fs.Name.Token),
+				Children:       src.functionSymbols(fs.Function),
+			})
+		}
+
+		// note: blocks don't introduce scopes, their lets belong to the
+		// enclosing function
+		ast.Inspect(stm, func(n ast.Node) bool {
+			switch n := n.(type) {
+			case *ast.FunctionLiteral:
+				return false
+			case *ast.BlockStatement:
+				syms = append(syms, src.symbols(n.Statements, n.End)...)
+				return false
+			}
+			return true
+		})
+	}
+	return syms
+}
+
+func (src *source) functionSymbols(fn *ast.FunctionLiteral) []DocumentSymbol {
+	syms := []DocumentSymbol{}
+	for _, param := range fn.Names() {
+		rng := src.tokenRange(param.Token)
+		syms = append(syms, DocumentSymbol{
+			Name:           param.Value,
+			Detail:         src.typeOf(param),
+			Kind:           symbolVariable,
+			Range:          rng,
+			SelectionRange: rng,
+		})
+	}
+	return append(syms, src.symbols(fn.Body.Statements, fn.Body.End)...)
+}
+
+// completions returns the variables visible at pos, the builtins and the
+// keywords.
+func (src *source) completions(pos Position) []CompletionItem {
+	line, col := src.location(pos)
+	at := token.Token{Line: line, Column: col}
+
+	idents := declared(src.prog.Statements)
+	ast.Inspect(src.prog, func(n ast.Node) bool {
+		fn, ok := n.(*ast.FunctionLiteral)
+		if !ok {
+			return true
+		}
+		if at.Before(fn.Token) || fn.Body.End.Before(at) {
+			return false
+		}
+		idents = append(idents, fn.Names()...)
+		idents = append(idents, declared(fn.Body.Statements)...)
+		return true
+	})
+
+	items := []CompletionItem{}
+	seen := map[string]bool{}
+	for _, ident := range idents {
+		if seen[ident.Value] {
+			continue
+		}
+		seen[ident.Value] = true
+
+		item := CompletionItem{Label: ident.Value, Kind: completionVariable, Detail: src.typeOf(ident)}
+		if _, ok := src.info.Types[ident].(*types.Function); ok {
+			item.Kind = completionFunction
+		}
+		items = append(items, item)
+	}
+	sort.Slice(items, func(i, j int) bool { return items[i].Label < items[j].Label })
+
+	for _, name := range evaluator.BuiltinNames() {
+		if !seen[name] {
+			fn, _ := types.Builtin(name)
+			items = append(items, CompletionItem{Label: name, Kind: completionFunction, Detail: fn.String()})
+		}
+	}
+	for _, word := range token.Keywords() {
+		items = append(items, CompletionItem{Label: word, Kind: completionKeyword})
+	}
+	return items
+}
+
+// declared returns the names bound by the lets, the named functions and the
+// match arms in stms, nested functions excluded.
+func declared(stms []ast.Statement) []*ast.Identifier {
+	idents := []*ast.Identifier{}
+	for _, stm := range stms {
+		ast.Inspect(stm, func(n ast.Node) bool {
+			switch n := n.(type) {
+			case *ast.LetStatement:
+				idents = append(idents, n.Names()...)
+			case *ast.FunctionStatement:
+				idents = append(idents, n.Name)
+			case *ast.MatchExpression:
+				for _, arm := range n.Arms {
+					idents = append(idents, ast.Names(arm.Pattern)...)
+				}
+			case *ast.FunctionLiteral:
+				return false
+			}
+			return true
+		})
+	}
+	return idents
+}
+
+// whole returns the range of the whole text.
+func whole(text string) Range {
+	lines := strings.Split(text, "\n")
+	return Range{End: Position{Line: len(lines) - 1, Character: utf16Len(lines[len(lines)-1])}}
+}
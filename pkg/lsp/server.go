@@ -0,0 +1,298 @@
+// Package lsp implements a Language Server Protocol server for Cube, giving
+// editors diagnostics, hovers, go-to-definition, document symbols,
+// completion and formatting.
+package lsp
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+
+	"github.com/AzraelSec/cube/pkg/evaluator"
+	"github.com/AzraelSec/cube/pkg/format"
+	"github.com/AzraelSec/cube/pkg/framing"
+)
+
+type server struct {
+	out      *framing.Writer
+	logger   *log.Logger
+	docs     map[string]*document
+	ready    bool // whether initialize has been received
+	shutdown bool
+	exitCode int
+	exited   bool
+}
+
+// Serve speaks the protocol over in and out until the client asks the server
+// to exit or closes in, returning the exit code the process should use.
+// Problems with the connection are logged to logs.
+func Serve(in io.Reader, out io.Writer, logs io.Writer) int {
+	s := &server{
+		out:    framing.NewWriter(out),
+		logger: log.New(logs, "cube lsp: ", 0),
+		docs:   map[string]*document{},
+	}
+
+	r := framing.NewReader(in)
+	for !s.exited {
+		payload, err := r.Read()
+		if err != nil {
+			if err != io.EOF {
+				s.logger.Println(err)
+			}
+			break
+		}
+		s.handle(payload)
+	}
+
+	if s.exited {
+		return s.exitCode
+	}
+	// note: a client going away without asking is an error too
+	if s.shutdown {
+		return 0
+	}
+	return 1
+}
+
+func (s *server) handle(payload []byte) {
+	var msg message
+	if err := json.Unmarshal(payload, &msg); err != nil {
+		s.reply(json.RawMessage("null"), nil, &responseError{Code: codeParseError, Message: err.Error()})
+		return
+	}
+
+	// note: notifications don't get answers, not even errors
+	if msg.ID == nil {
+		if err := s.notify(msg); err != nil {
+			s.logger.Printf("%s: %v", msg.Method, err)
+		}
+		return
+	}
+
+	result, rerr := s.request(msg)
+	s.reply(msg.ID, result, rerr)
+}
+
+func (s *server) reply(id json.RawMessage, result interface{}, rerr *responseError) {
+	resp := response{JSONRPC: "2.0", ID: id, Error: rerr}
+	if rerr == nil {
+		encoded, err := json.Marshal(result)
+		if err != nil {
+			resp.Error = &responseError{Code: codeInvalidRequest, Message: err.Error()}
+		}
+		resp.Result = encoded
+	}
+
+	if err := s.out.Write(resp); err != nil {
+		s.logger.Println(err)
+	}
+}
+
+func (s *server) request(msg message) (interface{}, *responseError) {
+	switch {
+	case msg.Method == "initialize":
+		s.ready = true
+		return InitializeResult{
+			Capabilities: ServerCapabilities{
+				TextDocumentSync:           syncFull,
+				HoverProvider:              true,
+				DefinitionProvider:         true,
+				DocumentSymbolProvider:     true,
+				DocumentFormattingProvider: true,
+			},
+			ServerInfo: ServerInfo{Name: "cube"},
+		}, nil
+	case !s.ready:
+		return nil, &responseError{Code: codeServerNotInitialized, Message: "the server is not initialized"}
+	case s.shutdown:
+		return nil, &responseError{Code: codeInvalidRequest, Message: "the server is shutting down"}
+	case msg.Method == "shutdown":
+		s.shutdown = true
+		return nil, nil
+	}
+
+	var handler func(json.RawMessage) (interface{}, error)
+	switch msg.Method {
+	case "textDocument/hover":
+		handler = s.hover
+	case "textDocument/definition":
+		handler = s.definition
+	case "textDocument/documentSymbol":
+		handler = s.documentSymbol
+	case "textDocument/completion":
+		handler = s.completion
+	case "textDocument/formatting":
+		handler = s.formatting
+	default:
+		return nil, &responseError{Code: codeMethodNotFound, Message: "unknown method " + msg.Method}
+	}
+
+	result, err := handler(msg.Params)
+	if err != nil {
+		return nil, &responseError{Code: codeInvalidParams, Message: err.Error()}
+	}
+	return result, nil
+}
+
+func (s *server) notify(msg message) error {
+	switch msg.Method {
+	case "exit":
+		s.exited = true
+		if !s.shutdown {
+			s.exitCode = 1
+		}
+	case "textDocument/didOpen":
+		var params DidOpenTextDocumentParams
+		if err := json.Unmarshal(msg.Params, &params); err != nil {
+			return err
+		}
+		s.update(params.TextDocument.URI, params.TextDocument.Text)
+	case "textDocument/didChange":
+		var params DidChangeTextDocumentParams
+		if err := json.Unmarshal(msg.Params, &params); err != nil {
+			return err
+		}
+		if len(params.ContentChanges) == 0 {
+			return nil
+		}
+		// note: with full sync the last change holds the whole text
+		s.update(params.TextDocument.URI, params.ContentChanges[len(params.ContentChanges)-1].Text)
+	case "textDocument/didClose":
+		var params DidCloseTextDocumentParams
+		if err := json.Unmarshal(msg.Params, &params); err != nil {
+			return err
+		}
+		delete(s.docs, params.TextDocument.URI)
+		return s.publish(params.TextDocument.URI, []Diagnostic{})
+	}
+	// note: the other notifications, e.g. initialized, need no action
+	return nil
+}
+
+func (s *server) update(uri, text string) {
+	doc := open(uri, text, s.docs[uri])
+	s.docs[uri] = doc
+	if err := s.publish(uri, doc.diagnostics); err != nil {
+		s.logger.Println(err)
+	}
+}
+
+func (s *server) publish(uri string, diagnostics []Diagnostic) error {
+	return s.out.Write(notification{
+		JSONRPC: "2.0",
+		Method:  "textDocument/publishDiagnostics",
+		Params:  PublishDiagnosticsParams{URI: uri, Diagnostics: diagnostics},
+	})
+}
+
+func (s *server) document(uri string) (*document, error) {
+	doc, ok := s.docs[uri]
+	if !ok {
+		return nil, fmt.Errorf("unknown document %s", uri)
+	}
+	return doc, nil
+}
+
+// parsed decodes the params of a request about a position in a document,
+// returning the last version of the document that parsed, nil if none did.
+func (s *server) parsed(raw json.RawMessage) (*source, TextDocumentPositionParams, error) {
+	var params TextDocumentPositionParams
+	if err := json.Unmarshal(raw, &params); err != nil {
+		return nil, params, err
+	}
+	doc, err := s.document(params.TextDocument.URI)
+	if err != nil {
+		return nil, params, err
+	}
+	return doc.parsed, params, nil
+}
+
+func (s *server) hover(raw json.RawMessage) (interface{}, error) {
+	src, params, err := s.parsed(raw)
+	if err != nil || src == nil {
+		return nil, err
+	}
+
+	ident := src.identifierAt(params.Position)
+	if ident == nil {
+		return nil, nil
+	}
+	typ := src.typeOf(ident)
+	if typ == "" {
+		return nil, nil
+	}
+
+	text := "```cube\n" + ident.Value + ": " + typ + "\n```"
+	if b, ok := evaluator.LookupBuiltin(ident.Value); ok && ident.Binding.Decl == nil {
+		text = "```cube\nbuiltin " + b.Signature() + "\n```\n" + b.Doc
+	}
+	return Hover{
+		Contents: MarkupContent{Kind: "markdown", Value: text},
+		Range:    src.tokenRange(ident.Token),
+	}, nil
+}
+
+func (s *server) definition(raw json.RawMessage) (interface{}, error) {
+	src, params, err := s.parsed(raw)
+	if err != nil || src == nil {
+		return nil, err
+	}
+
+	ident := src.identifierAt(params.Position)
+	if ident == nil || ident.Binding.Decl == nil {
+		return nil, nil
+	}
+	return Location{URI: params.TextDocument.URI, Range: src.tokenRange(ident.Binding.Decl.Token)}, nil
+}
+
+func (s *server) documentSymbol(raw json.RawMessage) (interface{}, error) {
+	var params DocumentSymbolParams
+	if err := json.Unmarshal(raw, &params); err != nil {
+		return nil, err
+	}
+	doc, err := s.document(params.TextDocument.URI)
+	if err != nil {
+		return nil, err
+	}
+
+	src := doc.parsed
+	if src == nil {
+		return []DocumentSymbol{}, nil
+	}
+	return src.symbols(src.prog.Statements, src.tokens[len(src.tokens)-1]), nil
+}
+
+func (s *server) completion(raw json.RawMessage) (interface{}, error) {
+	src, params, err := s.parsed(raw)
+	if err != nil {
+		return nil, err
+	}
+	if src == nil {
+		// note: only the builtins and the keywords are left
+		src = open("", "", nil).parsed
+	}
+	return src.completions(params.Position), nil
+}
+
+func (s *server) formatting(raw json.RawMessage) (interface{}, error) {
+	var params DocumentFormattingParams
+	if err := json.Unmarshal(raw, &params); err != nil {
+		return nil, err
+	}
+	doc, err := s.document(params.TextDocument.URI)
+	if err != nil {
+		return nil, err
+	}
+
+	formatted, err := format.Source(doc.text)
+	if err != nil {
+		// note: the diagnostics already tell what's wrong
+		return nil, nil
+	}
+	if formatted == doc.text {
+		return []TextEdit{}, nil
+	}
+	return []TextEdit{{Range: whole(doc.text), NewText: formatted}}, nil
+}
@@ -0,0 +1,426 @@
+package lsp
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/AzraelSec/cube/pkg/framing"
+)
+
+const uri = "file:///test.cb"
+
+type reply struct {
+	ID     json.RawMessage `json:"id"`
+	Method string          `json:"method"`
+	Result json.RawMessage `json:"result"`
+	Error  json.RawMessage `json:"error"`
+	Params json.RawMessage `json:"params"`
+}
+
+func TestLifecycle(t *testing.T) {
+	replies, code := run(t,
+		`{"jsonrpc":"2.0","id":1,"method":"textDocument/hover","params":{}}`,
+		`{"jsonrpc":"2.0","id":2,"method":"initialize","params":{"capabilities":{}}}`,
+		`{"jsonrpc":"2.0","method":"initialized","params":{}}`,
+		`{"jsonrpc":"2.0","id":3,"method":"workspace/symbol","params":{}}`,
+		`{"jsonrpc":"2.0","method":"$/cancelRequest","params":{"id":3}}`,
+		`{"jsonrpc":"2.0","id":4,"method":"shutdown"}`,
+		`{"jsonrpc":"2.0","id":5,"method":"textDocument/hover","params":{}}`,
+		`{"jsonrpc":"2.0","method":"exit"}`,
+		`{"jsonrpc":"2.0","id":6,"method":"shutdown"}`,
+	)
+
+	expected := []string{
+		`{"jsonrpc":"2.0","id":1,"error":{"code":-32002,"message":"the server is not initialized"}}`,
+		`{"jsonrpc":"2.0","id":2,"result":{"capabilities":{"textDocumentSync":1,"hoverProvider":true,"definitionProvider":true,"documentSymbolProvider":true,"completionProvider":{},"documentFormattingProvider":true},"serverInfo":{"name":"cube"}}}`,
+		`{"jsonrpc":"2.0","id":3,"error":{"code":-32601,"message":"unknown method workspace/symbol"}}`,
+		`{"jsonrpc":"2.0","id":4,"result":null}`,
+		`{"jsonrpc":"2.0","id":5,"error":{"code":-32600,"message":"the server is shutting down"}}`,
+	}
+	compare(t, replies, expected)
+	if code != 0 {
+		t.Errorf("wrong exit code. got=%d, want=0", code)
+	}
+}
+
+func TestExitCode(t *testing.T) {
+	tests := []struct {
+		script   []string
+		expected int
+	}{
+		{[]string{`{"jsonrpc":"2.0","method":"exit"}`}, 1},
+		{[]string{`{"jsonrpc":"2.0","id":1,"method":"shutdown"}`}, 1},
+		{[]string{`{"jsonrpc":"2.0","id":1,"method":"initialize","params":{}}`}, 1},
+		{[]string{`{"jsonrpc":"2.0","id":1,"method":"initialize","params":{}}`, `{"jsonrpc":"2.0","id":2,"method":"shutdown"}`}, 0},
+	}
+
+	for _, tt := range tests {
+		if _, code := run(t, tt.script...); code != tt.expected {
+			t.Errorf("%q: wrong exit code. got=%d, want=%d", tt.script, code, tt.expected)
+		}
+	}
+}
+
+func TestMalformedMessage(t *testing.T) {
+	replies, _ := run(t, `{"jsonrpc":`)
+	if len(replies) != 1 || !strings.Contains(string(replies[0].Error), `"code":-32700`) {
+		t.Errorf("wrong replies. got=%s", encode(replies))
+	}
+}
+
+func TestDiagnostics(t *testing.T) {
+	replies, _ := run(t,
+		initialize,
+		didOpen("let a = ;\nlet b = 1"),
+		didChange("let a = 1;\nlet b = a + c;"),
+		didChange("let a = 1;\nlet b: string = a + \"x\";"),
+		didChange("let s = \"a\nb\"; s + 1"),
+		didChange("let a = 1;"),
+		didChange("match (1) { _ => 1, 2 => 2 }"),
+		`{"jsonrpc":"2.0","method":"textDocument/didClose","params":{"textDocument":{"uri":"`+uri+`"}}}`,
+	)
+
+	expected := []string{
+		`[{"range":{"start":{"line":0,"character":8},"end":{"line":0,"character":9}},"severity":1,"source":"cube","message":"no prefix parse function for ;"}]`,
+		`[{"range":{"start":{"line":1,"character":12},"end":{"line":1,"character":13}},"severity":1,"source":"cube","message":"identifier not found: c"}]`,
+		`[{"range":{"start":{"line":1,"character":16},"end":{"line":1,"character":17}},"severity":2,"source":"cube","message":"type mismatch: int + string"}]`,
+		`[{"range":{"start":{"line":1,"character":4},"end":{"line":1,"character":5}},"severity":2,"source":"cube","message":"type mismatch: string + int"}]`,
+		`[]`,
+		`[{"range":{"start":{"line":0,"character":20},"end":{"line":0,"character":21}},"severity":2,"source":"cube","message":"unreachable match arm, 2 is already matched by the arm at 1:13"}]`,
+		`[]`,
+	}
+
+	got := []string{}
+	for _, r := range replies[1:] {
+		if r.Method != "textDocument/publishDiagnostics" {
+			t.Fatalf("unexpected reply %s", encode([]reply{r}))
+		}
+		var params PublishDiagnosticsParams
+		json.Unmarshal(r.Params, &params)
+		if params.URI != uri {
+			t.Errorf("wrong uri. got=%q, want=%q", params.URI, uri)
+		}
+		got = append(got, string(mustMarshal(t, params.Diagnostics)))
+	}
+
+	if len(got) != len(expected) {
+		t.Fatalf("wrong number of notifications. got=%q, want=%q", got, expected)
+	}
+	for idx := range expected {
+		if got[idx] != expected[idx] {
+			t.Errorf("notification %d: wrong diagnostics. got=%s, want=%s", idx, got[idx], expected[idx])
+		}
+	}
+}
+
+func TestTestFileDiagnostics(t *testing.T) {
+	source := `test("a", fn() { assert(true) });`
+	for _, tt := range []struct {
+		uri      string
+		expected string
+	}{
+		{"file:///a_test.cb", `[]`},
+		{"file:///a.cb", `[{"range":{"start":{"line":0,"character":0},"end":{"line":0,"character":4}},"severity":1,"source":"cube","message":"identifier not found: test"}]`},
+	} {
+		replies, _ := run(t,
+			initialize,
+			fmt.Sprintf(`{"jsonrpc":"2.0","method":"textDocument/didOpen","params":{"textDocument":{"uri":%q,"languageId":"cube","version":1,"text":%s}}}`, tt.uri, quote(source)),
+		)
+
+		var params PublishDiagnosticsParams
+		json.Unmarshal(replies[len(replies)-1].Params, &params)
+		if got := string(mustMarshal(t, params.Diagnostics)); got != tt.expected {
+			t.Errorf("%s: wrong diagnostics. got=%s, want=%s", tt.uri, got, tt.expected)
+		}
+	}
+}
+
+func TestHover(t *testing.T) {
+	text := "let xs = [1];\nlet f = fn(a: string, b) { len(a) + b };\nf(\"é\", xs[0]); args"
+
+	tests := []struct {
+		line, character int
+		expected        string
+	}{
+		{0, 4, `{"contents":{"kind":"markdown","value":"` + "```cube\\nxs: [int]\\n```" + `"},"range":{"start":{"line":0,"character":4},"end":{"line":0,"character":6}}}`},
+		// note: right after the identifier
+		{0, 6, `{"contents":{"kind":"markdown","value":"` + "```cube\\nxs: [int]\\n```" + `"},"range":{"start":{"line":0,"character":4},"end":{"line":0,"character":6}}}`},
+		{1, 11, `{"contents":{"kind":"markdown","value":"` + "```cube\\na: string\\n```" + `"},"range":{"start":{"line":1,"character":11},"end":{"line":1,"character":12}}}`},
+		{1, 28, `{"contents":{"kind":"markdown","value":"` + "```cube\\nbuiltin len(value: any): int\\n```\\nReturns the number of bytes of a string, of elements of an array or of pairs of a hash." + `"},"range":{"start":{"line":1,"character":27},"end":{"line":1,"character":30}}}`},
+		{2, 0, `{"contents":{"kind":"markdown","value":"` + "```cube\\nf: fn(string, any): any\\n```" + `"},"range":{"start":{"line":2,"character":0},"end":{"line":2,"character":1}}}`},
+		// note: columns are in UTF-16 code units
+		{2, 7, `{"contents":{"kind":"markdown","value":"` + "```cube\\nxs: [int]\\n```" + `"},"range":{"start":{"line":2,"character":7},"end":{"line":2,"character":9}}}`},
+		{2, 16, `{"contents":{"kind":"markdown","value":"` + "```cube\\nargs: [string]\\n```" + `"},"range":{"start":{"line":2,"character":15},"end":{"line":2,"character":19}}}`},
+		{0, 10, `null`},
+	}
+
+	for _, tt := range tests {
+		got := request(t, text, "textDocument/hover", tt.line, tt.character)
+		if got != tt.expected {
+			t.Errorf("%d:%d: wrong hover. got=%s, want=%s", tt.line, tt.character, got, tt.expected)
+		}
+	}
+}
+
+func TestDefinition(t *testing.T) {
+	text := "let a = 1;\nlet f = fn(x) {\n  let y = x + a;\n  y + len(x)\n};\nlet a = 2;\nfn g([p, q]) { q }"
+
+	tests := []struct {
+		line, character int
+		expected        string
+	}{
+		{2, 14, `{"uri":"` + uri + `","range":{"start":{"line":0,"character":4},"end":{"line":0,"character":5}}}`},
+		{2, 10, `{"uri":"` + uri + `","range":{"start":{"line":1,"character":11},"end":{"line":1,"character":12}}}`},
+		{3, 2, `{"uri":"` + uri + `","range":{"start":{"line":2,"character":6},"end":{"line":2,"character":7}}}`},
+		// note: the first let of a variable is its definition
+		{5, 4, `{"uri":"` + uri + `","range":{"start":{"line":0,"character":4},"end":{"line":0,"character":5}}}`},
+		{3, 7, `null`},
+		{1, 0, `null`},
+		// note: the variables of a destructured parameter, not the parameter
+		{6, 15, `{"uri":"` + uri + `","range":{"start":{"line":6,"character":9},"end":{"line":6,"character":10}}}`},
+		{6, 9, `{"uri":"` + uri + `","range":{"start":{"line":6,"character":9},"end":{"line":6,"character":10}}}`},
+	}
+
+	for _, tt := range tests {
+		got := request(t, text, "textDocument/definition", tt.line, tt.character)
+		if got != tt.expected {
+			t.Errorf("%d:%d: wrong definition. got=%s, want=%s", tt.line, tt.character, got, tt.expected)
+		}
+	}
+}
+
+func TestDocumentSymbol(t *testing.T) {
+	text := "let n = 1;\nlet f = fn(x: int) {\n  if (x > n) { let y = x; }\n  x\n}\nif (true) { let z = \"a\" }\nfn g(): int { 1 }"
+
+	expected := `[` +
+		`{"name":"n","detail":"int","kind":13,"range":{"start":{"line":0,"character":0},"end":{"line":0,"character":10}},"selectionRange":{"start":{"line":0,"character":4},"end":{"line":0,"character":5}}},` +
+		`{"name":"f","detail":"fn(int): int","kind":12,"range":{"start":{"line":1,"character":0},"end":{"line":4,"character":1}},"selectionRange":{"start":{"line":1,"character":4},"end":{"line":1,"character":5}},"children":[` +
+		`{"name":"x","detail":"int","kind":13,"range":{"start":{"line":1,"character":11},"end":{"line":1,"character":12}},"selectionRange":{"start":{"line":1,"character":11},"end":{"line":1,"character":12}}},` +
+		`{"name":"y","detail":"int","kind":13,"range":{"start":{"line":2,"character":15},"end":{"line":2,"character":25}},"selectionRange":{"start":{"line":2,"character":19},"end":{"line":2,"character":20}}}]},` +
+		`{"name":"z","detail":"string","kind":13,"range":{"start":{"line":5,"character":12},"end":{"line":5,"character":23}},"selectionRange":{"start":{"line":5,"character":16},"end":{"line":5,"character":17}}},` +
+		`{"name":"g","detail":"fn(): int","kind":12,"range":{"start":{"line":6,"character":0},"end":{"line":6,"character":17}},"selectionRange":{"start":{"line":6,"character":3},"end":{"line":6,"character":4}}}` +
+		`]`
+
+	got := requestDocument(t, text, "textDocument/documentSymbol")
+	if got != expected {
+		t.Errorf("wrong symbols.\ngot= %s\nwant=%s", got, expected)
+	}
+}
+
+func TestCompletion(t *testing.T) {
+	text := "let n = 1;\nlet f = fn(x) {\n  let y = x;\n  \n};\nlet g = fn(z) { z };\n"
+
+	tests := []struct {
+		line, character int
+		expected        []string
+		unexpected      []string
+	}{
+		{3, 2, []string{"f", "g", "n", "x", "y", "len", "let"}, []string{"z"}},
+		{6, 0, []string{"f", "g", "n", "print", "return"}, []string{"x", "y", "z"}},
+	}
+
+	for _, tt := range tests {
+		var items []CompletionItem
+		json.Unmarshal([]byte(request(t, text, "textDocument/completion", tt.line, tt.character)), &items)
+
+		labels := map[string]CompletionItem{}
+		for _, item := range items {
+			labels[item.Label] = item
+		}
+		for _, label := range tt.expected {
+			if _, ok := labels[label]; !ok {
+				t.Errorf("%d:%d: %s should be completed", tt.line, tt.character, label)
+			}
+		}
+		for _, label := range tt.unexpected {
+			if _, ok := labels[label]; ok {
+				t.Errorf("%d:%d: %s should not be completed", tt.line, tt.character, label)
+			}
+		}
+	}
+
+	items := request(t, "let count = 1;\n", "textDocument/completion", 1, 0)
+	for _, item := range []string{
+		`{"label":"count","kind":6,"detail":"int"}`,
+		`{"label":"len","kind":3,"detail":"fn(any): int"}`,
+		`{"label":"fn","kind":14}`,
+	} {
+		if !strings.Contains(items, item) {
+			t.Errorf("missing completion item %s in %s", item, items)
+		}
+	}
+}
+
+// note: while the user is typing, the last version that parsed is used
+func TestStaleDocument(t *testing.T) {
+	replies, _ := run(t,
+		initialize,
+		didOpen("let count = 1;\n"),
+		didChange("let count = 1;\nlet x = ("),
+		`{"jsonrpc":"2.0","id":2,"method":"textDocument/completion","params":{"textDocument":{"uri":"`+uri+`"},"position":{"line":1,"character":9}}}`,
+		`{"jsonrpc":"2.0","id":3,"method":"textDocument/documentSymbol","params":{"textDocument":{"uri":"`+uri+`"}}}`,
+	)
+
+	if res := string(find(t, replies, "2").Result); !strings.Contains(res, `"label":"count"`) {
+		t.Errorf("count should be completed. got=%s", res)
+	}
+	if res := string(find(t, replies, "3").Result); !strings.Contains(res, `"name":"count"`) {
+		t.Errorf("count should be a symbol. got=%s", res)
+	}
+}
+
+func TestFormatting(t *testing.T) {
+	tests := []struct {
+		text     string
+		expected string
+	}{
+		{"let a=1\nlet b=[1,2];\"é\"", `[{"range":{"start":{"line":0,"character":0},"end":{"line":1,"character":15}},"newText":"let a = 1;\nlet b = [1, 2];\n\"é\";\n"}]`},
+		{"let a = 1;\n", `[]`},
+		{"let a = ;", `null`},
+	}
+
+	for _, tt := range tests {
+		if got := requestDocument(t, tt.text, "textDocument/formatting"); got != tt.expected {
+			t.Errorf("%q: wrong edits. got=%s, want=%s", tt.text, got, tt.expected)
+		}
+	}
+}
+
+func TestUnknownDocument(t *testing.T) {
+	replies, _ := run(t,
+		initialize,
+		`{"jsonrpc":"2.0","id":2,"method":"textDocument/hover","params":{"textDocument":{"uri":"file:///other.cb"},"position":{"line":0,"character":0}}}`,
+	)
+
+	expected := `{"code":-32602,"message":"unknown document file:///other.cb"}`
+	if got := string(find(t, replies, "2").Error); got != expected {
+		t.Errorf("wrong error. got=%s, want=%s", got, expected)
+	}
+}
+
+const initialize = `{"jsonrpc":"2.0","id":1,"method":"initialize","params":{"capabilities":{}}}`
+
+func didOpen(text string) string {
+	return fmt.Sprintf(`{"jsonrpc":"2.0","method":"textDocument/didOpen","params":{"textDocument":{"uri":%q,"languageId":"cube","version":1,"text":%s}}}`, uri, quote(text))
+}
+
+func didChange(text string) string {
+	return fmt.Sprintf(`{"jsonrpc":"2.0","method":"textDocument/didChange","params":{"textDocument":{"uri":%q,"version":2},"contentChanges":[{"text":%s}]}}`, uri, quote(text))
+}
+
+// request opens text and returns the result of a request about a position.
+func request(t *testing.T, text, method string, line, character int) string {
+	t.Helper()
+	replies, _ := run(t,
+		initialize,
+		didOpen(text),
+		fmt.Sprintf(`{"jsonrpc":"2.0","id":2,"method":%q,"params":{"textDocument":{"uri":%q},"position":{"line":%d,"character":%d}}}`, method, uri, line, character),
+	)
+	return string(find(t, replies, "2").Result)
+}
+
+// requestDocument opens text and returns the result of a request about the
+// whole document.
+func requestDocument(t *testing.T, text, method string) string {
+	t.Helper()
+	replies, _ := run(t,
+		initialize,
+		didOpen(text),
+		fmt.Sprintf(`{"jsonrpc":"2.0","id":2,"method":%q,"params":{"textDocument":{"uri":%q}}}`, method, uri),
+	)
+	return string(find(t, replies, "2").Result)
+}
+
+// run plays a session made of the given messages, returning what the server
+// sent back and its exit code.
+func run(t *testing.T, script ...string) ([]reply, int) {
+	t.Helper()
+
+	var in, out, logs bytes.Buffer
+	for _, msg := range script {
+		fmt.Fprintf(&in, "Content-Length: %d\r\n\r\n%s", len(msg), msg)
+	}
+	code := Serve(&in, &out, &logs)
+
+	replies := []reply{}
+	r := framing.NewReader(&out)
+	for {
+		payload, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("invalid output: %v", err)
+		}
+
+		var rep reply
+		if err := json.Unmarshal(payload, &rep); err != nil {
+			t.Fatalf("invalid message %s: %v", payload, err)
+		}
+		replies = append(replies, rep)
+	}
+	return replies, code
+}
+
+func find(t *testing.T, replies []reply, id string) reply {
+	t.Helper()
+	for _, r := range replies {
+		if string(r.ID) == id {
+			return r
+		}
+	}
+	t.Fatalf("no reply to %s in %s", id, encode(replies))
+	return reply{}
+}
+
+func compare(t *testing.T, replies []reply, expected []string) {
+	t.Helper()
+	if len(replies) != len(expected) {
+		t.Fatalf("wrong number of replies. got=%s, want=%q", encode(replies), expected)
+	}
+	for idx, r := range replies {
+		got := encode([]reply{r})
+		if got != expected[idx] {
+			t.Errorf("reply %d: got=%s, want=%s", idx, got, expected[idx])
+		}
+	}
+}
+
+// encode marshals replies back, dropping the fields they don't have.
+func encode(replies []reply) string {
+	msgs := []string{}
+	for _, r := range replies {
+		msg := `{"jsonrpc":"2.0"`
+		for _, field := range []struct {
+			name  string
+			value json.RawMessage
+		}{{"id", r.ID}, {"result", r.Result}, {"error", r.Error}, {"params", r.Params}} {
+			if field.value != nil {
+				msg += fmt.Sprintf(`,%q:%s`, field.name, field.value)
+			}
+		}
+		if r.Method != "" {
+			msg += fmt.Sprintf(`,"method":%q`, r.Method)
+		}
+		msgs = append(msgs, msg+"}")
+	}
+	return strings.Join(msgs, "\n")
+}
+
+func mustMarshal(t *testing.T, v interface{}) []byte {
+	t.Helper()
+	encoded, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return encoded
+}
+
+func quote(s string) string {
+	encoded, _ := json.Marshal(s)
+	return string(encoded)
+}
@@ -1,23 +1,115 @@
 package object
 
+import (
+	"io"
+	"os"
+	"sort"
+
+	"github.com/AzraelSec/cube/pkg/ast"
+)
+
+// Environment holds the variables of the program. The outermost one maps
+// global names to their values, while the frames of function calls, and the
+// scopes of match arms, store their local variables in slots, as laid out by
+// the resolver.
 type Environment struct {
-	store map[string]Object
-	outer *Environment
+	store   map[string]Object
+	slots   []Object
+	outer   *Environment
+	fn      *Function     // the function called, for frames
+	arm     *ast.MatchArm // the arm evaluated, for the scopes of match arms
+	runtime *Runtime
 }
 
+// Runtime holds the settings of an evaluation. It's shared by the global
+// environment and the frames of the calls made from it, so that programs
+// evaluated in different environments don't affect each other.
+type Runtime struct {
+	// Strict disables implicit conversions. When it's off, `+` between a
+	// string and any other value concatenates the string with the Inspect
+	// form of the other operand.
+	Strict bool
+	// Stdout and Stdin are the streams of the I/O builtins, tools running
+	// programs can redirect them.
+	Stdout io.Writer
+	Stdin  io.Reader
+	// Hook, when not nil, is notified of the progress of the evaluation.
+	Hook Hook
+}
+
+// Hook is notified by the evaluator of the progress of the evaluation,
+// letting tools like the debugger follow it.
+type Hook interface {
+	// Statement is called before evaluating each statement of a program or
+	// block. A non nil result interrupts the evaluation, which returns it.
+	Statement(stm ast.Statement, env *Environment) Object
+	// Call is called when a function is called, env being the frame of the
+	// call, and Return after it returns. call is nil for the functions called
+	// by builtins.
+	Call(call *ast.CallExpression, fn *Function, env *Environment)
+	Return(call *ast.CallExpression, result Object)
+	// Branch is called once the condition of an if is evaluated, taken
+	// telling whether the consequence is.
+	Branch(ie *ast.IfExpression, taken bool)
+}
+
+// NewEnvironment returns a global environment with the default settings.
 func NewEnvironment() *Environment {
 	return &Environment{
-		store: make(map[string]Object),
-		outer: nil,
+		store:   make(map[string]Object),
+		outer:   nil,
+		runtime: &Runtime{Strict: true, Stdout: os.Stdout, Stdin: os.Stdin},
 	}
 }
 
+// NewEnclosedEnvironment returns an environment for globals of its own,
+// which sees the ones of outer and shares its settings.
 func NewEnclosedEnvironment(outer *Environment) *Environment {
-	env := NewEnvironment()
-	env.outer = outer
-	return env
+	return &Environment{store: make(map[string]Object), outer: outer, runtime: outer.runtime}
+}
+
+// NewFrame returns the environment for a call to fn, enclosed by the one of
+// the function definition.
+func NewFrame(fn *Function) *Environment {
+	return &Environment{slots: make([]Object, fn.Slots), outer: fn.Env, fn: fn, runtime: fn.Env.runtime}
+}
+
+// NewScope returns the environment holding the variables of arm, while it's
+// matched and evaluated in outer.
+func NewScope(outer *Environment, arm *ast.MatchArm) *Environment {
+	return &Environment{slots: make([]Object, arm.Slots), outer: outer, arm: arm, runtime: outer.runtime}
+}
+
+// Runtime returns the settings of the evaluations run in e, which can be
+// changed through it.
+func (e *Environment) Runtime() *Runtime {
+	return e.runtime
+}
+
+// Outer returns the enclosing environment, nil for the global one.
+func (e *Environment) Outer() *Environment {
+	return e.outer
+}
+
+// Function returns the function whose call e is the frame of, nil for the
+// global environment.
+func (e *Environment) Function() *Function {
+	return e.fn
 }
 
+// Arm returns the match arm whose scope e is, nil for the other
+// environments.
+func (e *Environment) Arm() *ast.MatchArm {
+	return e.arm
+}
+
+// Locals returns the slots of a frame or of a scope, the ones of the variables whose let
+// wasn't evaluated yet are nil.
+func (e *Environment) Locals() []Object {
+	return e.slots
+}
+
+// Get looks up a global variable.
 func (e *Environment) Get(key string) (Object, bool) {
 	obj, ok := e.store[key]
 	if !ok && e.outer != nil {
@@ -26,7 +118,47 @@ func (e *Environment) Get(key string) (Object, bool) {
 	return obj, ok
 }
 
+// Set binds a global variable.
 func (e *Environment) Set(key string, val Object) Object {
+	if e.store == nil {
+		return e.outer.Set(key, val)
+	}
 	e.store[key] = val
 	return val
 }
+
+// GetSlot returns the local variable in slot of the frame depth levels up,
+// which is nil if it wasn't set yet.
+func (e *Environment) GetSlot(depth, slot int) Object {
+	return e.frame(depth).slots[slot]
+}
+
+func (e *Environment) SetSlot(depth, slot int, val Object) Object {
+	e.frame(depth).slots[slot] = val
+	return val
+}
+
+func (e *Environment) frame(depth int) *Environment {
+	env := e
+	for ; depth > 0; depth-- {
+		env = env.outer
+	}
+	return env
+}
+
+// Names returns every name visible from this environment, including the
+// ones of the enclosing environments, sorted and without duplicates.
+func (e *Environment) Names() []string {
+	seen := make(map[string]bool)
+	names := []string{}
+	for env := e; env != nil; env = env.outer {
+		for name := range env.store {
+			if !seen[name] {
+				seen[name] = true
+				names = append(names, name)
+			}
+		}
+	}
+	sort.Strings(names)
+	return names
+}
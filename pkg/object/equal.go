@@ -0,0 +1,54 @@
+package object
+
+// Equal reports whether a and b are the same value. Scalars are compared by
+// value, arrays and hashes structurally (hashes regardless of insertion
+// order) and functions by identity.
+func Equal(a, b Object) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	if a.Type() != b.Type() {
+		return false
+	}
+
+	switch a := a.(type) {
+	case *Integer:
+		return a.Value == b.(*Integer).Value
+	case *String:
+		return a.Value == b.(*String).Value
+	case *Boolean:
+		return a.Value == b.(*Boolean).Value
+	case *Null:
+		return true
+	case *Error:
+		return a.Msg == b.(*Error).Msg
+	case *ReturnValue:
+		return Equal(a.Value, b.(*ReturnValue).Value)
+	case *Array:
+		b := b.(*Array)
+		if len(a.Elements) != len(b.Elements) {
+			return false
+		}
+		for idx := range a.Elements {
+			if !Equal(a.Elements[idx], b.Elements[idx]) {
+				return false
+			}
+		}
+		return true
+	case *Hash:
+		b := b.(*Hash)
+		if a.Len() != b.Len() {
+			return false
+		}
+		for _, pair := range a.Pairs() {
+			val, ok := b.Get(pair.Key)
+			if !ok || !Equal(pair.Value, val) {
+				return false
+			}
+		}
+		return true
+	default:
+		// note: functions, builtins and any other reference-like value
+		return a == b
+	}
+}
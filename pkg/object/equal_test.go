@@ -0,0 +1,47 @@
+package object
+
+import "testing"
+
+func TestEqual(t *testing.T) {
+	fn := &Function{}
+	hash := func(pairs ...Object) *Hash {
+		h := &Hash{}
+		for i := 0; i < len(pairs); i += 2 {
+			h.Set(pairs[i], pairs[i+1])
+		}
+		return h
+	}
+	arr := func(elems ...Object) *Array {
+		return &Array{Elements: elems}
+	}
+	one, two := &Integer{Value: 1}, &Integer{Value: 2}
+	a, b := &String{Value: "a"}, &String{Value: "b"}
+
+	tests := []struct {
+		left, right Object
+		expected    bool
+	}{
+		{one, &Integer{Value: 1}, true},
+		{one, two, false},
+		{a, &String{Value: "a"}, true},
+		{a, b, false},
+		{&Boolean{Value: true}, &Boolean{Value: true}, true},
+		{&Null{}, &Null{}, true},
+		{&Null{}, &Boolean{Value: false}, false},
+		{one, &String{Value: "1"}, false},
+		{arr(one, arr(a)), arr(&Integer{Value: 1}, arr(&String{Value: "a"})), true},
+		{arr(one, two), arr(two, one), false},
+		{arr(one), arr(one, one), false},
+		{hash(a, one, b, two), hash(b, two, a, one), true},
+		{hash(a, one), hash(a, two), false},
+		{hash(a, one), hash(b, one), false},
+		{hash(a, arr(one)), hash(a, arr(one)), true},
+		{fn, fn, true},
+		{fn, &Function{}, false},
+	}
+	for _, tt := range tests {
+		if got := Equal(tt.left, tt.right); got != tt.expected {
+			t.Errorf("Equal(%s, %s) wrong. got=%t, want=%t", tt.left.Inspect(), tt.right.Inspect(), got, tt.expected)
+		}
+	}
+}
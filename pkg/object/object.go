@@ -2,6 +2,7 @@ package object
 
 import (
 	"bytes"
+	"encoding/binary"
 	"fmt"
 	"hash/fnv"
 	"strings"
@@ -10,7 +11,10 @@ import (
 )
 
 type ObjectType string
-type BuiltinFunction func(...Object) Object
+
+// BuiltinFunction implements a builtin, env being the environment of the
+// call, whose runtime holds the streams to use.
+type BuiltinFunction func(env *Environment, args ...Object) Object
 
 const (
 	INTEGER_OBJ      = "INTEGER"
@@ -23,6 +27,7 @@ const (
 	BUILTIN_OBJ      = "BUILTIN"
 	ARRAY_OBJ        = "ARRAY"
 	HASH_OBJ         = "HASH"
+	EXIT_OBJ         = "EXIT"
 )
 
 type Object interface {
@@ -40,6 +45,31 @@ type Hashable interface {
 	HashKey() HashKey
 }
 
+// HashKeyOf computes the hash key of any value that can be used as a hash
+// key: Hashable scalars and arrays made only of hashable values. Arrays are
+// never mutated in place, so they work as composite (tuple-like) keys.
+func HashKeyOf(obj Object) (HashKey, bool) {
+	switch obj := obj.(type) {
+	case Hashable:
+		return obj.HashKey(), true
+	case *Array:
+		h := fnv.New64a()
+		buff := make([]byte, 8)
+		for _, elem := range obj.Elements {
+			hk, ok := HashKeyOf(elem)
+			if !ok {
+				return HashKey{}, false
+			}
+			binary.LittleEndian.PutUint64(buff, hk.Value)
+			h.Write([]byte(hk.Type))
+			h.Write(buff)
+		}
+		return HashKey{Type: obj.Type(), Value: h.Sum64()}, true
+	default:
+		return HashKey{}, false
+	}
+}
+
 type Integer struct {
 	Value int64
 }
@@ -95,36 +125,130 @@ type Error struct {
 func (*Error) Type() ObjectType  { return ERROR_OBJ }
 func (e *Error) Inspect() string { return fmt.Sprintf("Error: %s", e.Msg) }
 
+// Exit is the result of a call to the `exit` builtin: it unwinds the whole
+// evaluation and is handed back to the embedder as the result of Eval.
+type Exit struct {
+	Code int64
+}
+
+func (*Exit) Type() ObjectType  { return EXIT_OBJ }
+func (e *Exit) Inspect() string { return fmt.Sprintf("exit(%d)", e.Code) }
+
 type Function struct {
+	Name       string // empty for anonymous functions
 	Parameters []*ast.Identifier
+	Defaults   []ast.Expression // one per parameter, nil if it's required
+	Patterns   []ast.Pattern    // one per parameter, nil unless it's destructured
+	Rest       bool             // whether the last parameter collects the remaining arguments
 	Body       *ast.BlockStatement
+	Slots      int // size of the frame of a call
 	Env        *Environment
+	Doc        string
 }
 
 func (*Function) Type() ObjectType { return FUNCTION_OBJ }
 func (f *Function) Inspect() string {
-	var buff bytes.Buffer
+	params := make([]string, len(f.Parameters))
+	for idx, p := range f.Parameters {
+		params[idx] = p.Value
+		if f.Rest && idx == len(f.Parameters)-1 {
+			params[idx] = "..." + p.Value
+		}
+		if idx < len(f.Defaults) && f.Defaults[idx] != nil {
+			params[idx] += " = " + f.Defaults[idx].String()
+		}
+	}
+	if f.Name == "" {
+		return "fn(" + strings.Join(params, ", ") + ")"
+	}
+	return "fn " + f.Name + "(" + strings.Join(params, ", ") + ")"
+}
 
-	params := []string{}
-	for _, p := range f.Parameters {
-		params = append(params, p.String())
+// Label returns the name of the function to be shown in messages.
+func (f *Function) Label() string {
+	if f.Name == "" {
+		return "anonymous function"
 	}
+	return f.Name
+}
 
-	buff.WriteString("fn(")
-	buff.WriteString(strings.Join(params, ", "))
-	buff.WriteString(") {\n")
-	buff.WriteString(f.Body.String())
-	buff.WriteString("\n}")
+// Required returns the number of arguments a call must pass.
+func (f *Function) Required() int {
+	required := 0
+	for idx := range f.Parameters {
+		if (idx >= len(f.Defaults) || f.Defaults[idx] == nil) && !(f.Rest && idx == len(f.Parameters)-1) {
+			required++
+		}
+	}
+	return required
+}
 
-	return buff.String()
+// Arity describes the number of arguments the function accepts.
+func (f *Function) Arity() string {
+	if f.Rest {
+		return DescribeArity(f.Required(), -1)
+	}
+	return DescribeArity(f.Required(), len(f.Parameters))
 }
 
+// Builtin is a function implemented by the interpreter, described well
+// enough for its calls to be validated and for it to be documented.
 type Builtin struct {
-	Fn BuiltinFunction
+	Name string
+	Fn   BuiltinFunction
+	// note: the accepted number of arguments, MaxArgs < 0 standing for any
+	MinArgs, MaxArgs int
+	// Params are the parameters, the ones past MinArgs being optional and
+	// the last one taking the remaining arguments when MaxArgs < 0.
+	Params []Param
+	Result string // type annotation of the result
+	Doc    string
 }
 
-func (*Builtin) Type() ObjectType { return BUILTIN_OBJ }
-func (*Builtin) Inspect() string  { return "builtin function" }
+// Param is a parameter of a builtin, with its type annotation.
+type Param struct {
+	Name, Type string
+}
+
+func (*Builtin) Type() ObjectType  { return BUILTIN_OBJ }
+func (b *Builtin) Inspect() string { return "builtin " + b.Signature() }
+
+// Signature returns the name, parameters and result of the builtin, like
+// `exit([code: int]): never`.
+func (b *Builtin) Signature() string {
+	params := make([]string, len(b.Params))
+	for idx, p := range b.Params {
+		params[idx] = p.Name + ": " + p.Type
+		switch {
+		case b.MaxArgs < 0 && idx == len(b.Params)-1:
+			params[idx] = "..." + params[idx]
+		case idx >= b.MinArgs:
+			params[idx] = "[" + params[idx] + "]"
+		}
+	}
+	return b.Name + "(" + strings.Join(params, ", ") + "): " + b.Result
+}
+
+// Arity describes the accepted number of arguments, like `1`, `0 or 1` or
+// `at least 2`.
+func (b *Builtin) Arity() string {
+	return DescribeArity(b.MinArgs, b.MaxArgs)
+}
+
+// DescribeArity describes a number of arguments, or elements, between min
+// and max, max < 0 standing for any.
+func DescribeArity(min, max int) string {
+	switch {
+	case max < 0:
+		return fmt.Sprintf("at least %d", min)
+	case min == max:
+		return fmt.Sprintf("%d", min)
+	case min+1 == max:
+		return fmt.Sprintf("%d or %d", min, max)
+	default:
+		return fmt.Sprintf("%d to %d", min, max)
+	}
+}
 
 type Array struct {
 	Elements []Object
@@ -150,17 +274,23 @@ type HashPair struct {
 	Key   Object
 	Value Object
 }
+
+// Hash keeps its pairs in insertion order, so iteration and Inspect are
+// deterministic. Pairs are bucketed by HashKey and keys within a bucket are
+// compared by content, so colliding hash keys never overwrite each other.
+// The zero value is an empty hash ready to use.
 type Hash struct {
-	Pairs map[HashKey]HashPair
+	buckets map[HashKey][]int // indexes into pairs
+	pairs   []HashPair
 }
 
 func (*Hash) Type() ObjectType { return HASH_OBJ }
 func (h *Hash) Inspect() string {
 	var buff bytes.Buffer
 
-	pairs := []string{}
-	for _, pair := range h.Pairs {
-		pairs = append(pairs, fmt.Sprintf("%s: %s", pair.Key.Inspect(), pair.Value.Inspect()))
+	pairs := make([]string, len(h.pairs))
+	for idx, pair := range h.pairs {
+		pairs[idx] = fmt.Sprintf("%s: %s", pair.Key.Inspect(), pair.Value.Inspect())
 	}
 
 	buff.WriteString("{")
@@ -169,3 +299,76 @@ func (h *Hash) Inspect() string {
 
 	return buff.String()
 }
+
+func (h *Hash) lookup(hk HashKey, key Object) (int, bool) {
+	for _, idx := range h.buckets[hk] {
+		if Equal(h.pairs[idx].Key, key) {
+			return idx, true
+		}
+	}
+	return 0, false
+}
+
+// Set binds value to key. Updating an existing key keeps its original
+// position. It reports false, leaving the hash untouched, when key is not
+// hashable.
+func (h *Hash) Set(key, value Object) bool {
+	hk, ok := HashKeyOf(key)
+	if !ok {
+		return false
+	}
+
+	if idx, ok := h.lookup(hk, key); ok {
+		h.pairs[idx] = HashPair{Key: key, Value: value}
+		return true
+	}
+
+	if h.buckets == nil {
+		h.buckets = make(map[HashKey][]int)
+	}
+	h.buckets[hk] = append(h.buckets[hk], len(h.pairs))
+	h.pairs = append(h.pairs, HashPair{Key: key, Value: value})
+	return true
+}
+
+// Get returns the value bound to key and whether it is present at all.
+func (h *Hash) Get(key Object) (Object, bool) {
+	hk, ok := HashKeyOf(key)
+	if !ok {
+		return nil, false
+	}
+
+	idx, ok := h.lookup(hk, key)
+	if !ok {
+		return nil, false
+	}
+	return h.pairs[idx].Value, true
+}
+
+// Delete removes key from the hash and reports whether it was present.
+func (h *Hash) Delete(key Object) bool {
+	hk, ok := HashKeyOf(key)
+	if !ok {
+		return false
+	}
+
+	idx, ok := h.lookup(hk, key)
+	if !ok {
+		return false
+	}
+
+	h.pairs = append(h.pairs[:idx], h.pairs[idx+1:]...)
+
+	// note: every pair after idx shifted, so the buckets are rebuilt
+	h.buckets = make(map[HashKey][]int, len(h.pairs))
+	for i, pair := range h.pairs {
+		phk, _ := HashKeyOf(pair.Key)
+		h.buckets[phk] = append(h.buckets[phk], i)
+	}
+	return true
+}
+
+func (h *Hash) Len() int { return len(h.pairs) }
+
+// Pairs returns the pairs in insertion order. The slice must not be modified.
+func (h *Hash) Pairs() []HashPair { return h.pairs }
@@ -17,3 +17,97 @@ func TestStringHashKey(t *testing.T) {
 		t.Errorf("strings with different content have same hash keys")
 	}
 }
+
+func TestHashInsertionOrder(t *testing.T) {
+	hash := &Hash{}
+	hash.Set(&String{Value: "b"}, &Integer{Value: 1})
+	hash.Set(&String{Value: "a"}, &Integer{Value: 2})
+	hash.Set(&String{Value: "b"}, &Integer{Value: 3})
+
+	if hash.Len() != 2 {
+		t.Fatalf("hash has wrong number of pairs. got=%d", hash.Len())
+	}
+	if hash.Inspect() != "{b: 3, a: 2}" {
+		t.Errorf("hash.Inspect() wrong. got=%q", hash.Inspect())
+	}
+	if _, ok := hash.Get(&String{Value: "c"}); ok {
+		t.Errorf("hash.Get() found a missing key")
+	}
+}
+
+// collidingKey always hashes to the same key, to exercise bucket collisions.
+type collidingKey struct {
+	name string
+}
+
+func (*collidingKey) Type() ObjectType  { return "COLLIDING" }
+func (c *collidingKey) Inspect() string { return c.name }
+func (*collidingKey) HashKey() HashKey  { return HashKey{Type: "COLLIDING", Value: 42} }
+
+func TestHashCollisions(t *testing.T) {
+	a, b := &collidingKey{name: "a"}, &collidingKey{name: "b"}
+	if a.HashKey() != b.HashKey() {
+		t.Fatalf("test keys should collide")
+	}
+
+	hash := &Hash{}
+	hash.Set(a, &Integer{Value: 1})
+	hash.Set(b, &Integer{Value: 2})
+
+	if hash.Len() != 2 {
+		t.Fatalf("colliding keys overwrote each other. got=%d pairs", hash.Len())
+	}
+	for key, expected := range map[Object]int64{a: 1, b: 2} {
+		val, ok := hash.Get(key)
+		if !ok {
+			t.Errorf("no value for key %s", key.Inspect())
+			continue
+		}
+		if val.(*Integer).Value != expected {
+			t.Errorf("wrong value for key %s. got=%d, want=%d", key.Inspect(), val.(*Integer).Value, expected)
+		}
+	}
+
+	if !hash.Delete(a) {
+		t.Fatalf("hash.Delete() did not find key a")
+	}
+	if _, ok := hash.Get(a); ok {
+		t.Errorf("key a still present after delete")
+	}
+	if _, ok := hash.Get(b); !ok {
+		t.Errorf("key b lost after deleting a")
+	}
+}
+
+func TestArrayHashKey(t *testing.T) {
+	arr1 := &Array{Elements: []Object{&Integer{Value: 1}, &String{Value: "a"}}}
+	arr2 := &Array{Elements: []Object{&Integer{Value: 1}, &String{Value: "a"}}}
+	arr3 := &Array{Elements: []Object{&String{Value: "a"}, &Integer{Value: 1}}}
+	nested := &Array{Elements: []Object{arr1, &Boolean{Value: true}}}
+
+	hk1, ok := HashKeyOf(arr1)
+	if !ok {
+		t.Fatalf("array of hashable values is not hashable")
+	}
+	if hk2, _ := HashKeyOf(arr2); hk1 != hk2 {
+		t.Errorf("arrays with same content have different hash keys")
+	}
+	if hk3, _ := HashKeyOf(arr3); hk1 == hk3 {
+		t.Errorf("arrays with different content have same hash keys")
+	}
+	if _, ok := HashKeyOf(nested); !ok {
+		t.Errorf("nested array of hashable values is not hashable")
+	}
+	if _, ok := HashKeyOf(&Array{Elements: []Object{&Null{}}}); ok {
+		t.Errorf("array with a non hashable element is hashable")
+	}
+
+	hash := &Hash{}
+	hash.Set(arr1, &Integer{Value: 1})
+	if _, ok := hash.Get(arr2); !ok {
+		t.Errorf("array key not found by an equal array")
+	}
+	if _, ok := hash.Get(arr3); ok {
+		t.Errorf("array key found by a different array")
+	}
+}
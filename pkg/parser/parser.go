@@ -3,6 +3,7 @@ package parser
 import (
 	"fmt"
 	"strconv"
+	"strings"
 
 	"github.com/AzraelSec/cube/pkg/ast"
 	"github.com/AzraelSec/cube/pkg/lexer"
@@ -41,11 +42,36 @@ type (
 	infixParseFn  func(ast.Expression) ast.Expression
 )
 
+// Error is an error found in the source, with the position of the token it
+// was found at. The resolver and the type checker report theirs as well.
+type Error struct {
+	Line, Column int
+	Msg          string
+}
+
+// String returns the message prefixed by the position, like `1:5: msg`.
+func (e Error) String() string {
+	return fmt.Sprintf("%d:%d: %s", e.Line, e.Column, e.Msg)
+}
+
+// ErrorStrings returns the messages of errs prefixed by their positions.
+func ErrorStrings(errs []Error) []string {
+	msgs := make([]string, len(errs))
+	for idx, err := range errs {
+		msgs[idx] = err.String()
+	}
+	return msgs
+}
+
 type Parser struct {
 	l *lexer.Lexer
 
-	errors []string
+	errors []Error
+	// note: true when the first error was caused by the input ending too early
+	incomplete bool
+	warnings   []Error
 
+	prevToken token.Token
 	currToken token.Token
 	peekToken token.Token
 
@@ -55,6 +81,7 @@ type Parser struct {
 
 // Generic Methods
 func (p *Parser) nextToken() {
+	p.prevToken = p.currToken
 	p.currToken = p.peekToken
 	p.peekToken = p.l.NextToken()
 }
@@ -75,7 +102,47 @@ func (p *Parser) expectPeekIs(t token.TokenType) bool {
 }
 func (p *Parser) peekError(t token.TokenType) {
 	msg := fmt.Sprintf("expected next token to be %s, found %s", t, p.peekToken.Type)
-	p.errors = append(p.errors, msg)
+	p.appendError(p.peekToken, msg, p.peekTokenIs(token.EOF))
+}
+func (p *Parser) appendError(at token.Token, msg string, atEOF bool) {
+	if len(p.errors) == 0 {
+		p.incomplete = atEOF
+	}
+	p.errors = append(p.errors, Error{Line: at.Line, Column: at.Column, Msg: msg})
+}
+func (p *Parser) appendWarning(at token.Token, msg string) {
+	p.warnings = append(p.warnings, Error{Line: at.Line, Column: at.Column, Msg: msg})
+}
+
+// docComment returns the text of the /// comments on the lines right
+// before the current token, with no code in between.
+func (p *Parser) docComment() string {
+	comments := p.l.Comments()
+	idx := len(comments) - 1
+	// note: the lexer is already past the peek token, skip what follows
+	for idx >= 0 && (comments[idx].Line > p.currToken.Line ||
+		comments[idx].Line == p.currToken.Line && comments[idx].Column > p.currToken.Column) {
+		idx--
+	}
+
+	lines := []string{}
+	line := p.currToken.Line - 1
+	for ; idx >= 0; idx-- {
+		c := comments[idx]
+		if c.Line != line || c.Line <= p.prevToken.Line || !isDocComment(c.Literal) {
+			break
+		}
+		text := strings.TrimPrefix(c.Literal, "///")
+		lines = append([]string{strings.TrimPrefix(text, " ")}, lines...)
+		line--
+	}
+	return strings.Join(lines, "\n")
+}
+
+// note: //// starts a regular comment, so that a doc comment can be
+// commented out
+func isDocComment(literal string) bool {
+	return strings.HasPrefix(literal, "///") && !strings.HasPrefix(literal, "////")
 }
 
 // Parsing Statements
@@ -85,18 +152,37 @@ func (p *Parser) parseStatement() ast.Statement {
 		return p.parseLetStatement()
 	case token.RETURN:
 		return p.parseReturnStatement()
+	case token.FUNCTION:
+		if p.peekTokenIs(token.IDENT) {
+			return p.parseFunctionStatement()
+		}
+		return p.parseExpressionStatement()
 	default:
 		return p.parseExpressionStatement()
 	}
 }
 func (p *Parser) parseLetStatement() *ast.LetStatement {
-	stm := &ast.LetStatement{Token: p.currToken}
+	stm := &ast.LetStatement{Token: p.currToken, Doc: p.docComment()}
 
-	if !p.expectPeekIs(token.IDENT) {
-		return nil
+	if p.peekTokenIs(token.LBRACKET) || p.peekTokenIs(token.LBRACE) {
+		p.nextToken()
+		if stm.Pattern = p.parsePattern(false); stm.Pattern == nil {
+			return nil
+		}
+	} else {
+		if !p.expectPeekIs(token.IDENT) {
+			return nil
+		}
+		stm.Name = &ast.Identifier{Token: p.currToken, Value: p.currToken.Literal}
 	}
 
-	stm.Name = &ast.Identifier{Token: p.currToken, Value: p.currToken.Literal}
+	if p.peekTokenIs(token.COLON) {
+		p.nextToken()
+		p.nextToken()
+		if stm.Type = p.parseType(); stm.Type == nil {
+			return nil
+		}
+	}
 
 	if !p.expectPeekIs(token.ASSIGN) {
 		return nil
@@ -104,6 +190,13 @@ func (p *Parser) parseLetStatement() *ast.LetStatement {
 
 	p.nextToken()
 	stm.Value = p.parseExpression(LOWEST)
+	// note: functions bound by a let are named and documented by it
+	if fun, ok := stm.Value.(*ast.FunctionLiteral); ok && stm.Name != nil {
+		fun.Name = stm.Name.Value
+		if fun.Doc == "" {
+			fun.Doc = stm.Doc
+		}
+	}
 
 	if p.peekTokenIs(token.SEMICOLON) {
 		p.nextToken()
@@ -161,7 +254,7 @@ func (p *Parser) parseIntegerLiteral() ast.Expression {
 	v, err := strconv.ParseInt(p.currToken.Literal, 10, 64)
 	if err != nil {
 		msg := fmt.Sprintf("could not parse token %q as integer", p.currToken.Literal)
-		p.errors = append(p.errors, msg)
+		p.appendError(p.currToken, msg, false)
 		return nil
 	}
 
@@ -171,21 +264,66 @@ func (p *Parser) parseIntegerLiteral() ast.Expression {
 func (p *Parser) parseStringLiteral() ast.Expression {
 	return &ast.StringLiteral{Token: p.currToken, Value: p.currToken.Literal}
 }
+func (p *Parser) parseIllegal() ast.Expression {
+	// note: the lexer reports unterminated strings as illegal tokens starting with a quote
+	if strings.HasPrefix(p.currToken.Literal, `"`) {
+		p.appendError(p.currToken, "unterminated string literal", true)
+		return nil
+	}
+
+	p.appendError(p.currToken, fmt.Sprintf("illegal token %q", p.currToken.Literal), false)
+	return nil
+}
 func (p *Parser) parseArrayLiteral() ast.Expression {
 	lit := &ast.ArrayLiteral{Token: p.currToken}
 
 	lit.Elements = p.parseExpressionList(token.RBRACKET)
+	lit.End = p.currToken
 
 	return lit
 }
+func (p *Parser) parseFunctionStatement() ast.Statement {
+	stm := &ast.FunctionStatement{Token: p.currToken}
+	fun := &ast.FunctionLiteral{Token: p.currToken, Doc: p.docComment()}
+
+	p.nextToken()
+	stm.Name = &ast.Identifier{Token: p.currToken, Value: p.currToken.Literal}
+	fun.Name = stm.Name.Value
+
+	if stm.Function = p.parseFunction(fun); stm.Function == nil {
+		return nil
+	}
+	if p.peekTokenIs(token.SEMICOLON) {
+		p.nextToken()
+	}
+	return stm
+}
 func (p *Parser) parseFunctionLiteral() ast.Expression {
-	fun := &ast.FunctionLiteral{Token: p.currToken}
+	fun := p.parseFunction(&ast.FunctionLiteral{Token: p.currToken, Doc: p.docComment()})
+	if fun == nil {
+		return nil
+	}
+	return fun
+}
 
+// parseFunction parses the parameters, result type and body of fun, the
+// opening parenthesis being the next token.
+func (p *Parser) parseFunction(fun *ast.FunctionLiteral) *ast.FunctionLiteral {
 	if !p.expectPeekIs(token.LPAREN) {
 		return nil
 	}
 
-	fun.Parameters = p.parseFunctionParameters()
+	if !p.parseFunctionParameters(fun) {
+		return nil
+	}
+
+	if p.peekTokenIs(token.COLON) {
+		p.nextToken()
+		p.nextToken()
+		if fun.ReturnType = p.parseType(); fun.ReturnType == nil {
+			return nil
+		}
+	}
 
 	if !p.expectPeekIs(token.LBRACE) {
 		return nil
@@ -195,33 +333,407 @@ func (p *Parser) parseFunctionLiteral() ast.Expression {
 
 	return fun
 }
-func (p *Parser) parseFunctionParameters() []*ast.Identifier {
-	ids := []*ast.Identifier{}
+func (p *Parser) parseFunctionParameters(fun *ast.FunctionLiteral) bool {
+	fun.Parameters = []*ast.Identifier{}
+	fun.ParamTypes = []ast.TypeExpression{}
+	fun.Defaults = []ast.Expression{}
+	fun.Patterns = []ast.Pattern{}
 
 	p.nextToken()
 
 	// note: handle declarations of funcion with no params
 	if p.currTokenIs(token.RPAREN) {
-		return ids
+		return true
 	}
 
-	id := &ast.Identifier{Token: p.currToken, Value: p.currToken.Literal}
-	ids = append(ids, id)
+	for {
+		if fun.Rest {
+			last := fun.Parameters[len(fun.Parameters)-1]
+			p.appendError(p.currToken, fmt.Sprintf("rest parameter %s must be the last one", last.Value), false)
+			return false
+		}
+		if p.currTokenIs(token.ELLIPSIS) {
+			fun.Rest = true
+			p.nextToken()
+		}
 
-	for p.peekTokenIs(token.COMMA) {
+		var pattern ast.Pattern
+		switch {
+		case !fun.Rest && (p.currTokenIs(token.LBRACKET) || p.currTokenIs(token.LBRACE)):
+			if pattern = p.parsePattern(false); pattern == nil {
+				return false
+			}
+		case !p.currTokenIs(token.IDENT):
+			p.appendError(p.currToken, fmt.Sprintf("expected a parameter name, found %s", p.currToken.Type), p.currTokenIs(token.EOF))
+			return false
+		}
+		id := &ast.Identifier{Token: p.currToken, Value: p.currToken.Literal}
+		if pattern != nil {
+			id = &ast.Identifier{Token: ast.Start(pattern), Value: pattern.String()}
+		}
+		fun.Parameters = append(fun.Parameters, id)
+		fun.Patterns = append(fun.Patterns, pattern)
+
+		var typ ast.TypeExpression
+		if p.peekTokenIs(token.COLON) {
+			p.nextToken()
+			p.nextToken()
+			if typ = p.parseType(); typ == nil {
+				return false
+			}
+		}
+		fun.ParamTypes = append(fun.ParamTypes, typ)
+
+		var def ast.Expression
+		if p.peekTokenIs(token.ASSIGN) {
+			p.nextToken()
+			if fun.Rest {
+				p.appendError(p.currToken, fmt.Sprintf("rest parameter %s can't have a default value", id.Value), false)
+				return false
+			}
+			p.nextToken()
+			if def = p.parseExpression(LOWEST); def == nil {
+				return false
+			}
+		} else if !fun.Rest && len(fun.Parameters) > 1 && fun.Defaults[len(fun.Defaults)-1] != nil {
+			// note: arguments fill the parameters in order, so the optional ones come last
+			p.appendError(id.Token, fmt.Sprintf("parameter %s without a default value follows one with a default value", id.Value), false)
+			return false
+		}
+		fun.Defaults = append(fun.Defaults, def)
+
+		if !p.peekTokenIs(token.COMMA) {
+			break
+		}
 		// note: skip the comma and position on the next token (next identifier)
 		p.nextToken()
 		p.nextToken()
+	}
 
-		id := &ast.Identifier{Token: p.currToken, Value: p.currToken.Literal}
-		ids = append(ids, id)
+	return p.expectPeekIs(token.RPAREN)
+}
+
+// parsePattern parses the pattern starting at the current token: an
+// identifier, or an array or hash pattern, whose elements can be patterns
+// in turn and have default values. Refutable patterns, the ones of match
+// arms, can also be wildcards, literals and type patterns.
+func (p *Parser) parsePattern(refutable bool) ast.Pattern {
+	switch p.currToken.Type {
+	case token.IDENT:
+		var pattern ast.Pattern = &ast.Identifier{Token: p.currToken, Value: p.currToken.Literal}
+		if !refutable {
+			return pattern
+		}
+		if p.currToken.Literal == "_" {
+			pattern = &ast.WildcardPattern{Token: p.currToken}
+		}
+		if !p.peekTokenIs(token.COLON) {
+			return pattern
+		}
+		p.nextToken()
+		p.nextToken()
+		typ := p.parseType()
+		if typ == nil {
+			return nil
+		}
+		return &ast.TypePattern{Target: pattern, Type: typ}
+	case token.INT, token.STRING, token.TRUE, token.FALSE, token.MINUS:
+		if !refutable {
+			break
+		}
+		pattern := &ast.LiteralPattern{Token: p.currToken}
+		if p.currTokenIs(token.MINUS) && !p.peekTokenIs(token.INT) {
+			p.peekError(token.INT)
+			return nil
+		}
+		if pattern.Value = p.prefixParseFns[p.currToken.Type](); pattern.Value == nil {
+			return nil
+		}
+		return pattern
+	case token.LBRACKET:
+		pattern := &ast.ArrayPattern{Token: p.currToken, Elements: []ast.PatternElement{}}
+		for !p.peekTokenIs(token.RBRACKET) {
+			p.nextToken()
+			if p.currTokenIs(token.ELLIPSIS) {
+				if pattern.Rest = p.parsePatternRest(token.RBRACKET); pattern.Rest == nil {
+					return nil
+				}
+				break
+			}
+
+			elem := ast.PatternElement{}
+			if elem.Target = p.parsePattern(refutable); elem.Target == nil || !p.parsePatternDefault(&elem) {
+				return nil
+			}
+			pattern.Elements = append(pattern.Elements, elem)
+			if !p.peekTokenIs(token.RBRACKET) && !p.expectPeekIs(token.COMMA) {
+				return nil
+			}
+		}
+		p.nextToken()
+		return pattern
+	case token.LBRACE:
+		pattern := &ast.HashPattern{Token: p.currToken, Elements: []ast.PatternElement{}}
+		for !p.peekTokenIs(token.RBRACE) {
+			p.nextToken()
+			if p.currTokenIs(token.ELLIPSIS) {
+				if pattern.Rest = p.parsePatternRest(token.RBRACE); pattern.Rest == nil {
+					return nil
+				}
+				break
+			}
+
+			elem := ast.PatternElement{Key: p.currToken.Literal}
+			switch {
+			case p.currTokenIs(token.STRING) || p.currTokenIs(token.IDENT) && p.peekTokenIs(token.COLON):
+				if !p.expectPeekIs(token.COLON) {
+					return nil
+				}
+				p.nextToken()
+				if elem.Target = p.parsePattern(refutable); elem.Target == nil {
+					return nil
+				}
+			case p.currTokenIs(token.IDENT):
+				elem.Target = &ast.Identifier{Token: p.currToken, Value: p.currToken.Literal}
+			default:
+				p.appendError(p.currToken, fmt.Sprintf("expected a key, found %s", p.currToken.Type), p.currTokenIs(token.EOF))
+				return nil
+			}
+			if !p.parsePatternDefault(&elem) {
+				return nil
+			}
+			pattern.Elements = append(pattern.Elements, elem)
+			if !p.peekTokenIs(token.RBRACE) && !p.expectPeekIs(token.COMMA) {
+				return nil
+			}
+		}
+		p.nextToken()
+		return pattern
 	}
 
-	if !p.expectPeekIs(token.RPAREN) {
+	p.appendError(p.currToken, fmt.Sprintf("expected a pattern, found %s", p.currToken.Type), p.currTokenIs(token.EOF))
+	return nil
+}
+
+// parsePatternRest parses the rest element at the current token, which
+// must be the last one before end.
+func (p *Parser) parsePatternRest(end token.TokenType) *ast.Identifier {
+	if !p.expectPeekIs(token.IDENT) {
+		return nil
+	}
+	rest := &ast.Identifier{Token: p.currToken, Value: p.currToken.Literal}
+	if !p.peekTokenIs(end) {
+		p.appendError(p.peekToken, fmt.Sprintf("rest element %s must be the last one", rest.Value), p.peekTokenIs(token.EOF))
 		return nil
 	}
+	return rest
+}
 
-	return ids
+// parsePatternDefault parses the default value of a pattern element, if it
+// has one.
+func (p *Parser) parsePatternDefault(elem *ast.PatternElement) bool {
+	if !p.peekTokenIs(token.ASSIGN) {
+		return true
+	}
+	p.nextToken()
+	p.nextToken()
+	elem.Default = p.parseExpression(LOWEST)
+	return elem.Default != nil
+}
+
+func (p *Parser) parseMatchExpression() ast.Expression {
+	exp := &ast.MatchExpression{Token: p.currToken, Arms: []ast.MatchArm{}}
+
+	if !p.expectPeekIs(token.LPAREN) {
+		return nil
+	}
+	p.nextToken()
+	exp.Subject = p.parseExpression(LOWEST)
+	if !p.expectPeekIs(token.RPAREN) || !p.expectPeekIs(token.LBRACE) {
+		return nil
+	}
+
+	for !p.peekTokenIs(token.RBRACE) {
+		p.nextToken()
+
+		arm := ast.MatchArm{Pattern: p.parsePattern(true)}
+		if arm.Pattern == nil {
+			return nil
+		}
+		if p.peekTokenIs(token.IF) {
+			p.nextToken()
+			p.nextToken()
+			if arm.Guard = p.parseExpression(LOWEST); arm.Guard == nil {
+				return nil
+			}
+		}
+		if !p.expectPeekIs(token.ARROW) {
+			return nil
+		}
+		p.nextToken()
+		if arm.Value = p.parseExpression(LOWEST); arm.Value == nil {
+			return nil
+		}
+
+		p.checkReachable(exp.Arms, arm)
+		exp.Arms = append(exp.Arms, arm)
+		if !p.peekTokenIs(token.RBRACE) && !p.expectPeekIs(token.COMMA) {
+			return nil
+		}
+	}
+	p.nextToken()
+	exp.End = p.currToken
+
+	return exp
+}
+
+// checkReachable warns about arm if one of the arms before it, with no
+// guard, matches all of the values it does.
+func (p *Parser) checkReachable(arms []ast.MatchArm, arm ast.MatchArm) {
+	for _, prev := range arms {
+		if prev.Guard == nil && covers(prev.Pattern, arm.Pattern) {
+			at := ast.Start(prev.Pattern)
+			msg := fmt.Sprintf("unreachable match arm, %s is already matched by the arm at %d:%d", arm.Pattern, at.Line, at.Column)
+			p.appendWarning(ast.Start(arm.Pattern), msg)
+			return
+		}
+	}
+}
+
+// covers reports whether pattern a matches all of the values b does, as far
+// as it can tell from their shapes.
+func covers(a, b ast.Pattern) bool {
+	if ast.Irrefutable(a) || a.String() == b.String() {
+		return true
+	}
+
+	switch a := a.(type) {
+	case *ast.TypePattern:
+		switch b := b.(type) {
+		case *ast.TypePattern:
+			return a.Type.String() == b.Type.String()
+		case *ast.LiteralPattern:
+			return a.Type.String() == literalType(b)
+		}
+	case *ast.ArrayPattern:
+		b, ok := b.(*ast.ArrayPattern)
+		if !ok || hasDefaults(a.Elements) || hasDefaults(b.Elements) {
+			return false
+		}
+		// note: a must take every length b does, and cover the elements of b
+		// it doesn't leave to its rest
+		if len(b.Elements) < len(a.Elements) || a.Rest == nil && (b.Rest != nil || len(b.Elements) != len(a.Elements)) {
+			return false
+		}
+		for idx, elem := range a.Elements {
+			if !covers(elem.Target, b.Elements[idx].Target) {
+				return false
+			}
+		}
+		return true
+	case *ast.HashPattern:
+		b, ok := b.(*ast.HashPattern)
+		if !ok || hasDefaults(a.Elements) || hasDefaults(b.Elements) {
+			return false
+		}
+		targets := map[string]ast.Pattern{}
+		for _, elem := range b.Elements {
+			targets[elem.Key] = elem.Target
+		}
+		for _, elem := range a.Elements {
+			if target, ok := targets[elem.Key]; !ok || !covers(elem.Target, target) {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
+
+func hasDefaults(elems []ast.PatternElement) bool {
+	for _, elem := range elems {
+		if elem.Default != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// literalType returns the name of the type of the value of a literal
+// pattern.
+func literalType(lp *ast.LiteralPattern) string {
+	switch lp.Value.(type) {
+	case *ast.StringLiteral:
+		return "string"
+	case *ast.Boolean:
+		return "bool"
+	default:
+		return "int"
+	}
+}
+
+// parseType parses the type annotation starting at the current token.
+func (p *Parser) parseType() ast.TypeExpression {
+	switch p.currToken.Type {
+	case token.IDENT:
+		return &ast.TypeName{Token: p.currToken, Name: p.currToken.Literal}
+	case token.LBRACKET:
+		typ := &ast.ArrayType{Token: p.currToken}
+		p.nextToken()
+		if typ.Elem = p.parseType(); typ.Elem == nil || !p.expectPeekIs(token.RBRACKET) {
+			return nil
+		}
+		return typ
+	case token.LBRACE:
+		typ := &ast.HashType{Token: p.currToken}
+		p.nextToken()
+		if typ.Key = p.parseType(); typ.Key == nil || !p.expectPeekIs(token.COLON) {
+			return nil
+		}
+		p.nextToken()
+		if typ.Value = p.parseType(); typ.Value == nil || !p.expectPeekIs(token.RBRACE) {
+			return nil
+		}
+		return typ
+	case token.FUNCTION:
+		typ := &ast.FunctionType{Token: p.currToken, Params: []ast.TypeExpression{}}
+		if !p.expectPeekIs(token.LPAREN) {
+			return nil
+		}
+		for !p.peekTokenIs(token.RPAREN) {
+			p.nextToken()
+			// note: the rest is the type of each further argument, and ends the list
+			if p.currTokenIs(token.ELLIPSIS) {
+				p.nextToken()
+				if typ.Rest = p.parseType(); typ.Rest == nil || !p.expectPeekIs(token.RPAREN) {
+					return nil
+				}
+				break
+			}
+			param := p.parseType()
+			if param == nil {
+				return nil
+			}
+			typ.Params = append(typ.Params, param)
+			if !p.peekTokenIs(token.RPAREN) && !p.expectPeekIs(token.COMMA) {
+				return nil
+			}
+		}
+		if typ.Rest == nil {
+			p.nextToken()
+		}
+		if p.peekTokenIs(token.COLON) {
+			p.nextToken()
+			p.nextToken()
+			if typ.Return = p.parseType(); typ.Return == nil {
+				return nil
+			}
+		}
+		return typ
+	default:
+		p.appendError(p.currToken, fmt.Sprintf("expected a type, found %s", p.currToken.Type), p.currTokenIs(token.EOF))
+		return nil
+	}
 }
 func (p *Parser) parseIdentifier() ast.Expression {
 	return &ast.Identifier{Token: p.currToken, Value: p.currToken.Literal}
@@ -263,6 +775,11 @@ func (p *Parser) parseBlockStatement() *ast.BlockStatement {
 		p.nextToken()
 	}
 
+	if p.currTokenIs(token.EOF) {
+		p.appendError(p.currToken, fmt.Sprintf("expected next token to be %s, found %s", token.RBRACE, token.EOF), true)
+	}
+	block.End = p.currToken
+
 	return block
 }
 func (p *Parser) parseGroupedExpression() ast.Expression {
@@ -309,10 +826,64 @@ func (p *Parser) parseIfExpression() ast.Expression {
 
 func (p *Parser) parseCallExpression(exp ast.Expression) ast.Expression {
 	ast := &ast.CallExpression{Token: p.currToken, Function: exp}
-	ast.Args = p.parseExpressionList(token.RPAREN)
+	ast.Args = p.parseCallArguments()
+	ast.End = p.currToken
 	return ast
 }
 
+// parseCallArguments parses the arguments of a call: expressions, arrays
+// spread with `...`, and then the keyword arguments, as in f(1, ...xs, c: 3).
+func (p *Parser) parseCallArguments() []ast.Expression {
+	args := []ast.Expression{}
+	names := map[string]bool{}
+
+	if p.peekTokenIs(token.RPAREN) {
+		p.nextToken()
+		return args
+	}
+
+	for {
+		p.nextToken()
+		start := p.currToken
+
+		var arg ast.Expression
+		switch {
+		case p.currTokenIs(token.ELLIPSIS):
+			spread := &ast.SpreadExpression{Token: p.currToken}
+			p.nextToken()
+			spread.Value = p.parseExpression(LOWEST)
+			arg = spread
+		case p.currTokenIs(token.IDENT) && p.peekTokenIs(token.COLON):
+			kw := &ast.KeywordArgument{Token: p.currToken, Name: p.currToken.Literal}
+			if names[kw.Name] {
+				p.appendError(kw.Token, fmt.Sprintf("duplicate keyword argument %s", kw.Name), false)
+			}
+			names[kw.Name] = true
+			p.nextToken()
+			p.nextToken()
+			kw.Value = p.parseExpression(LOWEST)
+			arg = kw
+		default:
+			arg = p.parseExpression(LOWEST)
+		}
+
+		if _, ok := arg.(*ast.KeywordArgument); !ok && len(names) != 0 {
+			p.appendError(start, "positional argument follows keyword arguments", false)
+		}
+		args = append(args, arg)
+
+		if !p.peekTokenIs(token.COMMA) {
+			break
+		}
+		p.nextToken()
+	}
+
+	if !p.expectPeekIs(token.RPAREN) {
+		return nil
+	}
+	return args
+}
+
 func (p *Parser) parseIndexExpression(left ast.Expression) ast.Expression {
 	exp := &ast.IndexExpression{Token: p.currToken, Left: left}
 
@@ -354,7 +925,7 @@ func (p *Parser) parseExpressionList(end token.TokenType) []ast.Expression {
 func (p *Parser) parseHashLiteral() ast.Expression {
 	hash := &ast.HashLiteral{
 		Token: p.currToken,
-		Content: make(map[ast.Expression]ast.Expression),
+		Pairs: []ast.HashPair{},
 	}
 
 	for !p.peekTokenIs(token.RBRACE) {
@@ -368,7 +939,7 @@ func (p *Parser) parseHashLiteral() ast.Expression {
 		p.nextToken()
 		val := p.parseExpression(LOWEST)
 
-		hash.Content[key] = val
+		hash.Pairs = append(hash.Pairs, ast.HashPair{Key: key, Value: val})
 
 		if !p.peekTokenIs(token.RBRACE) && !p.expectPeekIs(token.COMMA) {
 			return nil
@@ -378,6 +949,7 @@ func (p *Parser) parseHashLiteral() ast.Expression {
 	if !p.expectPeekIs(token.RBRACE) {
 		return nil
 	}
+	hash.End = p.currToken
 
 	return hash
 }
@@ -385,7 +957,7 @@ func (p *Parser) parseHashLiteral() ast.Expression {
 // Pratt's Utils
 func (p *Parser) noPrefixParseFnError(t token.TokenType) {
 	msg := fmt.Sprintf("no prefix parse function for %s", t)
-	p.errors = append(p.errors, msg)
+	p.appendError(p.currToken, msg, t == token.EOF)
 }
 func (p *Parser) registerPrefix(tokenType token.TokenType, fn prefixParseFn) {
 	p.prefixParseFns[tokenType] = fn
@@ -393,6 +965,15 @@ func (p *Parser) registerPrefix(tokenType token.TokenType, fn prefixParseFn) {
 func (p *Parser) registerInfix(tokenType token.TokenType, fn infixParseFn) {
 	p.infixParseFns[tokenType] = fn
 }
+
+// Precedence returns the binding power of an operator token, LOWEST for
+// tokens that aren't operators.
+func Precedence(t token.TokenType) int {
+	if p, ok := opPrecedence[t]; ok {
+		return p
+	}
+	return LOWEST
+}
 func (p *Parser) peekPrecedence() int {
 	if p, ok := opPrecedence[p.peekToken.Type]; ok {
 		return p
@@ -410,7 +991,7 @@ func (p *Parser) currPrecedence() int {
 func New(l *lexer.Lexer) *Parser {
 	p := &Parser{
 		l:              l,
-		errors:         []string{},
+		errors:         []Error{},
 		prefixParseFns: make(map[token.TokenType]prefixParseFn),
 		infixParseFns:  make(map[token.TokenType]infixParseFn),
 	}
@@ -425,8 +1006,10 @@ func New(l *lexer.Lexer) *Parser {
 	p.registerPrefix(token.FALSE, p.parseBoolean)
 	p.registerPrefix(token.LPAREN, p.parseGroupedExpression)
 	p.registerPrefix(token.IF, p.parseIfExpression)
+	p.registerPrefix(token.MATCH, p.parseMatchExpression)
 	p.registerPrefix(token.FUNCTION, p.parseFunctionLiteral)
 	p.registerPrefix(token.LBRACE, p.parseHashLiteral)
+	p.registerPrefix(token.ILLEGAL, p.parseIllegal)
 
 	p.registerInfix(token.PLUS, p.parseInfixExpression)
 	p.registerInfix(token.MINUS, p.parseInfixExpression)
@@ -445,6 +1028,20 @@ func New(l *lexer.Lexer) *Parser {
 	return p
 }
 
+// ParseType parses a type annotation on its own, like the ones describing
+// the builtins.
+func ParseType(input string) (ast.TypeExpression, []string) {
+	p := New(lexer.New(input))
+	typ := p.parseType()
+	if typ != nil && !p.peekTokenIs(token.EOF) {
+		p.appendError(p.peekToken, fmt.Sprintf("expected the end of the type, found %s", p.peekToken.Type), false)
+	}
+	if errs := p.Errors(); len(errs) != 0 {
+		return nil, errs
+	}
+	return typ, nil
+}
+
 func (p *Parser) ParseProgram() *ast.Program {
 	program := &ast.Program{Statements: []ast.Statement{}}
 
@@ -460,5 +1057,27 @@ func (p *Parser) ParseProgram() *ast.Program {
 }
 
 func (p *Parser) Errors() []string {
+	msgs := make([]string, len(p.errors))
+	for idx, err := range p.errors {
+		msgs[idx] = err.Msg
+	}
+	return msgs
+}
+
+// Warnings returns the constructs that are valid but likely mistakes, like
+// the match arms that can never be reached, along with their positions.
+func (p *Parser) Warnings() []Error {
+	return p.warnings
+}
+
+// ErrorList returns the errors along with their positions.
+func (p *Parser) ErrorList() []Error {
 	return p.errors
 }
+
+// Incomplete reports whether parsing failed only because the input ended too
+// early (e.g. unbalanced braces or an unterminated string), so that more
+// input could still make it valid.
+func (p *Parser) Incomplete() bool {
+	return len(p.errors) > 0 && p.incomplete
+}
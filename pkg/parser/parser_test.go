@@ -2,6 +2,7 @@ package parser
 
 import (
 	"fmt"
+	"strings"
 	"testing"
 
 	"github.com/AzraelSec/cube/pkg/ast"
@@ -622,21 +623,27 @@ func TestParsingHashLiteralsStringKeys(t *testing.T) {
 	if !ok {
 		t.Fatalf("exp is not ast.HashLiteral. got=%T", stmt.Expression)
 	}
-	if len(hash.Content) != 3 {
-		t.Errorf("hash.Content has wrong length. got=%d", len(hash.Content))
+	if len(hash.Pairs) != 3 {
+		t.Errorf("hash.Pairs has wrong length. got=%d", len(hash.Pairs))
 	}
-	expected := map[string]int64{
-		"one":   1,
-		"two":   2,
-		"three": 3,
+	expected := []struct {
+		key   string
+		value int64
+	}{
+		{"one", 1},
+		{"two", 2},
+		{"three", 3},
 	}
-	for key, value := range hash.Content {
-		literal, ok := key.(*ast.StringLiteral)
+	for idx, pair := range hash.Pairs {
+		literal, ok := pair.Key.(*ast.StringLiteral)
 		if !ok {
-			t.Errorf("key is not ast.StringLiteral. got=%T", key)
+			t.Errorf("key is not ast.StringLiteral. got=%T", pair.Key)
+			continue
+		}
+		if literal.String() != expected[idx].key {
+			t.Errorf("key at position %d is not %q. got=%q", idx, expected[idx].key, literal.String())
 		}
-		expectedValue := expected[literal.String()]
-		testIntegerLiteral(t, value, expectedValue)
+		testIntegerLiteral(t, pair.Value, expected[idx].value)
 	}
 }
 func TestParsingEmptyHashLiteral(t *testing.T) {
@@ -650,8 +657,8 @@ func TestParsingEmptyHashLiteral(t *testing.T) {
 	if !ok {
 		t.Fatalf("exp is not ast.HashLiteral. got=%T", stmt.Expression)
 	}
-	if len(hash.Content) != 0 {
-		t.Errorf("hash.Content has wrong length. got=%d", len(hash.Content))
+	if len(hash.Pairs) != 0 {
+		t.Errorf("hash.Pairs has wrong length. got=%d", len(hash.Pairs))
 	}
 }
 func TestParsingHashLiteralsWithExpressions(t *testing.T) {
@@ -665,8 +672,8 @@ func TestParsingHashLiteralsWithExpressions(t *testing.T) {
 	if !ok {
 		t.Fatalf("exp is not ast.HashLiteral. got=%T", stmt.Expression)
 	}
-	if len(hash.Content) != 3 {
-		t.Errorf("hash.Content has wrong length. got=%d", len(hash.Content))
+	if len(hash.Pairs) != 3 {
+		t.Errorf("hash.Pairs has wrong length. got=%d", len(hash.Pairs))
 	}
 	tests := map[string]func(ast.Expression){
 		"one": func(e ast.Expression) {
@@ -679,10 +686,10 @@ func TestParsingHashLiteralsWithExpressions(t *testing.T) {
 			testInfixExpression(t, e, 15, "/", 5)
 		},
 	}
-	for key, value := range hash.Content {
-		literal, ok := key.(*ast.StringLiteral)
+	for _, pair := range hash.Pairs {
+		literal, ok := pair.Key.(*ast.StringLiteral)
 		if !ok {
-			t.Errorf("key is not ast.StringLiteral. got=%T", key)
+			t.Errorf("key is not ast.StringLiteral. got=%T", pair.Key)
 			continue
 		}
 		testFunc, ok := tests[literal.String()]
@@ -690,7 +697,7 @@ func TestParsingHashLiteralsWithExpressions(t *testing.T) {
 			t.Errorf("No test function for key %q found", literal.String())
 			continue
 		}
-		testFunc(value)
+		testFunc(pair.Value)
 	}
 }
 
@@ -805,3 +812,419 @@ func testInfixExpression(t *testing.T, exp ast.Expression, left any, op string,
 
 	return true
 }
+
+func TestIncompleteInput(t *testing.T) {
+	tests := []struct {
+		input      string
+		incomplete bool
+	}{
+		{"let x = 5;", false},
+		{"let add = fn(a, b) {", true},
+		{"let add = fn(a, b) {\n a + b", true},
+		{"let add = fn(a, b) {\n a + b\n}", false},
+		{"if (x > 1) {", true},
+		{"if (x > 1", true},
+		{"[1, 2", true},
+		{`{"a": 1`, true},
+		{`{"a": 1,`, true},
+		{"add(1, ", true},
+		{"(1 + 2", true},
+		{"let x = ", true},
+		{"1 +", true},
+		{`"unterminated`, true},
+		{`"multi` + "\nline", true},
+		{`"multi` + "\nline\"", false},
+		{"let = 5; fn() {", false},
+		{"1 + )", false},
+		{"x }", false},
+		{"#", false},
+	}
+
+	for _, tt := range tests {
+		p := New(lexer.New(tt.input))
+		p.ParseProgram()
+		if p.Incomplete() != tt.incomplete {
+			t.Errorf("%q: wrong Incomplete(). got=%t, want=%t (errors: %v)", tt.input, p.Incomplete(), tt.incomplete, p.Errors())
+		}
+	}
+}
+
+func TestTypeAnnotations(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"let x: int = 1;", "let x: int = 1;"},
+		{"let xs: [string] = [];", "let xs: [string] = [];"},
+		{"let h: {string: [int]} = {};", "let h: {string: [int]} = {};"},
+		{"let f: fn(int, bool): string = g;", "let f: fn(int, bool): string = g;"},
+		{"let f: fn() = g;", "let f: fn() = g;"},
+		{"fn(a: int, b, c: any): bool { a }", "fn(a: int, b, c: any): boola"},
+		{"fn(f: fn(int): int): fn(): int { f }", "fn(f: fn(int): int): fn(): intf"},
+		{"fn(): {int: bool} { x }", "fn(): {int: bool}x"},
+		{"let f: fn(int, ...string): int = g;", "let f: fn(int, ...string): int = g;"},
+		{"let f: fn(...int) = g;", "let f: fn(...int) = g;"},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		if actual := program.String(); actual != tt.expected {
+			t.Errorf("expected=%q, got=%q", tt.expected, actual)
+		}
+	}
+}
+
+func TestTypeAnnotationErrors(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"let x: = 1;", "expected a type, found ="},
+		{"let x: [int = 1;", "expected next token to be ], found ="},
+		{"fn(a: {int}) { a }", "expected next token to be :, found }"},
+		{"fn(): 1 { 1 }", "expected a type, found INT"},
+	}
+
+	for _, tt := range tests {
+		p := New(lexer.New(tt.input))
+		p.ParseProgram()
+
+		if len(p.Errors()) == 0 || p.Errors()[0] != tt.expected {
+			t.Errorf("%q: wrong errors. want first=%q, got=%q", tt.input, tt.expected, p.Errors())
+		}
+	}
+}
+
+func TestErrorList(t *testing.T) {
+	p := New(lexer.New("let x = 1;\nlet = 2;\nlet y = @;"))
+	p.ParseProgram()
+
+	expected := []Error{
+		{Line: 2, Column: 5, Msg: "expected next token to be IDENT, found ="},
+		{Line: 2, Column: 5, Msg: "no prefix parse function for ="},
+		{Line: 3, Column: 9, Msg: "illegal token \"@\""},
+	}
+	errs := p.ErrorList()
+	if len(errs) != len(expected) {
+		t.Fatalf("wrong number of errors. got=%+v, want=%+v", errs, expected)
+	}
+	for idx := range expected {
+		if errs[idx] != expected[idx] {
+			t.Errorf("wrong error %d. got=%+v, want=%+v", idx, errs[idx], expected[idx])
+		}
+	}
+}
+
+func TestDocComments(t *testing.T) {
+	input := `// not a doc comment
+/// Adds two numbers.
+///
+///   a + b
+let add = fn(a, b) { a + b };
+
+/// detached
+
+let one = 1; /// trailing
+let two = 2;
+/// the callback
+//// hidden
+let call = fn(f) { f() };
+call(
+    /// a callback
+    fn() { 1 });
+//
+/// first
+/// second
+let three = 3;`
+
+	p := New(lexer.New(input))
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	expected := []string{"Adds two numbers.\n\n  a + b", "", "", "", "first\nsecond"}
+	lets := []*ast.LetStatement{}
+	for _, stm := range program.Statements {
+		if let, ok := stm.(*ast.LetStatement); ok {
+			lets = append(lets, let)
+		}
+	}
+	if len(lets) != len(expected) {
+		t.Fatalf("wrong number of let statements. got=%d", len(lets))
+	}
+	for idx, let := range lets {
+		if let.Doc != expected[idx] {
+			t.Errorf("%s: wrong doc. got=%q, want=%q", let.Name, let.Doc, expected[idx])
+		}
+	}
+
+	if fun := lets[0].Value.(*ast.FunctionLiteral); fun.Doc != expected[0] {
+		t.Errorf("wrong function doc. got=%q, want=%q", fun.Doc, expected[0])
+	}
+	call := program.Statements[4].(*ast.ExpressionStatement).Expression.(*ast.CallExpression)
+	if fun := call.Args[0].(*ast.FunctionLiteral); fun.Doc != "a callback" {
+		t.Errorf("wrong argument doc. got=%q, want=%q", fun.Doc, "a callback")
+	}
+}
+
+func TestFunctionStatement(t *testing.T) {
+	input := `/// Adds a and b.
+fn add(a: int, b: int): int { a + b }
+fn noop() {};
+fn(x) { x }(1)`
+
+	p := New(lexer.New(input))
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	if len(program.Statements) != 3 {
+		t.Fatalf("wrong number of statements. got=%d", len(program.Statements))
+	}
+	expected := []struct {
+		name   string
+		str    string
+		doc    string
+		params int
+	}{
+		{"add", "fn add(a: int, b: int): int(a + b)", "Adds a and b.", 2},
+		{"noop", "fn noop()", "", 0},
+	}
+	for idx, tt := range expected {
+		stm, ok := program.Statements[idx].(*ast.FunctionStatement)
+		if !ok {
+			t.Fatalf("statement %d is not *ast.FunctionStatement. got=%T", idx, program.Statements[idx])
+		}
+		if stm.Name.Value != tt.name || stm.Function.Name != tt.name {
+			t.Errorf("wrong name. got=%q (%q), want=%q", stm.Name.Value, stm.Function.Name, tt.name)
+		}
+		if stm.String() != tt.str {
+			t.Errorf("wrong string. got=%q, want=%q", stm.String(), tt.str)
+		}
+		if stm.Function.Doc != tt.doc {
+			t.Errorf("wrong doc. got=%q, want=%q", stm.Function.Doc, tt.doc)
+		}
+		if len(stm.Function.Parameters) != tt.params {
+			t.Errorf("wrong number of parameters. got=%d, want=%d", len(stm.Function.Parameters), tt.params)
+		}
+	}
+	if _, ok := program.Statements[2].(*ast.ExpressionStatement); !ok {
+		t.Errorf("anonymous function is not an expression. got=%T", program.Statements[2])
+	}
+
+	let := New(lexer.New("let f = fn() { 1 };")).ParseProgram().Statements[0].(*ast.LetStatement)
+	if name := let.Value.(*ast.FunctionLiteral).Name; name != "f" {
+		t.Errorf("wrong let function name. got=%q", name)
+	}
+}
+
+func TestFunctionArguments(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"fn(a, b = 10) { a }", "fn(a, b = 10)a"},
+		{"fn(a: int = 1 + 2, b: string = \"x\") { a }", "fn(a: int = (1 + 2), b: string = x)a"},
+		{"fn(first, ...rest: int) { rest }", "fn(first, ...rest: int)rest"},
+		{"fn(a = 1, ...rest) { rest }", "fn(a = 1, ...rest)rest"},
+		{"f(...xs)", "f(...xs)"},
+		{"f(1, ...xs, ...[2])", "f(1, ...xs, ...[2])"},
+		{"f(b: 2, a: 1 + 2)", "f(b: 2, a: (1 + 2))"},
+		{"f(1, ...xs, c: 3)", "f(1, ...xs, c: 3)"},
+	}
+
+	for _, tt := range tests {
+		p := New(lexer.New(tt.input))
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		if actual := program.String(); actual != tt.expected {
+			t.Errorf("expected=%q, got=%q", tt.expected, actual)
+		}
+	}
+
+	fun := New(lexer.New("fn(a, b = 1, ...c) { a }")).ParseProgram().Statements[0].(*ast.ExpressionStatement).Expression.(*ast.FunctionLiteral)
+	if !fun.Rest || fun.Required() != 1 || fun.Default(0) != nil || fun.Default(1) == nil || !fun.IsRest(2) {
+		t.Errorf("wrong parameters %s", fun)
+	}
+}
+
+func TestFunctionArgumentErrors(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"fn(...xs, a) { a }", "rest parameter xs must be the last one"},
+		{"fn(...xs = []) { xs }", "rest parameter xs can't have a default value"},
+		{"fn(a = 1, b) { a }", "parameter b without a default value follows one with a default value"},
+		{"fn(1) { 1 }", "expected a parameter name, found INT"},
+		{"f(a: 1, 2)", "positional argument follows keyword arguments"},
+		{"f(a: 1, ...xs)", "positional argument follows keyword arguments"},
+		{"f(a: 1, a: 2)", "duplicate keyword argument a"},
+		{"let f: fn(...int, int) = g;", "expected next token to be ), found ,"},
+	}
+
+	for _, tt := range tests {
+		p := New(lexer.New(tt.input))
+		p.ParseProgram()
+
+		if len(p.Errors()) == 0 || p.Errors()[0] != tt.expected {
+			t.Errorf("%q: wrong errors. want first=%q, got=%q", tt.input, tt.expected, p.Errors())
+		}
+	}
+}
+
+func TestDestructuring(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"let [a, b] = xs;", "let [a, b] = xs;"},
+		{"let [a, b = 1 + 2, ...rest] = xs;", "let [a, b = (1 + 2), ...rest] = xs;"},
+		{"let [] = xs;", "let [] = xs;"},
+		{"let {name, age: years = 0} = h;", "let {name, age: years = 0} = h;"},
+		{"let {\"full name\": full, name: name} = h;", "let {\"full name\": full, name} = h;"},
+		{"let {tags: [first, ...others], meta: {id}} = h;", "let {tags: [first, ...others], meta: {id}} = h;"},
+		{"let [a, b]: [int] = xs;", "let [a, b]: [int] = xs;"},
+		{"fn([a, b], {c} = h) { a }", "fn([a, b], {c} = h)a"},
+	}
+
+	for _, tt := range tests {
+		p := New(lexer.New(tt.input))
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		if actual := program.String(); actual != tt.expected {
+			t.Errorf("expected=%q, got=%q", tt.expected, actual)
+		}
+	}
+
+	let := New(lexer.New("let {a, b: [c, ...d]} = h;")).ParseProgram().Statements[0].(*ast.LetStatement)
+	names := []string{}
+	for _, name := range let.Names() {
+		names = append(names, name.Value)
+	}
+	if let.Name != nil || strings.Join(names, " ") != "a c d" {
+		t.Errorf("wrong names %v for %s", names, let)
+	}
+
+	fun := New(lexer.New("fn(x, [y, z]) { x }")).ParseProgram().Statements[0].(*ast.ExpressionStatement).Expression.(*ast.FunctionLiteral)
+	if fun.Pattern(0) != nil || fun.Pattern(1) == nil || fun.Parameters[1].Value != "[y, z]" || len(fun.Names()) != 3 {
+		t.Errorf("wrong parameters %s", fun)
+	}
+}
+
+func TestDestructuringErrors(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"let [...rest, a] = xs;", "rest element rest must be the last one"},
+		{"let [1] = xs;", "expected a pattern, found INT"},
+		{"let {1} = h;", "expected a key, found INT"},
+		{"let {\"name\"} = h;", "expected next token to be :, found }"},
+		{"let [a b] = xs;", "expected next token to be ,, found IDENT"},
+		{"fn(...[a, b]) { a }", "expected a parameter name, found ["},
+	}
+
+	for _, tt := range tests {
+		p := New(lexer.New(tt.input))
+		p.ParseProgram()
+
+		if len(p.Errors()) == 0 || p.Errors()[0] != tt.expected {
+			t.Errorf("%q: wrong errors. want first=%q, got=%q", tt.input, tt.expected, p.Errors())
+		}
+	}
+}
+
+func TestMatchExpression(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"match (x) { 1 => a, _ => b }", "match (x) { 1 => a, _ => b }"},
+		{"match (x) { -1 => a, \"s\" => b, true => c, }", "match (x) { -1 => a, \"s\" => b, true => c }"},
+		{"match (x) { n: int if n > 0 => n, _: [string] => 0 }", "match (x) { n: int if (n > 0) => n, _: [string] => 0 }"},
+		{"match (f(x)) { [a, _, ...rest] => rest, {kind: \"k\", x: n: int, ...others} => n }", "match (f(x)) { [a, _, ...rest] => rest, {kind: \"k\", x: n: int, ...others} => n }"},
+		{"match (x) {}", "match (x) {  }"},
+		{"let {a, ...others} = h;", "let {a, ...others} = h;"},
+	}
+
+	for _, tt := range tests {
+		p := New(lexer.New(tt.input))
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		if actual := program.String(); actual != tt.expected {
+			t.Errorf("expected=%q, got=%q", tt.expected, actual)
+		}
+	}
+}
+
+func TestMatchExpressionErrors(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"match x { _ => 1 }", "expected next token to be (, found IDENT"},
+		{"match (x) { 1 }", "expected next token to be =>, found }"},
+		{"match (x) { 1 => 1 2 => 2 }", "expected next token to be ,, found INT"},
+		{"match (x) { - => 1 }", "expected next token to be INT, found =>"},
+		{"match (x) { n: => 1 }", "expected a type, found =>"},
+		{"match (x) { {...others, a} => 1 }", "rest element others must be the last one"},
+		{"let [1, a] = xs;", "expected a pattern, found INT"},
+		{"let [_: int] = xs;", "expected next token to be ,, found :"},
+	}
+
+	for _, tt := range tests {
+		p := New(lexer.New(tt.input))
+		p.ParseProgram()
+
+		if len(p.Errors()) == 0 || p.Errors()[0] != tt.expected {
+			t.Errorf("%q: wrong errors. want first=%q, got=%q", tt.input, tt.expected, p.Errors())
+		}
+	}
+}
+
+func TestMatchWarnings(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected []string
+	}{
+		{"match (x) { 1 => a, 2 => b, n if n > 0 => c, _ => d }", []string{}},
+		{"match (x) { n => a, 1 => b }", []string{"1:21: unreachable match arm, 1 is already matched by the arm at 1:13"}},
+		{"match (x) { 1 => a, 1 => b, 1 if c => d }", []string{
+			"1:21: unreachable match arm, 1 is already matched by the arm at 1:13",
+			"1:29: unreachable match arm, 1 is already matched by the arm at 1:13",
+		}},
+		{"match (x) { _: int => a, 5 => b, n: string => c, _: any => d, [] => e }", []string{
+			"1:26: unreachable match arm, 5 is already matched by the arm at 1:13",
+			"1:63: unreachable match arm, [] is already matched by the arm at 1:50",
+		}},
+		{"match (x) { [a, ...r] => a, [1, 2] => b, [] => c, [_] => d }", []string{
+			"1:29: unreachable match arm, [1, 2] is already matched by the arm at 1:13",
+			"1:51: unreachable match arm, [_] is already matched by the arm at 1:13",
+		}},
+		{"match (x) { [a, b] => a, [1, ...r] => b, [a = 1] => c }", []string{}},
+		{"match (x) { {k} => a, {k: 1, j} => b, {j} => c, {k, ...r} => d }", []string{
+			"1:23: unreachable match arm, {k: 1, j} is already matched by the arm at 1:13",
+			"1:49: unreachable match arm, {k, ...r} is already matched by the arm at 1:13",
+		}},
+	}
+
+	for _, tt := range tests {
+		p := New(lexer.New(tt.input))
+		p.ParseProgram()
+		checkParserErrors(t, p)
+
+		got := []string{}
+		for _, w := range p.Warnings() {
+			got = append(got, fmt.Sprintf("%d:%d: %s", w.Line, w.Column, w.Msg))
+		}
+		if strings.Join(got, "\n") != strings.Join(tt.expected, "\n") {
+			t.Errorf("%q: wrong warnings. got=%q, want=%q", tt.input, got, tt.expected)
+		}
+	}
+}
@@ -0,0 +1,189 @@
+package profiler
+
+import (
+	"compress/gzip"
+	"io"
+)
+
+// note: the fields of profile.proto written by WritePprof, see
+// https://github.com/google/pprof/blob/main/proto/profile.proto
+const (
+	profileSampleType        = 1
+	profileSample            = 2
+	profileLocation          = 4
+	profileFunction          = 5
+	profileStringTable       = 6
+	profileTimeNanos         = 9
+	profileDurationNanos     = 10
+	profilePeriodType        = 11
+	profilePeriod            = 12
+	profileDefaultSampleType = 14
+
+	valueTypeType = 1
+	valueTypeUnit = 2
+
+	sampleLocationID = 1
+	sampleValue      = 2
+
+	locationID   = 1
+	locationLine = 4
+
+	lineFunctionID = 1
+	lineLine       = 2
+
+	functionID         = 1
+	functionName       = 2
+	functionSystemName = 3
+	functionFilename   = 4
+	functionStartLine  = 5
+)
+
+// WritePprof writes the profile in the gzipped protocol buffer format read by
+// `go tool pprof`. Each sample has two values: the number of calls and the
+// time spent, in nanoseconds.
+func (p *Profiler) WritePprof(w io.Writer) error {
+	e := &encoder{strings: map[string]int64{"": 0}, table: []string{""}}
+	functions := map[Function]uint64{}
+	locations := map[Location]uint64{}
+
+	var prof buffer
+	for _, vt := range [][2]string{{"calls", "count"}, {"time", "nanoseconds"}} {
+		prof.message(profileSampleType, e.valueType(vt[0], vt[1]))
+	}
+
+	var funcs, locs buffer
+	for _, s := range p.Samples() {
+		if s.Calls == 0 && s.Time == 0 {
+			continue
+		}
+
+		ids := make([]uint64, len(s.Stack))
+		for idx, loc := range s.Stack {
+			fnID, ok := functions[loc.Function]
+			if !ok {
+				fnID = uint64(len(functions) + 1)
+				functions[loc.Function] = fnID
+
+				var fn buffer
+				fn.uint64(functionID, fnID)
+				fn.int64(functionName, e.str(loc.Function.Name))
+				fn.int64(functionSystemName, e.str(loc.Function.Name))
+				fn.int64(functionFilename, e.str(p.file))
+				fn.int64(functionStartLine, int64(loc.Function.Line))
+				funcs.message(profileFunction, fn)
+			}
+
+			locID, ok := locations[loc]
+			if !ok {
+				locID = uint64(len(locations) + 1)
+				locations[loc] = locID
+
+				var line, l buffer
+				line.uint64(lineFunctionID, fnID)
+				line.int64(lineLine, int64(loc.Line))
+				l.uint64(locationID, locID)
+				l.message(locationLine, line)
+				locs.message(profileLocation, l)
+			}
+			ids[idx] = locID
+		}
+
+		var sample buffer
+		sample.packed(sampleLocationID, ids)
+		sample.packed(sampleValue, []uint64{uint64(s.Calls), uint64(s.Time)})
+		prof.message(profileSample, sample)
+	}
+	prof = append(prof, locs...)
+	prof = append(prof, funcs...)
+
+	prof.int64(profileTimeNanos, p.start.UnixNano())
+	prof.int64(profileDurationNanos, int64(p.total))
+	prof.message(profilePeriodType, e.valueType("time", "nanoseconds"))
+	prof.int64(profilePeriod, 1)
+	prof.int64(profileDefaultSampleType, e.str("time"))
+
+	// note: the string table goes last, once all the strings are known
+	for _, s := range e.table {
+		prof.bytes(profileStringTable, []byte(s))
+	}
+
+	gz := gzip.NewWriter(w)
+	if _, err := gz.Write(prof); err != nil {
+		return err
+	}
+	return gz.Close()
+}
+
+type encoder struct {
+	strings map[string]int64
+	table   []string
+}
+
+// str returns the index of s in the string table.
+func (e *encoder) str(s string) int64 {
+	idx, ok := e.strings[s]
+	if !ok {
+		idx = int64(len(e.table))
+		e.strings[s] = idx
+		e.table = append(e.table, s)
+	}
+	return idx
+}
+
+func (e *encoder) valueType(typ, unit string) buffer {
+	var b buffer
+	b.int64(valueTypeType, e.str(typ))
+	b.int64(valueTypeUnit, e.str(unit))
+	return b
+}
+
+// buffer is a protocol buffer message being encoded.
+type buffer []byte
+
+const (
+	wireVarint = 0
+	wireBytes  = 2
+)
+
+func (b *buffer) varint(v uint64) {
+	for v >= 0x80 {
+		*b = append(*b, byte(v)|0x80)
+		v >>= 7
+	}
+	*b = append(*b, byte(v))
+}
+
+func (b *buffer) key(field, wire int) {
+	b.varint(uint64(field<<3 | wire))
+}
+
+// note: zero values are the default ones, so they are not written
+func (b *buffer) uint64(field int, v uint64) {
+	if v == 0 {
+		return
+	}
+	b.key(field, wireVarint)
+	b.varint(v)
+}
+
+func (b *buffer) int64(field int, v int64) {
+	b.uint64(field, uint64(v))
+}
+
+func (b *buffer) bytes(field int, v []byte) {
+	b.key(field, wireBytes)
+	b.varint(uint64(len(v)))
+	*b = append(*b, v...)
+}
+
+func (b *buffer) message(field int, m buffer) {
+	b.bytes(field, m)
+}
+
+func (b *buffer) packed(field int, vs []uint64) {
+	var p buffer
+	for _, v := range vs {
+		p.varint(v)
+	}
+	b.bytes(field, p)
+}
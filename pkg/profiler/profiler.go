@@ -0,0 +1,270 @@
+// Package profiler measures the time a program spends in each of its
+// functions, writing the profile in the pprof format.
+package profiler
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"time"
+
+	"github.com/AzraelSec/cube/pkg/ast"
+	"github.com/AzraelSec/cube/pkg/object"
+)
+
+// Function is a function of the profiled program, Line being the one of its
+// fn keyword.
+type Function struct {
+	Name string
+	Line int
+}
+
+// note: pprof drops what's between angle brackets from names, taking it for
+// template arguments
+var mainFunction = Function{Name: "main", Line: 1}
+
+// Location is a line of a function: for the innermost function of a stack it
+// is the start of the function, for the others the call to the next one.
+type Location struct {
+	Function Function
+	Line     int
+}
+
+// Sample is the time spent in the innermost function of a stack, not
+// counting the functions it calls, and the number of calls to it.
+type Sample struct {
+	Stack []Location // innermost first
+	Calls int64
+	Time  time.Duration
+}
+
+// note: the profile is a calling context tree, with a node for each stack
+type node struct {
+	fn       Function
+	site     int // line of the call, in the caller
+	children map[edge]*node
+	order    []*node // children, in the order they were first called
+	calls    int64
+	self     time.Duration
+}
+
+type edge struct {
+	site int
+	body *ast.BlockStatement
+}
+
+type frame struct {
+	node     *node
+	start    time.Time
+	children time.Duration // spent in the calls made by the frame
+}
+
+// Profiler is an object.Hook measuring the time spent in each function
+// called between Start and Stop.
+type Profiler struct {
+	file      string
+	functions map[*ast.BlockStatement]Function
+	now       func() time.Time
+
+	root  *node
+	stack []*frame
+	start time.Time
+	total time.Duration
+}
+
+// New returns a profiler for prog, read from file.
+func New(prog *ast.Program, file string) *Profiler {
+	p := &Profiler{file: file, functions: map[*ast.BlockStatement]Function{}, now: time.Now}
+
+	ast.Inspect(prog, func(n ast.Node) bool {
+		if fl, ok := n.(*ast.FunctionLiteral); ok {
+			name := fl.Name
+			if name == "" {
+				name = fmt.Sprintf("anonymous:%d", fl.Token.Line)
+			}
+			p.functions[fl.Body] = Function{Name: name, Line: fl.Token.Line}
+		}
+		return true
+	})
+	return p
+}
+
+// Start starts measuring, counting the time spent out of any function in
+// main.
+func (p *Profiler) Start() {
+	p.root = &node{fn: mainFunction, children: map[edge]*node{}}
+	p.start = p.now()
+	p.stack = []*frame{{node: p.root, start: p.start}}
+}
+
+// Stop stops measuring, ending the calls that didn't return.
+func (p *Profiler) Stop() {
+	for len(p.stack) > 0 {
+		p.pop()
+	}
+	p.total = p.root.self
+	for _, child := range p.root.order {
+		p.total += child.cumulative()
+	}
+}
+
+func (p *Profiler) Statement(ast.Statement, *object.Environment) object.Object {
+	return nil
+}
+
+func (p *Profiler) Call(call *ast.CallExpression, fn *object.Function, env *object.Environment) {
+	if len(p.stack) == 0 {
+		return
+	}
+
+	parent := p.stack[len(p.stack)-1].node
+	// note: functions called by builtins are called from the line the
+	// caller is at, which is not tracked
+	key := edge{body: fn.Body}
+	if call != nil {
+		key.site = ast.Start(call).Line
+	}
+	n, ok := parent.children[key]
+	if !ok {
+		f, ok := p.functions[fn.Body]
+		if !ok {
+			f = Function{Name: "anonymous", Line: fn.Body.Token.Line}
+		}
+		n = &node{fn: f, site: key.site, children: map[edge]*node{}}
+		parent.children[key] = n
+		parent.order = append(parent.order, n)
+	}
+
+	p.stack = append(p.stack, &frame{node: n, start: p.now()})
+}
+
+func (p *Profiler) Return(*ast.CallExpression, object.Object) {
+	// note: the bottom frame is main, ended by Stop
+	if len(p.stack) > 1 {
+		p.pop()
+	}
+}
+
+func (p *Profiler) Branch(*ast.IfExpression, bool) {}
+
+func (p *Profiler) pop() {
+	f := p.stack[len(p.stack)-1]
+	p.stack = p.stack[:len(p.stack)-1]
+
+	elapsed := p.now().Sub(f.start)
+	f.node.self += elapsed - f.children
+	f.node.calls++
+	if len(p.stack) > 0 {
+		p.stack[len(p.stack)-1].children += elapsed
+	}
+}
+
+func (n *node) cumulative() time.Duration {
+	total := n.self
+	for _, child := range n.order {
+		total += child.cumulative()
+	}
+	return total
+}
+
+// Total is the time spent between Start and Stop.
+func (p *Profiler) Total() time.Duration {
+	return p.total
+}
+
+// Samples returns a sample for each stack, in the order they were first seen.
+func (p *Profiler) Samples() []Sample {
+	samples := []Sample{}
+	var walk func(n *node, callers []Location)
+	walk = func(n *node, callers []Location) {
+		stack := append([]Location{{Function: n.fn, Line: n.fn.Line}}, callers...)
+		samples = append(samples, Sample{Stack: stack, Calls: n.calls, Time: n.self})
+		for _, child := range n.order {
+			walk(child, append([]Location{{Function: n.fn, Line: child.site}}, callers...))
+		}
+	}
+	if p.root != nil {
+		walk(p.root, nil)
+	}
+	return samples
+}
+
+// Entry sums up the time spent in a function: Flat in the function itself,
+// Cum including the functions it calls.
+type Entry struct {
+	Function Function
+	Calls    int64
+	Flat     time.Duration
+	Cum      time.Duration
+}
+
+// Top returns the n functions the program spent the most time in, all of
+// them if n is not positive.
+func (p *Profiler) Top(n int) []Entry {
+	entries := map[Function]*Entry{}
+	active := map[Function]int{}
+
+	var walk func(nd *node)
+	walk = func(nd *node) {
+		e, ok := entries[nd.fn]
+		if !ok {
+			e = &Entry{Function: nd.fn}
+			entries[nd.fn] = e
+		}
+		e.Calls += nd.calls
+		e.Flat += nd.self
+		// note: recursive calls are already part of the outermost one
+		if active[nd.fn] == 0 {
+			e.Cum += nd.cumulative()
+		}
+
+		active[nd.fn]++
+		for _, child := range nd.order {
+			walk(child)
+		}
+		active[nd.fn]--
+	}
+	if p.root != nil {
+		walk(p.root)
+	}
+
+	top := make([]Entry, 0, len(entries))
+	for _, e := range entries {
+		top = append(top, *e)
+	}
+	sort.Slice(top, func(i, j int) bool {
+		if top[i].Flat != top[j].Flat {
+			return top[i].Flat > top[j].Flat
+		}
+		if top[i].Cum != top[j].Cum {
+			return top[i].Cum > top[j].Cum
+		}
+		return top[i].Function.Line < top[j].Function.Line
+	})
+	if n > 0 && len(top) > n {
+		top = top[:n]
+	}
+	return top
+}
+
+// Summary writes a table of the n functions the program spent the most time
+// in.
+func (p *Profiler) Summary(w io.Writer, n int) {
+	fmt.Fprintf(w, "profile: %v total\n", round(p.total))
+	fmt.Fprintf(w, "%10s %6s %10s %6s %8s  %s\n", "flat", "flat%", "cum", "cum%", "calls", "function")
+	for _, e := range p.Top(n) {
+		fmt.Fprintf(w, "%10v %6s %10v %6s %8d  %s (%s:%d)\n",
+			round(e.Flat), p.percent(e.Flat), round(e.Cum), p.percent(e.Cum), e.Calls, e.Function.Name, p.file, e.Function.Line)
+	}
+}
+
+func (p *Profiler) percent(d time.Duration) string {
+	if p.total == 0 {
+		return "0.0%"
+	}
+	return fmt.Sprintf("%.1f%%", 100*float64(d)/float64(p.total))
+}
+
+func round(d time.Duration) time.Duration {
+	return d.Round(time.Microsecond)
+}
@@ -0,0 +1,276 @@
+package profiler
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/AzraelSec/cube/pkg/ast"
+	"github.com/AzraelSec/cube/pkg/evaluator"
+	"github.com/AzraelSec/cube/pkg/lexer"
+	"github.com/AzraelSec/cube/pkg/object"
+	"github.com/AzraelSec/cube/pkg/parser"
+)
+
+const program = `let square = fn(x) { x * x };
+let sum = fn(a, b) {
+    square(a) + square(b)
+};
+sum(1, 2);
+sum(3, 4);
+fn() { 1 }()`
+
+const recursive = `let f = fn(n) {
+    if (n == 0) { 0 } else { f(n - 1) }
+};
+f(2)`
+
+func TestSamples(t *testing.T) {
+	p := profile(t, program)
+
+	expected := []string{
+		"main:1 calls=1 time=4ms",
+		"sum:2 main:5 calls=1 time=3ms",
+		"square:1 sum:3 main:5 calls=2 time=2ms",
+		"sum:2 main:6 calls=1 time=3ms",
+		"square:1 sum:3 main:6 calls=2 time=2ms",
+		"anonymous:7:7 main:7 calls=1 time=1ms",
+	}
+	got := []string{}
+	for _, s := range p.Samples() {
+		got = append(got, describe(s.Stack, s.Calls, s.Time))
+	}
+	if strings.Join(got, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("wrong samples.\ngot:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(expected, "\n"))
+	}
+	if p.Total() != 15*time.Millisecond {
+		t.Errorf("wrong total. got=%v", p.Total())
+	}
+}
+
+func TestTop(t *testing.T) {
+	tests := []struct {
+		input    string
+		n        int
+		expected []string
+	}{
+		{program, 0, []string{
+			"sum calls=2 flat=6ms cum=10ms",
+			"main calls=1 flat=4ms cum=15ms",
+			"square calls=4 flat=4ms cum=4ms",
+			"anonymous:7 calls=1 flat=1ms cum=1ms",
+		}},
+		{program, 2, []string{
+			"sum calls=2 flat=6ms cum=10ms",
+			"main calls=1 flat=4ms cum=15ms",
+		}},
+		{recursive, 0, []string{
+			"f calls=3 flat=5ms cum=5ms",
+			"main calls=1 flat=2ms cum=7ms",
+		}},
+	}
+
+	for _, tt := range tests {
+		got := []string{}
+		for _, e := range profile(t, tt.input).Top(tt.n) {
+			got = append(got, fmt.Sprintf("%s calls=%d flat=%v cum=%v", e.Function.Name, e.Calls, e.Flat, e.Cum))
+		}
+		if strings.Join(got, "\n") != strings.Join(tt.expected, "\n") {
+			t.Errorf("wrong top.\ngot:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(tt.expected, "\n"))
+		}
+	}
+}
+
+func TestSummary(t *testing.T) {
+	var out bytes.Buffer
+	profile(t, recursive).Summary(&out, 10)
+
+	expected := `profile: 7ms total
+      flat  flat%        cum   cum%    calls  function
+       5ms  71.4%        5ms  71.4%        3  f (test.cb:1)
+       2ms  28.6%        7ms 100.0%        1  main (test.cb:1)
+`
+	if out.String() != expected {
+		t.Errorf("wrong summary.\ngot:\n%s\nwant:\n%s", out.String(), expected)
+	}
+}
+
+func TestWritePprof(t *testing.T) {
+	var out bytes.Buffer
+	if err := profile(t, program).WritePprof(&out); err != nil {
+		t.Fatal(err)
+	}
+
+	gz, err := gzip.NewReader(&out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw, err := io.ReadAll(gz)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	prof := decode(t, raw)
+	strs := []string{}
+	for _, f := range prof[profileStringTable] {
+		strs = append(strs, string(f.bytes))
+	}
+	str := func(f []field) string {
+		if len(f) == 0 {
+			return ""
+		}
+		return strs[f[0].varint]
+	}
+
+	types := []string{}
+	for _, vt := range prof[profileSampleType] {
+		m := decode(t, vt.bytes)
+		types = append(types, str(m[valueTypeType])+"/"+str(m[valueTypeUnit]))
+	}
+	if strings.Join(types, " ") != "calls/count time/nanoseconds" {
+		t.Errorf("wrong sample types. got=%q", types)
+	}
+	if got := str(prof[profileDefaultSampleType]); got != "time" {
+		t.Errorf("wrong default sample type. got=%q", got)
+	}
+	if got := prof[profileDurationNanos][0].varint; got != uint64(15*time.Millisecond) {
+		t.Errorf("wrong duration. got=%d", got)
+	}
+
+	functions := map[uint64]Function{}
+	for _, f := range prof[profileFunction] {
+		m := decode(t, f.bytes)
+		if file := str(m[functionFilename]); file != "test.cb" {
+			t.Errorf("wrong file name. got=%q", file)
+		}
+		functions[m[functionID][0].varint] = Function{Name: str(m[functionName]), Line: int(m[functionStartLine][0].varint)}
+	}
+	locations := map[uint64]Location{}
+	for _, l := range prof[profileLocation] {
+		m := decode(t, l.bytes)
+		line := decode(t, m[locationLine][0].bytes)
+		locations[m[locationID][0].varint] = Location{
+			Function: functions[line[lineFunctionID][0].varint],
+			Line:     int(line[lineLine][0].varint),
+		}
+	}
+
+	got := []string{}
+	for _, s := range prof[profileSample] {
+		m := decode(t, s.bytes)
+		stack := []Location{}
+		for _, id := range packed(t, m[sampleLocationID][0].bytes) {
+			stack = append(stack, locations[id])
+		}
+		values := packed(t, m[sampleValue][0].bytes)
+		got = append(got, describe(stack, int64(values[0]), time.Duration(values[1])))
+	}
+
+	expected := []string{}
+	for _, s := range profile(t, program).Samples() {
+		expected = append(expected, describe(s.Stack, s.Calls, s.Time))
+	}
+	if strings.Join(got, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("wrong samples.\ngot:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(expected, "\n"))
+	}
+}
+
+// profile runs input with a clock ticking a millisecond every time it's read.
+func profile(t *testing.T, input string) *Profiler {
+	t.Helper()
+
+	prog := parse(t, input)
+	p := New(prog, "test.cb")
+	var now time.Time
+	p.now = func() time.Time {
+		defer func() { now = now.Add(time.Millisecond) }()
+		return now
+	}
+
+	env := object.NewEnvironment()
+	env.Runtime().Hook = p
+	p.Start()
+	if res, ok := evaluator.Eval(prog, env).(*object.Error); ok {
+		t.Fatalf("unexpected error %s", res.Inspect())
+	}
+	p.Stop()
+	return p
+}
+
+func describe(stack []Location, calls int64, elapsed time.Duration) string {
+	locs := []string{}
+	for _, loc := range stack {
+		locs = append(locs, fmt.Sprintf("%s:%d", loc.Function.Name, loc.Line))
+	}
+	return fmt.Sprintf("%s calls=%d time=%v", strings.Join(locs, " "), calls, elapsed)
+}
+
+func parse(t *testing.T, input string) *ast.Program {
+	t.Helper()
+	p := parser.New(lexer.New(input))
+	prog := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		t.Fatalf("unexpected parse errors %v", p.Errors())
+	}
+	if errs := evaluator.Resolve(prog, object.NewEnvironment()); len(errs) != 0 {
+		t.Fatalf("unexpected resolve errors %v", errs)
+	}
+	return prog
+}
+
+type field struct {
+	varint uint64
+	bytes  []byte
+}
+
+// decode splits a protocol buffer message in its fields.
+func decode(t *testing.T, b []byte) map[int][]field {
+	t.Helper()
+	fields := map[int][]field{}
+	for len(b) > 0 {
+		key, n := varint(t, b)
+		b = b[n:]
+
+		var f field
+		switch key & 7 {
+		case wireVarint:
+			f.varint, n = varint(t, b)
+			b = b[n:]
+		case wireBytes:
+			size, n := varint(t, b)
+			b = b[n:]
+			f.bytes, b = b[:size], b[size:]
+		default:
+			t.Fatalf("unexpected wire type %d", key&7)
+		}
+		fields[int(key>>3)] = append(fields[int(key>>3)], f)
+	}
+	return fields
+}
+
+func packed(t *testing.T, b []byte) []uint64 {
+	t.Helper()
+	vs := []uint64{}
+	for len(b) > 0 {
+		v, n := varint(t, b)
+		vs, b = append(vs, v), b[n:]
+	}
+	return vs
+}
+
+func varint(t *testing.T, b []byte) (uint64, int) {
+	t.Helper()
+	var v uint64
+	for idx, c := range b {
+		v |= uint64(c&0x7f) << (7 * idx)
+		if c < 0x80 {
+			return v, idx + 1
+		}
+	}
+	t.Fatalf("truncated varint")
+	return 0, 0
+}
@@ -0,0 +1,157 @@
+package repl
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/AzraelSec/cube/pkg/ast"
+	"github.com/AzraelSec/cube/pkg/lexer"
+	"github.com/AzraelSec/cube/pkg/object"
+	"github.com/AzraelSec/cube/pkg/token"
+)
+
+const commandPrefix = ":"
+
+type metaCommand struct {
+	name string
+	args string
+	help string
+	run  func(s *session, arg string) *object.Exit
+}
+
+var metaCommands []metaCommand
+
+func init() {
+	metaCommands = []metaCommand{
+		{"help", "", "show this help", (*session).help},
+		{"env", "", "list the current bindings with their types", (*session).listEnv},
+		{"type", "expr", "evaluate expr and show the type of its result", (*session).typeOf},
+		{"ast", "expr", "show the syntax tree of expr", (*session).dumpAST},
+		{"tokens", "expr", "show the tokens of expr", (*session).dumpTokens},
+		{"load", "file.cb", "evaluate a file in the current session", (*session).load},
+		{"save", "file", "save the inputs evaluated so far to a file", (*session).save},
+		{"reset", "", "drop every binding and start over", (*session).reset},
+		{"time", "expr", "evaluate expr and show how long it took", (*session).timeEval},
+	}
+}
+
+// command runs a meta-command line, returning the exit request of the code
+// it evaluated, if any.
+func (s *session) command(line string) *object.Exit {
+	name, arg, _ := strings.Cut(strings.TrimPrefix(line, commandPrefix), " ")
+	arg = strings.TrimSpace(arg)
+
+	for _, cmd := range metaCommands {
+		if cmd.name != name {
+			continue
+		}
+		if cmd.args != "" && arg == "" {
+			fmt.Fprintf(s.out, "usage: %s%s %s\n", commandPrefix, cmd.name, cmd.args)
+			return nil
+		}
+		return cmd.run(s, arg)
+	}
+
+	fmt.Fprintf(s.out, "unknown command %s%s, type %shelp for the list of commands\n", commandPrefix, name, commandPrefix)
+	return nil
+}
+
+func (s *session) help(string) *object.Exit {
+	for _, cmd := range metaCommands {
+		usage := commandPrefix + cmd.name
+		if cmd.args != "" {
+			usage += " " + cmd.args
+		}
+		fmt.Fprintf(s.out, "%-16s %s\n", usage, cmd.help)
+	}
+	return nil
+}
+
+func (s *session) listEnv(string) *object.Exit {
+	for _, name := range s.env.Names() {
+		val, _ := s.env.Get(name)
+		fmt.Fprintf(s.out, "%s: %s\n", name, val.Type())
+	}
+	return nil
+}
+
+func (s *session) typeOf(source string) *object.Exit {
+	return s.evalWith(source, func(evaluated object.Object) {
+		if evaluated != nil {
+			fmt.Fprintln(s.out, evaluated.Type())
+		}
+	})
+}
+
+func (s *session) dumpAST(source string) *object.Exit {
+	if prog := s.parse(source); prog != nil {
+		io.WriteString(s.out, ast.Dump(prog))
+	}
+	return nil
+}
+
+func (s *session) dumpTokens(source string) *object.Exit {
+	l := lexer.New(source)
+	for tok := l.NextToken(); tok.Type != token.EOF; tok = l.NextToken() {
+		fmt.Fprintf(s.out, "%-10s %q\n", tok.Type, tok.Literal)
+	}
+	return nil
+}
+
+func (s *session) load(path string) *object.Exit {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		fmt.Fprintf(s.out, "impossible to read the file %s: %v\n", path, err)
+		return nil
+	}
+	return s.evalWith(string(content), func(evaluated object.Object) {
+		if isError(evaluated) {
+			s.print(evaluated)
+		}
+	})
+}
+
+func (s *session) save(path string) *object.Exit {
+	content := strings.Join(s.history, "\n")
+	if content != "" {
+		content += "\n"
+	}
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		fmt.Fprintf(s.out, "impossible to write the file %s: %v\n", path, err)
+	}
+	return nil
+}
+
+func (s *session) reset(string) *object.Exit {
+	s.env = s.newEnvironment()
+	s.history = nil
+	return nil
+}
+
+func (s *session) timeEval(source string) *object.Exit {
+	start := time.Now()
+	return s.evalWith(source, func(evaluated object.Object) {
+		elapsed := time.Since(start)
+		s.print(evaluated)
+		fmt.Fprintf(s.out, "took %s\n", elapsed)
+	})
+}
+
+// evalWith evaluates the source in the session and hands the result to
+// report, unless it's an exit request, which is returned instead.
+func (s *session) evalWith(source string, report func(object.Object)) *object.Exit {
+	prog := s.parse(source)
+	if prog == nil {
+		return nil
+	}
+
+	evaluated := s.eval(source, prog)
+	if exit, ok := evaluated.(*object.Exit); ok {
+		return exit
+	}
+	report(evaluated)
+	return nil
+}
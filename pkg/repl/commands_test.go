@@ -0,0 +1,83 @@
+package repl
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestMetaCommands(t *testing.T) {
+	dir := t.TempDir()
+	script := filepath.Join(dir, "lib.cb")
+	if err := os.WriteFile(script, []byte("let double = fn(x) { x * 2 };\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{":env\n", ">>>>"},
+		{"let a = 1\nlet b = \"x\"\n:env\n", ">>>>>>a: INTEGER\nb: STRING\n>>"},
+		{":type [1, 2]\n", ">>ARRAY\n>>"},
+		{":type\n", ">>usage: :type expr\n>>"},
+		{":type 1 +\n", ">>\tno prefix parse function for EOF\n>>"},
+		{":ast 1 + 2\n", ">>Program\n  Statements[0]: ExpressionStatement\n    Expression: InfixExpression Operator=\"+\"\n      Left: IntegerLiteral Value=1\n      Right: IntegerLiteral Value=2\n>>"},
+		{":tokens let x\n", ">>let        \"let\"\nIDENT      \"x\"\n>>"},
+		{":load " + script + "\ndouble(4)\n", ">>>>8\n>>"},
+		{":load " + filepath.Join(dir, "missing.cb") + "\n", ">>impossible to read the file " + filepath.Join(dir, "missing.cb") + ": open " + filepath.Join(dir, "missing.cb") + ": no such file or directory\n>>"},
+		{"let a = 1\n:reset\na\n", ">>>>>>\t1:1: identifier not found: a\n>>"},
+		{":reset\nprint(\"y\")\n", ">>>>y\nnull\n>>"},
+		{":nope\n", ">>unknown command :nope, type :help for the list of commands\n>>"},
+		{":type exit(4)\n1\n", ">>"},
+	}
+
+	for _, tt := range tests {
+		var out bytes.Buffer
+		Start(strings.NewReader(tt.input), &out)
+		if out.String() != tt.expected {
+			t.Errorf("%q: wrong output. got=%q, want=%q", tt.input, out.String(), tt.expected)
+		}
+	}
+}
+
+func TestSaveCommand(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "session.cb")
+
+	var out bytes.Buffer
+	input := "let a = 1\nmissing\nlet f = fn(x) {\n x + a\n}\n:save " + path + "\n"
+	Start(strings.NewReader(input), &out)
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("session not saved: %v", err)
+	}
+	expected := "let a = 1\nlet f = fn(x) {\n x + a\n}\n"
+	if string(content) != expected {
+		t.Errorf("wrong saved content. got=%q, want=%q", content, expected)
+	}
+
+	out.Reset()
+	Start(strings.NewReader(":load "+path+"\nf(2)\n"), &out)
+	if out.String() != ">>>>3\n>>" {
+		t.Errorf("saved session does not load back. got=%q", out.String())
+	}
+}
+
+func TestTimeAndHelpCommands(t *testing.T) {
+	var out bytes.Buffer
+	Start(strings.NewReader(":time 1 + 1\n"), &out)
+	if !strings.HasPrefix(out.String(), ">>2\ntook ") {
+		t.Errorf(":time wrong output. got=%q", out.String())
+	}
+
+	out.Reset()
+	Start(strings.NewReader(":help\n"), &out)
+	for _, cmd := range metaCommands {
+		if !strings.Contains(out.String(), commandPrefix+cmd.name) {
+			t.Errorf(":help does not mention %s", cmd.name)
+		}
+	}
+}
@@ -0,0 +1,55 @@
+package repl
+
+import (
+	"bufio"
+	"os"
+)
+
+// note: the size of the history of term.Terminal, which drops anything
+// older, so there's no point in replaying more
+const maxHistory = 100
+
+// fileHistory appends every entry to a file, so that it survives across
+// sessions.
+type fileHistory struct {
+	path    string
+	entries []string // oldest first
+}
+
+func loadHistory(path string) *fileHistory {
+	h := &fileHistory{path: path}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return h
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		h.push(scanner.Text())
+	}
+	return h
+}
+
+func (h *fileHistory) push(entry string) {
+	h.entries = append(h.entries, entry)
+	if len(h.entries) > maxHistory {
+		h.entries = h.entries[len(h.entries)-maxHistory:]
+	}
+}
+
+func (h *fileHistory) Add(entry string) {
+	// note: blank lines and repetitions of the last entry are just noise
+	if entry == "" || len(h.entries) > 0 && h.entries[len(h.entries)-1] == entry {
+		return
+	}
+	h.push(entry)
+
+	file, err := os.OpenFile(h.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return
+	}
+	defer file.Close()
+	file.WriteString(entry + "\n")
+}
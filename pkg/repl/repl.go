@@ -0,0 +1,196 @@
+package repl
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/AzraelSec/cube/pkg/ast"
+	"github.com/AzraelSec/cube/pkg/evaluator"
+	"github.com/AzraelSec/cube/pkg/lexer"
+	"github.com/AzraelSec/cube/pkg/object"
+	"github.com/AzraelSec/cube/pkg/parser"
+	"github.com/AzraelSec/cube/pkg/types"
+)
+
+const (
+	Prompt             = ">>"
+	ContinuationPrompt = ".."
+)
+
+// lineReader abstracts where the input lines come from: a plain stream or an
+// interactive terminal with line editing.
+type lineReader interface {
+	ReadLine(prompt string) (string, error)
+}
+
+type scannerReader struct {
+	scanner *bufio.Scanner
+	out     io.Writer
+}
+
+func (sr *scannerReader) ReadLine(prompt string) (string, error) {
+	io.WriteString(sr.out, prompt)
+	if !sr.scanner.Scan() {
+		if err := sr.scanner.Err(); err != nil {
+			return "", err
+		}
+		return "", io.EOF
+	}
+	return sr.scanner.Text(), nil
+}
+
+// lineInput turns the lines read by a function into a stream, so that the
+// programs run by a session read their input the way the session does.
+type lineInput struct {
+	readLine func() (string, error)
+	pending  []byte
+}
+
+func (li *lineInput) Read(p []byte) (int, error) {
+	// note: a line at a time, so that nothing past it is read ahead
+	if len(li.pending) == 0 {
+		line, err := li.readLine()
+		if err != nil {
+			return 0, err
+		}
+		li.pending = []byte(line + "\n")
+	}
+	n := copy(p, li.pending)
+	li.pending = li.pending[n:]
+	return n, nil
+}
+
+// Start runs the read-eval-print loop on a plain stream until the input ends
+// or the script calls `exit`, returning the exit code.
+func Start(in io.Reader, out io.Writer) int {
+	reader := &scannerReader{scanner: bufio.NewScanner(in), out: out}
+	input := &lineInput{readLine: func() (string, error) { return reader.ReadLine("") }}
+	return newSession(input, out).run(reader)
+}
+
+// session holds the state shared by the inputs of a single REPL run.
+type session struct {
+	env *object.Environment
+	in  io.Reader
+	out io.Writer
+	// note: sources evaluated so far, so that they can be saved
+	history []string
+}
+
+// newSession returns a session whose programs read from in and print to out.
+func newSession(in io.Reader, out io.Writer) *session {
+	s := &session{in: in, out: out}
+	s.env = s.newEnvironment()
+	return s
+}
+
+// newEnvironment returns an empty environment using the streams of the
+// session.
+func (s *session) newEnvironment() *object.Environment {
+	env := object.NewEnvironment()
+	env.Runtime().Stdout, env.Runtime().Stdin = s.out, s.in
+	return env
+}
+
+func (s *session) run(reader lineReader) int {
+	var pending []string
+	for {
+		prompt := Prompt
+		if len(pending) > 0 {
+			prompt = ContinuationPrompt
+		}
+
+		line, err := reader.ReadLine(prompt)
+		if err != nil {
+			return 0
+		}
+
+		if len(pending) == 0 && strings.HasPrefix(line, commandPrefix) {
+			if exit := s.command(line); exit != nil {
+				return int(exit.Code)
+			}
+			continue
+		}
+
+		// note: an empty line gives up on the incomplete input and shows the errors
+		force := len(pending) > 0 && strings.TrimSpace(line) == ""
+		pending = append(pending, line)
+
+		source := strings.Join(pending, "\n")
+		p := parser.New(lexer.New(source))
+		prog := p.ParseProgram()
+		if p.Incomplete() && !force {
+			continue
+		}
+		pending = nil
+
+		if len(p.Errors()) != 0 {
+			printParserErrors(s.out, p.Errors())
+			continue
+		}
+		printWarnings(s.out, p.Warnings())
+
+		evaluated := s.eval(source, prog)
+		if exit, ok := evaluated.(*object.Exit); ok {
+			return int(exit.Code)
+		}
+		s.print(evaluated)
+	}
+}
+
+// parse reports the parser errors and warnings, returning a nil program if
+// there are errors.
+func (s *session) parse(source string) *ast.Program {
+	p := parser.New(lexer.New(source))
+	prog := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		printParserErrors(s.out, p.Errors())
+		return nil
+	}
+	printWarnings(s.out, p.Warnings())
+	return prog
+}
+
+func (s *session) eval(source string, prog *ast.Program) object.Object {
+	errs := evaluator.Resolve(prog, s.env)
+	if len(errs) == 0 {
+		info := &types.Info{}
+		errs = types.CheckInfo(prog, types.Globals(s.env), info)
+		printWarnings(s.out, info.Warnings)
+	}
+	if len(errs) != 0 {
+		printParserErrors(s.out, parser.ErrorStrings(errs))
+		return nil
+	}
+
+	evaluated := evaluator.Eval(prog, s.env)
+	if !isError(evaluated) {
+		s.history = append(s.history, source)
+	}
+	return evaluated
+}
+
+func (s *session) print(obj object.Object) {
+	if obj != nil {
+		io.WriteString(s.out, obj.Inspect())
+		io.WriteString(s.out, "\n")
+	}
+}
+
+func isError(obj object.Object) bool {
+	return obj != nil && obj.Type() == object.ERROR_OBJ
+}
+
+func printParserErrors(out io.Writer, errors []string) {
+	for _, msg := range errors {
+		io.WriteString(out, "\t"+msg+"\n")
+	}
+}
+
+func printWarnings(out io.Writer, warnings []parser.Error) {
+	for _, w := range warnings {
+		fmt.Fprintf(out, "\twarning: %d:%d: %s\n", w.Line, w.Column, w.Msg)
+	}
+}
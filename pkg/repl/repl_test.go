@@ -0,0 +1,82 @@
+package repl
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestStart(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+		code     int
+	}{
+		{
+			"1 + 2\n",
+			">>3\n>>",
+			0,
+		},
+		{
+			"let n: int = 1\nlet s: string = n\nn + \"a\"\nn\n",
+			">>>>\t1:17: cannot assign int to s of type string\n>>\twarning: 1:1: type mismatch: int + string\nError: type mismatch: INTEGER + STRING\n>>1\n>>",
+			0,
+		},
+		{
+			"let add = fn(a, b) {\n  a + b\n}\nadd(1, 2)\n",
+			">>....>>3\n>>",
+			0,
+		},
+		{
+			"if (1 > 2) {\n 1\n} else {\n 2\n}\n",
+			">>........2\n>>",
+			0,
+		},
+		{
+			"[1,\n2,\n3]\n",
+			">>....[1, 2, 3]\n>>",
+			0,
+		},
+		{
+			"\"multi\nline\"\n",
+			">>..multi\nline\n>>",
+			0,
+		},
+		{
+			"let f = fn() {\n\n",
+			">>..\texpected next token to be }, found EOF\n>>",
+			0,
+		},
+		{
+			"let x = 1 +;\n",
+			">>\tno prefix parse function for ;\n>>",
+			0,
+		},
+		{
+			"print(\"x\")\n",
+			">>x\nnull\n>>",
+			0,
+		},
+		{
+			"let name = read()\nAda\nprint(name)\n",
+			">>>>Ada\nnull\n>>",
+			0,
+		},
+		{
+			"let f = fn() {\n exit(3)\n}\nf()\n1\n",
+			">>....>>",
+			3,
+		},
+	}
+
+	for _, tt := range tests {
+		var out bytes.Buffer
+		code := Start(strings.NewReader(tt.input), &out)
+		if out.String() != tt.expected {
+			t.Errorf("%q: wrong output. got=%q, want=%q", tt.input, out.String(), tt.expected)
+		}
+		if code != tt.code {
+			t.Errorf("%q: wrong exit code. got=%d, want=%d", tt.input, code, tt.code)
+		}
+	}
+}
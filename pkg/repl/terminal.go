@@ -0,0 +1,178 @@
+package repl
+
+import (
+	"io"
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/AzraelSec/cube/pkg/evaluator"
+	"github.com/AzraelSec/cube/pkg/object"
+	"github.com/AzraelSec/cube/pkg/token"
+	"golang.org/x/term"
+)
+
+type terminalReader struct {
+	term    *term.Terminal
+	fd      int
+	history *fileHistory // nil when the history isn't persisted
+}
+
+func (tr *terminalReader) ReadLine(prompt string) (string, error) {
+	line, err := tr.read(prompt)
+	if err == nil && tr.history != nil {
+		tr.history.Add(line)
+	}
+	return line, err
+}
+
+// read reads a line without adding it to the history, like the ones read by
+// the programs.
+func (tr *terminalReader) read(prompt string) (string, error) {
+	// note: raw mode is only kept while editing, so that evaluation output
+	// (e.g. from `print`) isn't mangled
+	if tr.fd >= 0 {
+		state, err := term.MakeRaw(tr.fd)
+		if err != nil {
+			return "", err
+		}
+		defer term.Restore(tr.fd, state)
+	}
+
+	tr.term.SetPrompt(prompt)
+	return tr.term.ReadLine()
+}
+
+// replayer lets the saved history be fed to a term.Terminal, which can't be
+// given one directly, by reading it back as typed input while muted.
+type replayer struct {
+	io.ReadWriter
+	input []byte
+	muted bool
+}
+
+func (r *replayer) Read(p []byte) (int, error) {
+	if len(r.input) > 0 {
+		n := copy(p, r.input)
+		r.input = r.input[n:]
+		return n, nil
+	}
+	return r.ReadWriter.Read(p)
+}
+
+func (r *replayer) Write(p []byte) (int, error) {
+	if r.muted {
+		return len(p), nil
+	}
+	return r.ReadWriter.Write(p)
+}
+
+// replayable drops the control characters of a history entry, which would
+// otherwise be taken as key presses while it's replayed.
+func replayable(entry string) string {
+	return strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) {
+			return -1
+		}
+		return r
+	}, entry)
+}
+
+// StartTerminal runs the read-eval-print loop on an interactive terminal,
+// with line editing, history and tab completion of identifiers. The fd
+// terminal is switched to raw mode while reading (a negative fd leaves it
+// untouched) and the history is persisted to historyFile, unless empty.
+func StartTerminal(rw io.ReadWriter, fd int, historyFile string) int {
+	reader := &terminalReader{fd: fd}
+	s := newSession(&lineInput{readLine: func() (string, error) { return reader.read("") }}, rw)
+
+	r := &replayer{ReadWriter: rw}
+	t := term.NewTerminal(r, Prompt)
+	if fd >= 0 {
+		if width, height, err := term.GetSize(fd); err == nil && width > 0 {
+			t.SetSize(width, height)
+		}
+	}
+
+	var history *fileHistory
+	if historyFile != "" {
+		history = loadHistory(historyFile)
+		// note: this has to happen before the completion callback is set, so
+		// that a replayed tab isn't taken as a completion request
+		r.muted = true
+		for _, entry := range history.entries {
+			r.input = append(r.input, replayable(entry)+"\r"...)
+		}
+		for range history.entries {
+			t.ReadLine()
+		}
+		r.muted = false
+	}
+	t.AutoCompleteCallback = func(line string, pos int, key rune) (string, int, bool) {
+		if key != '\t' {
+			return "", 0, false
+		}
+
+		newLine, newPos, candidates := complete(line, pos, completionNames(s.env))
+		if len(candidates) > 1 && newLine == line {
+			io.WriteString(t, strings.Join(candidates, "  ")+"\n")
+		}
+		return newLine, newPos, true
+	}
+
+	reader.term, reader.history = t, history
+	return s.run(reader)
+}
+
+// completionNames lists every name that can be completed: the bindings in
+// env, the builtins and the keywords.
+func completionNames(env *object.Environment) []string {
+	seen := make(map[string]bool)
+	names := []string{}
+	for _, group := range [][]string{env.Names(), evaluator.BuiltinNames(), token.Keywords()} {
+		for _, name := range group {
+			if !seen[name] {
+				seen[name] = true
+				names = append(names, name)
+			}
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// complete extends the identifier right before pos with the longest prefix
+// shared by the names it matches, which are returned as candidates.
+func complete(line string, pos int, names []string) (string, int, []string) {
+	start := pos
+	for start > 0 && isIdentChar(line[start-1]) {
+		start--
+	}
+	prefix := line[start:pos]
+	if prefix == "" {
+		return line, pos, nil
+	}
+
+	candidates := []string{}
+	for _, name := range names {
+		if strings.HasPrefix(name, prefix) {
+			candidates = append(candidates, name)
+		}
+	}
+	if len(candidates) == 0 {
+		return line, pos, nil
+	}
+
+	common := candidates[0]
+	for _, candidate := range candidates[1:] {
+		for !strings.HasPrefix(candidate, common) {
+			common = common[:len(common)-1]
+		}
+	}
+
+	return line[:start] + common + line[pos:], start + len(common), candidates
+}
+
+func isIdentChar(c byte) bool {
+	return 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || c == '_'
+}
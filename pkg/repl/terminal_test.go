@@ -0,0 +1,205 @@
+package repl
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/creack/pty"
+)
+
+func TestComplete(t *testing.T) {
+	names := []string{"first", "foo", "foobar", "fn", "let", "len"}
+
+	tests := []struct {
+		line       string
+		pos        int
+		expected   string
+		cursor     int
+		candidates int
+	}{
+		{"fi", 2, "first", 5, 1},
+		{"let x = foob", 12, "let x = foobar", 14, 1},
+		{"fo", 2, "foo", 3, 2},
+		{"f", 1, "f", 1, 4},
+		{"le(x)", 2, "le(x)", 2, 2},
+		{"fi(x)", 2, "first(x)", 5, 1},
+		{"zzz", 3, "zzz", 3, 0},
+		{"1 + ", 4, "1 + ", 4, 0},
+	}
+
+	for _, tt := range tests {
+		line, pos, candidates := complete(tt.line, tt.pos, names)
+		if line != tt.expected || pos != tt.cursor {
+			t.Errorf("complete(%q, %d) wrong. got=(%q, %d), want=(%q, %d)", tt.line, tt.pos, line, pos, tt.expected, tt.cursor)
+		}
+		if len(candidates) != tt.candidates {
+			t.Errorf("complete(%q, %d) wrong candidates. got=%v, want %d", tt.line, tt.pos, candidates, tt.candidates)
+		}
+	}
+}
+
+func TestHistoryPersistence(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "history")
+
+	h := loadHistory(path)
+	h.Add("let a = 1")
+	h.Add("")
+	h.Add("a + 1")
+	h.Add("a + 1")
+
+	h = loadHistory(path)
+	if len(h.entries) != 2 {
+		t.Fatalf("history has wrong number of entries. got=%d", len(h.entries))
+	}
+	if h.entries[0] != "let a = 1" || h.entries[1] != "a + 1" {
+		t.Errorf("history has wrong entries. got=%q", h.entries)
+	}
+}
+
+// ptySession drives StartTerminal through a pseudo-terminal.
+type ptySession struct {
+	t      *testing.T
+	master *os.File
+	mu     sync.Mutex
+	out    bytes.Buffer
+	done   chan int
+}
+
+func newPTYSession(t *testing.T, historyFile string) *ptySession {
+	master, slave, err := pty.Open()
+	if err != nil {
+		t.Skipf("pseudo-terminals not available: %v", err)
+	}
+
+	s := &ptySession{t: t, master: master, done: make(chan int, 1)}
+	go func() {
+		buff := make([]byte, 1024)
+		for {
+			n, err := master.Read(buff)
+			s.mu.Lock()
+			s.out.Write(buff[:n])
+			s.mu.Unlock()
+			if err != nil {
+				return
+			}
+		}
+	}()
+	go func() {
+		s.done <- StartTerminal(slave, int(slave.Fd()), historyFile)
+		slave.Close()
+	}()
+	return s
+}
+
+func (s *ptySession) expect(substr string) {
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		s.mu.Lock()
+		found := strings.Contains(s.out.String(), substr)
+		s.mu.Unlock()
+		if found {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.t.Fatalf("%q not found in terminal output %q", substr, s.out.String())
+}
+
+func (s *ptySession) send(input string) {
+	s.mu.Lock()
+	s.out.Reset()
+	s.mu.Unlock()
+	s.master.Write([]byte(input))
+}
+
+func (s *ptySession) close() int {
+	defer s.master.Close()
+	select {
+	case code := <-s.done:
+		return code
+	case <-time.After(5 * time.Second):
+		s.t.Fatalf("REPL did not terminate")
+		return -1
+	}
+}
+
+func TestTerminalSession(t *testing.T) {
+	historyFile := filepath.Join(t.TempDir(), "history")
+
+	// note: every step waits for the next prompt, which is only written once
+	// the terminal is back in raw mode
+	s := newPTYSession(t, historyFile)
+	s.expect(Prompt)
+	s.send("let answer = 40\r")
+	s.expect("\r\n" + Prompt)
+	s.send("answ\t + 2\r")
+	s.expect("42\r\n" + Prompt)
+	s.send("let f = fn() {\r")
+	s.expect("\r\n" + ContinuationPrompt)
+	s.send("exit(3) }\r")
+	s.expect("\r\n" + Prompt)
+	s.send("f()\r")
+	if code := s.close(); code != 3 {
+		t.Errorf("wrong exit code. got=%d, want=3", code)
+	}
+
+	// note: a new session must recall the previous one's history
+	s = newPTYSession(t, historyFile)
+	s.expect(Prompt)
+	s.send("let answer = 1\r")
+	s.expect("\r\n" + Prompt)
+	s.send(strings.Repeat("\x1b[A", 5) + "\r")
+	s.expect("\r\n3\r\n" + Prompt)
+	s.send("\x04")
+	if code := s.close(); code != 0 {
+		t.Errorf("wrong exit code. got=%d, want=0", code)
+	}
+}
+
+func TestTerminalRead(t *testing.T) {
+	s := newPTYSession(t, "")
+	s.expect(Prompt)
+	s.send("read() + \"!\"\r")
+	s.expect("\r\n")
+	s.send("Ada\r")
+	s.expect("Ada!\r\n" + Prompt)
+	s.send("\x04")
+	if code := s.close(); code != 0 {
+		t.Errorf("wrong exit code. got=%d, want=0", code)
+	}
+}
+
+func TestTerminalHistoryReplay(t *testing.T) {
+	historyFile := filepath.Join(t.TempDir(), "history")
+	entries := []string{}
+	for idx := 0; idx < 2*maxHistory; idx++ {
+		entries = append(entries, fmt.Sprintf("%d", idx))
+	}
+	// note: replayed as typed, ^U would erase the entry and DEL its last character
+	entries = append(entries, "let b = 2\x15\x7f")
+	if err := os.WriteFile(historyFile, []byte(strings.Join(entries, "\n")+"\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if h := loadHistory(historyFile); len(h.entries) != maxHistory {
+		t.Errorf("history has wrong number of entries. got=%d, want=%d", len(h.entries), maxHistory)
+	}
+
+	s := newPTYSession(t, historyFile)
+	s.expect(Prompt)
+	s.send("\x1b[A\r")
+	s.expect("\r\n" + Prompt)
+	s.send("b\r")
+	s.expect("2\r\n" + Prompt)
+	s.send("\x04")
+	if code := s.close(); code != 0 {
+		t.Errorf("wrong exit code. got=%d, want=0", code)
+	}
+}
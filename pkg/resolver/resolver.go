@@ -0,0 +1,319 @@
+// Package resolver binds the identifiers of a program to the variables they
+// refer to, before it gets evaluated.
+package resolver
+
+import (
+	"fmt"
+
+	"github.com/AzraelSec/cube/pkg/ast"
+	"github.com/AzraelSec/cube/pkg/parser"
+)
+
+// Resolve sets the binding of every identifier in prog and the frame size of
+// its functions, returning the errors found, e.g. references to undefined
+// variables. globals are the names already defined when prog runs, such as
+// the builtins and the bindings of previous REPL inputs.
+//
+// A let or a named function binds its name in the whole function it's in (or
+// in the whole program at the top level), so that functions can refer to
+// each other regardless of their order. Inside a function, the code before
+// any let of a variable refers to the one of the same name outside of the
+// function instead, as it did when the let hadn't run yet, and the code
+// that may run before the let (after the block holding it, or in a nested
+// function) falls back to it until the variable is set.
+//
+// A match arm has a scope of its own, like a function, for the variables of
+// its pattern and the lets of its guard and value, so that they're neither
+// seen after the match nor bound by the arms that don't match.
+func Resolve(prog *ast.Program, globals []string) []parser.Error {
+	r := &resolver{globals: map[string]*ast.Identifier{}}
+	for _, name := range globals {
+		r.globals[name] = nil
+	}
+
+	for _, stm := range prog.Statements {
+		r.hoist(stm)
+	}
+	for _, stm := range prog.Statements {
+		r.node(stm)
+	}
+	prog.Resolved = len(r.errors) == 0
+	return r.errors
+}
+
+// scope holds the local variables of a function or of a match arm.
+type scope struct {
+	outer *scope
+	arm   bool                   // whether it's the scope of a match arm, which runs right away
+	vars  map[string]ast.Binding // with a zero depth
+	size  int
+	// note: the variables surely set where the code being resolved is, by
+	// the blocks holding it (innermost last), and the ones with a let
+	// resolved before it, wherever it is
+	blocks []map[string]bool
+	seen   map[string]bool
+}
+
+// set reports whether the variable called name is surely set by the time
+// the code being resolved runs.
+func (s *scope) set(name string) bool {
+	for _, block := range s.blocks {
+		if block[name] {
+			return true
+		}
+	}
+	return false
+}
+
+type resolver struct {
+	scope *scope // nil at the top level
+	// note: the declarations of the globals, nil for the predefined ones
+	globals map[string]*ast.Identifier
+	errors  []parser.Error
+}
+
+func (r *resolver) errorf(node ast.Node, format string, args ...interface{}) {
+	start := ast.Start(node)
+	r.errors = append(r.errors, parser.Error{Line: start.Line, Column: start.Column, Msg: fmt.Sprintf(format, args...)})
+}
+
+// hoist declares the lets and the named functions of node in the current
+// scope, but not the ones of the functions and match arms nested in it.
+func (r *resolver) hoist(node ast.Node) {
+	ast.Inspect(node, func(n ast.Node) bool {
+		switch n := n.(type) {
+		case *ast.LetStatement:
+			for _, name := range n.Names() {
+				r.declare(name)
+			}
+		case *ast.FunctionStatement:
+			r.declare(n.Name)
+		case *ast.MatchExpression:
+			r.hoist(n.Subject)
+			return false
+		case *ast.FunctionLiteral:
+			return false
+		}
+		return true
+	})
+}
+
+func (r *resolver) declare(name *ast.Identifier) {
+	if r.scope == nil {
+		if decl, ok := r.globals[name.Value]; !ok || decl == nil {
+			r.globals[name.Value] = name
+		}
+		return
+	}
+	if _, ok := r.scope.vars[name.Value]; !ok {
+		r.scope.vars[name.Value] = ast.Binding{Scope: ast.Local, Slot: r.scope.size, Decl: name}
+		r.scope.size++
+	}
+}
+
+// define records that the variable called name is set from here to the end
+// of the innermost block.
+func (r *resolver) define(name string) {
+	if r.scope == nil {
+		return
+	}
+	r.scope.seen[name] = true
+	if n := len(r.scope.blocks); n > 0 {
+		r.scope.blocks[n-1][name] = true
+	}
+}
+
+// lookup returns the binding of name, if it's defined.
+func (r *resolver) lookup(name string) (ast.Binding, bool) {
+	return r.lookupFrom(r.scope, 0, false, name)
+}
+
+// lookupFrom looks name up from s, depth levels up from the code being
+// resolved, nested telling whether the code is in a function nested in s.
+func (r *resolver) lookupFrom(s *scope, depth int, nested bool, name string) (ast.Binding, bool) {
+	for ; s != nil; s, depth = s.outer, depth+1 {
+		binding, ok := s.vars[name]
+		switch {
+		case !ok:
+		case s.set(name):
+			binding.Depth = depth
+			return binding, true
+		case nested || s.seen[name]:
+			binding.Depth = depth
+			if outer, ok := r.lookupFrom(s.outer, depth+1, nested || !s.arm, name); ok {
+				binding.Outer = &outer
+			}
+			return binding, true
+		}
+		nested = nested || !s.arm
+	}
+	decl, ok := r.globals[name]
+	return ast.Binding{Scope: ast.Global, Decl: decl}, ok
+}
+
+func (r *resolver) node(node ast.Node) {
+	switch node := node.(type) {
+	case *ast.Identifier:
+		binding, ok := r.lookup(node.Value)
+		if !ok {
+			r.errorf(node, "identifier not found: %s", node.Value)
+		}
+		node.Binding = binding
+		return
+	case *ast.LetStatement:
+		r.node(node.Value)
+		if node.Pattern != nil {
+			r.pattern(node.Pattern)
+			return
+		}
+		r.define(node.Name.Value)
+		node.Name.Binding, _ = r.lookup(node.Name.Value)
+		return
+	case *ast.BlockStatement:
+		if r.scope != nil {
+			r.block(node)
+			return
+		}
+	case *ast.MatchExpression:
+		r.node(node.Subject)
+		for idx := range node.Arms {
+			r.arm(&node.Arms[idx])
+		}
+		return
+	case *ast.FunctionStatement:
+		node.Name.Binding, _ = r.lookup(node.Name.Value)
+		r.function(node.Function)
+		return
+	case *ast.FunctionLiteral:
+		r.function(node)
+		return
+	}
+
+	for _, child := range ast.Children(node) {
+		r.node(child)
+	}
+}
+
+func (r *resolver) function(fn *ast.FunctionLiteral) {
+	// note: default values are evaluated in the environment the function is defined in
+	for _, def := range fn.Defaults {
+		if def != nil {
+			r.node(def)
+		}
+	}
+
+	r.enter(false, len(fn.Parameters))
+	defer r.leave()
+
+	// note: parameters take the first slots, in order, so that calls can fill
+	// them, and the variables of their patterns the following ones
+	for idx, param := range fn.Parameters {
+		param.Binding = ast.Binding{Scope: ast.Local, Slot: idx, Decl: param}
+		names := []*ast.Identifier{param}
+		if pattern := fn.Pattern(idx); pattern != nil {
+			names = ast.Names(pattern)
+		}
+		for _, name := range names {
+			if _, ok := r.scope.vars[name.Value]; ok {
+				r.errorf(name, "duplicate parameter %s", name.Value)
+			}
+			if name != param {
+				r.declare(name)
+				continue
+			}
+			r.scope.vars[name.Value] = param.Binding
+			r.define(name.Value)
+		}
+	}
+	for _, pattern := range fn.Patterns {
+		if pattern != nil {
+			r.pattern(pattern)
+		}
+	}
+	r.hoist(fn.Body)
+	r.node(fn.Body)
+	fn.Slots = r.scope.size
+}
+
+func (r *resolver) arm(arm *ast.MatchArm) {
+	r.enter(true, 0)
+	defer r.leave()
+
+	for _, name := range ast.Names(arm.Pattern) {
+		r.declare(name)
+	}
+	if arm.Guard != nil {
+		r.hoist(arm.Guard)
+	}
+	r.hoist(arm.Value)
+
+	r.pattern(arm.Pattern)
+	if arm.Guard != nil {
+		r.node(arm.Guard)
+	}
+	r.node(arm.Value)
+	arm.Slots = r.scope.size
+}
+
+// enter opens the scope of a function, whose parameters take the first
+// size slots, or of a match arm.
+func (r *resolver) enter(arm bool, size int) {
+	r.scope = &scope{
+		outer:  r.scope,
+		arm:    arm,
+		vars:   map[string]ast.Binding{},
+		size:   size,
+		blocks: []map[string]bool{{}},
+		seen:   map[string]bool{},
+	}
+}
+
+func (r *resolver) leave() {
+	r.scope = r.scope.outer
+}
+
+// block resolves the statements of a block of a function, whose named
+// functions are set before any of them runs.
+func (r *resolver) block(block *ast.BlockStatement) {
+	s := r.scope
+	s.blocks = append(s.blocks, map[string]bool{})
+	defer func() { s.blocks = s.blocks[:len(s.blocks)-1] }()
+
+	for _, stm := range block.Statements {
+		if fs, ok := stm.(*ast.FunctionStatement); ok {
+			r.define(fs.Name.Value)
+		}
+	}
+	for _, stm := range block.Statements {
+		r.node(stm)
+	}
+}
+
+// pattern binds the identifiers of a pattern, already declared, in the
+// order they're bound at runtime: the default value of an element sees the
+// variables of the elements before it.
+func (r *resolver) pattern(p ast.Pattern) {
+	switch p := p.(type) {
+	case *ast.Identifier:
+		r.define(p.Value)
+		p.Binding, _ = r.lookup(p.Value)
+	case *ast.TypePattern:
+		r.pattern(p.Target)
+	case *ast.ArrayPattern:
+		r.elements(p.Elements, p.Rest)
+	case *ast.HashPattern:
+		r.elements(p.Elements, p.Rest)
+	}
+}
+
+func (r *resolver) elements(elems []ast.PatternElement, rest *ast.Identifier) {
+	for _, elem := range elems {
+		if elem.Default != nil {
+			r.node(elem.Default)
+		}
+		r.pattern(elem.Target)
+	}
+	if rest != nil {
+		r.pattern(rest)
+	}
+}
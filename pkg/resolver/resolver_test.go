@@ -0,0 +1,224 @@
+package resolver
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/AzraelSec/cube/pkg/ast"
+	"github.com/AzraelSec/cube/pkg/lexer"
+	"github.com/AzraelSec/cube/pkg/parser"
+)
+
+func TestResolveBindings(t *testing.T) {
+	input := `let a = 1;
+let f = fn(x, y) {
+	let z = x;
+	fn(w) { let v = a + y + w + z + g; v }
+};
+let g = 2;`
+
+	prog := parse(t, input)
+	if errs := Resolve(prog, nil); len(errs) != 0 {
+		t.Fatalf("unexpected errors %v", errs)
+	}
+
+	// note: identifiers in source order, each with its scope, depth and slot
+	expected := []string{
+		"a global",
+		"f global",
+		"x local 0 0", "y local 0 1",
+		"z local 0 2", "x local 0 0",
+		"w local 0 0", "v local 0 1", "a global", "y local 1 1", "w local 0 0", "z local 1 2", "g global", "v local 0 1",
+		"g global",
+	}
+
+	got := []string{}
+	ast.Inspect(prog, func(n ast.Node) bool {
+		if ident, ok := n.(*ast.Identifier); ok {
+			got = append(got, describe(ident))
+		}
+		return true
+	})
+
+	if len(got) != len(expected) {
+		t.Fatalf("wrong number of identifiers. got=%q, want=%q", got, expected)
+	}
+	for idx := range expected {
+		if got[idx] != expected[idx] {
+			t.Errorf("identifier %d: wrong binding. got=%q, want=%q", idx, got[idx], expected[idx])
+		}
+	}
+
+	outer := prog.Statements[1].(*ast.LetStatement).Value.(*ast.FunctionLiteral)
+	if outer.Slots != 3 {
+		t.Errorf("wrong number of slots for the outer function. got=%d, want=3", outer.Slots)
+	}
+	inner := outer.Body.Statements[1].(*ast.ExpressionStatement).Expression.(*ast.FunctionLiteral)
+	if inner.Slots != 2 {
+		t.Errorf("wrong number of slots for the inner function. got=%d, want=2", inner.Slots)
+	}
+}
+
+func TestResolveDeclarations(t *testing.T) {
+	input := "let a = 1;\nlet f = fn(x) { let y = x; a + y + len(x) };\nlet a = 2;"
+
+	prog := parse(t, input)
+	if errs := Resolve(prog, []string{"len"}); len(errs) != 0 {
+		t.Fatalf("unexpected errors %v", errs)
+	}
+
+	// note: where the declaration of each identifier is, in source order
+	expected := []string{"1:5", "2:5", "2:12", "2:21", "2:12", "1:5", "2:21", "none", "2:12", "1:5"}
+
+	got := []string{}
+	ast.Inspect(prog, func(n ast.Node) bool {
+		if ident, ok := n.(*ast.Identifier); ok {
+			decl := "none"
+			if d := ident.Binding.Decl; d != nil {
+				decl = fmt.Sprintf("%d:%d", d.Token.Line, d.Token.Column)
+			}
+			got = append(got, decl)
+		}
+		return true
+	})
+
+	if len(got) != len(expected) {
+		t.Fatalf("wrong number of identifiers. got=%q, want=%q", got, expected)
+	}
+	for idx := range expected {
+		if got[idx] != expected[idx] {
+			t.Errorf("identifier %d: wrong declaration. got=%q, want=%q", idx, got[idx], expected[idx])
+		}
+	}
+}
+
+func TestResolvePatterns(t *testing.T) {
+	input := `let [x, y] = [1, 2];
+let f = fn(a, [b, c], {d = a}) { let [e, ...g] = b; e + d };`
+
+	prog := parse(t, input)
+	if errs := Resolve(prog, nil); len(errs) != 0 {
+		t.Fatalf("unexpected errors %v", errs)
+	}
+
+	// note: the parameters named after their patterns keep the first slots
+	expected := []string{
+		"x global", "y global",
+		"f global",
+		"a local 0 0", "[b, c] local 0 1", "{d = a} local 0 2",
+		"b local 0 3", "c local 0 4", "d local 0 5", "a local 0 0",
+		"e local 0 6", "g local 0 7", "b local 0 3", "e local 0 6", "d local 0 5",
+	}
+
+	got := []string{}
+	ast.Inspect(prog, func(n ast.Node) bool {
+		if ident, ok := n.(*ast.Identifier); ok {
+			got = append(got, describe(ident))
+		}
+		return true
+	})
+
+	if len(got) != len(expected) {
+		t.Fatalf("wrong number of identifiers. got=%q, want=%q", got, expected)
+	}
+	for idx := range expected {
+		if got[idx] != expected[idx] {
+			t.Errorf("identifier %d: wrong binding. got=%q, want=%q", idx, got[idx], expected[idx])
+		}
+	}
+
+	if fn := prog.Statements[1].(*ast.LetStatement).Value.(*ast.FunctionLiteral); fn.Slots != 8 {
+		t.Errorf("wrong number of slots. got=%d, want=8", fn.Slots)
+	}
+}
+
+func TestResolveMatch(t *testing.T) {
+	prog := parse(t, "let f = fn(x) { match (x) { [a, _] if a > 0 => a, n: int => n + x } };")
+	if errs := Resolve(prog, nil); len(errs) != 0 {
+		t.Fatalf("unexpected errors %v", errs)
+	}
+
+	// note: each arm has a scope of its own, below the one of the function
+	expected := []string{
+		"f global",
+		"x local 0 0",
+		"x local 0 0", "a local 0 0", "a local 0 0", "a local 0 0", "n local 0 0", "n local 0 0", "x local 1 0",
+	}
+
+	got := []string{}
+	ast.Inspect(prog, func(n ast.Node) bool {
+		if ident, ok := n.(*ast.Identifier); ok {
+			got = append(got, describe(ident))
+		}
+		return true
+	})
+
+	if strings.Join(got, ", ") != strings.Join(expected, ", ") {
+		t.Errorf("wrong bindings. got=%q, want=%q", got, expected)
+	}
+}
+
+func TestResolveErrors(t *testing.T) {
+	tests := []struct {
+		input    string
+		globals  []string
+		expected []string
+	}{
+		{"let a = 1; a + len", []string{"len"}, []string{}},
+		{"foobar", nil, []string{"1:1: identifier not found: foobar"}},
+		{"let f = fn() { x }; let g = fn(x) { x };", nil, []string{"1:16: identifier not found: x"}},
+		{"args[0]", []string{"args"}, []string{}},
+		{"let f = fn(a, b, a) { a };", nil, []string{"1:18: duplicate parameter a"}},
+		{"if (true) { let a = 1; } a", nil, []string{}},
+		{"let a = a;", nil, []string{}},
+		{"f(); fn f() { g() } fn g() { 1 }", nil, []string{}},
+		{"fn f() { fn g() { 1 } } g()", nil, []string{"1:25: identifier not found: g"}},
+		{"fn f(a, a) { a }", nil, []string{"1:9: duplicate parameter a"}},
+		{"let n = 1; fn f(a, b = n + a) { b }", nil, []string{"1:28: identifier not found: a"}},
+		{"fn f(a, ...a) { a }", nil, []string{"1:12: duplicate parameter a"}},
+		{"let xs = [1]; fn f(...ys) { ys } f(...xs, b: xs)", nil, []string{}},
+		{"fn f(a, [b, {a}]) { a }", nil, []string{"1:14: duplicate parameter a"}},
+		{"let [a, b = c] = [1];", nil, []string{"1:13: identifier not found: c"}},
+		{"fn f([a, b = a], {c = b}) { c }", nil, []string{}},
+		{"match (1) { n if n > 0 => n, [a, ...r] => a + r, {k: v, ...o} => v, _ => 0 }", nil, []string{}},
+		{"match (1) { {k: v, ...o} => v, _ => o }; n", nil, []string{"1:37: identifier not found: o", "1:42: identifier not found: n"}},
+		{"match (1) { n => n }; n", nil, []string{"1:23: identifier not found: n"}},
+		{"let n = 1; match (1) { n => if (true) { let m = n; m } }; m", nil, []string{"1:59: identifier not found: m"}},
+		{"fn f(x) { match (x) { y => y } } y", nil, []string{"1:34: identifier not found: y"}},
+		{"match (1) { [a = b] => a }", nil, []string{"1:18: identifier not found: b"}},
+		{"let f = fn() { let y = x; let x = 2; y }", nil, []string{"1:24: identifier not found: x"}},
+		{"let x = 1; let f = fn() { if (false) { let x = 2 }; x }", nil, []string{}},
+		{"let f = fn() { if (true) { let x = 2 }; x }", nil, []string{}},
+		{"let f = fn() { let g = fn() { x }; let x = 1; g() }", nil, []string{}},
+	}
+
+	for _, tt := range tests {
+		errs := Resolve(parse(t, tt.input), tt.globals)
+		if len(errs) != len(tt.expected) {
+			t.Errorf("%q: wrong errors. got=%q, want=%q", tt.input, errs, tt.expected)
+			continue
+		}
+		for idx := range errs {
+			if errs[idx].String() != tt.expected[idx] {
+				t.Errorf("%q: wrong error. got=%q, want=%q", tt.input, errs[idx], tt.expected[idx])
+			}
+		}
+	}
+}
+
+func describe(ident *ast.Identifier) string {
+	if ident.Binding.Scope == ast.Global {
+		return ident.Value + " global"
+	}
+	return fmt.Sprintf("%s local %d %d", ident.Value, ident.Binding.Depth, ident.Binding.Slot)
+}
+
+func parse(t *testing.T, input string) *ast.Program {
+	p := parser.New(lexer.New(input))
+	prog := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		t.Fatalf("%q: unexpected parse errors %v", input, p.Errors())
+	}
+	return prog
+}
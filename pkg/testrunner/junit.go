@@ -0,0 +1,90 @@
+package testrunner
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"time"
+)
+
+type junitSuites struct {
+	XMLName  xml.Name     `xml:"testsuites"`
+	Tests    int          `xml:"tests,attr"`
+	Failures int          `xml:"failures,attr"`
+	Errors   int          `xml:"errors,attr"`
+	Time     string       `xml:"time,attr"`
+	Suites   []junitSuite `xml:"testsuite"`
+}
+
+type junitSuite struct {
+	Name     string      `xml:"name,attr"`
+	Tests    int         `xml:"tests,attr"`
+	Failures int         `xml:"failures,attr"`
+	Errors   int         `xml:"errors,attr"`
+	Time     string      `xml:"time,attr"`
+	Cases    []junitCase `xml:"testcase"`
+}
+
+type junitCase struct {
+	Name      string        `xml:"name,attr"`
+	Classname string        `xml:"classname,attr"`
+	Time      string        `xml:"time,attr"`
+	Failure   *junitProblem `xml:"failure,omitempty"`
+	Error     *junitProblem `xml:"error,omitempty"`
+}
+
+type junitProblem struct {
+	Message string `xml:"message,attr"`
+	Body    string `xml:",chardata"`
+}
+
+// WriteJUnit writes the outcomes in the JUnit XML format read by CI
+// services, with a suite for each file. The files that couldn't run have a
+// single test case named after them, with the error that prevented it.
+func WriteJUnit(w io.Writer, files []File) error {
+	report := junitSuites{}
+	var total time.Duration
+	for _, f := range files {
+		suite := junitSuite{Name: f.Path, Time: seconds(f.Duration)}
+		if f.Error != "" {
+			suite.Errors = 1
+			suite.Cases = append(suite.Cases, junitCase{
+				Name:      f.Path,
+				Classname: f.Path,
+				Time:      seconds(f.Duration),
+				Error:     &junitProblem{Message: f.Error, Body: f.Error},
+			})
+		}
+		for _, t := range f.Tests {
+			c := junitCase{Name: t.Name, Classname: f.Path, Time: seconds(t.Duration)}
+			if t.Failure != "" {
+				suite.Failures++
+				c.Failure = &junitProblem{Message: t.Failure, Body: t.Failure}
+			}
+			suite.Cases = append(suite.Cases, c)
+		}
+		suite.Tests = len(suite.Cases)
+
+		report.Tests += suite.Tests
+		report.Failures += suite.Failures
+		report.Errors += suite.Errors
+		total += f.Duration
+		report.Suites = append(report.Suites, suite)
+	}
+	report.Time = seconds(total)
+
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(report); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
+
+func seconds(d time.Duration) string {
+	return fmt.Sprintf("%.3f", d.Seconds())
+}
@@ -0,0 +1,273 @@
+// Package testrunner runs the tests of Cube programs: files named *_test.cb,
+// registering functions with the test builtin, which fail by returning an
+// error, usually the one of a failed assertion.
+package testrunner
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/AzraelSec/cube/pkg/ast"
+	"github.com/AzraelSec/cube/pkg/coverage"
+	"github.com/AzraelSec/cube/pkg/evaluator"
+	"github.com/AzraelSec/cube/pkg/lexer"
+	"github.com/AzraelSec/cube/pkg/object"
+	"github.com/AzraelSec/cube/pkg/parser"
+	"github.com/AzraelSec/cube/pkg/types"
+)
+
+// Suffix is the one of the names of test files.
+const Suffix = "_test.cb"
+
+// Globals returns the variables test files are evaluated with, register
+// being called by the test builtin. Tools checking test files can pass nil.
+func Globals(register func(name string, fn object.Object) *object.Error) *object.Environment {
+	env := object.NewEnvironment()
+	env.Set("args", &object.Array{})
+	env.Set("test", &object.Builtin{
+		Name:    "test",
+		MinArgs: 2,
+		MaxArgs: 2,
+		Params:  []object.Param{{Name: "name", Type: "string"}, {Name: "fn", Type: "fn()"}},
+		Result:  "null",
+		Doc:     "Registers a test, failing when the function returns an error.",
+		Fn: func(_ *object.Environment, o ...object.Object) object.Object {
+			fn, ok := o[1].(*object.Function)
+			if !ok || fn.Required() != 0 {
+				return &object.Error{Msg: fmt.Sprintf("second argument to `test` must be a function with no required parameters, got %s", o[1].Inspect())}
+			}
+			if register != nil {
+				if err := register(o[0].(*object.String).Value, fn); err != nil {
+					return err
+				}
+			}
+			return evaluator.NULL
+		},
+	})
+	return env
+}
+
+// Options tweak how tests are run.
+type Options struct {
+	// Run selects the tests to run by name, all of them if nil.
+	Run *regexp.Regexp
+	// Parallel is the number of files run at the same time, at least one.
+	Parallel int
+	// Coverage, if not nil, records the coverage of the files.
+	Coverage *coverage.Coverage
+	// NoStrict enables implicit conversions, see object.Runtime.
+	NoStrict bool
+}
+
+// Test is the outcome of a test.
+type Test struct {
+	Name     string
+	Failure  string // empty if the test passed
+	Duration time.Duration
+}
+
+// File is the outcome of a test file.
+type File struct {
+	Path string
+	// Error prevented the tests of the file from running: it couldn't be
+	// read, had errors, or failed while registering them.
+	Error    string
+	Tests    []Test
+	Duration time.Duration
+}
+
+// Failed reports whether the file or any of its tests failed.
+func (f File) Failed() bool {
+	if f.Error != "" {
+		return true
+	}
+	for _, t := range f.Tests {
+		if t.Failure != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// Find returns the test files the patterns stand for: files are taken as
+// they are, directories for the test files in them, and dir/... for the
+// test files in dir and its subdirectories. No pattern stands for the
+// current directory.
+func Find(patterns []string) ([]string, error) {
+	if len(patterns) == 0 {
+		patterns = []string{"."}
+	}
+
+	seen := map[string]bool{}
+	paths := []string{}
+	add := func(path string) {
+		if !seen[path] {
+			seen[path] = true
+			paths = append(paths, path)
+		}
+	}
+
+	for _, pattern := range patterns {
+		dir, recursive := strings.CutSuffix(pattern, "...")
+		if recursive {
+			dir = filepath.Clean(dir)
+		}
+
+		info, err := os.Stat(dir)
+		if err != nil {
+			return nil, err
+		}
+		if !info.IsDir() {
+			if recursive {
+				return nil, fmt.Errorf("%s is not a directory", dir)
+			}
+			add(dir)
+			continue
+		}
+
+		found := []string{}
+		err = filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if d.IsDir() {
+				// note: hidden directories are skipped, like .git
+				if path != dir && (!recursive || strings.HasPrefix(d.Name(), ".")) {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			if strings.HasSuffix(d.Name(), Suffix) {
+				found = append(found, path)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+		sort.Strings(found)
+		for _, path := range found {
+			add(path)
+		}
+	}
+	return paths, nil
+}
+
+// Run runs the test files, returning their outcomes in the same order.
+func Run(paths []string, opts Options) []File {
+	files := make([]File, len(paths))
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	workers := opts.Parallel
+	if workers < 1 {
+		workers = 1
+	}
+	for n := 0; n < workers; n++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for idx := range jobs {
+				files[idx] = runFile(paths[idx], opts)
+			}
+		}()
+	}
+	for idx := range paths {
+		jobs <- idx
+	}
+	close(jobs)
+	wg.Wait()
+	return files
+}
+
+type registered struct {
+	name string
+	fn   object.Object
+}
+
+func runFile(path string, opts Options) (f File) {
+	start := time.Now()
+	f.Path = path
+	defer func() { f.Duration = time.Since(start) }()
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		f.Error = fmt.Sprintf("impossible to read the file: %v", err)
+		return f
+	}
+
+	tests := []registered{}
+	names := map[string]bool{}
+	env := Globals(func(name string, fn object.Object) *object.Error {
+		if names[name] {
+			return &object.Error{Msg: fmt.Sprintf("test %q is already registered", name)}
+		}
+		names[name] = true
+		tests = append(tests, registered{name: name, fn: fn})
+		return nil
+	})
+
+	env.Runtime().Strict = !opts.NoStrict
+	if opts.Coverage != nil {
+		env.Runtime().Hook = opts.Coverage
+	}
+	prog, errs := load(string(content), env)
+	if len(errs) != 0 {
+		f.Error = strings.Join(errs, "\n")
+		return f
+	}
+	if opts.Coverage != nil {
+		opts.Coverage.Add(prog, path, string(content))
+	}
+
+	switch res := evaluator.Eval(prog, env).(type) {
+	case *object.Error:
+		f.Error = res.Msg
+		return f
+	case *object.Exit:
+		f.Error = fmt.Sprintf("unexpected %s", res.Inspect())
+		return f
+	}
+
+	for _, t := range tests {
+		if opts.Run != nil && !opts.Run.MatchString(t.name) {
+			continue
+		}
+		f.Tests = append(f.Tests, run(env, t))
+	}
+	return f
+}
+
+func load(source string, env *object.Environment) (*ast.Program, []string) {
+	p := parser.New(lexer.New(source))
+	prog := p.ParseProgram()
+	if errs := p.Errors(); len(errs) != 0 {
+		return nil, errs
+	}
+
+	errs := evaluator.Resolve(prog, env)
+	if len(errs) == 0 {
+		errs = types.Config{Strict: env.Runtime().Strict}.Check(prog, types.Globals(env), nil)
+	}
+	return prog, parser.ErrorStrings(errs)
+}
+
+func run(env *object.Environment, t registered) Test {
+	start := time.Now()
+	res := evaluator.Apply(env, t.fn)
+	test := Test{Name: t.name, Duration: time.Since(start)}
+
+	switch res := res.(type) {
+	case *object.Error:
+		test.Failure = res.Msg
+	case *object.Exit:
+		test.Failure = fmt.Sprintf("unexpected %s", res.Inspect())
+	}
+	return test
+}
@@ -0,0 +1,184 @@
+package testrunner
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/AzraelSec/cube/pkg/coverage"
+)
+
+var files = map[string]string{
+	"math_test.cb": `let double = fn(x) { x * 2 };
+test("double", fn() { assertEq(double(2), 4) });
+test("double fails", fn() { assertEq(double(2), 5) });
+test("errors", fn() { assertError(fn() { double("a") }, "type mismatch"); assertError(fn() { 1 + "a" }) });
+test("exits", fn() { exit(1) });`,
+	"broken_test.cb":       `let a = ;`,
+	"top_test.cb":          "test(\"a\", fn() { 1 });\nfirst(1)",
+	"duplicate_test.cb":    "test(\"a\", fn() { 1 });\ntest(\"a\", fn() { 2 });",
+	"lib.cb":               `let skipped = 1;`,
+	"sub/nested_test.cb":   `test("nested", fn() { assert(true) });`,
+	".hidden/skip_test.cb": `test("hidden", fn() { assert(false) });`,
+}
+
+func TestFind(t *testing.T) {
+	dir := tree(t)
+
+	tests := []struct {
+		patterns []string
+		expected []string
+	}{
+		{[]string{dir}, []string{"broken_test.cb", "duplicate_test.cb", "math_test.cb", "top_test.cb"}},
+		{[]string{dir + "/..."}, []string{"broken_test.cb", "duplicate_test.cb", "math_test.cb", "sub/nested_test.cb", "top_test.cb"}},
+		{[]string{dir + "/sub", dir + "/lib.cb", dir + "/sub/nested_test.cb"}, []string{"sub/nested_test.cb", "lib.cb"}},
+	}
+
+	for _, tt := range tests {
+		paths, err := Find(tt.patterns)
+		if err != nil {
+			t.Fatalf("%q: unexpected error %v", tt.patterns, err)
+		}
+		got := []string{}
+		for _, path := range paths {
+			rel, _ := filepath.Rel(dir, path)
+			got = append(got, filepath.ToSlash(rel))
+		}
+		if strings.Join(got, " ") != strings.Join(tt.expected, " ") {
+			t.Errorf("%q: wrong files.\ngot= %q\nwant=%q", tt.patterns, got, tt.expected)
+		}
+	}
+
+	if _, err := Find([]string{dir + "/missing"}); err == nil {
+		t.Errorf("missing error for a missing directory")
+	}
+	if _, err := Find([]string{dir + "/lib.cb/..."}); err == nil {
+		t.Errorf("missing error for a recursive file pattern")
+	}
+}
+
+func TestRun(t *testing.T) {
+	dir := tree(t)
+	paths, err := Find([]string{dir + "/..."})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{
+		"broken_test.cb: error: no prefix parse function for ;",
+		"duplicate_test.cb: error: test \"a\" is already registered",
+		"math_test.cb: double: ok",
+		"math_test.cb: double fails: assertEq failed: got 4, want 5",
+		"math_test.cb: errors: ok",
+		"math_test.cb: exits: unexpected exit(1)",
+		"sub/nested_test.cb: nested: ok",
+		"top_test.cb: error: argument to `first` not supported, got INTEGER",
+	}
+	for _, parallel := range []int{0, 1, 4} {
+		got := outcomes(t, dir, Run(paths, Options{Parallel: parallel}))
+		if strings.Join(got, "\n") != strings.Join(expected, "\n") {
+			t.Errorf("parallel %d: wrong outcomes.\ngot:\n%s\nwant:\n%s", parallel, strings.Join(got, "\n"), strings.Join(expected, "\n"))
+		}
+	}
+
+	for _, f := range Run([]string{filepath.Join(dir, "sub/nested_test.cb"), filepath.Join(dir, "top_test.cb")}, Options{}) {
+		if f.Failed() != (f.Error != "") {
+			t.Errorf("%s: wrong failed state %t", f.Path, f.Failed())
+		}
+	}
+
+	got := outcomes(t, dir, Run([]string{filepath.Join(dir, "math_test.cb")}, Options{Run: regexp.MustCompile("^double")}))
+	if strings.Join(got, "\n") != strings.Join(expected[2:4], "\n") {
+		t.Errorf("wrong filtered outcomes.\ngot:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(expected[2:4], "\n"))
+	}
+}
+
+func TestRunCoverage(t *testing.T) {
+	dir := tree(t)
+	c := coverage.New()
+	Run([]string{filepath.Join(dir, "math_test.cb"), filepath.Join(dir, "sub/nested_test.cb")}, Options{Parallel: 2, Coverage: c})
+
+	got := []string{}
+	for _, f := range c.Files() {
+		rel, _ := filepath.Rel(dir, f.Name)
+		got = append(got, fmt.Sprintf("%s %s", filepath.ToSlash(rel), f.FunctionRatio()))
+	}
+	if len(got) != 2 || !strings.Contains(strings.Join(got, "\n"), "math_test.cb 100.0% (7/7)") {
+		t.Errorf("wrong coverage. got=%q", got)
+	}
+}
+
+func TestWriteJUnit(t *testing.T) {
+	files := []File{
+		{Path: "a_test.cb", Duration: 1500 * time.Millisecond, Tests: []Test{
+			{Name: "passes", Duration: time.Millisecond},
+			{Name: "fails", Failure: `assertEq failed: got 1, want "<2>"`, Duration: 2 * time.Millisecond},
+		}},
+		{Path: "b_test.cb", Error: "1:9: no prefix parse function for ;"},
+	}
+
+	var out bytes.Buffer
+	if err := WriteJUnit(&out, files); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := `<?xml version="1.0" encoding="UTF-8"?>
+<testsuites tests="3" failures="1" errors="1" time="1.500">
+  <testsuite name="a_test.cb" tests="2" failures="1" errors="0" time="1.500">
+    <testcase name="passes" classname="a_test.cb" time="0.001"></testcase>
+    <testcase name="fails" classname="a_test.cb" time="0.002">
+      <failure message="assertEq failed: got 1, want &#34;&lt;2&gt;&#34;">assertEq failed: got 1, want &#34;&lt;2&gt;&#34;</failure>
+    </testcase>
+  </testsuite>
+  <testsuite name="b_test.cb" tests="1" failures="0" errors="1" time="0.000">
+    <testcase name="b_test.cb" classname="b_test.cb" time="0.000">
+      <error message="1:9: no prefix parse function for ;">1:9: no prefix parse function for ;</error>
+    </testcase>
+  </testsuite>
+</testsuites>
+`
+	if out.String() != expected {
+		t.Errorf("wrong report.\ngot:\n%s\nwant:\n%s", out.String(), expected)
+	}
+}
+
+// tree writes files to a temporary directory, returning it.
+func tree(t *testing.T) string {
+	t.Helper()
+	dir := t.TempDir()
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func outcomes(t *testing.T, dir string, files []File) []string {
+	t.Helper()
+	got := []string{}
+	for _, f := range files {
+		rel, _ := filepath.Rel(dir, f.Path)
+		rel = filepath.ToSlash(rel)
+		if f.Error != "" {
+			got = append(got, fmt.Sprintf("%s: error: %s", rel, f.Error))
+		}
+		for _, test := range f.Tests {
+			outcome := test.Failure
+			if outcome == "" {
+				outcome = "ok"
+			}
+			got = append(got, fmt.Sprintf("%s: %s: %s", rel, test.Name, outcome))
+		}
+	}
+	return got
+}
@@ -1,9 +1,12 @@
 package token
 
+import "sort"
+
 const (
 	// special
 	ILLEGAL = "ILLEGAL"
 	EOF     = "EOF"
+	COMMENT = "COMMENT"
 
 	// types
 	IDENT  = "IDENT"
@@ -28,6 +31,8 @@ const (
 	COMMA     = ","
 	SEMICOLON = ";"
 	COLON     = ":"
+	ELLIPSIS  = "..."
+	ARROW     = "=>"
 
 	LPAREN   = "("
 	RPAREN   = ")"
@@ -44,6 +49,7 @@ const (
 	TRUE     = "true"
 	FALSE    = "false"
 	RETURN   = "return"
+	MATCH    = "match"
 )
 
 var keywords = map[string]TokenType{
@@ -54,6 +60,7 @@ var keywords = map[string]TokenType{
 	"true":   TRUE,
 	"false":  FALSE,
 	"return": RETURN,
+	"match":  MATCH,
 }
 
 type TokenType string
@@ -61,6 +68,13 @@ type TokenType string
 type Token struct {
 	Type    TokenType
 	Literal string
+	Line    int // 1-based, 0 when unknown
+	Column  int // 1-based, in bytes
+}
+
+// Before reports whether the token starts before the given one.
+func (t Token) Before(other Token) bool {
+	return t.Line < other.Line || t.Line == other.Line && t.Column < other.Column
 }
 
 func New(tokenType TokenType, literal string) Token {
@@ -70,6 +84,16 @@ func New(tokenType TokenType, literal string) Token {
 	}
 }
 
+// Keywords returns the reserved words of the language, sorted.
+func Keywords() []string {
+	words := make([]string, 0, len(keywords))
+	for word := range keywords {
+		words = append(words, word)
+	}
+	sort.Strings(words)
+	return words
+}
+
 func LookupIdent(s string) TokenType {
 	if v, ok := keywords[s]; ok {
 		return v
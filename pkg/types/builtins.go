@@ -0,0 +1,49 @@
+package types
+
+import (
+	"github.com/AzraelSec/cube/pkg/ast"
+	"github.com/AzraelSec/cube/pkg/evaluator"
+	"github.com/AzraelSec/cube/pkg/object"
+	"github.com/AzraelSec/cube/pkg/parser"
+)
+
+// Builtin returns the type of the builtin function called name, if any.
+func Builtin(name string) (*Function, bool) {
+	b, ok := evaluator.LookupBuiltin(name)
+	if !ok {
+		return nil, false
+	}
+	return builtinType(b), true
+}
+
+// builtinType converts the annotations describing b. The parameters past
+// the required ones become the rest of the function, typed as they are if
+// they agree, and the upper bound of their number is left to the linter.
+func builtinType(b *object.Builtin) *Function {
+	fn := &Function{Params: []Type{}, Return: builtinAnnotation(b.Result)}
+	for idx, p := range b.Params {
+		typ := builtinAnnotation(p.Type)
+		switch {
+		case idx < b.MinArgs:
+			fn.Params = append(fn.Params, typ)
+		case fn.Rest == nil:
+			fn.Rest = typ
+		case !Identical(fn.Rest, typ):
+			fn.Rest = Any
+		}
+	}
+	return fn
+}
+
+// note: unlike programs, builtins can be annotated as returning never
+func builtinAnnotation(annotation string) Type {
+	if annotation == Never.String() {
+		return Never
+	}
+	te, errs := parser.ParseType(annotation)
+	if len(errs) != 0 {
+		return Any
+	}
+	c := &checker{annotations: map[ast.TypeExpression]Type{}}
+	return c.annotation(te)
+}
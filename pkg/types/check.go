@@ -0,0 +1,756 @@
+package types
+
+import (
+	"fmt"
+
+	"github.com/AzraelSec/cube/pkg/ast"
+	"github.com/AzraelSec/cube/pkg/parser"
+)
+
+// Check type checks prog, which must have gone through the resolver and
+// runs in strict mode, returning the errors found. globals are the types of
+// the variables defined before prog runs, builtins excluded.
+//
+// Only the errors involving an annotation stop the program from running:
+// the ones found in code without annotations are warnings, see Info, and
+// are left to the runtime, since the program may never run into them.
+//
+// The type of a variable is its annotation if it has one, or else the type
+// of its value if it's bound by a single let. Variables bound more than once
+// without annotations are `any`.
+func Check(prog *ast.Program, globals map[string]Type) []parser.Error {
+	return CheckInfo(prog, globals, nil)
+}
+
+// Info holds the types found by the checker.
+type Info struct {
+	// Types maps the expressions to their types, including the identifiers
+	// being declared by lets and function parameters
+	Types map[ast.Expression]Type
+
+	// Warnings holds the errors found in code without annotations
+	Warnings []parser.Error
+}
+
+// CheckInfo is like Check, but also records the types it finds into info
+// when it's not nil.
+func CheckInfo(prog *ast.Program, globals map[string]Type, info *Info) []parser.Error {
+	return Config{Strict: true}.Check(prog, globals, info)
+}
+
+// Config holds the settings of the evaluation the checked programs run in.
+type Config struct {
+	// Strict tells whether implicit conversions are disabled, see
+	// object.Runtime.
+	Strict bool
+}
+
+// Check is like CheckInfo, for programs run with the settings of conf.
+func (conf Config) Check(prog *ast.Program, globals map[string]Type, info *Info) []parser.Error {
+	c := &checker{
+		conf:        conf,
+		globals:     map[string]*variable{},
+		annotations: map[ast.TypeExpression]Type{},
+		info:        info,
+	}
+	if info != nil && info.Types == nil {
+		info.Types = map[ast.Expression]Type{}
+	}
+	for name, typ := range globals {
+		c.globals[name] = &variable{typ: typ, lets: 1}
+	}
+
+	for _, stm := range prog.Statements {
+		c.hoist(stm)
+	}
+	c.statements(prog.Statements)
+	if info != nil {
+		info.Warnings = c.warnings
+	}
+	return c.errors
+}
+
+type variable struct {
+	typ      Type
+	declared bool // whether typ comes from an annotation
+	lets     int  // number of bindings
+}
+
+// function is the context of the function being checked, or of a match
+// arm, which only has variables of its own.
+type function struct {
+	slots   []*variable
+	arm     bool
+	ret     Type // the annotated result type, nil if there's none
+	returns Type // the join of the types returned so far
+}
+
+type checker struct {
+	conf        Config
+	globals     map[string]*variable
+	funcs       []*function // innermost last, match arms included
+	annotations map[ast.TypeExpression]Type
+	info        *Info
+	errors      []parser.Error
+	warnings    []parser.Error
+}
+
+func (c *checker) record(exp ast.Expression, typ Type) {
+	if c.info != nil {
+		c.info.Types[exp] = typ
+	}
+}
+
+func (c *checker) errorf(node ast.Node, format string, args ...interface{}) {
+	c.reportf(true, node, format, args...)
+}
+
+// reportf reports an error if the types involved come from an annotation,
+// or else a warning.
+func (c *checker) reportf(annotated bool, node ast.Node, format string, args ...interface{}) {
+	start := ast.Start(node)
+	err := parser.Error{Line: start.Line, Column: start.Column, Msg: fmt.Sprintf(format, args...)}
+	if annotated {
+		c.errors = append(c.errors, err)
+	} else {
+		c.warnings = append(c.warnings, err)
+	}
+}
+
+// annotated reports whether the type of node comes from an annotation, like
+// the ones of the variables declared with a type.
+func (c *checker) annotated(node ast.Node) bool {
+	switch node := node.(type) {
+	case *ast.Identifier:
+		v := c.variable(node)
+		return v != nil && v.declared
+	case *ast.IndexExpression:
+		return c.annotated(node.Left)
+	default:
+		return false
+	}
+}
+
+// variable returns the variable ident refers to, creating it if it's a new
+// global, or nil for the builtins.
+func (c *checker) variable(ident *ast.Identifier) *variable {
+	return c.lookup(ident.Value, ident.Binding)
+}
+
+func (c *checker) lookup(name string, b ast.Binding) *variable {
+	if b.Scope == ast.Local {
+		fn := c.funcs[len(c.funcs)-1-b.Depth]
+		if fn.slots[b.Slot] == nil {
+			fn.slots[b.Slot] = &variable{typ: Any}
+		}
+		return fn.slots[b.Slot]
+	}
+
+	if v, ok := c.globals[name]; ok {
+		return v
+	}
+	if _, ok := Builtin(name); ok {
+		return nil
+	}
+	c.globals[name] = &variable{typ: Any}
+	return c.globals[name]
+}
+
+// identifier returns the type of the variable called name, bound as b,
+// which for a local that may not be set yet includes the type of the one it
+// falls back to.
+func (c *checker) identifier(name string, b ast.Binding) Type {
+	var typ Type
+	if v := c.lookup(name, b); v != nil {
+		typ = v.typ
+	} else {
+		typ, _ = Builtin(name)
+	}
+	if b.Outer != nil {
+		typ = Join(typ, c.identifier(name, *b.Outer))
+	}
+	return typ
+}
+
+// hoist declares the lets of node in the current function or match arm,
+// giving their annotated type to the variables right away.
+func (c *checker) hoist(node ast.Node) {
+	ast.Inspect(node, func(n ast.Node) bool {
+		switch n := n.(type) {
+		case *ast.LetStatement:
+			if n.Pattern != nil {
+				for _, name := range n.Names() {
+					if v := c.declare(name); v.lets > 1 && !v.declared {
+						v.typ = Any
+					}
+				}
+				return true
+			}
+			v := c.declare(n.Name)
+			switch {
+			case n.Type != nil && !v.declared:
+				v.typ, v.declared = c.annotation(n.Type), true
+			case v.lets > 1 && !v.declared:
+				v.typ = Any
+			}
+		case *ast.MatchExpression:
+			// note: the arms are checked in scopes of their own
+			c.hoist(n.Subject)
+			return false
+		case *ast.FunctionStatement:
+			// note: calls coming before the declaration see its signature
+			v := c.declare(n.Name)
+			switch {
+			case v.lets == 1:
+				v.typ = c.signature(n.Function)
+			case !v.declared:
+				v.typ = Any
+			}
+		case *ast.FunctionLiteral:
+			return false
+		}
+		return true
+	})
+}
+
+// declare counts a new binding of the variable name refers to.
+func (c *checker) declare(name *ast.Identifier) *variable {
+	v := c.variable(name)
+	if v == nil {
+		// note: a global shadowing a builtin
+		c.globals[name.Value] = &variable{typ: Any}
+		v = c.globals[name.Value]
+	}
+	v.lets++
+	return v
+}
+
+// signature returns the type of fn according to its annotations alone.
+func (c *checker) signature(fn *ast.FunctionLiteral) *Function {
+	params := make([]Type, len(fn.Parameters))
+	for idx := range fn.Parameters {
+		params[idx] = c.param(fn, idx)
+	}
+	ret := Type(Any)
+	if fn.ReturnType != nil {
+		ret = c.annotation(fn.ReturnType)
+	}
+	return functionType(fn, params, ret)
+}
+
+// param returns the annotated type of the parameter of fn at idx, which is
+// the one of each of the elements for the rest parameter.
+func (c *checker) param(fn *ast.FunctionLiteral, idx int) Type {
+	if idx < len(fn.ParamTypes) && fn.ParamTypes[idx] != nil {
+		return c.annotation(fn.ParamTypes[idx])
+	}
+	return Any
+}
+
+// functionType builds the type of fn out of the types of its parameters.
+// Like for builtins, the optional parameters become the rest, typed as they
+// are if they agree, and the upper bound of their number is left to the
+// runtime.
+func functionType(fn *ast.FunctionLiteral, params []Type, ret Type) *Function {
+	typ := &Function{Params: []Type{}, Return: ret}
+	for idx, param := range params {
+		switch {
+		case idx < fn.Required():
+			typ.Params = append(typ.Params, param)
+		case typ.Rest == nil:
+			typ.Rest = param
+		case !Identical(typ.Rest, param):
+			typ.Rest = Any
+		}
+	}
+	return typ
+}
+
+// annotation converts a type annotation, reporting unknown types only once.
+func (c *checker) annotation(te ast.TypeExpression) Type {
+	if typ, ok := c.annotations[te]; ok {
+		return typ
+	}
+
+	var typ Type
+	switch te := te.(type) {
+	case *ast.TypeName:
+		basic, ok := basics[te.Name]
+		if !ok {
+			c.errorf(te, "unknown type %s", te.Name)
+			basic = Any
+		}
+		typ = basic
+	case *ast.ArrayType:
+		typ = &Array{Elem: c.annotation(te.Elem)}
+	case *ast.HashType:
+		typ = &Hash{Key: c.annotation(te.Key), Value: c.annotation(te.Value)}
+	case *ast.FunctionType:
+		fn := &Function{Params: make([]Type, len(te.Params)), Return: Any}
+		for idx, param := range te.Params {
+			fn.Params[idx] = c.annotation(param)
+		}
+		if te.Rest != nil {
+			fn.Rest = c.annotation(te.Rest)
+		}
+		if te.Return != nil {
+			fn.Return = c.annotation(te.Return)
+		}
+		typ = fn
+	default:
+		typ = Any
+	}
+
+	c.annotations[te] = typ
+	return typ
+}
+
+// statements checks a list of statements, returning the type of the value
+// they evaluate to.
+func (c *checker) statements(stms []ast.Statement) Type {
+	res := Type(Null)
+	for _, stm := range stms {
+		typ := c.statement(stm)
+		if res != Never {
+			res = typ
+		}
+	}
+	return res
+}
+
+func (c *checker) statement(stm ast.Statement) Type {
+	switch stm := stm.(type) {
+	case *ast.LetStatement:
+		c.let(stm)
+		return Null
+	case *ast.FunctionStatement:
+		typ := c.expression(stm.Function)
+		v := c.variable(stm.Name)
+		if v.lets == 1 {
+			v.typ = typ
+		}
+		c.record(stm.Name, v.typ)
+		return Null
+	case *ast.ReturnStatement:
+		typ := c.expression(stm.RetValue)
+		fn := c.enclosing()
+		if fn == nil {
+			return Never
+		}
+
+		if fn.ret != nil && !Assignable(typ, fn.ret) {
+			c.errorf(stm.RetValue, "cannot return %s from a function returning %s", typ, fn.ret)
+		}
+		fn.returns = Join(fn.returns, typ)
+		return Never
+	case *ast.ExpressionStatement:
+		return c.expression(stm.Expression)
+	case *ast.BlockStatement:
+		return c.statements(stm.Statements)
+	default:
+		return Any
+	}
+}
+
+// enclosing returns the function being checked, nil at the top level.
+func (c *checker) enclosing() *function {
+	for idx := len(c.funcs) - 1; idx >= 0; idx-- {
+		if !c.funcs[idx].arm {
+			return c.funcs[idx]
+		}
+	}
+	return nil
+}
+
+func (c *checker) let(stm *ast.LetStatement) {
+	typ := c.expression(stm.Value)
+	if stm.Pattern != nil {
+		// note: the annotation of a destructuring let is the one of its value
+		if stm.Type != nil {
+			declared := c.annotation(stm.Type)
+			if !Assignable(typ, declared) {
+				c.errorf(stm.Value, "cannot assign %s to %s of type %s", typ, stm.Pattern, declared)
+			}
+			typ = declared
+		}
+		c.destructure(stm.Pattern, typ, stm.Value, false)
+		return
+	}
+
+	v := c.variable(stm.Name)
+	switch {
+	case stm.Type != nil:
+		if declared := c.annotation(stm.Type); !Assignable(typ, declared) {
+			c.errorf(stm.Value, "cannot assign %s to %s of type %s", typ, stm.Name.Value, declared)
+		}
+	case v.declared:
+		if !Assignable(typ, v.typ) {
+			c.errorf(stm.Value, "cannot assign %s to %s of type %s", typ, stm.Name.Value, v.typ)
+		}
+	case v.lets == 1:
+		v.typ = typ
+	}
+	c.record(stm.Name, v.typ)
+}
+
+// destructure binds the variables of p to the parts of a value of type typ,
+// reporting the errors at value. The elements with a default value take the
+// join of the two types. Refutable patterns, the ones of match arms, just
+// don't match the values of other shapes, and narrow the type of the ones
+// they bind.
+func (c *checker) destructure(p ast.Pattern, typ Type, value ast.Node, refutable bool) {
+	var elems []ast.PatternElement
+	var rest *ast.Identifier
+	elem, restType := Type(Any), Type(Any)
+
+	switch p := p.(type) {
+	case *ast.Identifier:
+		v := c.variable(p)
+		switch {
+		case v.declared:
+			if !Assignable(typ, v.typ) {
+				c.errorf(value, "cannot assign %s to %s of type %s", typ, p.Value, v.typ)
+			}
+		case v.lets == 1:
+			v.typ = typ
+		}
+		c.record(p, v.typ)
+		return
+	case *ast.LiteralPattern:
+		c.expression(p.Value)
+		return
+	case *ast.TypePattern:
+		c.destructure(p.Target, c.annotation(p.Type), value, refutable)
+		// note: the variable is as good as declared with the type
+		if ident, ok := p.Target.(*ast.Identifier); ok {
+			if v := c.variable(ident); v.lets == 1 {
+				v.declared = true
+			}
+		}
+		return
+	case *ast.ArrayPattern:
+		switch typ := typ.(type) {
+		case *Array:
+			elem = typ.Elem
+		default:
+			if typ != Any && typ != Never && !refutable {
+				c.reportf(c.annotated(value), value, "cannot destructure %s with array pattern %s", typ, p)
+			}
+		}
+		elems, rest = p.Elements, p.Rest
+		restType = &Array{Elem: elem}
+	case *ast.HashPattern:
+		restType = &Hash{Key: Any, Value: Any}
+		switch typ := typ.(type) {
+		case *Hash:
+			elem, restType = typ.Value, typ
+		default:
+			if typ != Any && typ != Never && !refutable {
+				c.reportf(c.annotated(value), value, "cannot destructure %s with hash pattern %s", typ, p)
+			}
+		}
+		elems, rest = p.Elements, p.Rest
+	}
+
+	for _, e := range elems {
+		typ := elem
+		if e.Default != nil {
+			typ = Join(typ, c.expression(e.Default))
+		}
+		c.destructure(e.Target, typ, value, refutable)
+	}
+	if rest != nil {
+		c.destructure(rest, restType, value, refutable)
+	}
+}
+
+// match checks a match expression, whose type is the join of the ones of
+// its arms.
+func (c *checker) match(exp *ast.MatchExpression) Type {
+	subject := c.expression(exp.Subject)
+	res := Type(Never)
+	for _, arm := range exp.Arms {
+		c.funcs = append(c.funcs, &function{slots: make([]*variable, arm.Slots), arm: true})
+		for _, name := range ast.Names(arm.Pattern) {
+			if v := c.declare(name); v.lets > 1 && !v.declared {
+				v.typ = Any
+			}
+		}
+		if arm.Guard != nil {
+			c.hoist(arm.Guard)
+		}
+		c.hoist(arm.Value)
+
+		c.destructure(arm.Pattern, subject, arm.Pattern, true)
+		if arm.Guard != nil {
+			c.expression(arm.Guard)
+		}
+		res = Join(res, c.expression(arm.Value))
+		c.funcs = c.funcs[:len(c.funcs)-1]
+	}
+	return res
+}
+
+func (c *checker) expression(exp ast.Expression) Type {
+	typ := c.typeOf(exp)
+	c.record(exp, typ)
+	return typ
+}
+
+func (c *checker) typeOf(exp ast.Expression) Type {
+	switch exp := exp.(type) {
+	case *ast.IntegerLiteral:
+		return Int
+	case *ast.StringLiteral:
+		return String
+	case *ast.Boolean:
+		return Bool
+	case *ast.Identifier:
+		return c.identifier(exp.Value, exp.Binding)
+	case *ast.PrefixExpression:
+		return c.prefix(exp)
+	case *ast.InfixExpression:
+		return c.infix(exp)
+	case *ast.IfExpression:
+		c.expression(exp.Condition)
+		res := c.statements(exp.Consequence.Statements)
+		if exp.Alternative == nil {
+			return Join(res, Null)
+		}
+		return Join(res, c.statements(exp.Alternative.Statements))
+	case *ast.MatchExpression:
+		return c.match(exp)
+	case *ast.FunctionLiteral:
+		return c.function(exp)
+	case *ast.CallExpression:
+		return c.call(exp)
+	case *ast.ArrayLiteral:
+		elem := Type(Never)
+		for _, e := range exp.Elements {
+			elem = Join(elem, c.expression(e))
+		}
+		return &Array{Elem: elem}
+	case *ast.HashLiteral:
+		// note: the keys and the values of an empty hash are yet to come
+		if len(exp.Pairs) == 0 {
+			return &Hash{Key: Any, Value: Any}
+		}
+		key, value := Type(Never), Type(Never)
+		for _, pair := range exp.Pairs {
+			k := c.expression(pair.Key)
+			if !hashable(k) {
+				c.reportf(c.annotated(pair.Key), pair.Key, "not hashable key: %s", k)
+			}
+			key, value = Join(key, k), Join(value, c.expression(pair.Value))
+		}
+		return &Hash{Key: key, Value: value}
+	case *ast.IndexExpression:
+		return c.index(exp)
+	default:
+		return Any
+	}
+}
+
+// unknown reports whether nothing can be said about the values of typ.
+func unknown(typ Type) bool {
+	return typ == Any || typ == Never
+}
+
+func hashable(typ Type) bool {
+	switch typ.(type) {
+	case *Hash, *Function:
+		return false
+	default:
+		return typ != Null
+	}
+}
+
+func (c *checker) prefix(exp *ast.PrefixExpression) Type {
+	right := c.expression(exp.Right)
+	if exp.Operator == "!" {
+		return Bool
+	}
+	if !unknown(right) && right != Int {
+		c.reportf(c.annotated(exp.Right), exp, "unknown operator: %s%s", exp.Operator, right)
+		return Any
+	}
+	return Int
+}
+
+func (c *checker) infix(exp *ast.InfixExpression) Type {
+	left, right := c.expression(exp.Left), c.expression(exp.Right)
+	op := exp.Operator
+	annotated := c.annotated(exp.Left) || c.annotated(exp.Right)
+
+	switch {
+	case op == "==" || op == "!=":
+		return Bool
+	case op == "+" && !c.conf.Strict && (left == String || right == String):
+		return String
+	case unknown(left) || unknown(right):
+		switch op {
+		case "<", ">":
+			return Bool
+		case "-", "*", "/":
+			return Int
+		default:
+			return Any
+		}
+	case left == Int && right == Int:
+		if op == "<" || op == ">" {
+			return Bool
+		}
+		return Int
+	case left == String && right == String && op == "+":
+		return String
+	case !Identical(left, right):
+		c.reportf(annotated, exp, "type mismatch: %s %s %s", left, op, right)
+	default:
+		c.reportf(annotated, exp, "unknown operator: %s %s %s", left, op, right)
+	}
+	return Any
+}
+
+func (c *checker) function(exp *ast.FunctionLiteral) Type {
+	slots := exp.Slots
+	if slots < len(exp.Parameters) {
+		slots = len(exp.Parameters)
+	}
+	fn := &function{slots: make([]*variable, slots), returns: Never}
+	params := make([]Type, len(exp.Parameters))
+
+	for idx, ident := range exp.Parameters {
+		params[idx] = c.param(exp, idx)
+		param := &variable{typ: params[idx], lets: 1, declared: idx < len(exp.ParamTypes) && exp.ParamTypes[idx] != nil}
+		if exp.IsRest(idx) {
+			param.typ = &Array{Elem: params[idx]}
+		}
+		// note: defaults are evaluated where the function is defined, not in its body
+		if def := exp.Default(idx); def != nil {
+			if typ := c.expression(def); !Assignable(typ, param.typ) {
+				c.errorf(def, "cannot use %s as %s in the default value of %s", typ, param.typ, ident.Value)
+			}
+		}
+		fn.slots[idx] = param
+		c.record(ident, param.typ)
+	}
+	if exp.ReturnType != nil {
+		fn.ret = c.annotation(exp.ReturnType)
+	}
+
+	c.funcs = append(c.funcs, fn)
+	for idx, pattern := range exp.Patterns {
+		if pattern == nil {
+			continue
+		}
+		for _, name := range ast.Names(pattern) {
+			c.declare(name)
+		}
+		c.destructure(pattern, fn.slots[idx].typ, exp.Parameters[idx], false)
+	}
+	c.hoist(exp.Body)
+	res := c.statements(exp.Body.Statements)
+	c.funcs = c.funcs[:len(c.funcs)-1]
+
+	if fn.ret == nil {
+		return functionType(exp, params, Join(fn.returns, res))
+	}
+
+	typ := functionType(exp, params, fn.ret)
+	if !Assignable(res, fn.ret) {
+		stms := exp.Body.Statements
+		var at ast.Node = exp
+		if len(stms) != 0 {
+			at = stms[len(stms)-1]
+		}
+		c.errorf(at, "cannot return %s from a function returning %s", res, fn.ret)
+	}
+	return typ
+}
+
+func (c *checker) call(exp *ast.CallExpression) Type {
+	callee := c.expression(exp.Function)
+	// note: the arguments past a spread or passed by name can't be told
+	// apart by their position, so only the ones before are checked
+	args := []Type{}
+	fixed := true
+	for _, arg := range exp.Args {
+		switch arg := arg.(type) {
+		case *ast.SpreadExpression:
+			if typ := c.expression(arg.Value); !Assignable(typ, &Array{Elem: Any}) {
+				c.reportf(c.annotated(arg.Value), arg, "spread argument must be an array, got %s", typ)
+			}
+			fixed = false
+		case *ast.KeywordArgument:
+			c.expression(arg.Value)
+			fixed = false
+		default:
+			typ := c.expression(arg)
+			if fixed {
+				args = append(args, typ)
+			}
+		}
+	}
+
+	fn, ok := callee.(*Function)
+	if !ok {
+		if !unknown(callee) {
+			c.reportf(c.annotated(exp.Function), exp, "not a function: %s", callee)
+		}
+		return Any
+	}
+
+	if fixed && len(args) < len(fn.Params) || fn.Rest == nil && len(args) > len(fn.Params) {
+		want := fmt.Sprintf("%d", len(fn.Params))
+		if fn.Rest != nil {
+			want = "at least " + want
+		}
+		c.reportf(c.annotated(exp.Function), exp, "wrong number of arguments for %s: got %d, want %s", exp.Function, len(args), want)
+		return fn.Return
+	}
+
+	for idx, arg := range args {
+		param := fn.Rest
+		if idx < len(fn.Params) {
+			param = fn.Params[idx]
+		}
+		if !Assignable(arg, param) {
+			// note: only the parameters of the functions of the program
+			// are typed by annotations, the ones of builtins aren't
+			annotated := !c.builtin(exp.Function) || c.annotated(exp.Args[idx])
+			c.reportf(annotated, exp.Args[idx], "cannot use %s as %s in argument %d to %s", arg, param, idx+1, exp.Function)
+		}
+	}
+	return fn.Return
+}
+
+func (c *checker) index(exp *ast.IndexExpression) Type {
+	left, index := c.expression(exp.Left), c.expression(exp.Index)
+
+	annotated := c.annotated(exp.Left) || c.annotated(exp.Index)
+
+	switch left := left.(type) {
+	case *Array:
+		if !Assignable(index, Int) {
+			c.reportf(annotated, exp.Index, "cannot index %s with %s", left, index)
+		}
+		return left.Elem
+	case *Hash:
+		// note: the missing keys are null, whatever their type
+		if annotated && !Assignable(index, left.Key) {
+			c.errorf(exp.Index, "cannot index %s with %s", left, index)
+		}
+		return left.Value
+	default:
+		if !unknown(left) {
+			c.reportf(c.annotated(exp.Left), exp, "index operator not supported: %s", left)
+		}
+		return Any
+	}
+}
+
+// builtin reports whether exp refers to a builtin.
+func (c *checker) builtin(exp ast.Expression) bool {
+	ident, ok := exp.(*ast.Identifier)
+	return ok && ident.Binding.Scope != ast.Local && c.variable(ident) == nil
+}
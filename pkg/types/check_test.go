@@ -0,0 +1,230 @@
+package types
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/AzraelSec/cube/pkg/ast"
+	"github.com/AzraelSec/cube/pkg/evaluator"
+	"github.com/AzraelSec/cube/pkg/lexer"
+	"github.com/AzraelSec/cube/pkg/object"
+	"github.com/AzraelSec/cube/pkg/parser"
+)
+
+func TestCheck(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected []string
+	}{
+		// note: programs without annotations keep working, the errors
+		// found in them are just warnings
+		{"let a = 1; let b = a + 2; let f = fn(x, y) { x + y }; f(a, b)", []string{}},
+		{"let f = fn(x) { if (x) { 1 } else { \"a\" } }; f(true) + 1", []string{}},
+		{"let x = 1; let x = \"a\"; x + \"b\"", []string{}},
+		{"let x: int = 1; let s: string = \"a\"; let b: bool = !x; let a: any = 1;", []string{}},
+		{"let x: int = \"a\";", []string{"1:14: cannot assign string to x of type int"}},
+		{"let x: int = 1; let x = \"a\";", []string{"1:25: cannot assign string to x of type int"}},
+		{"let x: strin = 1;", []string{"1:8: unknown type strin"}},
+		{"let xs: [int] = [1, 2]; let ys: [int] = []; let zs: [int] = [1, \"a\"];", []string{}},
+		{"let xs: [int] = [\"a\"];", []string{"1:17: cannot assign [string] to xs of type [int]"}},
+		{"let h: {string: int} = {\"a\": 1}; h[1]", []string{"1:36: cannot index {string: int} with int"}},
+		{"let h = {}; h[\"a\"]; let m = {1: \"a\"}; m[true]", []string{}},
+		{"let m: {int: string} = {1: \"a\"}; m[true]", []string{"1:36: cannot index {int: string} with bool"}},
+		{"let h: {string: int} = {}; let n: int = h[\"a\"];", []string{}},
+		{"let x: int = 1; x + \"a\"; fn f(y) { y }; f(1)(2)", []string{
+			"1:17: type mismatch: int + string",
+		}},
+		{"let xs = [1, 2]; xs[\"a\"]", []string{"1:21: warning: cannot index [int] with string"}},
+		{"let n = 1; n[0]", []string{"1:12: warning: index operator not supported: int"}},
+		{"1 + \"a\"", []string{"1:1: warning: type mismatch: int + string"}},
+		{"true + false", []string{"1:1: warning: unknown operator: bool + bool"}},
+		{"-\"a\"", []string{"1:1: warning: unknown operator: -string"}},
+		{"let x = 1; let y = x + 2; y - \"a\"", []string{"1:27: warning: type mismatch: int - string"}},
+		{"let f = fn(a: int, b: string) { a }; f(1, \"b\"); f(\"a\", 2)", []string{
+			"1:51: cannot use string as int in argument 1 to f",
+			"1:56: cannot use int as string in argument 2 to f",
+		}},
+		{"let f = fn(a) { a }; f(1, 2)", []string{"1:22: warning: wrong number of arguments for f: got 2, want 1"}},
+		{"let f = fn(a: int): string { a }", []string{"1:30: cannot return int from a function returning string"}},
+		{"let f = fn(a: int): string { if (a > 0) { return \"a\"; } return a; }", []string{"1:64: cannot return int from a function returning string"}},
+		{"let f = fn(a: int): int { if (a > 0) { return 1; } else { return 2; } }", []string{}},
+		{"let f = fn(a: int): int { if (a > 0) { return 1; } exit(1) }", []string{}},
+		{"let f = fn(a: int) { a * 2 }; let s: string = f(1);", []string{"1:47: cannot assign int to s of type string"}},
+		{"let f = fn(a) { if (a) { return 1; } 2 }; let s: string = f(1);", []string{"1:59: cannot assign int to s of type string"}},
+		{"let n = 1; n(2)", []string{"1:12: warning: not a function: int"}},
+		{"let s: string = len(\"abc\");", []string{"1:17: cannot assign int to s of type string"}},
+		{"let s: string = str(1) + type(1); let n: int = int(\"1\") + len([]);", []string{}},
+		{"len()", []string{"1:1: warning: wrong number of arguments for len: got 0, want 1"}},
+		{"print(1, \"a\", [])", []string{}},
+		{"push(1, 2); keys({1: 2}); exit(\"a\"); merge({}, [])", []string{
+			"1:6: warning: cannot use int as [any] in argument 1 to push",
+			"1:32: warning: cannot use string as int in argument 1 to exit",
+			"1:48: warning: cannot use [never] as {any: any} in argument 2 to merge",
+		}},
+		{"let apply = fn(f: fn(int): int, x: int): int { f(x) }; apply(fn(x) { x + 1 }, 1); apply(fn(x: string) { x }, 1)", []string{
+			"1:89: cannot use fn(string): string as fn(int): int in argument 1 to apply",
+		}},
+		{"let f = fn() { g(1) }; let g = fn(x: string) { x };", []string{}},
+		{"let fact = fn(n: int): int { if (n < 2) { 1 } else { n * fact(n - 1) } }; let s: string = fact(5);", []string{
+			"1:91: cannot assign int to s of type string",
+		}},
+		{"{fn() { 1 }: 1}", []string{"1:2: warning: not hashable key: fn(): int"}},
+		{"let f = fn(x: int) { let y = x; let z: string = y; z }", []string{"1:49: cannot assign int to z of type string"}},
+		{"let s: string = twice(2); twice(\"a\"); fn twice(x: int): int { x * 2 }", []string{
+			"1:17: cannot assign int to s of type string",
+			"1:33: cannot use string as int in argument 1 to twice",
+		}},
+		{"fn f(a: int): string { a }", []string{"1:24: cannot return int from a function returning string"}},
+		{"fn f(a: int, b: int = 1): int { a + b } let s: string = f(1); f(1, \"a\"); f()", []string{
+			"1:57: cannot assign int to s of type string",
+			"1:68: cannot use string as int in argument 2 to f",
+			"1:74: warning: wrong number of arguments for f: got 0, want at least 1",
+		}},
+		{"fn f(a: string = 1) { a }", []string{"1:18: cannot use int as string in the default value of a"}},
+		{"fn f(...xs: int): [int] { xs } f(1, 2, \"a\"); let s: string = f(1);", []string{
+			"1:40: cannot use string as int in argument 3 to f",
+			"1:62: cannot assign [int] to s of type string",
+		}},
+		{"fn f(a: int, b: string) { a } f(...[1], \"a\", 2); f(b: 1, a: \"x\"); f(...1)", []string{
+			"1:69: warning: spread argument must be an array, got int",
+		}},
+		{"let apply = fn(f: fn(int, int): int) { f(1, 2) }; apply(fn(a: int, b: int = 0): int { a + b }); apply(fn(...xs: string) { 1 })", []string{
+			"1:103: cannot use fn(...string): int as fn(int, int): int in argument 1 to apply",
+		}},
+		{"let [a, b] = [1, 2]; let {n, m = 0} = {\"n\": 1}; let [c, ...cs] = [\"x\"]; a + b + n + m; c + \"y\"; cs[0] + \"z\"", []string{}},
+		{"let [a, ...as] = [1]; let s: string = a; let t: string = as;", []string{
+			"1:39: cannot assign int to s of type string",
+			"1:58: cannot assign [int] to t of type string",
+		}},
+		{"let {k: [a]} = {\"k\": [1]}; let s: string = a;", []string{"1:44: cannot assign int to s of type string"}},
+		{"let [a] = 1; let {b} = [1];", []string{
+			"1:11: warning: cannot destructure int with array pattern [a]",
+			"1:24: warning: cannot destructure [int] with hash pattern {b}",
+		}},
+		{"let [a, b]: [int] = [\"x\"];", []string{"1:21: cannot assign [string] to [a, b] of type [int]"}},
+		{"let x: int = 1; let [x] = [\"a\"];", []string{"1:27: cannot assign string to x of type int"}},
+		{"let r: string = match (1) { 1 => \"one\", n => str(n) };", []string{}},
+		{"let r: string = match (1) { 1 => \"one\", _ => 2 };", []string{}},
+		{"let r: string = match (1) { 1 => 1, _ => 2 };", []string{"1:17: cannot assign int to r of type string"}},
+		{"fn f(x) { match (x) { n: int => n + 1, s: string => s + 1 } }", []string{"1:53: type mismatch: string + int"}},
+		{"match ([\"a\"]) { [s] => s - 1, {k} => k, 1 => 2 }", []string{"1:24: warning: type mismatch: string - int"}},
+		{"match ({\"a\": 1}) { {a, ...others} => others[\"b\"] + a }", []string{}},
+		{"match (1) { s: strin => s }", []string{"1:16: unknown type strin"}},
+		{"fn f([a, b]: [int], {k}: [int]) { let s: string = a; s }", []string{
+			"1:21: cannot destructure [int] with hash pattern {k}",
+			"1:51: cannot assign int to s of type string",
+		}},
+	}
+
+	for _, tt := range tests {
+		errs := check(t, tt.input, nil)
+		if len(errs) != len(tt.expected) {
+			t.Errorf("%q: wrong errors. got=%q, want=%q", tt.input, errs, tt.expected)
+			continue
+		}
+		for idx := range errs {
+			if errs[idx] != tt.expected[idx] {
+				t.Errorf("%q: wrong error. got=%q, want=%q", tt.input, errs[idx], tt.expected[idx])
+			}
+		}
+	}
+}
+
+func TestCheckGlobals(t *testing.T) {
+	env := object.NewEnvironment()
+	env.Set("n", &object.Integer{Value: 1})
+	env.Set("names", &object.Array{Elements: []object.Object{&object.String{Value: "a"}}})
+
+	errs := check(t, "let s: string = names[0]; let m: int = n; n + s", env)
+	if len(errs) != 1 || errs[0] != "1:43: type mismatch: int + string" {
+		t.Errorf("wrong errors. got=%q", errs)
+	}
+
+	// note: redefining a global drops its previous type
+	if errs := check(t, "let n = \"a\"; let s: string = n;", env); len(errs) != 0 {
+		t.Errorf("unexpected errors %q", errs)
+	}
+}
+
+func TestCheckInfo(t *testing.T) {
+	input := "let xs = [1, 2]; let f = fn(a: string, b) { len(a) + b }; f(\"a\", xs[0])"
+	p := parser.New(lexer.New(input))
+	prog := p.ParseProgram()
+	env := object.NewEnvironment()
+	if errs := evaluator.Resolve(prog, env); len(errs) != 0 {
+		t.Fatalf("unexpected resolve errors %v", errs)
+	}
+
+	info := &Info{}
+	if errs := CheckInfo(prog, Globals(env), info); len(errs) != 0 {
+		t.Fatalf("unexpected errors %q", errs)
+	}
+
+	// note: the identifiers in source order with their types
+	expected := []string{
+		"xs [int]",
+		"f fn(string, any): any",
+		"a string", "b any", "len fn(any): int", "a string", "b any",
+		"f fn(string, any): any", "xs [int]",
+	}
+
+	got := []string{}
+	ast.Inspect(prog, func(n ast.Node) bool {
+		if ident, ok := n.(*ast.Identifier); ok {
+			typ, ok := info.Types[ident]
+			if !ok {
+				t.Errorf("no type for %s at %d:%d", ident.Value, ident.Token.Line, ident.Token.Column)
+				return true
+			}
+			got = append(got, ident.Value+" "+typ.String())
+		}
+		return true
+	})
+
+	if len(got) != len(expected) {
+		t.Fatalf("wrong identifiers. got=%q, want=%q", got, expected)
+	}
+	for idx := range expected {
+		if got[idx] != expected[idx] {
+			t.Errorf("identifier %d: wrong type. got=%q, want=%q", idx, got[idx], expected[idx])
+		}
+	}
+}
+
+func TestCheckStrictMode(t *testing.T) {
+	input := "let s: string = \"count: \" + 1;"
+	if errs := check(t, input, nil); len(errs) != 1 {
+		t.Errorf("expected a warning in strict mode, got %q", errs)
+	}
+
+	p := parser.New(lexer.New(input))
+	prog := p.ParseProgram()
+	env := object.NewEnvironment()
+	if errs := evaluator.Resolve(prog, env); len(errs) != 0 {
+		t.Fatalf("unexpected resolve errors %v", errs)
+	}
+	if errs := (Config{Strict: false}).Check(prog, Globals(env), nil); len(errs) != 0 {
+		t.Errorf("unexpected errors %q", errs)
+	}
+}
+
+func check(t *testing.T, input string, env *object.Environment) []string {
+	p := parser.New(lexer.New(input))
+	prog := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		t.Fatalf("%q: unexpected parse errors %v", input, p.Errors())
+	}
+
+	if env == nil {
+		env = object.NewEnvironment()
+	}
+	if errs := evaluator.Resolve(prog, env); len(errs) != 0 {
+		t.Fatalf("%q: unexpected resolve errors %v", input, errs)
+	}
+	info := &Info{}
+	errs := parser.ErrorStrings(CheckInfo(prog, Globals(env), info))
+	for _, w := range info.Warnings {
+		errs = append(errs, fmt.Sprintf("%d:%d: warning: %s", w.Line, w.Column, w.Msg))
+	}
+	return errs
+}
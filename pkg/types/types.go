@@ -0,0 +1,188 @@
+// Package types implements a gradual type checker for Cube programs:
+// annotations are optional and whatever isn't annotated nor can be inferred
+// is `any`, which is compatible with every other type.
+package types
+
+import (
+	"strings"
+
+	"github.com/AzraelSec/cube/pkg/object"
+)
+
+type Type interface {
+	String() string
+}
+
+type Basic struct {
+	name string
+}
+
+func (b *Basic) String() string { return b.name }
+
+var (
+	Int    = &Basic{"int"}
+	String = &Basic{"string"}
+	Bool   = &Basic{"bool"}
+	Null   = &Basic{"null"}
+	Any    = &Basic{"any"}
+	// Never is the type of expressions that don't produce a value, e.g. a
+	// block ending with a return; it can't be written in annotations
+	Never = &Basic{"never"}
+)
+
+// note: the basic types that can be named in annotations
+var basics = map[string]*Basic{
+	"int":    Int,
+	"string": String,
+	"bool":   Bool,
+	"null":   Null,
+	"any":    Any,
+}
+
+type Array struct {
+	Elem Type
+}
+
+func (a *Array) String() string { return "[" + a.Elem.String() + "]" }
+
+type Hash struct {
+	Key   Type
+	Value Type
+}
+
+func (h *Hash) String() string { return "{" + h.Key.String() + ": " + h.Value.String() + "}" }
+
+type Function struct {
+	Params []Type
+	Rest   Type // type of any further argument, nil if the arity is fixed
+	Return Type
+}
+
+func (f *Function) String() string {
+	params := make([]string, len(f.Params))
+	for idx, param := range f.Params {
+		params[idx] = param.String()
+	}
+	if f.Rest != nil {
+		params = append(params, "..."+f.Rest.String())
+	}
+	return "fn(" + strings.Join(params, ", ") + "): " + f.Return.String()
+}
+
+// Identical reports whether a and b are the same type.
+func Identical(a, b Type) bool {
+	return a.String() == b.String()
+}
+
+// Assignable reports whether a value of type from can be used where one of
+// type to is expected.
+func Assignable(from, to Type) bool {
+	if from == Any || to == Any || from == Never {
+		return true
+	}
+
+	switch to := to.(type) {
+	case *Array:
+		from, ok := from.(*Array)
+		return ok && Assignable(from.Elem, to.Elem)
+	case *Hash:
+		from, ok := from.(*Hash)
+		return ok && Assignable(from.Key, to.Key) && Assignable(from.Value, to.Value)
+	case *Function:
+		// note: from must accept every call to accepts, its rest taking
+		// the arguments past its own parameters
+		from, ok := from.(*Function)
+		if !ok || len(from.Params) > len(to.Params) || to.Rest != nil && from.Rest == nil {
+			return false
+		}
+		// note: parameters are contravariant
+		for idx := range to.Params {
+			param := from.Rest
+			if idx < len(from.Params) {
+				param = from.Params[idx]
+			}
+			if param == nil || !Assignable(to.Params[idx], param) {
+				return false
+			}
+		}
+		if to.Rest != nil && !Assignable(to.Rest, from.Rest) {
+			return false
+		}
+		return Assignable(from.Return, to.Return)
+	default:
+		return from == to
+	}
+}
+
+// Join returns the most precise type both a and b can be used as.
+func Join(a, b Type) Type {
+	switch {
+	case a == Never:
+		return b
+	case b == Never:
+		return a
+	case Identical(a, b):
+		return a
+	}
+
+	switch a := a.(type) {
+	case *Array:
+		if b, ok := b.(*Array); ok {
+			return &Array{Elem: Join(a.Elem, b.Elem)}
+		}
+	case *Hash:
+		if b, ok := b.(*Hash); ok {
+			return &Hash{Key: Join(a.Key, b.Key), Value: Join(a.Value, b.Value)}
+		}
+	}
+	return Any
+}
+
+// Of returns the type of a runtime value.
+func Of(obj object.Object) Type {
+	switch obj := obj.(type) {
+	case *object.Integer:
+		return Int
+	case *object.String:
+		return String
+	case *object.Boolean:
+		return Bool
+	case *object.Null:
+		return Null
+	case *object.Array:
+		elem := Type(Never)
+		for _, e := range obj.Elements {
+			elem = Join(elem, Of(e))
+		}
+		return &Array{Elem: elem}
+	case *object.Hash:
+		key, value := Type(Never), Type(Never)
+		for _, pair := range obj.Pairs() {
+			key, value = Join(key, Of(pair.Key)), Join(value, Of(pair.Value))
+		}
+		return &Hash{Key: key, Value: value}
+	case *object.Function:
+		typ := &Function{Params: make([]Type, obj.Required()), Return: Any}
+		for idx := range typ.Params {
+			typ.Params[idx] = Any
+		}
+		if len(obj.Parameters) > obj.Required() {
+			typ.Rest = Any
+		}
+		return typ
+	case *object.Builtin:
+		return builtinType(obj)
+	default:
+		return Any
+	}
+}
+
+// Globals returns the types of the global variables defined in env.
+func Globals(env *object.Environment) map[string]Type {
+	globals := map[string]Type{}
+	for _, name := range env.Names() {
+		val, _ := env.Get(name)
+		globals[name] = Of(val)
+	}
+	return globals
+}
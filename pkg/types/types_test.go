@@ -0,0 +1,97 @@
+package types
+
+import (
+	"testing"
+
+	"github.com/AzraelSec/cube/pkg/ast"
+	"github.com/AzraelSec/cube/pkg/object"
+)
+
+func TestAssignable(t *testing.T) {
+	intToInt := &Function{Params: []Type{Int}, Return: Int}
+	anyToInt := &Function{Params: []Type{Any}, Return: Int}
+
+	tests := []struct {
+		from, to Type
+		expected bool
+	}{
+		{Int, Int, true},
+		{Int, String, false},
+		{Any, Int, true},
+		{Int, Any, true},
+		{Never, String, true},
+		{Null, Int, false},
+		{&Array{Elem: Int}, &Array{Elem: Int}, true},
+		{&Array{Elem: Never}, &Array{Elem: String}, true},
+		{&Array{Elem: Int}, &Array{Elem: String}, false},
+		{&Hash{Key: String, Value: Int}, &Hash{Key: String, Value: Any}, true},
+		{&Hash{Key: String, Value: Int}, &Hash{Key: Int, Value: Int}, false},
+		{anyToInt, intToInt, true},
+		{intToInt, anyToInt, true},
+		{intToInt, &Function{Params: []Type{String}, Return: Int}, false},
+		{intToInt, &Function{Params: []Type{Int}, Return: String}, false},
+		{intToInt, &Function{Params: []Type{}, Return: Int}, false},
+		{intToInt, Int, false},
+		{&Function{Params: []Type{Int}, Rest: Int, Return: Int}, &Function{Params: []Type{Int, Int}, Return: Int}, true},
+		{&Function{Params: []Type{}, Rest: String, Return: Int}, intToInt, false},
+		{intToInt, &Function{Params: []Type{Int}, Rest: Int, Return: Int}, false},
+		{&Function{Params: []Type{}, Rest: Any, Return: Int}, &Function{Params: []Type{Int}, Rest: Int, Return: Int}, true},
+	}
+
+	for _, tt := range tests {
+		if res := Assignable(tt.from, tt.to); res != tt.expected {
+			t.Errorf("Assignable(%s, %s) = %t, want %t", tt.from, tt.to, res, tt.expected)
+		}
+	}
+}
+
+func TestJoin(t *testing.T) {
+	tests := []struct {
+		a, b     Type
+		expected string
+	}{
+		{Int, Int, "int"},
+		{Int, Never, "int"},
+		{Never, String, "string"},
+		{Int, String, "any"},
+		{Int, Null, "any"},
+		{&Array{Elem: Never}, &Array{Elem: Int}, "[int]"},
+		{&Array{Elem: Int}, &Array{Elem: Bool}, "[any]"},
+		{&Hash{Key: String, Value: Int}, &Hash{Key: String, Value: Never}, "{string: int}"},
+		{&Function{Params: []Type{Int}, Return: Int}, &Function{Params: []Type{Int}, Return: Int}, "fn(int): int"},
+	}
+
+	for _, tt := range tests {
+		if res := Join(tt.a, tt.b); res.String() != tt.expected {
+			t.Errorf("Join(%s, %s) = %s, want %s", tt.a, tt.b, res, tt.expected)
+		}
+	}
+}
+
+func TestOf(t *testing.T) {
+	hash := &object.Hash{}
+	hash.Set(&object.String{Value: "a"}, &object.Integer{Value: 1})
+
+	tests := []struct {
+		obj      object.Object
+		expected string
+	}{
+		{&object.Integer{Value: 1}, "int"},
+		{&object.String{Value: "a"}, "string"},
+		{&object.Boolean{Value: true}, "bool"},
+		{&object.Null{}, "null"},
+		{&object.Array{Elements: []object.Object{&object.Integer{Value: 1}, &object.Integer{Value: 2}}}, "[int]"},
+		{&object.Array{Elements: []object.Object{}}, "[never]"},
+		{hash, "{string: int}"},
+		{&object.Builtin{MinArgs: 1, MaxArgs: 2, Params: []object.Param{{Name: "a", Type: "[int]"}, {Name: "b", Type: "string"}}, Result: "never"}, "fn([int], ...string): never"},
+		{&object.Builtin{MinArgs: 0, MaxArgs: -1, Params: []object.Param{{Name: "a", Type: "fn(int)"}}, Result: "bool"}, "fn(...fn(int): any): bool"},
+		{&object.Function{Parameters: []*ast.Identifier{{Value: "a"}, {Value: "b"}}}, "fn(any, any): any"},
+		{&object.Function{Parameters: []*ast.Identifier{{Value: "a"}, {Value: "b"}}, Defaults: []ast.Expression{nil, &ast.IntegerLiteral{Value: 1}}}, "fn(any, ...any): any"},
+	}
+
+	for _, tt := range tests {
+		if res := Of(tt.obj); res.String() != tt.expected {
+			t.Errorf("Of(%s) = %s, want %s", tt.obj.Inspect(), res, tt.expected)
+		}
+	}
+}